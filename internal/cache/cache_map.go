@@ -1,24 +1,60 @@
 package cache
 
 import (
+	"context"
+	"errors"
 	"sync"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/persistence"
 )
 
+// ErrFileRequested interrupts the work holding the lock of a file when the entry of it is requested
+var ErrFileRequested = errors.New("cache file is requested")
+
+type fileLock struct {
+	done chan struct{}
+	// interrupts the work holding the lock, nil if it can't be interrupted
+	interrupt context.CancelCauseFunc
+}
+
 type CacheMap struct {
 	sync.Mutex
 	cache map[string]Entry
+
+	// files being rewritten outside entries, entries of them cannot be created until they are unlocked
+	locked map[string]*fileLock
 }
 
 func NewCacheMap() *CacheMap {
 	return &CacheMap{
-		cache: make(map[string]Entry),
+		cache:  make(map[string]Entry),
+		locked: make(map[string]*fileLock),
 	}
 }
 
-func (cm *CacheMap) findOrCreate(id string) (Entry, error) {
+// findOrCreate waits until the file is unlocked, the work holding the lock is interrupted if possible
+func (cm *CacheMap) findOrCreate(ctx context.Context, id string) (Entry, error) {
 	cm.Lock()
 	defer cm.Unlock()
 
+	for {
+		lock, ok := cm.locked[id]
+		if !ok {
+			break
+		}
+		if lock.interrupt != nil {
+			lock.interrupt(ErrFileRequested)
+		}
+		cm.Unlock()
+		select {
+		case <-lock.done:
+			cm.Lock()
+		case <-ctx.Done():
+			cm.Lock()
+			return nil, ctx.Err()
+		}
+	}
+
 	e, ok := cm.cache[id]
 	if !ok {
 		e = NewEntry(id)
@@ -44,8 +80,8 @@ func (cm *CacheMap) removeIfInactive(id string) (Entry, bool) {
 	return e, true
 }
 
-func (cm *CacheMap) Open(id string) (Entry, error) {
-	e, err := cm.findOrCreate(id)
+func (cm *CacheMap) Open(ctx context.Context, id string) (Entry, error) {
+	e, err := cm.findOrCreate(ctx, id)
 	if err != nil {
 		return nil, err
 	}
@@ -84,3 +120,66 @@ func (cm *CacheMap) IsActive(id string) bool {
 
 	return e.Active()
 }
+
+func (cm *CacheMap) HasActive() bool {
+	cm.Lock()
+	defer cm.Unlock()
+
+	for _, e := range cm.cache {
+		if e.Active() {
+			return true
+		}
+	}
+	return false
+}
+
+func (cm *CacheMap) tryLockFile(id string, lock *fileLock) bool {
+	aliases := persistence.GetCacheAliases(id)
+
+	cm.Lock()
+	defer cm.Unlock()
+
+	if _, ok := cm.cache[id]; ok {
+		return false
+	}
+	for _, alias := range aliases {
+		if _, ok := cm.cache[alias]; ok {
+			return false
+		}
+	}
+	if _, ok := cm.locked[id]; ok {
+		return false
+	}
+	cm.locked[id] = lock
+	return true
+}
+
+// TryLockFile prevents the entry of this id from being created,
+// fails if the entry or an entry sharing this file exists, or the file is locked
+func (cm *CacheMap) TryLockFile(id string) bool {
+	return cm.tryLockFile(id, &fileLock{done: make(chan struct{})})
+}
+
+// TryLockFileInterruptible is TryLockFile for long work, the returned context is canceled with ErrFileRequested
+// once the entry of this id is requested, and the work should give up the file as soon as possible
+func (cm *CacheMap) TryLockFileInterruptible(ctx context.Context, id string) (context.Context, bool) {
+	lockCtx, cancel := context.WithCancelCause(ctx)
+	if !cm.tryLockFile(id, &fileLock{done: make(chan struct{}), interrupt: cancel}) {
+		cancel(nil)
+		return nil, false
+	}
+	return lockCtx, true
+}
+
+func (cm *CacheMap) UnlockFile(id string) {
+	cm.Lock()
+	defer cm.Unlock()
+
+	if lock, ok := cm.locked[id]; ok {
+		if lock.interrupt != nil {
+			lock.interrupt(nil)
+		}
+		close(lock.done)
+		delete(cm.locked, id)
+	}
+}
@@ -0,0 +1,385 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/persistence"
+	"github.com/wzhqwq/VRCDancePreloader/internal/requesting"
+	"github.com/wzhqwq/VRCDancePreloader/internal/rw_file"
+	"github.com/wzhqwq/VRCDancePreloader/internal/rw_file/continuous"
+	"github.com/wzhqwq/VRCDancePreloader/internal/rw_file/legacy_file"
+	"github.com/wzhqwq/VRCDancePreloader/internal/types"
+	"github.com/wzhqwq/VRCDancePreloader/internal/utils"
+)
+
+// The same video is often uploaded to several platforms. Complete files are fingerprinted by their size and hashes
+// of the head and the tail, so that a new download can be matched through two small range requests.
+// Once two local files have the same full hash, one of them is removed and its id becomes an alias of the other.
+
+// the head and the tail of mp4 contain the moov box, which hardly collides
+const fingerprintRangeSize = 1024 * 64
+
+var ErrNotDuplicate = errors.New("files are not the same")
+
+var pendingFingerprints = make(map[string]struct{})
+var pendingFingerprintsMutex sync.Mutex
+var fingerprintSignal = make(chan struct{}, 1)
+
+var dedupLogger = utils.NewLogger("Cache Dedup")
+
+// scheduleFingerprint queues a complete file, outdated fingerprints are computed again
+func scheduleFingerprint(id string) {
+	pendingFingerprintsMutex.Lock()
+	pendingFingerprints[id] = struct{}{}
+	pendingFingerprintsMutex.Unlock()
+
+	select {
+	case fingerprintSignal <- struct{}{}:
+	default:
+	}
+}
+
+func takePendingFingerprints() []string {
+	pendingFingerprintsMutex.Lock()
+	defer pendingFingerprintsMutex.Unlock()
+
+	ids := make([]string, 0, len(pendingFingerprints))
+	for id := range pendingFingerprints {
+		ids = append(ids, id)
+	}
+	pendingFingerprints = make(map[string]struct{})
+	return ids
+}
+
+func fingerprintLoop(stopCh chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stopCh
+		cancel()
+	}()
+
+	for _, id := range persistence.ListUnfingerprintedCacheFiles() {
+		scheduleFingerprint(id)
+	}
+	mergeIndexedDuplicates()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-fingerprintSignal:
+		}
+
+		for _, id := range takePendingFingerprints() {
+			if waitForIdle(ctx) != nil {
+				return
+			}
+			fp, err := updateFingerprint(id)
+			if err != nil {
+				dedupLogger.WarnLn("Failed to fingerprint", id, ":", err)
+				continue
+			}
+			if fp != nil {
+				mergeDuplicatesOf(fp)
+			}
+		}
+	}
+}
+
+func hashBytes(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func getTailStart(size int64) int64 {
+	return max(size-fingerprintRangeSize, 0)
+}
+
+// updateFingerprint hashes a complete file if its fingerprint is missing or outdated, nil is returned if nothing changed
+func updateFingerprint(id string) (*persistence.CacheFingerprint, error) {
+	f, err := persistence.GetCacheFile(id)
+	if err != nil || !f.IsComplete {
+		return nil, nil
+	}
+	if old := persistence.GetCacheFingerprint(id); old != nil && old.Size == f.FullSize && old.ModTime.Equal(f.ModTime) {
+		return nil, nil
+	}
+
+	r, size, err := openCompleteFile(id)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	ra, ok := r.(io.ReaderAt)
+	if !ok {
+		return nil, errors.New("cache file is not seekable")
+	}
+
+	fp := &persistence.CacheFingerprint{
+		ID:      id,
+		Size:    size,
+		ModTime: f.ModTime,
+	}
+	if fp.HeadHash, err = hashBytes(io.NewSectionReader(ra, 0, fingerprintRangeSize)); err != nil {
+		return nil, err
+	}
+	if fp.TailHash, err = hashBytes(io.NewSectionReader(ra, getTailStart(size), fingerprintRangeSize)); err != nil {
+		return nil, err
+	}
+	if fp.FullHash, err = hashBytes(io.NewSectionReader(ra, 0, size)); err != nil {
+		return nil, err
+	}
+
+	// the file may be rewritten while hashing
+	if now := readWorkingCacheFile(id); now == nil || !now.IsComplete || now.ModTime.Unix() != f.ModTime.Unix() {
+		return nil, nil
+	}
+
+	persistence.SaveCacheFingerprint(fp)
+	return fp, nil
+}
+
+// mergeIndexedDuplicates merges files that were confirmed duplicated but not merged, e.g. they were in use
+func mergeIndexedDuplicates() {
+	seen := make(map[string]string)
+	for _, fp := range persistence.ListCacheFingerprints() {
+		key := fmt.Sprintf("%d-%s", fp.Size, fp.FullHash)
+		if keep, ok := seen[key]; ok {
+			if err := mergeDuplicate(keep, fp.ID); err != nil {
+				dedupLogger.WarnLn("Failed to merge", fp.ID, "into", keep, ":", err)
+			}
+			continue
+		}
+		seen[key] = fp.ID
+	}
+}
+
+func mergeDuplicatesOf(fp *persistence.CacheFingerprint) {
+	for _, other := range persistence.FindCacheFingerprintsBySize(fp.Size) {
+		if other.ID == fp.ID || other.FullHash != fp.FullHash {
+			continue
+		}
+		// keep the older one, the newer one may be still in use
+		err := mergeDuplicate(other.ID, fp.ID)
+		if errors.Is(err, ErrCacheBusy) {
+			err = mergeDuplicate(fp.ID, other.ID)
+		}
+		if err != nil {
+			dedupLogger.WarnLn("Failed to merge", fp.ID, "and", other.ID, ":", err)
+		}
+		return
+	}
+}
+
+// mergeDuplicate removes the file of dup and lets dup share the file of keep
+func mergeDuplicate(keep, dup string) error {
+	keepFp := persistence.GetCacheFingerprint(keep)
+	dupFp := persistence.GetCacheFingerprint(dup)
+	if keepFp == nil || dupFp == nil || keepFp.FullHash == "" || keepFp.FullHash != dupFp.FullHash || keepFp.Size != dupFp.Size {
+		return ErrNotDuplicate
+	}
+
+	if !cacheMap.TryLockFile(dup) {
+		return ErrCacheBusy
+	}
+	defer cacheMap.UnlockFile(dup)
+
+	if err := removeCacheFiles(dup); err != nil {
+		return err
+	}
+	persistence.SaveCacheAlias(dup, keep)
+	persistence.RetargetCacheAliases(dup, keep)
+	localFileEm.NotifySubscribers("-" + dup)
+
+	dedupLogger.InfoLnf("%s is the same as %s, freed %s", dup, keep, utils.PrettyByteSize(dupFp.Size))
+	return nil
+}
+
+// aliasInUse reports whether the file of target is being served for some alias
+func aliasInUse(target string) bool {
+	for _, id := range persistence.GetCacheAliases(target) {
+		if cacheMap.IsActive(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// aliasProtected reports whether some alias of target should keep the file from eviction
+func aliasProtected(target string) bool {
+	for _, id := range persistence.GetCacheAliases(target) {
+		if keepFavorites && persistence.IsFavorite(id) {
+			return true
+		}
+		if persistence.IsInAllowList(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// openAliasFile opens the complete file of target for reading, nil if target no longer has a complete file
+func openAliasFile(target string) rw_file.DeferredReadableFile {
+	f := readWorkingCacheFile(target)
+	if f == nil || !f.IsComplete {
+		return nil
+	}
+
+	baseName := fmt.Sprintf("%s/%s.mp4", cachePath, target)
+	if f.Format == types.CacheFormatLegacy {
+		if file := legacy_file.NewReadOnlyFile(baseName); file != nil {
+			return file
+		}
+		return nil
+	}
+	// the alias must never modify the file of target
+	if file := continuous.NewReadOnlyFile(baseName); file != nil {
+		return file
+	}
+	return nil
+}
+
+// hasLocalFile reports whether there's any file for this id on disk
+func hasLocalFile(id string) bool {
+	for _, path := range getCacheFilePaths(id) {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// openAlias switches the entry to the file of its alias target if it has no file of its own (please wrap with mutex by yourself!!)
+func (e *BaseEntry) openAlias() bool {
+	if hasLocalFile(e.id) {
+		return false
+	}
+	target := persistence.GetCacheAlias(e.id)
+	if target == "" {
+		return false
+	}
+
+	file := openAliasFile(target)
+	if file == nil {
+		// the target has been removed
+		persistence.RemoveCacheAlias(e.id)
+		return false
+	}
+
+	e.workingFile = file
+	e.aliasOf = target
+	e.logger.InfoLn(e.id, "shares the file of", target)
+	return true
+}
+
+// dropAlias stops sharing the file of the alias target and opens a file of its own (please wrap with the write lock!!)
+func (e *BaseEntry) dropAlias() error {
+	err := e.workingFile.Close()
+	if err != nil && !errors.Is(err, os.ErrClosed) {
+		return err
+	}
+	e.workingFile = nil
+	e.aliasOf = ""
+	persistence.RemoveCacheAlias(e.id)
+
+	e.openFile()
+	return nil
+}
+
+// hashRemoteRange hashes length bytes of the remote file from start
+func (e *BaseEntry) hashRemoteRange(url string, start, length int64, ctx context.Context) (string, error) {
+	req, err := e.client.NewGetRequest(url, ctx)
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, start+length-1))
+	requesting.SetupHeader(req, e.referer)
+	res, err := e.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusTooManyRequests {
+		return "", ErrThrottle
+	}
+	if res.StatusCode != http.StatusPartialContent {
+		return "", fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+	return hashBytes(io.LimitReader(res.Body, length))
+}
+
+// findRemoteDuplicate looks for a complete local file that has the same size, head and tail as the remote file
+func (e *UrlBasedEntry) findRemoteDuplicate(ctx context.Context) string {
+	candidates := persistence.FindCacheFingerprintsBySize(e.remoteSize)
+	if len(candidates) == 0 {
+		return ""
+	}
+
+	headHash, err := e.hashRemoteRange(e.resolvedUrl, 0, min(fingerprintRangeSize, e.remoteSize), ctx)
+	if err != nil {
+		e.logger.WarnLn("Failed to fingerprint the remote file:", err)
+		return ""
+	}
+	tailStart := getTailStart(e.remoteSize)
+	tailHash, err := e.hashRemoteRange(e.resolvedUrl, tailStart, e.remoteSize-tailStart, ctx)
+	if err != nil {
+		e.logger.WarnLn("Failed to fingerprint the remote file:", err)
+		return ""
+	}
+
+	for _, c := range candidates {
+		if c.ID != e.id && c.HeadHash == headHash && c.TailHash == tailHash {
+			return c.ID
+		}
+	}
+	return ""
+}
+
+// tryShareDuplicate lets a fresh entry serve a local copy of the same video instantly (please wrap with the write lock!!)
+func (e *UrlBasedEntry) tryShareDuplicate(ctx context.Context) error {
+	if e.aliasOf != "" || e.remoteSize <= 0 || e.workingFile.IsComplete() || e.workingFile.GetDownloadedBytes() > 0 {
+		return nil
+	}
+
+	target := e.findRemoteDuplicate(ctx)
+	if target == "" {
+		return nil
+	}
+
+	file := openAliasFile(target)
+	if file == nil {
+		return nil
+	}
+
+	err := e.workingFile.Close()
+	if err != nil && !errors.Is(err, os.ErrClosed) {
+		file.Close()
+		return err
+	}
+	if err := removeCacheFiles(e.id); err != nil {
+		e.logger.WarnLn("Failed to remove the empty file:", err)
+	}
+	localFileEm.NotifySubscribers("-" + e.id)
+
+	persistence.SaveCacheAlias(e.id, target)
+	e.workingFile = file
+	e.aliasOf = target
+	e.logger.InfoLn(e.id, "is the same as", target, "so it's served from there")
+
+	return nil
+}
@@ -71,8 +71,16 @@ func NewEntry(id string) Entry {
 	}
 	if ytID, ok := utils.CheckIdIsYoutube(id); ok {
 		return newUrlBasedEntry(id, requesting.GetClient(requesting.YouTubeVideo), func(ctx context.Context) (*RemoteVideoInfo, error) {
+			if !third_party_api.EnableYoutubeVideo {
+				return nil, ErrNotSupported
+			}
+			stream, err := third_party_api.ResolveYoutubeVideo(ytID, ctx)
+			if err != nil {
+				return nil, err
+			}
+
 			return &RemoteVideoInfo{
-				FinalUrl: utils.GetStandardYoutubeURL(ytID),
+				FinalUrl: stream.Url,
 			}, nil
 		})
 	}
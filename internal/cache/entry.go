@@ -7,10 +7,12 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/wzhqwq/VRCDancePreloader/internal/persistence"
 	"github.com/wzhqwq/VRCDancePreloader/internal/requesting"
 	"github.com/wzhqwq/VRCDancePreloader/internal/rw_file"
 	"github.com/wzhqwq/VRCDancePreloader/internal/rw_file/continuous"
@@ -22,6 +24,7 @@ import (
 var unixEpochTime = time.Time{}
 
 var ErrThrottle = errors.New("too many requests, slow down")
+var ErrRemoteChanged = errors.New("remote file changed while resuming")
 
 type Entry interface {
 	io.Writer
@@ -42,7 +45,7 @@ type Entry interface {
 	GetDownloadStream(ctx context.Context) (io.ReadCloser, error)
 	IsComplete() bool
 	ModTime() time.Time
-	UpdateReqRangeStart(start int64)
+	UpdateReqRanges(ranges []rw_file.Range)
 }
 
 type BaseEntry struct {
@@ -56,6 +59,10 @@ type BaseEntry struct {
 	workingFileMutex sync.RWMutex
 
 	workingFile rw_file.DeferredReadableFile
+	// the id whose file is shared with this entry, workingFile must not be modified if it's set
+	aliasOf string
+	// validators of the remote file recorded last time
+	validation *persistence.CacheValidation
 
 	logger *utils.CustomLogger
 }
@@ -85,6 +92,12 @@ func (e *BaseEntry) checkLegacy() bool {
 // extendable operations (please wrap with mutex by yourself and check workingFile first!!)
 
 func (e *BaseEntry) openFile() {
+	e.validation = persistence.GetCacheValidation(e.id)
+
+	if e.openAlias() {
+		return
+	}
+
 	if e.checkLegacy() {
 		e.workingFile = legacy_file.NewFile(e.getVideoName())
 	}
@@ -102,9 +115,12 @@ func (e *BaseEntry) openFile() {
 }
 
 func (e *BaseEntry) closeFile() error {
+	e.syncIndex("")
+
 	err := e.workingFile.Close()
 	if err == nil || errors.Is(err, os.ErrClosed) {
 		e.workingFile = nil
+		e.aliasOf = ""
 	}
 
 	return err
@@ -116,6 +132,11 @@ func (e *BaseEntry) getReadSeeker(ctx context.Context) (io.ReadSeeker, error) {
 		return nil, errors.New("failed to download this video")
 	}
 
+	persistence.MarkCacheServed(e.id)
+	if e.aliasOf != "" {
+		persistence.MarkCacheServed(e.aliasOf)
+	}
+
 	return r, nil
 }
 
@@ -125,9 +146,16 @@ type RemoteVideoInfo struct {
 	FinalUrl     string
 	TotalSize    int64
 	LastModified time.Time
+
+	// validators in response headers
+	ETag             string
+	LastModifiedText string
+	// the server responded 304 to our conditional request
+	NotModified bool
 }
 
-func (e *BaseEntry) requestHttpResInfo(url string, ctx context.Context) (*RemoteVideoInfo, error) {
+// requestHttpResInfo gets the headers of the remote file, the request is conditional if validation is provided
+func (e *BaseEntry) requestHttpResInfo(url string, validation *persistence.CacheValidation, ctx context.Context) (*RemoteVideoInfo, error) {
 	e.logger.InfoLn("Request info", url)
 	req, err := e.client.NewGetRequest(url, ctx)
 	if err != nil {
@@ -138,6 +166,14 @@ func (e *BaseEntry) requestHttpResInfo(url string, ctx context.Context) (*Remote
 		e.referer = url
 	}
 	requesting.SetupHeader(req, e.referer)
+	if validation != nil {
+		if validation.ETag != "" {
+			req.Header.Set("If-None-Match", validation.ETag)
+		}
+		if validation.LastModified != "" {
+			req.Header.Set("If-Modified-Since", validation.LastModified)
+		}
+	}
 	res, err := e.client.Do(req)
 	if err != nil {
 		e.logger.ErrorLn("Failed to get ", url, "reason:", err)
@@ -149,12 +185,21 @@ func (e *BaseEntry) requestHttpResInfo(url string, ctx context.Context) (*Remote
 		return nil, ErrThrottle
 	}
 
+	if res.StatusCode == http.StatusNotModified {
+		e.referer = res.Request.Header.Get("Referer")
+		return &RemoteVideoInfo{
+			FinalUrl:    res.Request.URL.String(),
+			NotModified: true,
+		}, nil
+	}
+
 	if res.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
 	}
 
 	lastModified := unixEpochTime
-	if lastModifiedText := res.Header.Get("Last-Modified"); lastModifiedText != "" {
+	lastModifiedText := res.Header.Get("Last-Modified")
+	if lastModifiedText != "" {
 		lastModified, _ = http.ParseTime(lastModifiedText)
 	}
 
@@ -164,8 +209,23 @@ func (e *BaseEntry) requestHttpResInfo(url string, ctx context.Context) (*Remote
 		FinalUrl:     res.Request.URL.String(),
 		TotalSize:    res.ContentLength,
 		LastModified: lastModified,
+
+		ETag:             res.Header.Get("ETag"),
+		LastModifiedText: lastModifiedText,
 	}, nil
 }
+
+// getIfRange picks the validator for If-Range, weak ETags are not allowed there
+func getIfRange(validation *persistence.CacheValidation) string {
+	if validation == nil {
+		return ""
+	}
+	if validation.ETag != "" && !strings.HasPrefix(validation.ETag, "W/") {
+		return validation.ETag
+	}
+	return validation.LastModified
+}
+
 func (e *BaseEntry) requestHttpResBody(url string, offset int64, ctx context.Context) (io.ReadCloser, error) {
 	e.logger.InfoLn("Request body", url, offset)
 	req, err := e.client.NewGetRequest(url, ctx)
@@ -174,6 +234,13 @@ func (e *BaseEntry) requestHttpResBody(url string, offset int64, ctx context.Con
 	}
 
 	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	ifRange := ""
+	if offset > 0 {
+		ifRange = getIfRange(e.validation)
+		if ifRange != "" {
+			req.Header.Set("If-Range", ifRange)
+		}
+	}
 	requesting.SetupHeader(req, e.referer)
 	res, err := e.client.Do(req)
 	if err != nil {
@@ -186,6 +253,11 @@ func (e *BaseEntry) requestHttpResBody(url string, offset int64, ctx context.Con
 	if res.StatusCode == http.StatusRequestedRangeNotSatisfiable {
 		return nil, nil
 	}
+	if res.StatusCode == http.StatusOK && ifRange != "" {
+		// If-Range mismatched, what we have downloaded is outdated
+		res.Body.Close()
+		return nil, ErrRemoteChanged
+	}
 	if res.StatusCode != http.StatusPartialContent {
 		return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
 	}
@@ -264,11 +336,11 @@ func (e *BaseEntry) Write(bytes []byte) (int, error) {
 	return e.workingFile.Append(bytes)
 }
 
-func (e *BaseEntry) UpdateReqRangeStart(start int64) {
+func (e *BaseEntry) UpdateReqRanges(ranges []rw_file.Range) {
 	e.workingFileMutex.RLock()
 	defer e.workingFileMutex.RUnlock()
 
 	if e.workingFile != nil {
-		e.workingFile.NotifyRequestStart(start)
+		e.workingFile.NotifyRequestRanges(ranges)
 	}
 }
@@ -2,13 +2,19 @@ package cache
 
 import (
 	"context"
+	"errors"
 	"io"
 	"time"
 
+	"github.com/wzhqwq/VRCDancePreloader/internal/persistence"
 	"github.com/wzhqwq/VRCDancePreloader/internal/requesting"
+	"github.com/wzhqwq/VRCDancePreloader/internal/rw_file"
 	"github.com/wzhqwq/VRCDancePreloader/internal/utils"
 )
 
+// a complete file confirmed fresh within this interval is not checked again
+const revalidationInterval = time.Hour
+
 type UrlBasedEntry struct {
 	BaseEntry
 
@@ -17,6 +23,11 @@ type UrlBasedEntry struct {
 
 	remoteModTime time.Time
 	remoteSize    int64
+
+	// validators of the current remote file
+	remoteETag        string
+	remoteModTimeText string
+	remoteNotModified bool
 }
 
 func newUrlBasedEntry(id string, client *requesting.ClientProvider, initialInfoGetter func(ctx context.Context) (*RemoteVideoInfo, error)) *UrlBasedEntry {
@@ -26,6 +37,17 @@ func newUrlBasedEntry(id string, client *requesting.ClientProvider, initialInfoG
 	}
 }
 
+// getConditionalValidation returns the recorded validators if the local file is worth revalidating
+func (e *UrlBasedEntry) getConditionalValidation() *persistence.CacheValidation {
+	if e.validation == nil || !e.validation.HasValidator() {
+		return nil
+	}
+	if !e.workingFile.IsComplete() && e.workingFile.GetDownloadedBytes() == 0 {
+		return nil
+	}
+	return e.validation
+}
+
 func (e *UrlBasedEntry) resolveRemoteMedia(ctx context.Context) error {
 	info, err := e.initialInfoGetter(ctx)
 	if err != nil {
@@ -34,6 +56,8 @@ func (e *UrlBasedEntry) resolveRemoteMedia(ctx context.Context) error {
 
 	url := info.FinalUrl
 	e.referer = ""
+	e.remoteNotModified = false
+	validation := e.getConditionalValidation()
 	if !info.LastModified.IsZero() {
 		// BiliBili provide creation time through API
 		// DuDuFitDance CDN does not response with Last-Modified, but provide "publishTime" in manifest
@@ -42,16 +66,23 @@ func (e *UrlBasedEntry) resolveRemoteMedia(ctx context.Context) error {
 
 	for {
 		if _, ok := utils.CheckYoutubeURL(url); ok {
-			// TODO youtube handler
+			// YouTube pages are resolved by yt-dlp in advance, a redirection to them can't be followed
 			return ErrNotSupported
 		}
-		info, err = e.requestHttpResInfo(url, ctx)
+		info, err = e.requestHttpResInfo(url, validation, ctx)
 		if err != nil {
+			if validation != nil {
+				persistence.RecordRevalidation(e.id, persistence.RevalidationFailed)
+			}
 			return err
 		}
 		changed := info.FinalUrl != url
 		url = info.FinalUrl
 
+		if info.NotModified {
+			break
+		}
+
 		// check if it's a real video, at least 1MB
 		if info.TotalSize > 1024*1024 {
 			break
@@ -61,81 +92,276 @@ func (e *UrlBasedEntry) resolveRemoteMedia(ctx context.Context) error {
 		}
 	}
 
+	if info.NotModified {
+		// the server confirmed our copy, so everything recorded last time is still valid
+		e.remoteNotModified = true
+		e.remoteSize = validation.FullSize
+		e.remoteETag = validation.ETag
+		e.remoteModTimeText = validation.LastModified
+		if e.remoteModTime.IsZero() {
+			e.remoteModTime = e.workingFile.ModTime()
+		}
+		e.resolvedUrl = url
+		e.logger.InfoLn(e.id, "resolved to", url, "and it's not modified")
+
+		return nil
+	}
+
 	if e.remoteModTime.IsZero() {
-		if info.LastModified.IsZero() {
+		if info.LastModified.IsZero() && info.ETag == "" {
 			e.logger.WarnLn("We cannot get the modified time of this file on the server, so it's not possible to check if the cache is expired")
 		}
 		e.remoteModTime = info.LastModified
 	}
 
 	e.remoteSize = info.TotalSize
+	e.remoteETag = info.ETag
+	e.remoteModTimeText = info.LastModifiedText
 	e.resolvedUrl = url
 	e.logger.InfoLn(e.id, "resolved to", url, "size:", e.remoteSize, "modified time:", e.remoteModTime.Local().String())
 
 	return nil
 }
 
+// isExpired compares the remote file with what we have, validators go first
+func (e *UrlBasedEntry) isExpired(localModTime time.Time) bool {
+	if e.remoteNotModified {
+		return false
+	}
+	if e.validation != nil && e.validation.ETag != "" && e.remoteETag != "" {
+		return e.validation.ETag != e.remoteETag || (e.validation.FullSize > 0 && e.validation.FullSize != e.remoteSize)
+	}
+	return !e.remoteModTime.IsZero() && e.remoteModTime.After(localModTime)
+}
+
+// recentlyRevalidated reports whether a complete file has been confirmed fresh lately, so no request is needed
+func (e *UrlBasedEntry) recentlyRevalidated() bool {
+	v := e.validation
+	if v == nil || v.CheckedAt.IsZero() {
+		return false
+	}
+	if v.Result != persistence.RevalidationNotModified && v.Result != persistence.RevalidationUnchanged {
+		return false
+	}
+	return time.Since(v.CheckedAt) < revalidationInterval
+}
+
+// saveValidation records the validators and the result of this check
+func (e *UrlBasedEntry) saveValidation(expired bool) {
+	hadValidator := e.validation != nil && e.validation.HasValidator()
+
+	if !e.remoteNotModified {
+		persistence.SaveCacheValidators(e.id, e.remoteETag, e.remoteModTimeText, e.remoteSize)
+	}
+
+	if hadValidator {
+		result := persistence.RevalidationUnchanged
+		if e.remoteNotModified {
+			result = persistence.RevalidationNotModified
+		} else if expired {
+			result = persistence.RevalidationModified
+		}
+		persistence.RecordRevalidation(e.id, result)
+	}
+
+	e.validation = persistence.GetCacheValidation(e.id)
+}
+
+// needsResolving reports whether checkWorkingFile would resolve the remote file, which may clear the working file or
+// replace it with the file of an alias
+func (e *UrlBasedEntry) needsResolving() bool {
+	if e.resolvedUrl != "" || IsServedOffline(e.id) {
+		// a file served offline is revalidated in background
+		return false
+	}
+	if !e.workingFile.IsComplete() {
+		return true
+	}
+	return forceExpirationCheck && !e.recentlyRevalidated()
+}
+
+// hasLocalCopy reports whether there is something to serve without the remote server
+func (e *UrlBasedEntry) hasLocalCopy() bool {
+	if e.workingFile.IsComplete() {
+		return true
+	}
+	return e.workingFile.TotalLen() > 0 && e.workingFile.GetDownloadedBytes() > 0
+}
+
+// rLockChecked checks the working file and returns with the read lock held, unless it fails. The working file is
+// resolved under the write lock, so that no reader or writer is using it when it's cleared or replaced.
+func (e *UrlBasedEntry) rLockChecked(ctx context.Context) error {
+	e.workingFileMutex.RLock()
+	if e.workingFile != nil && !e.needsResolving() {
+		if err := e.checkWorkingFile(ctx); err != nil {
+			e.workingFileMutex.RUnlock()
+			return err
+		}
+		return nil
+	}
+	e.workingFileMutex.RUnlock()
+
+	e.workingFileMutex.Lock()
+	err := e.checkWorkingFile(ctx)
+	e.workingFileMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	e.workingFileMutex.RLock()
+	if e.workingFile == nil {
+		// closed in between
+		e.workingFileMutex.RUnlock()
+		return io.ErrClosedPipe
+	}
+	return nil
+}
+
 func (e *UrlBasedEntry) checkWorkingFile(ctx context.Context) error {
 	if e.workingFile == nil {
 		return io.ErrClosedPipe
 	}
 
-	if e.workingFile.IsComplete() && !forceExpirationCheck {
+	if e.resolvedUrl != "" {
+		if e.workingFile.IsComplete() {
+			return nil
+		}
+		// nothing is requested, the remote info is applied again
+		return e.checkRemote(ctx)
+	}
+	if !e.needsResolving() {
 		// skip check unless we need to check Last-Modified
 		return nil
 	}
+	if !e.hasLocalCopy() {
+		return e.checkRemote(ctx)
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, offlineCheckTimeout)
+	defer cancel()
+
+	err := e.checkRemote(checkCtx)
+	if err != nil && ctx.Err() == nil && e.hasLocalCopy() {
+		// the remote server is unreachable or erroring, but we have a local copy
+		e.logger.WarnLn("Failed to check the remote file, serve the local copy offline:", err)
+		markServedOffline(e.id)
+		return nil
+	}
+	return err
+}
+
+// Revalidate checks the remote file again even if the local file is complete
+func (e *UrlBasedEntry) Revalidate(ctx context.Context) error {
+	// the file may be cleared or replaced, so no one should be using it
+	e.workingFileMutex.Lock()
+	defer e.workingFileMutex.Unlock()
+
+	if e.workingFile == nil {
+		return io.ErrClosedPipe
+	}
+
+	e.resolvedUrl = ""
+	if err := e.checkRemote(ctx); err != nil {
+		return err
+	}
+
+	markServedOnline(e.id)
+	return nil
+}
 
+// checkRemote compares the local file with the remote one, the file is cleared if it's expired
+func (e *UrlBasedEntry) checkRemote(ctx context.Context) error {
 	localModTime := e.workingFile.ModTime()
 
+	resolved := false
 	if e.resolvedUrl == "" {
 		// make sure that we have recorded Last-Modified and url
 		err := e.resolveRemoteMedia(ctx)
 		if err != nil {
 			return err
 		}
+		resolved = true
 	}
 
-	if !e.remoteModTime.IsZero() && e.remoteModTime.After(localModTime) {
+	expired := resolved && e.isExpired(localModTime)
+	if expired && e.aliasOf != "" {
+		// the video on this platform is no longer the same as the shared one
+		e.logger.WarnLn("Remote file differs from", e.aliasOf, "so we will download it separately")
+		err := e.dropAlias()
+		if err != nil {
+			return err
+		}
+	} else if expired {
 		// local cache is expired
 		e.logger.WarnLn("Local cache expired so we will re-download it completely")
 		err := e.workingFile.Clear()
 		if err != nil {
 			return err
 		}
+		persistence.RemoveCacheAliasesTo(e.id)
+	}
+
+	if resolved {
+		err := e.tryShareDuplicate(ctx)
+		if err != nil {
+			return err
+		}
 	}
 
-	e.workingFile.UpdateRemoteInfo(e.remoteSize, e.remoteModTime)
+	if e.aliasOf == "" {
+		e.workingFile.UpdateRemoteInfo(e.remoteSize, e.remoteModTime)
+	}
+	if resolved {
+		e.saveValidation(expired)
+		if recorder, ok := e.workingFile.(rw_file.SourceRecorder); ok && e.aliasOf == "" {
+			recorder.SetSource(e.resolvedUrl, e.remoteETag)
+		}
+		e.syncIndex(getOrigin(e.resolvedUrl))
+	}
 
 	return nil
 }
 
 func (e *UrlBasedEntry) ModTime() time.Time {
-	e.workingFileMutex.RLock()
-	defer e.workingFileMutex.RUnlock()
-
-	if err := e.checkWorkingFile(context.Background()); err != nil {
+	if err := e.rLockChecked(context.Background()); err != nil {
 		return unixEpochTime
 	}
+	defer e.workingFileMutex.RUnlock()
 
 	return e.workingFile.ModTime()
 }
 
 func (e *UrlBasedEntry) TotalLen() (int64, error) {
-	e.workingFileMutex.RLock()
-	defer e.workingFileMutex.RUnlock()
-
-	if err := e.checkWorkingFile(context.Background()); err != nil {
+	if err := e.rLockChecked(context.Background()); err != nil {
 		return 0, err
 	}
+	defer e.workingFileMutex.RUnlock()
 
 	return e.workingFile.TotalLen(), nil
 }
 func (e *UrlBasedEntry) GetDownloadStream(ctx context.Context) (io.ReadCloser, error) {
-	e.workingFileMutex.RLock()
+	body, url, err := e.openDownloadStream(ctx)
+	if errors.Is(err, ErrRemoteChanged) {
+		e.logger.WarnLn("Remote file changed while resuming, so we will re-download it completely")
+		persistence.RecordRevalidation(e.id, persistence.RevalidationModified)
+		if clearErr := e.clearChangedRemote(url); clearErr != nil {
+			return nil, clearErr
+		}
+	}
+	return body, err
+}
+
+// openDownloadStream requests the rest of the file under the read lock, the requested url is returned as well
+func (e *UrlBasedEntry) openDownloadStream(ctx context.Context) (io.ReadCloser, string, error) {
+	if err := e.rLockChecked(ctx); err != nil {
+		return nil, "", err
+	}
 	defer e.workingFileMutex.RUnlock()
 
-	if err := e.checkWorkingFile(ctx); err != nil {
-		return nil, err
+	url := e.resolvedUrl
+	if url == "" {
+		// served offline, the download continues after it's revalidated
+		return nil, "", ErrOffline
 	}
 
 	e.workingFile.MarkDownloading()
@@ -143,28 +369,47 @@ func (e *UrlBasedEntry) GetDownloadStream(ctx context.Context) (io.ReadCloser, e
 
 	e.logger.InfoLnf("Download %s start from %d, (total %d)", e.id, offset, e.workingFile.TotalLen())
 
-	return e.requestHttpResBody(e.resolvedUrl, offset, ctx)
+	if body := e.requestPeerBody(offset, ctx); body != nil {
+		return body, url, nil
+	}
+
+	body, err := e.requestHttpResBody(url, offset, ctx)
+	return body, url, err
+}
+
+// clearChangedRemote clears the working file under the write lock after the remote file at url changed, unless it
+// has been closed or resolved again in the meantime
+func (e *UrlBasedEntry) clearChangedRemote(url string) error {
+	e.workingFileMutex.Lock()
+	defer e.workingFileMutex.Unlock()
+
+	if e.workingFile == nil || e.resolvedUrl != url {
+		return nil
+	}
+	if err := e.workingFile.Clear(); err != nil {
+		return err
+	}
+	// resolve again for the new size and validators
+	e.resolvedUrl = ""
+	return nil
 }
 func (e *UrlBasedEntry) Reset() {
 	e.resolvedUrl = ""
 }
 func (e *UrlBasedEntry) GetReadSeeker(ctx context.Context) (io.ReadSeeker, error) {
-	e.workingFileMutex.RLock()
-	defer e.workingFileMutex.RUnlock()
-
-	if err := e.checkWorkingFile(ctx); err != nil {
+	if err := e.rLockChecked(ctx); err != nil {
 		return nil, err
 	}
+	defer e.workingFileMutex.RUnlock()
 
 	return e.getReadSeeker(ctx)
 }
 
 func (e *UrlBasedEntry) IsComplete() bool {
-	e.workingFileMutex.RLock()
-	defer e.workingFileMutex.RUnlock()
-
-	if err := e.checkWorkingFile(context.Background()); err != nil {
+	if err := e.rLockChecked(context.Background()); err != nil {
 		return false
 	}
+	defer e.workingFileMutex.RUnlock()
+
 	return e.workingFile.IsComplete()
 }
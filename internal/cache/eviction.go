@@ -0,0 +1,220 @@
+package cache
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/persistence"
+	"github.com/wzhqwq/VRCDancePreloader/internal/utils"
+)
+
+const (
+	EvictByModTime    = "mtime"
+	EvictByLastServed = "lru"
+	EvictByPlayCount  = "lfu"
+	EvictByScore      = "weighted"
+)
+
+// weights of the weighted eviction score, the one with the lowest score is evicted first
+const (
+	likeWeight     = 2.0
+	skillWeight    = 1.0
+	favoriteWeight = 10.0
+	playWeight     = 0.5
+	// every 100MB takes one point away
+	sizeWeight = -1.0 / (100 * 1024 * 1024)
+)
+
+type EvictionCandidate struct {
+	ID      string
+	Format  string
+	Size    int64
+	ModTime time.Time
+
+	LastServed time.Time
+	ServeCount int
+	PlayCount  int
+
+	Like       int
+	Skill      int
+	IsFavorite bool
+}
+
+func (c *EvictionCandidate) Score() float64 {
+	score := float64(c.Like)*likeWeight + float64(c.Skill)*skillWeight + float64(c.PlayCount)*playWeight
+	if c.IsFavorite {
+		score += favoriteWeight
+	}
+	return score + float64(c.Size)*sizeWeight
+}
+
+// CandidateData is the information that is loaded into candidates besides the cache index
+type CandidateData int
+
+const (
+	NeedsPlayCount CandidateData = 1 << iota
+	// like, skill and favorite of the song
+	NeedsPreference
+)
+
+type EvictionPolicy interface {
+	// Name is the value used in config file
+	Name() string
+	// Needs tells the extra information compared by the policy
+	Needs() CandidateData
+	// Less reports whether a should be evicted before b
+	Less(a, b *EvictionCandidate) bool
+	// Reason explains why the candidate is chosen
+	Reason(c *EvictionCandidate) string
+}
+
+type modTimePolicy struct{}
+
+func (modTimePolicy) Name() string {
+	return EvictByModTime
+}
+func (modTimePolicy) Needs() CandidateData {
+	return 0
+}
+func (modTimePolicy) Less(a, b *EvictionCandidate) bool {
+	return a.ModTime.Before(b.ModTime)
+}
+func (modTimePolicy) Reason(c *EvictionCandidate) string {
+	return fmt.Sprintf("oldest file, modified at %s", c.ModTime.Local().Format(time.DateTime))
+}
+
+type lastServedPolicy struct{}
+
+func (lastServedPolicy) Name() string {
+	return EvictByLastServed
+}
+func (lastServedPolicy) Needs() CandidateData {
+	return 0
+}
+func (lastServedPolicy) Less(a, b *EvictionCandidate) bool {
+	if a.LastServed.Equal(b.LastServed) {
+		return a.ModTime.Before(b.ModTime)
+	}
+	return a.LastServed.Before(b.LastServed)
+}
+func (lastServedPolicy) Reason(c *EvictionCandidate) string {
+	if c.LastServed.IsZero() {
+		return "never served"
+	}
+	return fmt.Sprintf("least recently served, last served at %s", c.LastServed.Local().Format(time.DateTime))
+}
+
+type playCountPolicy struct{}
+
+func (playCountPolicy) Name() string {
+	return EvictByPlayCount
+}
+func (playCountPolicy) Needs() CandidateData {
+	return NeedsPlayCount
+}
+func (playCountPolicy) Less(a, b *EvictionCandidate) bool {
+	if a.PlayCount == b.PlayCount {
+		return a.LastServed.Before(b.LastServed)
+	}
+	return a.PlayCount < b.PlayCount
+}
+func (playCountPolicy) Reason(c *EvictionCandidate) string {
+	return fmt.Sprintf("least frequently played, played %d times", c.PlayCount)
+}
+
+type scorePolicy struct{}
+
+func (scorePolicy) Name() string {
+	return EvictByScore
+}
+func (scorePolicy) Needs() CandidateData {
+	return NeedsPlayCount | NeedsPreference
+}
+func (scorePolicy) Less(a, b *EvictionCandidate) bool {
+	sa, sb := a.Score(), b.Score()
+	if sa == sb {
+		return a.LastServed.Before(b.LastServed)
+	}
+	return sa < sb
+}
+func (scorePolicy) Reason(c *EvictionCandidate) string {
+	return fmt.Sprintf(
+		"lowest score %.2f (like %d, skill %d, favorite %t, played %d times, %s)",
+		c.Score(), c.Like, c.Skill, c.IsFavorite, c.PlayCount, utils.PrettyByteSize(c.Size),
+	)
+}
+
+var evictionPolicies = []EvictionPolicy{
+	modTimePolicy{},
+	lastServedPolicy{},
+	playCountPolicy{},
+	scorePolicy{},
+}
+
+func GetEvictionPolicy(name string) EvictionPolicy {
+	for _, p := range evictionPolicies {
+		if p.Name() == name {
+			return p
+		}
+	}
+	return modTimePolicy{}
+}
+
+func AllEvictionPolicies() []string {
+	names := make([]string, len(evictionPolicies))
+	for i, p := range evictionPolicies {
+		names[i] = p.Name()
+	}
+	return names
+}
+
+func sortCandidates(candidates []*EvictionCandidate, policy EvictionPolicy) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return policy.Less(candidates[i], candidates[j])
+	})
+}
+
+// fillCandidates loads the extra information required by the policy
+func fillCandidates(candidates []*EvictionCandidate, policy EvictionPolicy) {
+	needs := policy.Needs()
+
+	if needs&NeedsPlayCount != 0 {
+		playCounts, err := persistence.GetLocalRecords().CountPlays()
+		if err != nil {
+			managerLogger.ErrorLn("Failed to count plays:", err)
+		}
+		for _, c := range candidates {
+			c.PlayCount = playCounts[c.ID]
+		}
+	}
+
+	if needs&NeedsPreference != 0 {
+		for _, c := range candidates {
+			if entry, err := persistence.GetEntry(c.ID); err == nil {
+				c.Like = entry.Like
+				c.Skill = entry.Skill
+				c.IsFavorite = entry.IsFavorite
+			}
+		}
+	}
+}
+
+type EvictedFile struct {
+	ID     string
+	Format string
+	Size   int64
+	Reason string
+}
+
+type CleanupReport struct {
+	Policy     string
+	Time       time.Time
+	SizeBefore int64
+	SizeAfter  int64
+	Removed    []EvictedFile
+}
+
+func (r *CleanupReport) FreedSize() int64 {
+	return r.SizeBefore - r.SizeAfter
+}
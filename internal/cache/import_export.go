@@ -0,0 +1,298 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/persistence"
+	"github.com/wzhqwq/VRCDancePreloader/internal/requesting"
+	"github.com/wzhqwq/VRCDancePreloader/internal/song/raw_song"
+	"github.com/wzhqwq/VRCDancePreloader/internal/utils"
+)
+
+var ErrUnknownVideo = errors.New("cannot tell which song this file is")
+
+// <id>.mp4, the name used by this program
+var idFileNameRegex = regexp.MustCompile(`^((?:pypy|yt|wanna|dudu|bili)_[0-9A-Za-z_-]+)\.mp4$`)
+
+// names like "PyPy 123.mp4" or "wanna-123 title.mp4"
+var platformNumberRegex = regexp.MustCompile(`(?i)(pypy|wanna|dudu)[\s_#-]*(\d+)`)
+var bvIdRegex = regexp.MustCompile(`BV[0-9A-Za-z]{10}`)
+
+// names like "123.mp4" or "123 - title.mp4", the platform has to be guessed
+var leadingNumberRegex = regexp.MustCompile(`^(\d+)\b`)
+
+var numberedPlatforms = []string{"pypy", "wanna", "dudu"}
+
+type ImportResult struct {
+	Path string
+	ID   string
+	// how the id is found
+	MatchedBy string
+	Err       error
+}
+
+func getNumberedVideo(platform string, num int) (string, *requesting.ClientProvider) {
+	switch platform {
+	case "pypy":
+		return utils.GetPyPyVideoUrl(num), requesting.GetClient(requesting.PyPyDance)
+	case "wanna":
+		return utils.GetWannaVideoUrl(num), requesting.GetClient(requesting.WannaDance)
+	case "dudu":
+		return utils.GetDuDuVideoUrl(num), requesting.GetClient(requesting.DuDuFitDance)
+	}
+	return "", nil
+}
+
+// matchRemoteVideo asks the platform for the video and compares it with the local file
+func matchRemoteVideo(platform string, num int, stat os.FileInfo) (bool, error) {
+	url, client := getNumberedVideo(platform, num)
+	if client == nil {
+		return false, ErrNotSupported
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	res, err := client.Head(url, ctx)
+	if err != nil {
+		return false, err
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("unexpected status %s", res.Status)
+	}
+
+	if res.ContentLength > 0 {
+		return res.ContentLength == stat.Size(), nil
+	}
+	// no size provided, the file should be at least as new as the remote one
+	if lastModified, err := http.ParseTime(res.Header.Get("Last-Modified")); err == nil {
+		return !lastModified.After(stat.ModTime()), nil
+	}
+	return false, nil
+}
+
+// identifyVideoFile finds out the id of a video file by its name, platform is used when only a number is found
+func identifyVideoFile(path string, stat os.FileInfo, platform string) (string, string, error) {
+	name := filepath.Base(path)
+
+	if matches := idFileNameRegex.FindStringSubmatch(name); len(matches) > 1 {
+		return matches[1], "file name", nil
+	}
+	if matches := platformNumberRegex.FindStringSubmatch(name); len(matches) > 2 {
+		return strings.ToLower(matches[1]) + "_" + matches[2], "file name", nil
+	}
+	if bvId := bvIdRegex.FindString(name); bvId != "" {
+		return "bili_" + bvId, "file name", nil
+	}
+
+	matches := leadingNumberRegex.FindStringSubmatch(strings.TrimSuffix(name, filepath.Ext(name)))
+	if len(matches) < 2 {
+		return "", "", ErrUnknownVideo
+	}
+	num, err := strconv.Atoi(matches[1])
+	if err != nil {
+		return "", "", ErrUnknownVideo
+	}
+
+	platforms := numberedPlatforms
+	if platform != "" {
+		platforms = []string{platform}
+	}
+	var lastErr error
+	for _, p := range platforms {
+		ok, err := matchRemoteVideo(p, num, stat)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if ok {
+			return fmt.Sprintf("%s_%d", p, num), "remote file", nil
+		}
+	}
+	if lastErr != nil {
+		return "", "", fmt.Errorf("%w: %w", ErrUnknownVideo, lastErr)
+	}
+	return "", "", ErrUnknownVideo
+}
+
+func importVideoFile(path string, platform string) ImportResult {
+	result := ImportResult{Path: path}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	result.ID, result.MatchedBy, result.Err = identifyVideoFile(path, stat, platform)
+	if result.Err != nil {
+		return result
+	}
+
+	if f, err := persistence.GetCacheFile(result.ID); err == nil && f.IsComplete {
+		result.Err = ErrAlreadyCached
+		return result
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer src.Close()
+
+	result.Err = writeCacheFile(result.ID, src, stat.Size(), stat.ModTime())
+	return result
+}
+
+// ImportVideoFiles copies the mp4 files in the directory into cache, converting them to the configured format.
+// Files only named by a number are matched against platform (or all numbered platforms if it's empty) remotely.
+func ImportVideoFiles(dir string, platform string) []ImportResult {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		managerLogger.ErrorLn("Failed to read import directory:", err)
+		return nil
+	}
+
+	var results []ImportResult
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.EqualFold(filepath.Ext(entry.Name()), ".mp4") {
+			continue
+		}
+
+		result := importVideoFile(filepath.Join(dir, entry.Name()), platform)
+		if result.Err != nil {
+			managerLogger.WarnLn("Skipped", entry.Name(), ":", result.Err)
+		} else {
+			managerLogger.InfoLn("Imported", entry.Name(), "as", result.ID, "by", result.MatchedBy)
+		}
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// GetSongTitle finds the title of a cached song, falls back to the id
+func GetSongTitle(id string) string {
+	entry, err := persistence.GetEntry(id)
+	if err == nil && entry.Title != "" {
+		return entry.Title
+	}
+
+	if pypyId, ok := utils.CheckIdIsPyPy(id); ok {
+		if song, ok := raw_song.FindPyPySong(pypyId); ok {
+			return song.Name
+		}
+	}
+	if wannaId, ok := utils.CheckIdIsWanna(id); ok {
+		if song, ok := raw_song.FindWannaSong(wannaId); ok {
+			return song.FullTitle()
+		}
+	}
+	if duduId, ok := utils.CheckIdIsDuDu(id); ok {
+		if song, ok := raw_song.FindDuDuSong(duduId); ok {
+			return song.FullTitle()
+		}
+	}
+	return id
+}
+
+var invalidFileNameChars = regexp.MustCompile(`[<>:"/\\|?*\x00-\x1f]`)
+
+func sanitizeFileName(name string) string {
+	name = invalidFileNameChars.ReplaceAllString(name, "_")
+	name = strings.TrimRight(strings.TrimSpace(name), ".")
+	if len(name) > 150 {
+		name = strings.ToValidUTF8(name[:150], "")
+	}
+	return name
+}
+
+type ExportResult struct {
+	ID   string
+	Path string
+	Err  error
+}
+
+func exportCacheFile(id string, dir string) ExportResult {
+	result := ExportResult{ID: id}
+
+	src, size, err := openCompleteFile(id)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer src.Close()
+
+	name := sanitizeFileName(GetSongTitle(id))
+	if name == "" {
+		name = id
+	}
+	result.Path = filepath.Join(dir, name+".mp4")
+	if _, err := os.Stat(result.Path); err == nil {
+		result.Path = filepath.Join(dir, fmt.Sprintf("%s (%s).mp4", name, id))
+	}
+
+	dst, err := os.Create(result.Path)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	n, err := io.CopyBuffer(dst, src, make([]byte, copyBufferSize))
+	closeErr := dst.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil && n != size {
+		err = ErrSizeMismatch
+	}
+	if err != nil {
+		_ = os.Remove(result.Path)
+		result.Err = err
+	}
+	return result
+}
+
+// ExportCachedSongs writes complete cache files into the directory as plain mp4 files named by titles,
+// all complete files are exported if ids is empty
+func ExportCachedSongs(ids []string, dir string) []ExportResult {
+	if len(ids) == 0 {
+		for _, f := range persistence.ListCacheFiles() {
+			if f.IsComplete {
+				ids = append(ids, f.ID)
+			}
+		}
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		managerLogger.ErrorLn("Failed to create export directory:", err)
+		return nil
+	}
+
+	var results []ExportResult
+	for _, id := range ids {
+		result := exportCacheFile(id, dir)
+		if result.Err != nil {
+			managerLogger.WarnLn("Failed to export", id, ":", result.Err)
+		} else {
+			managerLogger.InfoLn("Exported", id, "to", result.Path)
+		}
+		results = append(results, result)
+	}
+
+	return results
+}
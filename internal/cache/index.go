@@ -0,0 +1,218 @@
+package cache
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/persistence"
+	"github.com/wzhqwq/VRCDancePreloader/internal/rw_file/trunk"
+	"github.com/wzhqwq/VRCDancePreloader/internal/types"
+)
+
+const (
+	AllCacheFileRegex      = `^((?:pypy|yt|wanna|dudu|bili)_.+)\.(?:mp4|mp4\.(?:dl|vrcdp))$`
+	CompleteCacheFileRegex = `^((?:pypy|yt|wanna|dudu|bili)_.+)\.mp4$`
+	PartialCacheFileRegex  = `^((?:pypy|yt|wanna|dudu|bili)_.+)\.mp4\.(?:dl|vrcdp)$`
+)
+
+var allCacheFileRegex = regexp.MustCompile(AllCacheFileRegex)
+
+// the index is flushed at most once per interval, since a downloading file is written continuously
+const indexSyncInterval = time.Second
+
+var pendingSyncIds = make(map[string]struct{})
+var pendingSyncMutex sync.Mutex
+
+func matchCacheFile(fileName string) (string, bool) {
+	matches := allCacheFileRegex.FindStringSubmatch(fileName)
+	if len(matches) == 0 {
+		return "", false
+	}
+	return matches[1], true
+}
+
+// getCacheFilePaths returns the paths of legacy file, downloading legacy file and trunk file
+func getCacheFilePaths(id string) []string {
+	base := filepath.Join(cachePath, id+".mp4")
+	return []string{base, base + ".dl", base + ".vrcdp"}
+}
+
+// readCacheFile collects the information of a cache file on disk, nil if there's no file for this id
+func readCacheFile(id string) *persistence.CacheFile {
+	f := readWorkingCacheFile(id)
+	if f == nil {
+		return nil
+	}
+
+	// stale files in other formats also take up space
+	f.Size = 0
+	for _, path := range getCacheFilePaths(id) {
+		if stat, err := os.Stat(path); err == nil {
+			f.Size += stat.Size()
+		}
+	}
+
+	return f
+}
+
+func readWorkingCacheFile(id string) *persistence.CacheFile {
+	paths := getCacheFilePaths(id)
+	legacyPath, legacyDlPath, trunkPath := paths[0], paths[1], paths[2]
+
+	// legacy files go first, just like BaseEntry.checkLegacy
+	if stat, err := os.Stat(legacyPath); err == nil {
+		return &persistence.CacheFile{
+			ID:         id,
+			Format:     types.CacheFormatLegacy,
+			Size:       stat.Size(),
+			FullSize:   stat.Size(),
+			Downloaded: stat.Size(),
+			IsComplete: true,
+			ModTime:    stat.ModTime(),
+		}
+	}
+	if stat, err := os.Stat(legacyDlPath); err == nil {
+		return &persistence.CacheFile{
+			ID:         id,
+			Format:     types.CacheFormatLegacy,
+			Size:       stat.Size(),
+			Downloaded: stat.Size(),
+			ModTime:    stat.ModTime(),
+		}
+	}
+	if stat, err := os.Stat(trunkPath); err == nil {
+		f := &persistence.CacheFile{
+			ID:      id,
+			Format:  types.CacheFormatTrunk,
+			Size:    stat.Size(),
+			ModTime: stat.ModTime(),
+		}
+		info, err := trunk.Stat(trunkPath)
+		if err == nil {
+			f.FullSize = info.FullSize
+			f.Downloaded = info.DownloadedBytes
+			f.IsComplete = info.Completed
+		} else if !errors.Is(err, trunk.ErrCorrupted) {
+			managerLogger.WarnLn("Failed to read header of", trunkPath, ":", err)
+		}
+		return f
+	}
+
+	return nil
+}
+
+func syncIndex(id string) {
+	f := readCacheFile(id)
+	if f == nil {
+		persistence.RemoveCacheFile(id)
+		localFileEm.NotifySubscribers("-" + id)
+		return
+	}
+	persistence.SaveCacheFile(f)
+	if f.IsComplete {
+		scheduleFingerprint(id)
+	}
+	localFileEm.NotifySubscribers("+" + id)
+}
+
+// RebuildCacheIndex scans the whole cache directory and makes the index consistent with it
+func RebuildCacheIndex() {
+	entries, err := os.ReadDir(cachePath)
+	if err != nil {
+		managerLogger.ErrorLn("Failed to read cache directory:", err)
+		return
+	}
+
+	onDisk := make(map[string]struct{})
+	for _, entry := range entries {
+		if id, ok := matchCacheFile(entry.Name()); ok {
+			onDisk[id] = struct{}{}
+		}
+	}
+
+	for id := range onDisk {
+		if f := readCacheFile(id); f != nil {
+			persistence.SaveCacheFile(f)
+		}
+	}
+	for id := range persistence.ListCacheFileIds() {
+		if _, ok := onDisk[id]; !ok {
+			persistence.RemoveCacheFile(id)
+		}
+	}
+
+	managerLogger.InfoLnf("Indexed %d cache files", len(onDisk))
+	localFileEm.NotifySubscribers("rebuild")
+}
+
+func scheduleIndexSync(id string) {
+	pendingSyncMutex.Lock()
+	defer pendingSyncMutex.Unlock()
+
+	pendingSyncIds[id] = struct{}{}
+}
+
+func flushIndexSync() {
+	pendingSyncMutex.Lock()
+	ids := pendingSyncIds
+	pendingSyncIds = make(map[string]struct{})
+	pendingSyncMutex.Unlock()
+
+	for id := range ids {
+		syncIndex(id)
+	}
+}
+
+func indexSyncLoop(stopCh chan struct{}) {
+	ticker := time.NewTicker(indexSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			flushIndexSync()
+			return
+		case <-ticker.C:
+			flushIndexSync()
+		}
+	}
+}
+
+func getOrigin(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// syncIndex writes what the entry knows into the index (please wrap with mutex by yourself and check workingFile first!!)
+func (e *BaseEntry) syncIndex(origin string) {
+	f := readCacheFile(e.id)
+	if f == nil {
+		return
+	}
+
+	f.Origin = origin
+	if fullSize := e.workingFile.TotalLen(); fullSize > 0 {
+		f.FullSize = fullSize
+	}
+	f.Downloaded = e.workingFile.GetDownloadedBytes()
+	f.IsComplete = e.workingFile.IsComplete()
+
+	persistence.SaveCacheFile(f)
+	if f.IsComplete {
+		scheduleFingerprint(e.id)
+	}
+}
+
+func cacheFileToInfo(f *persistence.CacheFile) types.CacheFileInfo {
+	info := f.ToInfo()
+	info.IsActive = cacheMap.IsActive(f.ID)
+	return info
+}
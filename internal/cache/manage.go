@@ -1,36 +1,39 @@
 package cache
 
 import (
+	"context"
 	"os"
 	"path/filepath"
-	"regexp"
-	"sort"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"github.com/samber/lo"
 	"github.com/wzhqwq/VRCDancePreloader/internal/persistence"
+	"github.com/wzhqwq/VRCDancePreloader/internal/rw_file/trunk"
 	"github.com/wzhqwq/VRCDancePreloader/internal/types"
 	"github.com/wzhqwq/VRCDancePreloader/internal/utils"
 )
 
-const (
-	AllCacheFileRegex      = `^((?:pypy|yt|wanna|bili)_.+)\.(?:mp4|mp4\.(?:dl|vrcdp))$`
-	CompleteCacheFileRegex = `^((?:pypy|yt|wanna|bili)_.+)\.mp4$`
-	PartialCacheFileRegex  = `^((?:pypy|yt|wanna|bili)_.+)\.mp4\.(?:dl|vrcdp)$`
-)
-
 var cachePath string
 var maxSize int64
 var keepFavorites bool
 var fileFormat int
 var forceExpirationCheck bool
+var evictionPolicy EvictionPolicy = modTimePolicy{}
 
 var cacheMap = NewCacheMap()
 var cleanUpChan = make(chan struct{}, 1)
 
+var lastReport *CleanupReport
+var lastReportMutex sync.Mutex
+
 var localFileEm = utils.NewEventManager[string]()
 var dirWatcher *fsnotify.Watcher
+var indexStopCh chan struct{}
+
+// the loops started by SetupCache, StopCache waits for them before the database is closed
+var cacheLoopsWg sync.WaitGroup
 
 var managerLogger = utils.NewLogger("Cache Manager")
 
@@ -41,8 +44,29 @@ func SetupCache(path string) {
 
 	cachePath = path
 
+	RebuildCacheIndex()
+	stopCh := make(chan struct{})
+	indexStopCh = stopCh
+	cacheLoopsWg.Add(2)
+	go func() {
+		defer cacheLoopsWg.Done()
+		indexSyncLoop(stopCh)
+	}()
+	go func() {
+		defer cacheLoopsWg.Done()
+		fingerprintLoop(stopCh)
+	}()
+
+	watcher, err := newCacheDirWatcher()
+	if err != nil {
+		managerLogger.ErrorLn("Failed to watch cache directory:", err)
+		return
+	}
+	dirWatcher = watcher
+	cacheLoopsWg.Add(1)
 	go func() {
-		err := watchCacheDir()
+		defer cacheLoopsWg.Done()
+		err := watchCacheDir(watcher)
 		if err != nil {
 			managerLogger.ErrorLn("Failed to watch cache directory:", err)
 		}
@@ -50,10 +74,22 @@ func SetupCache(path string) {
 }
 
 func StopCache() {
+	StopMigration()
+	stopRevalidations()
 	if dirWatcher != nil {
 		dirWatcher.Close()
+		dirWatcher = nil
+	}
+	if indexStopCh != nil {
+		close(indexStopCh)
+		indexStopCh = nil
 	}
+	cacheLoopsWg.Wait()
+
 	CleanUpCache()
+	// wait for the cleanup to release the slot
+	cleanUpChan <- struct{}{}
+	<-cleanUpChan
 }
 
 func SetMaxSize(size int64) {
@@ -67,11 +103,23 @@ func SetKeepFavorites(b bool) {
 	keepFavorites = b
 }
 func SetFileFormat(format int) {
+	changed := fileFormat != format
 	fileFormat = format
+	if changed && migrationEnabled {
+		StartMigration()
+	}
+}
+func SetTrunkSize(sizeInKb int) {
+	if sizeInKb > 0 {
+		trunk.SetTrunkSize(int64(sizeInKb) * 1024)
+	}
 }
 func SetForceExpirationCheck(b bool) {
 	forceExpirationCheck = b
 }
+func SetEvictionPolicy(name string) {
+	evictionPolicy = GetEvictionPolicy(name)
+}
 
 func CleanUpCache() {
 	// Only one cleanup operation can be running at a time
@@ -84,115 +132,151 @@ func CleanUpCache() {
 				<-cleanUpChan
 			}()
 
-			// remove files from cache until total size is less than maxSize
-			entries, err := os.ReadDir(cachePath)
-			if err != nil {
+			report := cleanUpCache()
+			if report == nil {
 				return
 			}
 
-			files := make([]os.FileInfo, len(entries))
-			totalSize := int64(0)
-			for i, entry := range entries {
-				files[i], _ = entry.Info()
-				totalSize += files[i].Size()
-			}
-			if totalSize <= maxSize {
-				return
-			}
+			lastReportMutex.Lock()
+			lastReport = report
+			lastReportMutex.Unlock()
+			// the cache window shows the latest report
+			localFileEm.NotifySubscribers("cleanup")
 
-			// sort entries by last modified time
-			sort.Slice(files, func(i, j int) bool {
-				return files[i].ModTime().Before(files[j].ModTime())
-			})
-
-			// remove files until total size is less than maxSize
-			for _, file := range files {
-				if totalSize < maxSize {
-					break
-				}
-
-				id := strings.Split(file.Name(), ".")[0]
-				if cacheMap.IsActive(id) {
-					continue
-				}
-				if keepFavorites && persistence.IsFavorite(id) {
-					continue
-				}
-				if persistence.IsInAllowList(id) {
-					continue
-				}
-
-				err := os.Remove(filepath.Join(cachePath, file.Name()))
-				if err != nil {
-					managerLogger.WarnLn("Failed to remove ", file.Name(), ":", err)
-				}
-				totalSize -= file.Size()
+			for _, file := range report.Removed {
+				managerLogger.InfoLnf("Removed %s (%s), reason: %s", file.ID, utils.PrettyByteSize(file.Size), file.Reason)
 			}
+			managerLogger.InfoLnf(
+				"Cleanup with policy %s removed %d files, freed %s",
+				report.Policy, len(report.Removed), utils.PrettyByteSize(report.FreedSize()),
+			)
 		}()
 	default:
 	}
 }
 
-func GetLocalCacheInfos() []types.CacheFileInfo {
-	entries, err := os.ReadDir(cachePath)
-	if err != nil {
+func cleanUpCache() *CleanupReport {
+	files := persistence.ListCacheFiles()
+	totalSize := lo.SumBy(files, func(f *persistence.CacheFile) int64 {
+		return f.Size
+	})
+	usage := sumPlatformUsage(files)
+	if totalSize <= maxSize && !anyQuotaExceeded(usage) {
 		return nil
 	}
 
-	var infos []types.CacheFileInfo
-	for _, entry := range entries {
-		matches := regexp.MustCompile(AllCacheFileRegex).FindStringSubmatch(entry.Name())
-		if len(matches) == 0 {
-			continue
+	candidates := lo.Map(files, func(f *persistence.CacheFile, _ int) *EvictionCandidate {
+		return &EvictionCandidate{
+			ID:         f.ID,
+			Format:     f.Format,
+			Size:       f.Size,
+			ModTime:    f.ModTime,
+			LastServed: f.LastServed,
+			ServeCount: f.ServeCount,
+		}
+	})
+
+	policy := evictionPolicy
+	fillCandidates(candidates, policy)
+	sortCandidates(candidates, policy)
+
+	report := &CleanupReport{
+		Policy:     policy.Name(),
+		Time:       time.Now(),
+		SizeBefore: totalSize,
+	}
+
+	removed := make(map[string]bool)
+	evict := func(c *EvictionCandidate, reason string) bool {
+		if removed[c.ID] {
+			return false
+		}
+		if cacheMap.IsActive(c.ID) || aliasInUse(c.ID) {
+			return false
+		}
+		if keepFavorites && persistence.IsFavorite(c.ID) {
+			return false
+		}
+		if persistence.IsInAllowList(c.ID) {
+			return false
 		}
-		id := matches[1]
-		info, err := entry.Info()
+		if aliasProtected(c.ID) {
+			return false
+		}
+
+		err := removeLocalFiles(c.ID)
 		if err != nil {
-			continue
+			managerLogger.WarnLn("Failed to remove ", c.ID, ":", err)
+			return false
 		}
-		infos = append(infos, types.CacheFileInfo{
-			ID:        id,
-			Size:      info.Size(),
-			IsActive:  cacheMap.IsActive(id),
-			IsPartial: strings.HasSuffix(entry.Name(), ".dl"),
+		removed[c.ID] = true
+		totalSize -= c.Size
+		usage[getPlatform(c.ID)] -= c.Size
+
+		report.Removed = append(report.Removed, EvictedFile{
+			ID:     c.ID,
+			Format: c.Format,
+			Size:   c.Size,
+			Reason: reason,
 		})
+		return true
 	}
 
-	sort.Slice(infos, func(i, j int) bool {
-		return infos[i].Size > infos[j].Size
-	})
-
-	return infos
-}
-
-func GetLocalCacheInfo(id string) types.CacheFileInfo {
-	entries, err := os.ReadDir(cachePath)
-
-	if err == nil {
-		for _, entry := range entries {
-			matches := regexp.MustCompile(AllCacheFileRegex).FindStringSubmatch(entry.Name())
-			if len(matches) == 0 || matches[0] != id {
-				continue
+	// platform quotas go first
+	for _, platform := range Platforms {
+		quota := GetPlatformQuota(platform)
+		if quota == 0 {
+			continue
+		}
+		for _, c := range candidates {
+			if usage[platform] <= quota {
+				break
 			}
-			info, err := entry.Info()
-			if err != nil {
+			if getPlatform(c.ID) != platform {
 				continue
 			}
-			return types.CacheFileInfo{
-				ID:        id,
-				Size:      info.Size(),
-				IsActive:  cacheMap.IsActive(id),
-				IsPartial: strings.HasSuffix(entry.Name(), ".dl"),
-			}
+			evict(c, policy.Reason(c)+", quota of "+platform+" exceeded")
+		}
+	}
+
+	// remove files until total size is less than maxSize
+	for _, c := range candidates {
+		if totalSize < maxSize {
+			break
 		}
+		evict(c, policy.Reason(c))
 	}
 
-	return types.CacheFileInfo{
-		ID:        id,
-		Size:      0,
-		IsActive:  false,
-		IsPartial: false,
+	report.SizeAfter = totalSize
+	return report
+}
+
+// GetLastCleanupReport returns the report of the latest cleanup which has removed something, or nil
+func GetLastCleanupReport() *CleanupReport {
+	lastReportMutex.Lock()
+	defer lastReportMutex.Unlock()
+
+	return lastReport
+}
+
+func GetLocalCacheInfos() []types.CacheFileInfo {
+	return lo.Map(persistence.ListCacheFiles(), func(f *persistence.CacheFile, _ int) types.CacheFileInfo {
+		return cacheFileToInfo(f)
+	})
+}
+
+func GetLocalCacheInfo(id string) types.CacheFileInfo {
+	f, err := persistence.GetCacheFile(id)
+	if err != nil {
+		return types.CacheFileInfo{
+			ID:        id,
+			Size:      0,
+			IsActive:  false,
+			IsPartial: false,
+		}
 	}
+
+	return cacheFileToInfo(f)
 }
 
 func RemoveLocalCacheById(id string) error {
@@ -200,34 +284,38 @@ func RemoveLocalCacheById(id string) error {
 		return nil
 	}
 
-	videoTrunkPath := filepath.Join(cachePath, id+".mp4.vrcdp")
-	videoPath := filepath.Join(cachePath, id+".mp4")
-	videoDlPath := filepath.Join(cachePath, id+".mp4.dl")
+	return removeLocalFiles(id)
+}
 
-	if _, err := os.Stat(videoTrunkPath); err == nil {
-		err := os.Remove(videoTrunkPath)
-		if err != nil {
-			return err
-		}
-	}
-	if _, err := os.Stat(videoPath); err == nil {
-		err := os.Remove(videoPath)
-		if err != nil {
-			return err
-		}
+// removeLocalFiles removes everything about the cache of this id, including aliases sharing it
+func removeLocalFiles(id string) error {
+	err := removeCacheFiles(id)
+	if err != nil {
+		return err
 	}
-	if _, err := os.Stat(videoDlPath); err == nil {
-		err := os.Remove(videoDlPath)
-		if err != nil {
-			return err
+	persistence.RemoveCacheValidation(id)
+	persistence.RemoveCacheAliasesTo(id)
+	return nil
+}
+
+// removeCacheFiles removes the files of this id and its index
+func removeCacheFiles(id string) error {
+	for _, path := range getCacheFilePaths(id) {
+		if _, err := os.Stat(path); err == nil {
+			err := os.Remove(path)
+			if err != nil {
+				return err
+			}
 		}
 	}
+	persistence.RemoveCacheFile(id)
 	return nil
 }
 
-func OpenCacheEntry(id string, logger utils.LoggerImpl) (Entry, error) {
+// OpenCacheEntry references the entry of id, it waits while the file is rewritten, e.g. converted by the migration
+func OpenCacheEntry(ctx context.Context, id string, logger utils.LoggerImpl) (Entry, error) {
 	logger.InfoLn("Open cache entry:", id)
-	return cacheMap.Open(id)
+	return cacheMap.Open(ctx, id)
 }
 
 func ReleaseCacheEntry(id string, logger utils.LoggerImpl) {
@@ -246,22 +334,23 @@ func SubscribeLocalFileEvent() *utils.EventSubscriber[string] {
 	return localFileEm.SubscribeEvent()
 }
 
-func watchCacheDir() error {
+func newCacheDirWatcher() (*fsnotify.Watcher, error) {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer watcher.Close()
 
 	err = watcher.Add(cachePath)
 	if err != nil {
-		return err
+		watcher.Close()
+		return nil, err
 	}
+	return watcher, nil
+}
 
-	dirWatcher = watcher
-	defer func() {
-		dirWatcher = nil
-	}()
+// watchCacheDir indexes the files changed by others until the watcher is closed
+func watchCacheDir(watcher *fsnotify.Watcher) error {
+	defer watcher.Close()
 
 	managerLogger.InfoLn("Watching directory:", cachePath)
 
@@ -272,19 +361,15 @@ func watchCacheDir() error {
 				return nil
 			}
 
-			path := event.Name
-			fileName := filepath.Base(path)
-			matches := regexp.MustCompile(AllCacheFileRegex).FindStringSubmatch(fileName)
-			if len(matches) == 0 {
+			id, ok := matchCacheFile(filepath.Base(event.Name))
+			if !ok {
 				continue
 			}
-			id := matches[1]
-			if event.Op.Has(fsnotify.Write) {
-				localFileEm.NotifySubscribers("+" + id)
-			}
-			if event.Op.Has(fsnotify.Remove) {
-				localFileEm.NotifySubscribers("-" + id)
+			if event.Op.Has(fsnotify.Chmod) {
+				continue
 			}
+			// subscribers are notified after the index is updated
+			scheduleIndexSync(id)
 		case err, ok := <-watcher.Errors:
 			if !ok {
 				return nil
@@ -0,0 +1,366 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/wzhqwq/VRCDancePreloader/internal/persistence"
+	"github.com/wzhqwq/VRCDancePreloader/internal/rw_file/trunk"
+	"github.com/wzhqwq/VRCDancePreloader/internal/types"
+	"github.com/wzhqwq/VRCDancePreloader/internal/utils"
+)
+
+var ErrNotMigratable = errors.New("progress of this file cannot be kept in the target format")
+
+// errMigrationPaused rolls back the file being converted when some entry becomes active, it's converted again later
+var errMigrationPaused = errors.New("migration is paused for an active entry")
+
+// the migration is slowed down so that it won't compete with playback for the disk
+const migrationBytesPerSecond = 1024 * 1024 * 8
+const migrationChunkSize = 1024 * 256
+
+// how long to wait before checking again when some entry is in use
+const migrationIdleCheckInterval = 5 * time.Second
+
+type MigrationProgress struct {
+	Running bool
+	Target  string
+
+	Total  int
+	Done   int
+	Failed int
+
+	Current       string
+	CurrentSize   int64
+	CurrentCopied int64
+}
+
+var migrationEnabled bool
+var migrationMutex sync.Mutex
+var migrationCancel context.CancelFunc
+var migrationWg sync.WaitGroup
+
+var migrationProgress MigrationProgress
+var migrationProgressMutex sync.Mutex
+var migrationEm = utils.NewEventManager[MigrationProgress]()
+
+var migrationLogger = utils.NewLogger("Cache Migration")
+
+func SetMigrateFormat(b bool) {
+	migrationEnabled = b
+	if b {
+		StartMigration()
+	} else {
+		StopMigration()
+	}
+}
+
+func getTargetFormat() string {
+	if fileFormat == 0 {
+		return types.CacheFormatLegacy
+	}
+	return types.CacheFormatTrunk
+}
+
+// StartMigration converts inactive cache files into the current format in background, a running migration is restarted
+func StartMigration() {
+	StopMigration()
+
+	migrationMutex.Lock()
+	defer migrationMutex.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	migrationCancel = cancel
+
+	migrationWg.Add(1)
+	go func() {
+		defer migrationWg.Done()
+		runMigration(ctx, getTargetFormat())
+	}()
+}
+
+func StopMigration() {
+	migrationMutex.Lock()
+	defer migrationMutex.Unlock()
+
+	if migrationCancel != nil {
+		migrationCancel()
+		migrationCancel = nil
+	}
+	migrationWg.Wait()
+}
+
+func GetMigrationProgress() MigrationProgress {
+	migrationProgressMutex.Lock()
+	defer migrationProgressMutex.Unlock()
+
+	return migrationProgress
+}
+
+func SubscribeMigrationEvent() *utils.EventSubscriber[MigrationProgress] {
+	return migrationEm.SubscribeEvent()
+}
+
+func updateMigrationProgress(update func(p *MigrationProgress)) {
+	migrationProgressMutex.Lock()
+	update(&migrationProgress)
+	progress := migrationProgress
+	migrationProgressMutex.Unlock()
+
+	migrationEm.NotifySubscribers(progress)
+}
+
+// waitForIdle blocks until no entry is in use
+func waitForIdle(ctx context.Context) error {
+	for cacheMap.HasActive() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(migrationIdleCheckInterval):
+		}
+	}
+	return nil
+}
+
+func runMigration(ctx context.Context, target string) {
+	files := lo.Filter(persistence.ListCacheFiles(), func(f *persistence.CacheFile, _ int) bool {
+		return f.Format != target
+	})
+	if len(files) == 0 {
+		return
+	}
+
+	migrationLogger.InfoLnf("Converting %d cache files into %s format", len(files), target)
+	updateMigrationProgress(func(p *MigrationProgress) {
+		*p = MigrationProgress{
+			Running: true,
+			Target:  target,
+			Total:   len(files),
+		}
+	})
+	defer updateMigrationProgress(func(p *MigrationProgress) {
+		p.Running = false
+		p.Current = ""
+	})
+
+	for _, f := range files {
+		var err error
+		for {
+			// never wait while holding the lock of a file, or the player requesting it would wait as well
+			if waitForIdle(ctx) != nil {
+				return
+			}
+
+			updateMigrationProgress(func(p *MigrationProgress) {
+				p.Current = f.ID
+				p.CurrentSize = f.Size
+				p.CurrentCopied = 0
+			})
+
+			err = migrateFile(ctx, f.ID, target)
+			if ctx.Err() != nil {
+				return
+			}
+			if !errors.Is(err, errMigrationPaused) {
+				break
+			}
+		}
+
+		if errors.Is(err, ErrFileRequested) {
+			// it's converted next time
+			migrationLogger.InfoLn("Gave up", f.ID, "because it's requested")
+		} else if err != nil {
+			migrationLogger.WarnLn("Skipped", f.ID, ":", err)
+		} else {
+			migrationLogger.InfoLn("Converted", f.ID, "into", target, "format")
+		}
+
+		updateMigrationProgress(func(p *MigrationProgress) {
+			p.Done++
+			if err != nil && !errors.Is(err, ErrFileRequested) {
+				p.Failed++
+			}
+		})
+	}
+}
+
+// migrateFile converts the file of id, it's rolled back if the entry of id is requested meanwhile
+func migrateFile(ctx context.Context, id string, target string) error {
+	fileCtx, ok := cacheMap.TryLockFileInterruptible(ctx, id)
+	if !ok {
+		return ErrCacheBusy
+	}
+	defer cacheMap.UnlockFile(id)
+
+	f := readWorkingCacheFile(id)
+	if f == nil || f.Format == target {
+		return nil
+	}
+
+	var err error
+	if target == types.CacheFormatTrunk {
+		err = migrateLegacyToTrunk(fileCtx, id)
+	} else {
+		err = migrateTrunkToLegacy(fileCtx, id)
+	}
+	if cause := context.Cause(fileCtx); ctx.Err() == nil && errors.Is(cause, ErrFileRequested) {
+		err = cause
+	}
+
+	syncIndex(id)
+	return err
+}
+
+// throttledCopy copies length bytes, it gives up with errMigrationPaused when some entry is in use
+func throttledCopy(ctx context.Context, dst io.Writer, src io.Reader, length int64) error {
+	buf := make([]byte, migrationChunkSize)
+	chunkDuration := time.Second * migrationChunkSize / migrationBytesPerSecond
+
+	copied := int64(0)
+	for copied < length {
+		if cacheMap.HasActive() {
+			return errMigrationPaused
+		}
+
+		start := time.Now()
+		n, err := io.ReadFull(src, buf[:min(int64(len(buf)), length-copied)])
+		if n > 0 {
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return err
+			}
+			copied += int64(n)
+			updateMigrationProgress(func(p *MigrationProgress) {
+				p.CurrentCopied = copied
+			})
+		}
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(chunkDuration - time.Since(start)):
+		}
+	}
+
+	return nil
+}
+
+func migrateLegacyToTrunk(ctx context.Context, id string) error {
+	paths := getCacheFilePaths(id)
+	legacyPath, legacyDlPath, trunkPath := paths[0], paths[1], paths[2]
+
+	srcPath, complete := legacyPath, true
+	stat, err := os.Stat(srcPath)
+	if err != nil {
+		srcPath, complete = legacyDlPath, false
+		stat, err = os.Stat(srcPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	fullSize := int64(0)
+	if complete {
+		fullSize = stat.Size()
+	}
+	// legacy files are written in place, so an unfinished one can only be told by the recorded full size
+	if indexed, err := persistence.GetCacheFile(id); err == nil && indexed.FullSize > stat.Size() {
+		fullSize = indexed.FullSize
+		complete = false
+	}
+	if fullSize == 0 {
+		// the bitmap cannot be arranged without the full size
+		return ErrNotMigratable
+	}
+
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// stale trunk file will be overwritten
+	_ = os.Remove(trunkPath)
+	dst := trunk.NewTrunkFile(legacyPath)
+	if dst == nil {
+		return trunk.ErrCorrupted
+	}
+
+	// the modified time of a legacy file is compared with the remote one, so it's kept as is
+	dst.Init(fullSize, stat.ModTime())
+
+	// partial progress is kept by the trunks filled while appending
+	frag := trunk.NewFragment(0, 0)
+	err = throttledCopy(ctx, appendWriter(func(p []byte) (int, error) {
+		return len(p), dst.AppendTo(frag, p)
+	}), src, stat.Size())
+	if err == nil && complete {
+		dst.MarkCompleted()
+	}
+
+	closeErr := dst.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(trunkPath)
+		return err
+	}
+
+	src.Close()
+	return os.Remove(srcPath)
+}
+
+func migrateTrunkToLegacy(ctx context.Context, id string) error {
+	paths := getCacheFilePaths(id)
+	legacyPath, legacyDlPath, trunkPath := paths[0], paths[1], paths[2]
+
+	info, err := trunk.Stat(trunkPath)
+	if err != nil {
+		return err
+	}
+	// a legacy file can only continue from its end, so only complete files are converted
+	if !info.Completed {
+		return ErrNotMigratable
+	}
+
+	src, err := trunk.OpenBody(trunkPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(legacyDlPath)
+	if err != nil {
+		return err
+	}
+
+	err = throttledCopy(ctx, dst, src, info.FullSize)
+	closeErr := dst.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(legacyDlPath, legacyPath)
+	}
+	if err != nil {
+		_ = os.Remove(legacyDlPath)
+		return err
+	}
+
+	modTime := info.LastModified
+	if modTime.Unix() <= 0 {
+		modTime = time.Now()
+	}
+	_ = os.Chtimes(legacyPath, modTime, modTime)
+
+	src.Close()
+	return os.Remove(trunkPath)
+}
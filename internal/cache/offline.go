@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/utils"
+)
+
+// A local file is served without the remote server if it's unreachable or erroring,
+// and it's revalidated in background later.
+
+var ErrOffline = errors.New("remote server is unreachable")
+
+// checking a file that has a local copy should not keep the player waiting
+const offlineCheckTimeout = 5 * time.Second
+
+// the delay before revalidating a file served offline, doubled after each failure
+const offlineRetryMinDelay = time.Minute
+const offlineRetryMaxDelay = 30 * time.Minute
+
+const offlineRevalidationTimeout = 30 * time.Second
+
+var offlineIds = make(map[string]struct{})
+var revalidationTimers = make(map[string]*time.Timer)
+var offlineMutex sync.Mutex
+
+var offlineEm = utils.NewEventManager[string]()
+
+// IsServedOffline reports whether the file is served without confirming with the remote server
+func IsServedOffline(id string) bool {
+	offlineMutex.Lock()
+	defer offlineMutex.Unlock()
+
+	_, ok := offlineIds[id]
+	return ok
+}
+
+// SubscribeOfflineEvent notifies the id whose offline state is changed
+func SubscribeOfflineEvent() *utils.EventSubscriber[string] {
+	return offlineEm.SubscribeEvent()
+}
+
+func markServedOffline(id string) {
+	offlineMutex.Lock()
+	_, existed := offlineIds[id]
+	offlineIds[id] = struct{}{}
+	offlineMutex.Unlock()
+
+	if !existed {
+		scheduleRevalidation(id, offlineRetryMinDelay)
+		offlineEm.NotifySubscribers(id)
+	}
+}
+
+func markServedOnline(id string) {
+	offlineMutex.Lock()
+	_, existed := offlineIds[id]
+	delete(offlineIds, id)
+	if timer, ok := revalidationTimers[id]; ok {
+		timer.Stop()
+		delete(revalidationTimers, id)
+	}
+	offlineMutex.Unlock()
+
+	if existed {
+		offlineEm.NotifySubscribers(id)
+	}
+}
+
+func scheduleRevalidation(id string, delay time.Duration) {
+	offlineMutex.Lock()
+	defer offlineMutex.Unlock()
+
+	if _, ok := revalidationTimers[id]; ok {
+		return
+	}
+	revalidationTimers[id] = time.AfterFunc(delay, func() {
+		offlineMutex.Lock()
+		delete(revalidationTimers, id)
+		offlineMutex.Unlock()
+
+		revalidateOffline(id, delay)
+	})
+}
+
+func stopRevalidations() {
+	offlineMutex.Lock()
+	defer offlineMutex.Unlock()
+
+	for id, timer := range revalidationTimers {
+		timer.Stop()
+		delete(revalidationTimers, id)
+	}
+}
+
+type revalidator interface {
+	Revalidate(ctx context.Context) error
+}
+
+func revalidateOffline(id string, lastDelay time.Duration) {
+	if !IsServedOffline(id) {
+		return
+	}
+
+	entry, err := OpenCacheEntry(context.Background(), id, managerLogger)
+	if err != nil {
+		markServedOnline(id)
+		return
+	}
+	defer ReleaseCacheEntry(id, managerLogger)
+
+	r, ok := entry.(revalidator)
+	if !ok {
+		markServedOnline(id)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), offlineRevalidationTimeout)
+	defer cancel()
+
+	err = r.Revalidate(ctx)
+	if err != nil {
+		delay := min(lastDelay*2, offlineRetryMaxDelay)
+		managerLogger.WarnLnf("Failed to revalidate %s, retry in %s: %v", id, delay, err)
+		scheduleRevalidation(id, delay)
+		return
+	}
+	managerLogger.InfoLn("Revalidated", id, "which was served offline")
+}
@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/samber/lo"
+	"github.com/wzhqwq/VRCDancePreloader/internal/persistence"
+)
+
+// platforms are the prefixes of cache ids
+var Platforms = []string{"pypy", "wanna", "dudu", "bili", "yt"}
+
+var platformQuotas = make(map[string]int64)
+var platformQuotasMutex sync.RWMutex
+
+func getPlatform(id string) string {
+	platform, _, _ := strings.Cut(id, "_")
+	return platform
+}
+
+// SetPlatformQuota limits the cache size of a platform, 0 means no quota
+func SetPlatformQuota(platform string, size int64) {
+	platformQuotasMutex.Lock()
+	if size > 0 {
+		platformQuotas[platform] = size
+	} else {
+		delete(platformQuotas, platform)
+	}
+	platformQuotasMutex.Unlock()
+
+	CleanUpCache()
+}
+
+func GetPlatformQuota(platform string) int64 {
+	platformQuotasMutex.RLock()
+	defer platformQuotasMutex.RUnlock()
+
+	return platformQuotas[platform]
+}
+
+type PlatformUsage struct {
+	Platform string
+	Size     int64
+	// 0 if there's no quota
+	Quota int64
+}
+
+func (u PlatformUsage) Exceeded() bool {
+	return u.Quota > 0 && u.Size > u.Quota
+}
+
+func sumPlatformUsage(files []*persistence.CacheFile) map[string]int64 {
+	usage := make(map[string]int64)
+	for _, f := range files {
+		usage[getPlatform(f.ID)] += f.Size
+	}
+	return usage
+}
+
+// GetPlatformUsages returns the usage of every platform in the order of Platforms
+func GetPlatformUsages() []PlatformUsage {
+	usage := sumPlatformUsage(persistence.ListCacheFiles())
+	return lo.Map(Platforms, func(platform string, _ int) PlatformUsage {
+		return PlatformUsage{
+			Platform: platform,
+			Size:     usage[platform],
+			Quota:    GetPlatformQuota(platform),
+		}
+	})
+}
+
+func anyQuotaExceeded(usage map[string]int64) bool {
+	platformQuotasMutex.RLock()
+	defer platformQuotasMutex.RUnlock()
+
+	for platform, quota := range platformQuotas {
+		if usage[platform] > quota {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/persistence"
+)
+
+var ErrNotComplete = errors.New("cache file is not complete")
+
+// PeerFetcher requests the body of a complete copy from other instances from offset, nil if no one has it.
+// The copy must be in this size and downloaded from the file with this strong etag, peers are not asked without one.
+type PeerFetcher func(ctx context.Context, id string, size int64, etag string, offset int64) io.ReadCloser
+
+var peerFetcher PeerFetcher
+
+func SetPeerFetcher(f PeerFetcher) {
+	peerFetcher = f
+}
+
+// CompleteFile is a complete cache file opened for serving to others
+type CompleteFile struct {
+	io.ReadSeeker
+	io.Closer
+
+	Size    int64
+	ModTime time.Time
+	// the ETag of the remote file it's downloaded from, may be empty
+	ETag string
+}
+
+// OpenCompleteCache opens the video of a complete cache file, the file of the alias target is opened for an alias
+func OpenCompleteCache(id string) (*CompleteFile, error) {
+	fileId := id
+	if !hasLocalFile(id) {
+		if target := persistence.GetCacheAlias(id); target != "" {
+			fileId = target
+		}
+	}
+
+	f, err := persistence.GetCacheFile(fileId)
+	if err != nil {
+		return nil, err
+	}
+	if !f.IsComplete {
+		return nil, ErrNotComplete
+	}
+
+	r, size, err := openCompleteFile(fileId)
+	if err != nil {
+		return nil, err
+	}
+	rs, ok := r.(io.ReadSeeker)
+	if !ok {
+		r.Close()
+		return nil, errors.New("cache file is not seekable")
+	}
+
+	file := &CompleteFile{
+		ReadSeeker: rs,
+		Closer:     r,
+		Size:       size,
+		ModTime:    f.ModTime,
+	}
+	if v := persistence.GetCacheValidation(id); v != nil {
+		file.ETag = v.ETag
+	}
+	return file, nil
+}
+
+// requestPeerBody asks other instances for the rest of this file before going to the remote server
+func (e *UrlBasedEntry) requestPeerBody(offset int64, ctx context.Context) io.ReadCloser {
+	fetcher := peerFetcher
+	if fetcher == nil || e.aliasOf != "" || e.remoteSize <= 0 {
+		return nil
+	}
+	return fetcher(ctx, e.id, e.remoteSize, e.remoteETag, offset)
+}
@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/persistence"
+	"github.com/wzhqwq/VRCDancePreloader/internal/rw_file/continuous"
+	"github.com/wzhqwq/VRCDancePreloader/internal/rw_file/trunk"
+)
+
+var ErrCacheBusy = errors.New("cache file is in use")
+var ErrAlreadyCached = errors.New("already cached")
+var ErrSizeMismatch = errors.New("written size mismatch")
+
+const copyBufferSize = 1024 * 256
+
+type appendWriter func(p []byte) (int, error)
+
+func (w appendWriter) Write(p []byte) (int, error) {
+	return w(p)
+}
+
+// writeCacheFile writes a complete video into the cache in the configured format,
+// any existing file of this id will be replaced
+func writeCacheFile(id string, src io.Reader, size int64, modTime time.Time) error {
+	if !cacheMap.TryLockFile(id) {
+		return ErrCacheBusy
+	}
+	defer cacheMap.UnlockFile(id)
+
+	err := removeLocalFiles(id)
+	if err != nil {
+		return err
+	}
+
+	baseName := filepath.Join(cachePath, id+".mp4")
+	if fileFormat == 0 {
+		err = writeLegacyFile(baseName, src, size, modTime)
+	} else {
+		err = writeTrunkFile(baseName, src, size, modTime)
+	}
+	if err != nil {
+		_ = removeLocalFiles(id)
+		return err
+	}
+
+	// it has a file of its own now
+	persistence.RemoveCacheAlias(id)
+	syncIndex(id)
+	return nil
+}
+
+func writeLegacyFile(baseName string, src io.Reader, size int64, modTime time.Time) error {
+	dst, err := os.Create(baseName + ".dl")
+	if err != nil {
+		return err
+	}
+
+	n, err := io.CopyBuffer(dst, src, make([]byte, copyBufferSize))
+	closeErr := dst.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	if n != size {
+		return ErrSizeMismatch
+	}
+
+	err = os.Rename(baseName+".dl", baseName)
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(baseName, modTime, modTime)
+}
+
+func writeTrunkFile(baseName string, src io.Reader, size int64, modTime time.Time) error {
+	// continuous and fragmented files share the same format on disk
+	f := continuous.NewFile(baseName)
+	if f == nil {
+		return trunk.ErrCorrupted
+	}
+	f.UpdateRemoteInfo(size, modTime)
+
+	_, err := io.CopyBuffer(appendWriter(f.Append), src, make([]byte, copyBufferSize))
+	complete := f.IsComplete()
+	closeErr := f.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	if !complete {
+		return ErrSizeMismatch
+	}
+	return nil
+}
+
+// openCompleteFile opens the video of a complete cache file for reading
+func openCompleteFile(id string) (io.ReadCloser, int64, error) {
+	paths := getCacheFilePaths(id)
+	legacyPath, trunkPath := paths[0], paths[2]
+
+	if stat, err := os.Stat(legacyPath); err == nil {
+		f, err := os.Open(legacyPath)
+		if err != nil {
+			return nil, 0, err
+		}
+		return f, stat.Size(), nil
+	}
+
+	r, err := trunk.OpenBody(trunkPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	return r, r.Size(), nil
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+	"github.com/wzhqwq/VRCDancePreloader/internal/exposure"
+	"github.com/wzhqwq/VRCDancePreloader/internal/gui/input"
+	"github.com/wzhqwq/VRCDancePreloader/internal/i18n"
+	"github.com/wzhqwq/VRCDancePreloader/internal/utils"
+)
+
+func (ec *ExposureConfig) Settings() exposure.Settings {
+	bind := ec.Bind
+	if bind != exposure.BindLAN {
+		bind = exposure.BindLoopback
+	}
+	return exposure.Settings{
+		Bind:           bind,
+		AllowList:      ec.AllowList,
+		Username:       ec.Username,
+		Password:       ec.Password,
+		Token:          ec.Token,
+		AllowedOrigins: ec.AllowedOrigins,
+	}
+}
+
+// warnExposure tells that a server can be reached by anyone in LAN
+func (ec *ExposureConfig) warnExposure(server string, withAuth bool) {
+	if ec.Bind != exposure.BindLAN || len(ec.AllowList) > 0 {
+		return
+	}
+	if withAuth && (ec.Token != "" || (ec.Username != "" && ec.Password != "")) {
+		return
+	}
+	logger.WarnLnf("%s is exposed to LAN without allow list or authentication, every device in LAN can use it", server)
+}
+
+// NewExposureEditor edits the binding, the allow list and the token of a server, apply is called after any change
+func NewExposureEditor(ec *ExposureConfig, withAuth bool, apply func()) fyne.CanvasObject {
+	c := container.NewVBox()
+
+	lanCheck := widget.NewCheck(i18n.T("label_exposure_lan"), func(b bool) {
+		bind := exposure.BindLoopback
+		if b {
+			bind = exposure.BindLAN
+		}
+		if ec.Bind == bind {
+			return
+		}
+		ec.Bind = bind
+		apply()
+	})
+	lanCheck.Checked = ec.Bind == exposure.BindLAN
+	c.Add(lanCheck)
+
+	allowListInput := input.NewInputWithSave(strings.Join(ec.AllowList, ", "), i18n.T("label_exposure_allow_list"))
+	allowListInput.OnSave = func() error {
+		ec.AllowList = utils.SplitList(allowListInput.Value)
+		apply()
+		return nil
+	}
+	c.Add(allowListInput)
+
+	if withAuth {
+		tokenInput := input.NewInputWithSave(ec.Token, i18n.T("label_exposure_token"))
+		tokenInput.OnSave = func() error {
+			ec.Token = strings.TrimSpace(tokenInput.Value)
+			apply()
+			return nil
+		}
+		c.Add(tokenInput)
+	}
+
+	return c
+}
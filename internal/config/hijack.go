@@ -16,10 +16,12 @@ import (
 type MultiSelectSites struct {
 	widget.BaseWidget
 
-	PyPySelected  []string
-	WannaSelected []string
-	DuDuSelected  []string
-	BiliSelected  []string
+	PyPySelected      []string
+	WannaSelected     []string
+	DuDuSelected      []string
+	BiliSelected      []string
+	YoutubeSelected   []string
+	ThumbnailSelected []string
 }
 
 func NewMultiSelectSites(selected []string) *MultiSelectSites {
@@ -35,12 +37,20 @@ func NewMultiSelectSites(selected []string) *MultiSelectSites {
 	biliSelected := lo.Filter(selected, func(site string, _ int) bool {
 		return constants.IsBiliSite(site)
 	})
+	youtubeSelected := lo.Filter(selected, func(site string, _ int) bool {
+		return constants.IsYoutubeSite(site)
+	})
+	thumbnailSelected := lo.Filter(selected, func(site string, _ int) bool {
+		return constants.IsThumbnailSite(site)
+	})
 
 	m := &MultiSelectSites{
-		PyPySelected:  pypySelected,
-		WannaSelected: wannaSelected,
-		DuDuSelected:  duduSelected,
-		BiliSelected:  biliSelected,
+		PyPySelected:      pypySelected,
+		WannaSelected:     wannaSelected,
+		DuDuSelected:      duduSelected,
+		BiliSelected:      biliSelected,
+		YoutubeSelected:   youtubeSelected,
+		ThumbnailSelected: thumbnailSelected,
 	}
 	m.ExtendBaseWidget(m)
 	return m
@@ -71,6 +81,17 @@ func (m *MultiSelectSites) CreateRenderer() fyne.WidgetRenderer {
 		m.update()
 	}
 
+	youtubeSelect := widgets.NewMultiSelect(constants.AllYoutubeSites(), m.YoutubeSelected)
+	youtubeSelect.OnChange = func(sites []string) {
+		m.YoutubeSelected = sites
+		m.update()
+	}
+	thumbnailSelect := widgets.NewMultiSelect(constants.AllThumbnailSites(), m.ThumbnailSelected)
+	thumbnailSelect.OnChange = func(sites []string) {
+		m.ThumbnailSelected = sites
+		m.update()
+	}
+
 	form := container.New(
 		layout.NewFormLayout(),
 		container.NewCenter(widget.NewLabel("PyPyDance")),
@@ -81,6 +102,10 @@ func (m *MultiSelectSites) CreateRenderer() fyne.WidgetRenderer {
 		duduSelect,
 		container.NewCenter(widget.NewLabel("BiliBili")),
 		biliSelect,
+		container.NewCenter(widget.NewLabel("YouTube")),
+		youtubeSelect,
+		container.NewCenter(widget.NewLabel(i18n.T("label_thumbnail"))),
+		thumbnailSelect,
 	)
 
 	return widget.NewSimpleRenderer(container.NewVBox(label, form))
@@ -90,6 +115,8 @@ func (m *MultiSelectSites) update() {
 	allSites := append(m.PyPySelected, m.WannaSelected...)
 	allSites = append(allSites, m.DuDuSelected...)
 	allSites = append(allSites, m.BiliSelected...)
+	allSites = append(allSites, m.YoutubeSelected...)
+	allSites = append(allSites, m.ThumbnailSelected...)
 
 	config.Hijack.UpdateSites(allSites)
 }
@@ -12,6 +12,7 @@ func checkInterceptionConflict() {
 	pypyIntercepted := false
 	wannaIntercepted := false
 	biliIntercepted := false
+	youtubeIntercepted := false
 	for _, site := range config.Hijack.InterceptedSites {
 		if constants.IsPyPySite(site) {
 			pypyIntercepted = true
@@ -22,6 +23,9 @@ func checkInterceptionConflict() {
 		if constants.IsBiliSite(site) {
 			biliIntercepted = true
 		}
+		if constants.IsYoutubeSite(site) {
+			youtubeIntercepted = true
+		}
 	}
 	if !pypyIntercepted {
 		if index := lo.IndexOf(config.Preload.EnabledPlatforms, "PyPyDance"); index != -1 {
@@ -44,4 +48,8 @@ func checkInterceptionConflict() {
 			logger.InfoLn("Valid sources for BiliBili:", strings.Join(constants.AllBiliSites(), ", "))
 		}
 	}
+	if !youtubeIntercepted && config.Youtube.EnableVideo {
+		logger.WarnLn("YouTube videos are preloaded, but VRChat won't play them from the cache since none of the YouTube video sources are intercepted.")
+		logger.InfoLn("Valid sources for YouTube:", strings.Join(constants.AllYoutubeSites(), ", "))
+	}
 }
@@ -6,6 +6,7 @@ import (
 	"sync"
 
 	"github.com/wzhqwq/VRCDancePreloader/internal/constants"
+	"github.com/wzhqwq/VRCDancePreloader/internal/exposure"
 	"github.com/wzhqwq/VRCDancePreloader/internal/gui/input"
 	"github.com/wzhqwq/VRCDancePreloader/internal/utils"
 	"gopkg.in/yaml.v3"
@@ -32,6 +33,12 @@ type ProxyConfig struct {
 type YoutubeConfig struct {
 	EnableApi       bool `yaml:"enable-youtube-api"`
 	EnableThumbnail bool `yaml:"enable-youtube-thumbnail"`
+	EnableVideo     bool `yaml:"enable-youtube-video"`
+
+	// yt-dlp resolving the videos, it should be the same version as the one VRChat uses
+	YtDlpPath string `yaml:"yt-dlp-path"`
+	// the format VRChat asks yt-dlp for, the resolved streams must match what VRChat requests
+	YtDlpFormat string `yaml:"yt-dlp-format"`
 }
 type PreloadConfig struct {
 	EnabledRooms     []string `yaml:"enabled-rooms"`
@@ -45,8 +52,44 @@ type HijackConfig struct {
 	EnablePWI        bool     `yaml:"enable-pwi"`
 	LimitBandwidth   bool     `yaml:"limit-bandwidth"`
 
+	// http or socks5 proxy for the traffic that goes through us but isn't intercepted, empty for direct access
+	UpstreamProxy string `yaml:"upstream-proxy"`
+	// also send intercepted requests that aren't handled by us to the upstream proxy
+	UpstreamForMisses bool `yaml:"upstream-for-misses"`
+	// save intercepted requests into the database besides the recent ones in memory
+	PersistRequests bool `yaml:"persist-requests"`
+
+	Transparent TransparentConfig `yaml:"transparent"`
+
+	Exposure    ExposureConfig `yaml:"exposure"`
+	PWIExposure ExposureConfig `yaml:"pwi-exposure"`
+
 	HijackRunner *input.ServerRunner `yaml:"-"`
 }
+
+// TransparentConfig serves the intercepted sites on local ports directly, used with hosts file or DNS overrides
+type TransparentConfig struct {
+	Enabled   bool `yaml:"enabled"`
+	HttpPort  int  `yaml:"http-port"`
+	HttpsPort int  `yaml:"https-port"`
+	// DNS server resolving the real origins of intercepted sites, the overrides in hosts file are bypassed
+	Resolver string `yaml:"resolver"`
+}
+
+// ExposureConfig decides who can reach a server
+type ExposureConfig struct {
+	// loopback: only this machine, lan: other devices as well
+	Bind string `yaml:"bind"`
+	// IPs or CIDRs allowed to connect, empty for everyone who can reach the server
+	AllowList []string `yaml:"allow-list"`
+	// optional Basic auth, not applied to PWI
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// optional token, also accepted as the password of Basic auth, not applied to PWI
+	Token string `yaml:"token"`
+	// origins allowed to open WebSockets of the live server besides the live page itself
+	AllowedOrigins []string `yaml:"allowed-origins"`
+}
 type DownloadConfig struct {
 	MaxDownload int `yaml:"max-parallel-download-count"`
 }
@@ -57,8 +100,28 @@ type CacheConfig struct {
 	//RWBufferSize  int    `yaml:"rw-buffer-size"`
 	// 0: legacy, 1: continuous, 2: fragmented
 	FileFormat int `yaml:"file-format"`
+	// trunk size of new continuous and fragmented files in KB
+	TrunkSize int `yaml:"trunk-size"`
 
 	ForceExpirationCheck bool `yaml:"force-expiration-check"`
+
+	// mtime, lru, lfu or weighted
+	EvictionPolicy string `yaml:"eviction-policy"`
+
+	// convert existing files into FileFormat in background
+	MigrateFormat bool `yaml:"migrate-format"`
+
+	// optional size limits in MB of pypy, wanna, dudu, bili and yt, enforced before max-cache-size
+	PlatformQuotas map[string]int `yaml:"platform-quotas"`
+}
+type ThumbnailConfig struct {
+	Path string `yaml:"path"`
+	// size limit in MB
+	MaxSize int `yaml:"max-size"`
+	// thumbnails older than this are fetched again, in hours
+	TTL int `yaml:"ttl"`
+	// serve the thumbnails requested by dance worlds from the cache
+	Intercept bool `yaml:"intercept"`
 }
 type DbConfig struct {
 	Path string `yaml:"path"`
@@ -68,20 +131,44 @@ type LiveConfig struct {
 	Port     int    `yaml:"port"`
 	Settings string `yaml:"settings"`
 
+	Exposure ExposureConfig `yaml:"exposure"`
+
 	LiveRunner *input.ServerRunner `yaml:"-"`
 }
+type PeerConfig struct {
+	Enabled bool `yaml:"enabled"`
+	Port    int  `yaml:"port"`
+	// find other instances in LAN through mDNS
+	Discovery bool `yaml:"discovery"`
+	// host:port of other instances that are always asked
+	Peers []string `yaml:"peers"`
+	// IPs or CIDRs allowed to fetch cache files from this instance
+	AllowList []string `yaml:"allow-list"`
+
+	PeerRunner *input.ServerRunner `yaml:"-"`
+}
 
 var config struct {
-	Version  string         `yaml:"version"`
-	Hijack   HijackConfig   `yaml:"hijack"`
-	Proxy    ProxyConfig    `yaml:"proxy"`
-	Keys     KeyConfig      `yaml:"keys"`
-	Youtube  YoutubeConfig  `yaml:"youtube"`
-	Preload  PreloadConfig  `yaml:"preload"`
-	Download DownloadConfig `yaml:"download"`
-	Cache    CacheConfig    `yaml:"cache"`
-	Db       DbConfig       `yaml:"db"`
-	Live     LiveConfig     `yaml:"live"`
+	Version   string          `yaml:"version"`
+	Hijack    HijackConfig    `yaml:"hijack"`
+	Proxy     ProxyConfig     `yaml:"proxy"`
+	Keys      KeyConfig       `yaml:"keys"`
+	Youtube   YoutubeConfig   `yaml:"youtube"`
+	Preload   PreloadConfig   `yaml:"preload"`
+	Download  DownloadConfig  `yaml:"download"`
+	Cache     CacheConfig     `yaml:"cache"`
+	Thumbnail ThumbnailConfig `yaml:"thumbnail"`
+	Db        DbConfig        `yaml:"db"`
+	Live      LiveConfig      `yaml:"live"`
+	Peer      PeerConfig      `yaml:"peer"`
+}
+
+func defaultExposure() ExposureConfig {
+	return ExposureConfig{
+		Bind:           exposure.BindLoopback,
+		AllowList:      []string{},
+		AllowedOrigins: []string{},
+	}
 }
 
 func FillDefaultSetting() {
@@ -92,6 +179,20 @@ func FillDefaultSetting() {
 		EnableHttps:      true,
 		EnablePWI:        false,
 		LimitBandwidth:   false,
+
+		UpstreamProxy:     "",
+		UpstreamForMisses: false,
+		PersistRequests:   false,
+
+		Transparent: TransparentConfig{
+			Enabled:   false,
+			HttpPort:  80,
+			HttpsPort: 443,
+			Resolver:  "223.5.5.5",
+		},
+
+		Exposure:    defaultExposure(),
+		PWIExposure: defaultExposure(),
 	}
 	config.Proxy = ProxyConfig{
 		Pypy:  "",
@@ -110,6 +211,10 @@ func FillDefaultSetting() {
 	config.Youtube = YoutubeConfig{
 		EnableApi:       false,
 		EnableThumbnail: false,
+		EnableVideo:     false,
+
+		YtDlpPath:   "yt-dlp",
+		YtDlpFormat: "(mp4/best)[height<=?1080][height>=?64][width>=?64]",
 	}
 	config.Preload = PreloadConfig{
 		EnabledRooms: []string{
@@ -135,6 +240,16 @@ func FillDefaultSetting() {
 		KeepFavorites: false,
 		//RWBufferSize:  1,
 		FileFormat: 1,
+		TrunkSize:  16,
+
+		EvictionPolicy: "mtime",
+		MigrateFormat:  true,
+	}
+	config.Thumbnail = ThumbnailConfig{
+		Path:      "./thumbnails",
+		MaxSize:   50,
+		TTL:       7 * 24,
+		Intercept: false,
 	}
 	config.Db = DbConfig{
 		Path: "./data.db",
@@ -143,6 +258,15 @@ func FillDefaultSetting() {
 		Enabled:  false,
 		Port:     7652,
 		Settings: "{}",
+
+		Exposure: defaultExposure(),
+	}
+	config.Peer = PeerConfig{
+		Enabled:   false,
+		Port:      7654,
+		Discovery: true,
+		Peers:     []string{},
+		AllowList: []string{"127.0.0.1", "::1"},
 	}
 }
 
@@ -216,6 +340,9 @@ func GetDownloadConfig() *DownloadConfig {
 func GetCacheConfig() *CacheConfig {
 	return &config.Cache
 }
+func GetThumbnailConfig() *ThumbnailConfig {
+	return &config.Thumbnail
+}
 func GetDbConfig() *DbConfig {
 	return &config.Db
 }
@@ -225,3 +352,6 @@ func GetYoutubeConfig() *YoutubeConfig {
 func GetLiveConfig() *LiveConfig {
 	return &config.Live
 }
+func GetPeerConfig() *PeerConfig {
+	return &config.Peer
+}
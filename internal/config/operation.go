@@ -1,7 +1,10 @@
 package config
 
 import (
+	"fmt"
+	"net"
 	"net/url"
+	"time"
 
 	"github.com/wzhqwq/VRCDancePreloader/internal/cache"
 	"github.com/wzhqwq/VRCDancePreloader/internal/download"
@@ -9,18 +12,68 @@ import (
 	"github.com/wzhqwq/VRCDancePreloader/internal/gui/input"
 	"github.com/wzhqwq/VRCDancePreloader/internal/hijack"
 	"github.com/wzhqwq/VRCDancePreloader/internal/live"
+	"github.com/wzhqwq/VRCDancePreloader/internal/peer"
 	"github.com/wzhqwq/VRCDancePreloader/internal/persistence"
 	"github.com/wzhqwq/VRCDancePreloader/internal/playlist"
 	"github.com/wzhqwq/VRCDancePreloader/internal/requesting"
 	"github.com/wzhqwq/VRCDancePreloader/internal/service"
 	"github.com/wzhqwq/VRCDancePreloader/internal/third_party_api"
+	"github.com/wzhqwq/VRCDancePreloader/internal/thumbnail_cache"
 )
 
+// the CA is kept next to config.yaml
+const caDir = "."
+
+func (hc *HijackConfig) InitCA() {
+	if err := hijack.InitCA(caDir); err != nil {
+		logger.ErrorLn("Failed to prepare CA, HTTPS hijacking won't work:", err)
+	}
+}
+
+// InitResolver lets every request reach the real origins before the intercepted sites are overridden to us,
+// it should be called before any request is sent
+func (hc *HijackConfig) InitResolver() {
+	resolver := ""
+	if hc.Transparent.Enabled {
+		resolver = hc.Transparent.Resolver
+	}
+	if err := requesting.SetBypassResolver(resolver); err != nil {
+		logger.ErrorLn("Invalid resolver for transparent hijacking:", err)
+	}
+}
+
+// applyProxySettings applies the settings of the proxy that take effect when it starts
+func (hc *HijackConfig) applyProxySettings() {
+	if err := hijack.SetUpstreamProxy(hc.UpstreamProxy); err != nil {
+		logger.ErrorLn("Invalid upstream proxy, pass-through traffic goes direct:", err)
+	}
+	hijack.SetUpstreamForMisses(hc.UpstreamForMisses)
+	hijack.SetExposure(hc.Exposure.Settings())
+}
+
 func (hc *HijackConfig) Init() {
+	hc.InitCA()
+	hc.applyProxySettings()
+	hijack.SetPersistRequests(hc.PersistRequests)
+	service.SetPWIExposure(hc.PWIExposure.Settings())
+	hc.Exposure.warnExposure("Hijack proxy", true)
+	hc.PWIExposure.warnExposure("PWI server", false)
+
 	runner := input.NewServerRunner(hc.ProxyPort)
 	runner.OnSave = hc.UpdatePort
 	runner.StartServer = func() error {
+		if hc.Transparent.Enabled {
+			t := hc.Transparent
+			if err := hijack.StartTransparent(hc.InterceptedSites, hc.EnableHttps, t.HttpPort, t.HttpsPort); err != nil {
+				if global_state.IsInGui() {
+					return err
+				}
+				logger.FatalLn("Failed to start transparent hijack server:", err)
+			}
+		}
 		if err := hijack.Start(hc.InterceptedSites, hc.EnableHttps, hc.ProxyPort); err != nil {
+			// don't leave the transparent servers running alone
+			hijack.StopTransparent()
 			if global_state.IsInGui() {
 				return err
 			}
@@ -39,6 +92,7 @@ func (hc *HijackConfig) Init() {
 }
 
 func (hc *HijackConfig) Stop() {
+	hijack.StopTransparent()
 	hijack.Stop()
 	if hc.EnablePWI {
 		service.StopPWIServer()
@@ -72,14 +126,139 @@ func (hc *HijackConfig) UpdateEnablePWI(b bool) {
 	SaveConfig()
 }
 
+// UpdateExposure applies the changes of hc.Exposure and restarts the proxy
+func (hc *HijackConfig) UpdateExposure() {
+	hijack.SetExposure(hc.Exposure.Settings())
+	hc.Exposure.warnExposure("Hijack proxy", true)
+	hc.HijackRunner.Run()
+	SaveConfig()
+}
+
+func (hc *HijackConfig) UpdatePWIExposure() {
+	service.SetPWIExposure(hc.PWIExposure.Settings())
+	hc.PWIExposure.warnExposure("PWI server", false)
+	if hc.EnablePWI {
+		service.StopPWIServer()
+		service.StartPWIServer()
+	}
+	SaveConfig()
+}
+
 func (hc *HijackConfig) UpdateLimitBandwidth(b bool) {
 	hc.LimitBandwidth = b
 	hijack.SetLimitBandwidth(b)
 	SaveConfig()
 }
 
+func (hc *HijackConfig) UpdateUpstreamProxy(value string) error {
+	if err := hijack.SetUpstreamProxy(value); err != nil {
+		return err
+	}
+	hc.UpstreamProxy = value
+	hc.HijackRunner.Run()
+	SaveConfig()
+	return nil
+}
+
+func (hc *HijackConfig) UpdateUpstreamForMisses(b bool) {
+	hc.UpstreamForMisses = b
+	hijack.SetUpstreamForMisses(b)
+	SaveConfig()
+}
+
+func (hc *HijackConfig) UpdateTransparent(enabled bool) {
+	hc.Transparent.Enabled = enabled
+	hc.InitResolver()
+	hc.HijackRunner.Run()
+	SaveConfig()
+}
+
+func (hc *HijackConfig) UpdateTransparentResolver(value string) error {
+	if hc.Transparent.Enabled {
+		if err := requesting.SetBypassResolver(value); err != nil {
+			return err
+		}
+	} else if _, err := requesting.NewResolver(value); err != nil {
+		return err
+	}
+	hc.Transparent.Resolver = value
+	SaveConfig()
+	return nil
+}
+
+func (hc *HijackConfig) UpdatePersistRequests(b bool) {
+	hc.PersistRequests = b
+	hijack.SetPersistRequests(b)
+	SaveConfig()
+}
+
+// RotateCA replaces the CA with a new one, the certificate should be installed again
+func (hc *HijackConfig) RotateCA() error {
+	if err := hijack.RotateCA(); err != nil {
+		return err
+	}
+	hc.HijackRunner.Run()
+	return nil
+}
+
+// RenewCA extends the CA with the same key
+func (hc *HijackConfig) RenewCA() error {
+	if err := hijack.RenewCA(); err != nil {
+		return err
+	}
+	hc.HijackRunner.Run()
+	return nil
+}
+
+// PrintRules prints proxy rules of intercepted sites for Clash and Proxifier, and the PAC URL
+func (hc *HijackConfig) PrintRules() {
+	fmt.Println(hijack.GenerateClashRules(hc.InterceptedSites, hc.ProxyPort))
+	fmt.Println(hijack.GenerateProxifierRules(hc.InterceptedSites, hc.ProxyPort))
+	fmt.Printf("# PAC: http://127.0.0.1:%d/proxy.pac\n", hc.ProxyPort)
+}
+
+// SelfCheck diagnoses the running proxy
+func (hc *HijackConfig) SelfCheck() []hijack.CheckResult {
+	return hijack.SelfCheck(hc.InterceptedSites, hc.EnableHttps, hc.ProxyPort)
+}
+
+// Diagnose prints the diagnostics of the proxy, it's started for the checks unless the port is taken (e.g. by
+// another instance, which is checked instead)
+func (hc *HijackConfig) Diagnose() {
+	hc.InitCA()
+	hc.applyProxySettings()
+
+	addr := fmt.Sprintf("127.0.0.1:%d", hc.ProxyPort)
+	if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		conn.Close()
+		logger.InfoLn("Port", hc.ProxyPort, "is in use, checking the proxy listening on it")
+	} else {
+		go func() {
+			if err := hijack.Start(hc.InterceptedSites, hc.EnableHttps, hc.ProxyPort); err != nil {
+				logger.ErrorLn("Failed to start hijack server:", err)
+			}
+		}()
+		defer hijack.Stop()
+		for i := 0; i < 50; i++ {
+			if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+				conn.Close()
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+
+	failed := 0
+	for _, result := range hc.SelfCheck() {
+		fmt.Println(result)
+		if result.Status == hijack.CheckFailed {
+			failed++
+		}
+	}
+	fmt.Printf("# %d check(s) failed\n", failed)
+}
+
 func (pc *ProxyConfig) Init() {
-	//TODO cancel comment after implemented youtube preloading
 	pc.ProxyControllers = map[string]*ProxyTester{
 		"pypydance-api":     NewProxyTester("pypydance-api", pc.Pypy),
 		"wannadance-api":    NewProxyTester("wannadance-api", pc.Wanna),
@@ -97,6 +276,7 @@ func (pc *ProxyConfig) Init() {
 	requesting.InitClient(requesting.YouTubeVideo, pc.YoutubeVideo)
 	requesting.InitClient(requesting.YouTubeImage, pc.YoutubeImage)
 	requesting.InitClient(requesting.YouTubeApi, pc.YoutubeApi)
+	third_party_api.YtDlpProxy = pc.YoutubeVideo
 
 	if !skipTest {
 		pc.ProxyControllers["pypydance-api"].Test()
@@ -104,11 +284,11 @@ func (pc *ProxyConfig) Init() {
 		pc.ProxyControllers["dudu-fitdance-api"].Test()
 		pc.ProxyControllers["bilibili-api"].Test()
 	}
-	//if config.Youtube.EnableVideo {
-	//	if !skipTest {
-	//		pc.ProxyControllers["youtube-video"].Test()
-	//	}
-	//}
+	if config.Youtube.EnableVideo {
+		if !skipTest {
+			pc.ProxyControllers["youtube-video"].Test()
+		}
+	}
 	if config.Youtube.EnableThumbnail {
 		if !skipTest {
 			pc.ProxyControllers["youtube-image"].Test()
@@ -143,6 +323,7 @@ func (pc *ProxyConfig) Update(item, value string) error {
 	case "youtube-video":
 		pc.YoutubeVideo = value
 		requesting.UpdateClient(requesting.YouTubeVideo, value)
+		third_party_api.YtDlpProxy = value
 	case "youtube-api":
 		pc.YoutubeApi = value
 		requesting.UpdateClient(requesting.YouTubeApi, value)
@@ -192,6 +373,9 @@ func (kc *KeyConfig) Init() {
 func (yc *YoutubeConfig) Init() {
 	third_party_api.EnableYoutubeApi = yc.EnableApi
 	third_party_api.EnableYoutubeThumbnail = yc.EnableThumbnail
+	third_party_api.EnableYoutubeVideo = yc.EnableVideo
+	third_party_api.YtDlpPath = yc.YtDlpPath
+	third_party_api.YtDlpFormat = yc.YtDlpFormat
 }
 
 func (yc *YoutubeConfig) UpdateEnableApi(enabled bool) {
@@ -206,6 +390,24 @@ func (yc *YoutubeConfig) UpdateEnableThumbnail(enabled bool) {
 	SaveConfig()
 }
 
+func (yc *YoutubeConfig) UpdateEnableVideo(enabled bool) {
+	yc.EnableVideo = enabled
+	third_party_api.EnableYoutubeVideo = enabled
+	SaveConfig()
+}
+
+func (yc *YoutubeConfig) UpdateYtDlpPath(path string) {
+	yc.YtDlpPath = path
+	third_party_api.YtDlpPath = path
+	SaveConfig()
+}
+
+func (yc *YoutubeConfig) UpdateYtDlpFormat(format string) {
+	yc.YtDlpFormat = format
+	third_party_api.YtDlpFormat = format
+	SaveConfig()
+}
+
 func (pc *PreloadConfig) Init() {
 	playlist.Init(pc.MaxPreload)
 	playlist.SetEnabledRooms(pc.EnabledRooms)
@@ -230,10 +432,17 @@ func (dc *DownloadConfig) UpdateMaxDownload(max int) {
 
 func (cc *CacheConfig) Init() {
 	cache.SetupCache(cc.Path)
+	cache.SetEvictionPolicy(cc.EvictionPolicy)
 	cache.SetMaxSize(int64(cc.MaxCacheSize) * 1024 * 1024)
+	for platform, sizeInMb := range cc.PlatformQuotas {
+		cache.SetPlatformQuota(platform, int64(sizeInMb)*1024*1024)
+	}
 	cache.SetKeepFavorites(cc.KeepFavorites)
 	cache.SetFileFormat(cc.FileFormat)
+	cache.SetTrunkSize(cc.TrunkSize)
 	cache.SetForceExpirationCheck(cc.ForceExpirationCheck)
+	// the target format must be set before migration starts
+	cache.SetMigrateFormat(cc.MigrateFormat)
 }
 
 func (cc *CacheConfig) UpdateMaxSize(sizeInMb int) {
@@ -242,6 +451,19 @@ func (cc *CacheConfig) UpdateMaxSize(sizeInMb int) {
 	SaveConfig()
 }
 
+func (cc *CacheConfig) UpdatePlatformQuota(platform string, sizeInMb int) {
+	if cc.PlatformQuotas == nil {
+		cc.PlatformQuotas = make(map[string]int)
+	}
+	if sizeInMb > 0 {
+		cc.PlatformQuotas[platform] = sizeInMb
+	} else {
+		delete(cc.PlatformQuotas, platform)
+	}
+	cache.SetPlatformQuota(platform, int64(sizeInMb)*1024*1024)
+	SaveConfig()
+}
+
 func (cc *CacheConfig) UpdateKeepFavorites(b bool) {
 	cc.KeepFavorites = b
 	cache.SetKeepFavorites(b)
@@ -260,6 +482,18 @@ func (cc *CacheConfig) UpdateFileFormat(fileFormat int) {
 	SaveConfig()
 }
 
+func (cc *CacheConfig) UpdateMigrateFormat(b bool) {
+	cc.MigrateFormat = b
+	cache.SetMigrateFormat(b)
+	SaveConfig()
+}
+
+func (cc *CacheConfig) UpdateEvictionPolicy(policy string) {
+	cc.EvictionPolicy = policy
+	cache.SetEvictionPolicy(policy)
+	SaveConfig()
+}
+
 func (dc *DbConfig) Init() error {
 	err := persistence.InitDB(dc.Path)
 	if err != nil {
@@ -268,6 +502,31 @@ func (dc *DbConfig) Init() error {
 	return nil
 }
 
+func (tc *ThumbnailConfig) Init() {
+	thumbnail_cache.SetTTL(time.Duration(tc.TTL) * time.Hour)
+	thumbnail_cache.SetMaxSize(int64(tc.MaxSize) * 1024 * 1024)
+	thumbnail_cache.Setup(tc.Path)
+	hijack.SetInterceptThumbnails(tc.Intercept)
+}
+
+func (tc *ThumbnailConfig) UpdateMaxSize(sizeInMb int) {
+	tc.MaxSize = sizeInMb
+	thumbnail_cache.SetMaxSize(int64(sizeInMb) * 1024 * 1024)
+	SaveConfig()
+}
+
+func (tc *ThumbnailConfig) UpdateTTL(hours int) {
+	tc.TTL = hours
+	thumbnail_cache.SetTTL(time.Duration(hours) * time.Hour)
+	SaveConfig()
+}
+
+func (tc *ThumbnailConfig) UpdateIntercept(b bool) {
+	tc.Intercept = b
+	hijack.SetInterceptThumbnails(b)
+	SaveConfig()
+}
+
 func (lc *LiveConfig) Init() {
 	live.OnSettingsChanged = func(settings string) {
 		lc.UpdateSettings(settings)
@@ -275,6 +534,8 @@ func (lc *LiveConfig) Init() {
 	live.GetSettings = func() string {
 		return lc.Settings
 	}
+	live.SetExposure(lc.Exposure.Settings())
+	lc.Exposure.warnExposure("Live server", true)
 
 	runner := input.NewServerRunner(lc.Port)
 	runner.OnSave = lc.UpdatePort
@@ -288,7 +549,7 @@ func (lc *LiveConfig) Init() {
 		}
 		return nil
 	}
-	runner.StopServer = config.Hijack.Stop
+	runner.StopServer = live.StopLiveServer
 	lc.LiveRunner = runner
 
 	if lc.Enabled {
@@ -306,6 +567,16 @@ func (lc *LiveConfig) UpdateEnable(b bool) {
 	SaveConfig()
 }
 
+// UpdateExposure applies the changes of lc.Exposure and restarts the live server
+func (lc *LiveConfig) UpdateExposure() {
+	live.SetExposure(lc.Exposure.Settings())
+	lc.Exposure.warnExposure("Live server", true)
+	if lc.Enabled {
+		lc.LiveRunner.Run()
+	}
+	SaveConfig()
+}
+
 func (lc *LiveConfig) UpdatePort(port int) {
 	lc.Port = port
 	SaveConfig()
@@ -319,3 +590,67 @@ func (lc *LiveConfig) UpdateSettings(settings string) {
 func (lc *LiveConfig) Stop() {
 	live.StopLiveServer()
 }
+
+func (pc *PeerConfig) Init() {
+	peer.SetStaticPeers(pc.Peers)
+	peer.SetAllowList(pc.AllowList)
+	cache.SetPeerFetcher(peer.Fetch)
+
+	runner := input.NewServerRunner(pc.Port)
+	runner.OnSave = pc.UpdatePort
+	runner.StartServer = func() error {
+		if err := peer.Start(pc.Port, pc.Discovery); err != nil {
+			if global_state.IsInGui() {
+				return err
+			}
+
+			logger.ErrorLn("Failed to start peer server:", err)
+		}
+		return nil
+	}
+	runner.StopServer = peer.Stop
+	pc.PeerRunner = runner
+
+	if pc.Enabled {
+		runner.Run()
+	}
+}
+
+func (pc *PeerConfig) UpdateEnable(b bool) {
+	pc.Enabled = b
+	if pc.Enabled {
+		pc.PeerRunner.Run()
+	} else {
+		peer.Stop()
+	}
+	SaveConfig()
+}
+
+func (pc *PeerConfig) UpdatePort(port int) {
+	pc.Port = port
+	SaveConfig()
+}
+
+func (pc *PeerConfig) UpdateDiscovery(b bool) {
+	pc.Discovery = b
+	if pc.Enabled {
+		pc.PeerRunner.Run()
+	}
+	SaveConfig()
+}
+
+func (pc *PeerConfig) UpdatePeers(peers []string) {
+	pc.Peers = peers
+	peer.SetStaticPeers(peers)
+	SaveConfig()
+}
+
+func (pc *PeerConfig) UpdateAllowList(allowList []string) {
+	pc.AllowList = allowList
+	peer.SetAllowList(allowList)
+	SaveConfig()
+}
+
+func (pc *PeerConfig) Stop() {
+	peer.Stop()
+}
@@ -8,12 +8,12 @@ import (
 var pypySupportedPlatforms = []string{
 	"PyPyDance",
 	"BiliBili",
-	//"YouTube",
+	"YouTube",
 }
 var wannaSupportedPlatforms = []string{
 	"WannaDance",
 	"BiliBili",
-	//"YouTube",
+	"YouTube",
 }
 
 func checkPreloadConflict() {
@@ -1,6 +1,8 @@
 package constants
 
 import (
+	"strings"
+
 	"github.com/samber/lo"
 )
 
@@ -20,6 +22,16 @@ var biliSites = []string{
 	"b23.tv",
 	"api.xin.moe",
 }
+
+// besides the video sites, the song browsers in dance worlds load thumbnails from these sites
+var thumbnailSites = []string{
+	"aya.kiva.moe",
+}
+
+// googlevideo.com has countless hosts like rr1---sn-xxx.googlevideo.com, so it's a wildcard site matching all subdomains
+var youtubeSites = []string{
+	"*.googlevideo.com",
+}
 var allSites = []string{
 	// PyPyDance
 	"jd.pypy.moe",
@@ -33,6 +45,7 @@ var allSites = []string{
 	"www.bilibili.com",
 	"b23.tv",
 	"api.xin.moe",
+	// YouTube is left out, intercepting googlevideo.com is useless unless YouTube preloading is enabled
 }
 
 var httpsSites = []string{
@@ -47,6 +60,37 @@ var httpsSites = []string{
 	"www.bilibili.com",
 	"b23.tv",
 	"api.xin.moe",
+
+	// YouTube
+	"*.googlevideo.com",
+
+	// Thumbnails
+	"aya.kiva.moe",
+}
+
+// WildcardSuffix returns the domain suffix of a wildcard site, e.g. .googlevideo.com for *.googlevideo.com
+func WildcardSuffix(site string) (string, bool) {
+	if strings.HasPrefix(site, "*.") {
+		return site[1:], true
+	}
+	return "", false
+}
+
+// MatchSite checks if host is site, or a subdomain of it if site is a wildcard
+func MatchSite(site, host string) bool {
+	if site == host {
+		return true
+	}
+	if suffix, ok := WildcardSuffix(site); ok {
+		return len(host) > len(suffix) && strings.HasSuffix(host, suffix)
+	}
+	return false
+}
+
+func MatchAnySite(sites []string, host string) bool {
+	return lo.ContainsBy(sites, func(site string) bool {
+		return MatchSite(site, host)
+	})
 }
 
 func IsPyPySite(host string) bool {
@@ -61,6 +105,12 @@ func IsDuDuSite(host string) bool {
 func IsBiliSite(host string) bool {
 	return lo.IndexOf(biliSites, host) >= 0
 }
+func IsYoutubeSite(host string) bool {
+	return MatchAnySite(youtubeSites, host)
+}
+func IsThumbnailSite(host string) bool {
+	return lo.IndexOf(thumbnailSites, host) >= 0
+}
 func IsHttpsSite(host string) bool {
 	return lo.IndexOf(httpsSites, host) >= 0
 }
@@ -77,6 +127,12 @@ func AllDuDuSites() []string {
 func AllBiliSites() []string {
 	return biliSites
 }
+func AllYoutubeSites() []string {
+	return youtubeSites
+}
+func AllThumbnailSites() []string {
+	return thumbnailSites
+}
 
 func CopyAllSites() []string {
 	ret := make([]string, len(allSites))
@@ -67,8 +67,10 @@ func (t *Task) singleDownload(entry cache.Entry) error {
 	}
 	defer body.Close()
 
+	t.stateMutex.Lock()
 	t.DownloadedSize = entry.DownloadedSize()
 	t.Requesting = false
+	t.stateMutex.Unlock()
 	t.resetEta()
 
 	// Notify about the total size and that the request header is done
@@ -79,6 +81,9 @@ func (t *Task) singleDownload(entry cache.Entry) error {
 }
 
 func (t *Task) markAsDone() {
+	t.stateMutex.Lock()
+	defer t.stateMutex.Unlock()
+
 	t.DownloadedSize = t.TotalSize
 	t.Done = true
 	t.Error = nil
@@ -89,9 +94,9 @@ func (t *Task) Download(retryDelay bool) {
 	t.Lock()
 	defer t.unlockAndNotifyStateChange()
 
-	cacheEntry, err := cache.OpenCacheEntry(t.ID, logger)
+	cacheEntry, err := cache.OpenCacheEntry(context.Background(), t.ID, logger)
 	if err != nil {
-		t.Error = err
+		t.setError(err)
 		logger.WarnLn("Skipped", t.ID, "due to", err)
 		return
 	}
@@ -100,14 +105,20 @@ func (t *Task) Download(retryDelay bool) {
 	// Check if file is already downloaded
 	if cacheEntry.IsComplete() {
 		logger.InfoLn("Already downloaded", t.ID)
-		t.TotalSize, _ = cacheEntry.TotalLen()
+		totalSize, _ := cacheEntry.TotalLen()
+		t.stateMutex.Lock()
+		t.TotalSize = totalSize
+		t.stateMutex.Unlock()
 		t.markAsDone()
 		return
 	}
 
+	t.stateMutex.Lock()
 	t.Error = nil
 	t.Pending = false
+	t.stateMutex.Unlock()
 	var delay time.Duration
+	var totalSize int64
 
 	// check if the task is canceled or paused
 	if errors.Is(t.BlockIfPending(), ErrCanceled) {
@@ -128,7 +139,7 @@ func (t *Task) Download(retryDelay bool) {
 				case <-time.After(t.manager.scheduler.Delay()):
 				}
 			}
-			t.Cooling = true
+			t.setCooling(true)
 			t.notifyStateChange()
 		}()
 
@@ -139,20 +150,23 @@ func (t *Task) Download(retryDelay bool) {
 		}
 		wg.Wait()
 	}
-	t.Cooling = false
+	t.setCooling(false)
 
-	t.Requesting = true
+	t.setRequesting(true)
 	t.notifyStateChange()
 
 startRequest:
-	t.TotalSize, err = cacheEntry.TotalLen()
+	totalSize, err = cacheEntry.TotalLen()
+	t.stateMutex.Lock()
+	t.TotalSize = totalSize
+	t.stateMutex.Unlock()
 	if err != nil {
 		if errors.Is(err, requesting.ErrClientChanged) {
 			logger.InfoLn("Restarted", t.ID, "reason:", err.Error())
 			goto startRequest
 		}
 
-		t.Error = err
+		t.setError(err)
 		logger.ErrorLn("Failed to get total size of", t.ID, ":", err)
 		if errors.Is(err, cache.ErrThrottle) {
 			t.manager.slowDown()
@@ -175,17 +189,23 @@ startTask:
 	if errors.Is(err, ErrCanceled) {
 		goto canceled
 	}
+	if errors.Is(err, cache.ErrRemoteChanged) {
+		// the size may change as well
+		logger.InfoLn("Restarted", t.ID, "reason:", err.Error())
+		t.setRequesting(true)
+		goto startRequest
+	}
 	if errors.Is(err, io.EOF) ||
 		errors.Is(err, fragmented.ErrEndOfFragment) ||
 		errors.Is(err, ErrRestarted) ||
 		errors.Is(err, requesting.ErrClientChanged) {
 
 		logger.InfoLn("Restarted", t.ID, "reason:", err.Error())
-		t.Requesting = true
+		t.setRequesting(true)
 		goto startTask
 	}
 
-	t.Error = err
+	t.setError(err)
 	logger.ErrorLn("Downloading error:", err.Error(), t.ID)
 	if errors.Is(err, cache.ErrThrottle) {
 		t.manager.slowDown()
@@ -193,7 +213,7 @@ startTask:
 	return
 
 canceled:
-	t.Error = ErrCanceled
+	t.setError(ErrCanceled)
 	logger.InfoLn("Canceled download task", t.ID)
 	return
 }
@@ -45,7 +45,7 @@ func (dm *downloadManager) UpdatePriorities() {
 
 	dm.queue = lo.Filter(dm.queue, func(id string, _ int) bool {
 		task, ok := dm.tasks[id]
-		return ok && !task.Done
+		return ok && !task.State().Done
 	})
 	dm.queueLogger.InfoLn("tasks:", dm.queue)
 	for i, id := range dm.queue {
@@ -82,7 +82,7 @@ func (dm *downloadManager) EstimatedToResume(id string) (time.Time, bool) {
 	slices.Sort(knownEta)
 
 	inQueue := lo.FilterMap(dm.queue, func(id string, _ int) (string, bool) {
-		if task, ok := dm.tasks[id]; ok && task.Pending {
+		if task, ok := dm.tasks[id]; ok && task.State().Pending {
 			return id, true
 		}
 		return "", false
@@ -117,7 +117,7 @@ func (t *Task) BlockIfPending() error {
 	for {
 		if t.manager.CanDownload(priority) {
 			if t.Pending {
-				t.Pending = false
+				t.setPending(false)
 				t.notifyStateChange()
 				logger.InfoLn("Continue download task", t.ID)
 			}
@@ -125,7 +125,7 @@ func (t *Task) BlockIfPending() error {
 		}
 
 		if !t.Pending {
-			t.Pending = true
+			t.setPending(true)
 			t.notifyStateChange()
 			logger.InfoLnf("Paused download task %s, because its priority is %d", t.ID, priority)
 			// clear ETA counter
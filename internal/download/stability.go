@@ -67,7 +67,7 @@ func (dm *downloadManager) UpdateRequestEta(id string, eta time.Time, duration t
 	}
 
 	// The task must be downloading
-	if task.Done || task.Cooling || task.Pending {
+	if state := task.State(); state.Done || state.Cooling || state.Pending {
 		return
 	}
 
@@ -24,6 +24,7 @@ type Task struct {
 
 	ID string
 
+	// states written by the download, other goroutines read them by State
 	TotalSize      int64
 	DownloadedSize int64
 
@@ -33,12 +34,17 @@ type Task struct {
 	Cooling    bool
 	Error      error
 
+	stateMutex sync.RWMutex
+
 	eta *etaCalculator
 	em  *utils.EventManager[TaskChangeType]
 
 	CancelCh   chan struct{}
 	PriorityCh chan int
 	RestartCh  chan struct{}
+
+	// the task can be cancelled by both the manager and the song
+	cancelOnce sync.Once
 }
 
 func newTask(manager *downloadManager, id string) *Task {
@@ -55,6 +61,55 @@ func newTask(manager *downloadManager, id string) *Task {
 	}
 }
 
+// TaskState is a copy of the states of a task
+type TaskState struct {
+	TotalSize      int64
+	DownloadedSize int64
+
+	Requesting bool
+	Done       bool
+	Pending    bool
+	Cooling    bool
+	Error      error
+}
+
+// State copies the states, so that subscribers can read them while the task is downloading
+func (t *Task) State() TaskState {
+	t.stateMutex.RLock()
+	defer t.stateMutex.RUnlock()
+
+	return TaskState{
+		TotalSize:      t.TotalSize,
+		DownloadedSize: t.DownloadedSize,
+		Requesting:     t.Requesting,
+		Done:           t.Done,
+		Pending:        t.Pending,
+		Cooling:        t.Cooling,
+		Error:          t.Error,
+	}
+}
+
+func (t *Task) setError(err error) {
+	t.stateMutex.Lock()
+	defer t.stateMutex.Unlock()
+	t.Error = err
+}
+func (t *Task) setPending(b bool) {
+	t.stateMutex.Lock()
+	defer t.stateMutex.Unlock()
+	t.Pending = b
+}
+func (t *Task) setCooling(b bool) {
+	t.stateMutex.Lock()
+	defer t.stateMutex.Unlock()
+	t.Cooling = b
+}
+func (t *Task) setRequesting(b bool) {
+	t.stateMutex.Lock()
+	defer t.stateMutex.Unlock()
+	t.Requesting = b
+}
+
 func (t *Task) unlockAndNotifyStateChange() {
 	t.Unlock()
 	t.notifyStateChange()
@@ -91,7 +146,9 @@ func (t *Task) resetEta() {
 }
 
 func (t *Task) addBytes(size int64) {
+	t.stateMutex.Lock()
 	t.DownloadedSize += size
+	t.stateMutex.Unlock()
 	t.eta.Add(size)
 	t.em.NotifySubscribers(Progress)
 }
@@ -99,7 +156,9 @@ func (t *Task) addBytes(size int64) {
 // Destroy
 
 func (t *Task) Cancel() {
-	close(t.CancelCh)
+	t.cancelOnce.Do(func() {
+		close(t.CancelCh)
+	})
 }
 
 // Event
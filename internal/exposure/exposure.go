@@ -0,0 +1,251 @@
+package exposure
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/utils"
+)
+
+// Every server we run decides who can reach it in the same way: the interfaces it binds to, the clients allowed by
+// IP, and optionally the credentials they have to provide.
+
+const (
+	BindLoopback = "loopback"
+	BindLAN      = "lan"
+)
+
+// TokenCookie keeps the token for browsers once it's given in the query, so that the page and the WebSocket opened
+// by it don't need to carry it
+const TokenCookie = "vrcdp_token"
+
+var logger = utils.NewLogger("Exposure")
+
+type Settings struct {
+	// loopback or lan
+	Bind string
+	// IPs or CIDRs allowed to connect, empty for everyone who can reach the server
+	AllowList []string
+
+	// Basic auth, disabled if either is empty
+	Username string
+	Password string
+	// accepted as "Authorization: Bearer", ?token= or the cookie, and as the password of Basic auth for any user
+	Token string
+
+	// origins allowed to open WebSockets besides the server itself
+	AllowedOrigins []string
+}
+
+type Guard struct {
+	settings  Settings
+	allowList []*net.IPNet
+}
+
+func NewGuard(settings Settings) *Guard {
+	return &Guard{
+		settings:  settings,
+		allowList: ParseAllowList(settings.AllowList),
+	}
+}
+
+// ParseAllowList parses IPs and CIDRs, malformed entries are ignored
+func ParseAllowList(entries []string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil {
+				bits := 8 * len(ip.To16())
+				if ip.To4() != nil {
+					ip = ip.To4()
+					bits = 32
+				}
+				nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			logger.WarnLn("Ignored malformed allow list entry", entry)
+			continue
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+func ContainsIP(nets []*net.IPNet, ip net.IP) bool {
+	return slices.ContainsFunc(nets, func(n *net.IPNet) bool {
+		return n.Contains(ip)
+	})
+}
+
+func (g *Guard) IsLAN() bool {
+	return g.settings.Bind == BindLAN
+}
+
+// Addr is the address to listen on
+func (g *Guard) Addr(port int) string {
+	if g.IsLAN() {
+		return fmt.Sprintf(":%d", port)
+	}
+	return fmt.Sprintf("127.0.0.1:%d", port)
+}
+
+// AllowAddr checks the IP of remoteAddr against the allow list
+func (g *Guard) AllowAddr(remoteAddr string) bool {
+	if len(g.allowList) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	return ContainsIP(g.allowList, net.ParseIP(host))
+}
+
+func (g *Guard) RequiresAuth() bool {
+	return g.settings.Token != "" || (g.settings.Username != "" && g.settings.Password != "")
+}
+
+// ProxyUserinfo gives the credentials accepted by WrapProxy, nil if they aren't required
+func (g *Guard) ProxyUserinfo() *url.Userinfo {
+	s := g.settings
+	if s.Username != "" && s.Password != "" {
+		return url.UserPassword(s.Username, s.Password)
+	}
+	if s.Token != "" {
+		return url.UserPassword("vrcdp", s.Token)
+	}
+	return nil
+}
+
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+func (g *Guard) checkBasic(username, password string) bool {
+	s := g.settings
+	if s.Username != "" && s.Password != "" && secureEqual(username, s.Username) && secureEqual(password, s.Password) {
+		return true
+	}
+	return s.Token != "" && secureEqual(password, s.Token)
+}
+
+func (g *Guard) checkToken(token string) bool {
+	return g.settings.Token != "" && token != "" && secureEqual(token, g.settings.Token)
+}
+
+// authorized checks the credentials in the Authorization header, the query and the cookie
+func (g *Guard) authorized(r *http.Request) (ok bool, fromQuery bool) {
+	if username, password, ok := r.BasicAuth(); ok && g.checkBasic(username, password) {
+		return true, false
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && g.checkToken(token) {
+		return true, false
+	}
+	if g.checkToken(r.URL.Query().Get("token")) {
+		return true, true
+	}
+	if cookie, err := r.Cookie(TokenCookie); err == nil && g.checkToken(cookie.Value) {
+		return true, false
+	}
+	return false, false
+}
+
+// proxyAuthorized checks Proxy-Authorization, proxy clients only support Basic auth
+func (g *Guard) proxyAuthorized(r *http.Request) bool {
+	auth := r.Header.Get("Proxy-Authorization")
+	if auth == "" {
+		return false
+	}
+	// reuse the parser of Authorization
+	probe := &http.Request{Header: http.Header{"Authorization": []string{auth}}}
+	username, password, ok := probe.BasicAuth()
+	return ok && g.checkBasic(username, password)
+}
+
+func (g *Guard) reject(w http.ResponseWriter, r *http.Request) bool {
+	if !g.AllowAddr(r.RemoteAddr) {
+		logger.WarnLn("Rejected request from", r.RemoteAddr)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return true
+	}
+	return false
+}
+
+// Wrap rejects clients not in the allow list, and asks for credentials if required
+func (g *Guard) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if g.reject(w, r) {
+			return
+		}
+		if g.RequiresAuth() {
+			ok, fromQuery := g.authorized(r)
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Basic realm="VRCDancePreloader"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if fromQuery {
+				http.SetCookie(w, &http.Cookie{
+					Name:     TokenCookie,
+					Value:    g.settings.Token,
+					Path:     "/",
+					HttpOnly: true,
+					SameSite: http.SameSiteStrictMode,
+				})
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// WrapProxy is Wrap for proxy servers, the proxied requests are authorized by Proxy-Authorization.
+// Requests to the proxy itself (e.g. the PAC file) are only checked by the allow list, since they are fetched by
+// the system which doesn't send credentials.
+func (g *Guard) WrapProxy(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if g.reject(w, r) {
+			return
+		}
+		proxied := r.Method == http.MethodConnect || r.URL.IsAbs()
+		if proxied && g.RequiresAuth() {
+			if !g.proxyAuthorized(r) {
+				w.Header().Set("Proxy-Authenticate", `Basic realm="VRCDancePreloader"`)
+				http.Error(w, "proxy authentication required", http.StatusProxyAuthRequired)
+				return
+			}
+			r.Header.Del("Proxy-Authorization")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CheckOrigin allows WebSockets opened by pages of the server itself and the allowed origins.
+// Clients other than browsers don't send Origin, they are allowed as well.
+func (g *Guard) CheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return slices.ContainsFunc(g.settings.AllowedOrigins, func(allowed string) bool {
+		return strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin)
+	})
+}
@@ -1,6 +1,8 @@
 package cache_window
 
 import (
+	"image/color"
+	"time"
 	"weak"
 
 	"fyne.io/fyne/v2"
@@ -8,6 +10,7 @@ import (
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
+	"github.com/eduardolat/goeasyi18n"
 	"github.com/samber/lo"
 	"github.com/wzhqwq/VRCDancePreloader/internal/cache"
 	"github.com/wzhqwq/VRCDancePreloader/internal/gui/button"
@@ -15,13 +18,35 @@ import (
 	"github.com/wzhqwq/VRCDancePreloader/internal/i18n"
 	"github.com/wzhqwq/VRCDancePreloader/internal/persistence"
 	"github.com/wzhqwq/VRCDancePreloader/internal/types"
+	"github.com/wzhqwq/VRCDancePreloader/internal/utils"
 )
 
+var platformNames = map[string]string{
+	"pypy":  "PyPyDance",
+	"wanna": "WannaDance",
+	"dudu":  "DuDuFitDance",
+	"bili":  "BiliBili",
+	"yt":    "YouTube",
+}
+
+var platformColors = map[string]color.Color{
+	"pypy":  color.RGBA{R: 186, G: 44, B: 101, A: 255},
+	"wanna": color.RGBA{R: 66, G: 133, B: 244, A: 255},
+	"dudu":  color.RGBA{R: 255, G: 152, B: 0, A: 255},
+	"bili":  color.RGBA{R: 0, G: 161, B: 214, A: 255},
+	"yt":    color.RGBA{R: 230, G: 33, B: 23, A: 255},
+}
+
 type LocalFilesGui struct {
 	widget.BaseWidget
 
 	infos     []types.CacheFileInfo
 	changedId string
+	migration cache.MigrationProgress
+	// only the migration label needs to be refreshed
+	migrationChanged bool
+	usages           []cache.PlatformUsage
+	cleanupReport    *cache.CleanupReport
 
 	stopCh chan struct{}
 }
@@ -41,11 +66,19 @@ func (g *LocalFilesGui) RenderLoop() {
 	defer localCh.Close()
 	allowCh := persistence.GetAllowList().SubscribeEvent()
 	defer allowCh.Close()
+	migrationCh := cache.SubscribeMigrationEvent()
+	defer migrationCh.Close()
 
 	for {
 		select {
 		case <-g.stopCh:
 			return
+		case progress := <-migrationCh.Channel:
+			g.migration = progress
+			g.migrationChanged = true
+			fyne.Do(func() {
+				g.Refresh()
+			})
 		case message := <-localCh.Channel:
 			if message[0] == '*' {
 				g.changedId = message[1:]
@@ -77,12 +110,20 @@ func (g *LocalFilesGui) CreateRenderer() fyne.WidgetRenderer {
 	refreshBtn := button.NewPaddedIconBtn(theme.ViewRefreshIcon())
 	refreshBtn.SetMinSquareSize(30)
 
-	progressBar := widgets.NewSizeProgressBar(cache.GetMaxSize(), 0)
+	progressBar := widgets.NewStackedSizeBar(cache.GetMaxSize())
 
 	refreshBtn.OnClick = func() {
-		g.RefreshFiles()
+		// subscribers will be notified after rebuilding
+		go cache.RebuildCacheIndex()
 	}
 
+	migrationLabel := canvas.NewText("", theme.Color(theme.ColorNamePlaceHolder))
+	migrationLabel.TextSize = 12
+	g.migration = cache.GetMigrationProgress()
+
+	cleanupLabel := canvas.NewText("", theme.Color(theme.ColorNamePlaceHolder))
+	cleanupLabel.TextSize = 12
+
 	r := &LocalFilesGuiRenderer{
 		g: g,
 
@@ -92,10 +133,15 @@ func (g *LocalFilesGui) CreateRenderer() fyne.WidgetRenderer {
 		RefreshBtn:  refreshBtn,
 		ProgressBar: progressBar,
 
+		MigrationLabel: migrationLabel,
+		CleanupLabel:   cleanupLabel,
+		Legend:         container.NewHBox(),
+
 		itemMap: make(map[string]weak.Pointer[LocalFileGui]),
 	}
 
 	r.updateItems()
+	r.updateMigration()
 
 	go g.RenderLoop()
 
@@ -104,6 +150,8 @@ func (g *LocalFilesGui) CreateRenderer() fyne.WidgetRenderer {
 
 func (g *LocalFilesGui) RefreshFiles() {
 	g.infos = cache.GetLocalCacheInfos()
+	g.usages = cache.GetPlatformUsages()
+	g.cleanupReport = cache.GetLastCleanupReport()
 	fyne.Do(func() {
 		g.Refresh()
 	})
@@ -116,7 +164,11 @@ type LocalFilesGuiRenderer struct {
 	List        *fyne.Container
 	Label       *canvas.Text
 	RefreshBtn  *button.PaddedIconBtn
-	ProgressBar *widgets.SizeProgressBar
+	ProgressBar *widgets.StackedSizeBar
+	Legend      *fyne.Container
+
+	MigrationLabel *canvas.Text
+	CleanupLabel   *canvas.Text
 
 	itemMap map[string]weak.Pointer[LocalFileGui]
 }
@@ -142,15 +194,105 @@ func (r *LocalFilesGuiRenderer) Layout(size fyne.Size) {
 	r.ProgressBar.Resize(fyne.NewSize(progressWidth, btnSize))
 	r.ProgressBar.Move(fyne.NewPos(progressX, p/2))
 
+	legendHeight := r.Legend.MinSize().Height
+	r.Legend.Resize(fyne.NewSize(size.Width-p*2, legendHeight))
+	r.Legend.Move(fyne.NewPos(p, topHeight))
+	topHeight += legendHeight
+
+	if r.MigrationLabel.Visible() {
+		migrationHeight := r.MigrationLabel.MinSize().Height
+		r.MigrationLabel.Resize(fyne.NewSize(size.Width-p*2, migrationHeight))
+		r.MigrationLabel.Move(fyne.NewPos(p, topHeight+p))
+		topHeight += migrationHeight + p
+	}
+	if r.CleanupLabel.Visible() {
+		cleanupHeight := r.CleanupLabel.MinSize().Height
+		r.CleanupLabel.Resize(fyne.NewSize(size.Width-p*2, cleanupHeight))
+		r.CleanupLabel.Move(fyne.NewPos(p, topHeight+p))
+		topHeight += cleanupHeight + p
+	}
+
 	r.Scroll.Resize(fyne.NewSize(size.Width, size.Height-topHeight-theme.Padding()))
 	r.Scroll.Move(fyne.NewPos(0, topHeight+theme.Padding()))
 }
 
-func (r *LocalFilesGuiRenderer) updateItems() {
-	totalSize := lo.Reduce(r.g.infos, func(sum int64, info types.CacheFileInfo, _ int) int64 {
-		return sum + info.Size
-	}, 0)
+func (r *LocalFilesGuiRenderer) updateMigration() {
+	progress := r.g.migration
+	if !progress.Running {
+		r.MigrationLabel.Hide()
+		return
+	}
+
+	format := i18n.T("option_legacy")
+	if progress.Target == types.CacheFormatTrunk {
+		format = i18n.T("option_continuous") + "/" + i18n.T("option_fragmented")
+	}
+	percent := 0
+	if progress.CurrentSize > 0 {
+		percent = int(min(progress.CurrentCopied*100/progress.CurrentSize, 100))
+	}
+	r.MigrationLabel.Text = i18n.T("label_cache_migrating", goeasyi18n.Options{
+		Data: map[string]any{
+			"Format":  format,
+			"Done":    progress.Done,
+			"Total":   progress.Total,
+			"Current": progress.Current,
+			"Percent": percent,
+		},
+	})
+	r.MigrationLabel.Show()
+	r.MigrationLabel.Refresh()
+}
 
+func (r *LocalFilesGuiRenderer) updateUsage() {
+	r.Legend.RemoveAll()
+	segments := make([]widgets.SizeSegment, 0, len(r.g.usages))
+	for _, usage := range r.g.usages {
+		if usage.Size == 0 && usage.Quota == 0 {
+			continue
+		}
+		segments = append(segments, widgets.SizeSegment{Size: usage.Size, Color: platformColors[usage.Platform]})
+
+		label := platformNames[usage.Platform] + " " + utils.PrettyByteSize(usage.Size)
+		labelColor := theme.Color(theme.ColorNamePlaceHolder)
+		if usage.Quota > 0 {
+			label += " / " + utils.PrettyByteSize(usage.Quota)
+			if usage.Exceeded() {
+				labelColor = theme.Color(theme.ColorNameError)
+			}
+		}
+		dot := canvas.NewRectangle(platformColors[usage.Platform])
+		dot.SetMinSize(fyne.NewSize(8, 8))
+		dot.CornerRadius = 4
+		text := canvas.NewText(label, labelColor)
+		text.TextSize = 11
+		r.Legend.Add(container.NewHBox(container.NewCenter(dot), text))
+	}
+	r.Legend.Refresh()
+
+	r.ProgressBar.SetTotalSize(cache.GetMaxSize())
+	r.ProgressBar.SetSegments(segments)
+}
+
+func (r *LocalFilesGuiRenderer) updateCleanup() {
+	report := r.g.cleanupReport
+	if report == nil {
+		r.CleanupLabel.Hide()
+		return
+	}
+
+	r.CleanupLabel.Text = i18n.T("label_cache_last_cleanup", goeasyi18n.Options{
+		Data: map[string]any{
+			"Time":  report.Time.Local().Format(time.DateTime),
+			"Count": len(report.Removed),
+			"Size":  utils.PrettyByteSize(report.FreedSize()),
+		},
+	})
+	r.CleanupLabel.Show()
+	r.CleanupLabel.Refresh()
+}
+
+func (r *LocalFilesGuiRenderer) updateItems() {
 	items := lo.Map(r.g.infos, func(info types.CacheFileInfo, _ int) *LocalFileGui {
 		if item, ok := r.itemMap[info.ID]; ok {
 			if v := item.Value(); v != nil {
@@ -172,10 +314,26 @@ func (r *LocalFilesGuiRenderer) updateItems() {
 	r.List.Refresh()
 	r.Scroll.Refresh()
 
-	r.ProgressBar.SetCurrentSize(totalSize)
+	r.updateUsage()
+
+	wasShown := r.CleanupLabel.Visible()
+	r.updateCleanup()
+	if wasShown != r.CleanupLabel.Visible() {
+		r.Layout(r.g.Size())
+	}
 }
 
 func (r *LocalFilesGuiRenderer) Refresh() {
+	if r.g.migrationChanged {
+		r.g.migrationChanged = false
+		wasMigrating := r.MigrationLabel.Visible()
+		r.updateMigration()
+		if wasMigrating != r.MigrationLabel.Visible() {
+			r.Layout(r.g.Size())
+		}
+		return
+	}
+
 	if r.g.changedId != "" {
 		if item, ok := r.itemMap[r.g.changedId]; ok {
 			if v := item.Value(); v != nil {
@@ -197,6 +355,9 @@ func (r *LocalFilesGuiRenderer) Objects() []fyne.CanvasObject {
 		r.Label,
 		r.RefreshBtn,
 		r.ProgressBar,
+		r.Legend,
+		r.MigrationLabel,
+		r.CleanupLabel,
 	}
 }
 
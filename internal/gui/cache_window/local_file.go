@@ -6,14 +6,15 @@ import (
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
+	"github.com/eduardolat/goeasyi18n"
 	"github.com/wzhqwq/VRCDancePreloader/internal/cache"
 	"github.com/wzhqwq/VRCDancePreloader/internal/gui/button"
 	"github.com/wzhqwq/VRCDancePreloader/internal/gui/widgets"
 	"github.com/wzhqwq/VRCDancePreloader/internal/i18n"
 	"github.com/wzhqwq/VRCDancePreloader/internal/persistence"
-	"github.com/wzhqwq/VRCDancePreloader/internal/song/raw_song"
 	"github.com/wzhqwq/VRCDancePreloader/internal/types"
 	"github.com/wzhqwq/VRCDancePreloader/internal/utils"
 )
@@ -44,27 +45,32 @@ func (g *LocalFileGui) UpdateInfo(info types.CacheFileInfo) {
 }
 
 func (g *LocalFileGui) getTitle() string {
-	entry, err := persistence.GetEntry(g.Info.ID)
-	if err == nil {
-		return entry.Title
+	title := cache.GetSongTitle(g.Info.ID)
+	if title == g.Info.ID {
+		return g.Info.ID + ".mp4"
 	}
+	return title
+}
 
-	if pypyId, ok := utils.CheckIdIsPyPy(g.Info.ID); ok {
-		if song, ok := raw_song.FindPyPySong(pypyId); ok {
-			return song.Name
-		}
+func (g *LocalFileGui) export() {
+	if openedWindow == nil {
+		return
 	}
-	if wannaId, ok := utils.CheckIdIsWanna(g.Info.ID); ok {
-		if song, ok := raw_song.FindWannaSong(wannaId); ok {
-			return song.FullTitle()
+	dialog.ShowFolderOpen(func(dir fyne.ListableURI, err error) {
+		if err != nil || dir == nil {
+			return
 		}
-	}
-	if duduId, ok := utils.CheckIdIsDuDu(g.Info.ID); ok {
-		if song, ok := raw_song.FindDuDuSong(duduId); ok {
-			return song.FullTitle()
-		}
-	}
-	return g.Info.ID + ".mp4"
+		go func() {
+			results := cache.ExportCachedSongs([]string{g.Info.ID}, dir.Path())
+			if len(results) > 0 && results[0].Err != nil {
+				fyne.Do(func() {
+					if openedWindow != nil {
+						dialog.ShowError(results[0].Err, openedWindow)
+					}
+				})
+			}
+		}()
+	}, openedWindow)
 }
 
 func (g *LocalFileGui) CreateRenderer() fyne.WidgetRenderer {
@@ -147,6 +153,13 @@ func (r *LocalFileGuiRenderer) RefreshInfos() {
 		partialLabel.TextSize = 12
 		r.Infos.Add(partialLabel)
 	}
+	if r.g.Info.ServeCount > 0 {
+		servedLabel := canvas.NewText(i18n.T("label_cache_served_times", goeasyi18n.Options{
+			Data: map[string]any{"Count": r.g.Info.ServeCount},
+		}), theme.Color(theme.ColorNamePlaceHolder))
+		servedLabel.TextSize = 12
+		r.Infos.Add(servedLabel)
+	}
 }
 
 func (r *LocalFileGuiRenderer) RefreshButtons() {
@@ -170,6 +183,15 @@ func (r *LocalFileGuiRenderer) RefreshButtons() {
 			r.Buttons.Add(deleteBtn)
 		}
 
+		if r.g.Info.IsComplete {
+			exportBtn := button.NewPaddedIconBtn(theme.DocumentSaveIcon())
+			exportBtn.SetMinSquareSize(30)
+			exportBtn.OnClick = func() {
+				r.g.export()
+			}
+			r.Buttons.Add(exportBtn)
+		}
+
 		if !persistence.IsInAllowList(r.g.Info.ID) {
 			addAllowListBtn := button.NewPaddedIconBtn(theme.NavigateNextIcon())
 			addAllowListBtn.SetMinSquareSize(30)
@@ -5,13 +5,11 @@ import (
 	"embed"
 	"image"
 	"image/jpeg"
-	"io"
 	"strings"
 	"sync"
 
-	"github.com/nfnt/resize"
 	"github.com/stephennancekivell/go-future/future"
-	"github.com/wzhqwq/VRCDancePreloader/internal/requesting"
+	"github.com/wzhqwq/VRCDancePreloader/internal/thumbnail_cache"
 	"github.com/wzhqwq/VRCDancePreloader/internal/utils"
 )
 
@@ -131,19 +129,11 @@ func GetThumbnailImage(id, url string) image.Image {
 			}
 		}()
 
-		logger.InfoLn("Downloading thumbnail from ", url)
-		resp, err := requesting.RequestThumbnail(url)
+		data, _, err := thumbnail_cache.GetResized(url, 320)
 		if err != nil {
 			logger.ErrorLn("Failed to get thumbnail:", err)
 			return getThumbnail(defaultThumbnail)
 		}
-		defer resp.Body.Close()
-
-		data, err := io.ReadAll(resp.Body)
-		if err != nil {
-			logger.ErrorLn("Unable to read image data", err)
-			return getThumbnail(defaultThumbnail)
-		}
 
 		img, err := jpeg.Decode(bytes.NewReader(data))
 		if err != nil {
@@ -151,7 +141,7 @@ func GetThumbnailImage(id, url string) image.Image {
 			return getThumbnail(defaultThumbnail)
 		}
 
-		return resize.Resize(320, 0, img, resize.Bilinear)
+		return img
 	})
 
 	cache.Set(key, AsyncImage{i: i, loaded: false})
@@ -31,6 +31,7 @@ func NewBroadcastButton() *BroadcastButton {
 	scroll.SetMinSize(fyne.NewSize(250, 300))
 
 	input := liveConfig.LiveRunner.GetInput(i18n.T("label_broadcast_port"))
+	exposureEditor := config.NewExposureEditor(&liveConfig.Exposure, true, liveConfig.UpdateExposure)
 
 	rich := widget.NewRichTextFromMarkdown(i18n.T("tip_on_live", goeasyi18n.Options{
 		Data: map[string]interface{}{
@@ -62,9 +63,11 @@ func NewBroadcastButton() *BroadcastButton {
 		btn.SetLive(b)
 		if b {
 			input.Show()
+			exposureEditor.Show()
 			rich.Show()
 		} else {
 			input.Hide()
+			exposureEditor.Hide()
 			rich.Hide()
 		}
 	})
@@ -72,6 +75,7 @@ func NewBroadcastButton() *BroadcastButton {
 
 	wholeContent.Add(enableCb)
 	wholeContent.Add(input)
+	wholeContent.Add(exposureEditor)
 	wholeContent.Add(rich)
 
 	go btn.renderLoop()
@@ -2,18 +2,28 @@ package settings
 
 import (
 	"strconv"
+	"strings"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
+	"github.com/eduardolat/goeasyi18n"
 	"github.com/samber/lo"
+	"github.com/wzhqwq/VRCDancePreloader/internal/cache"
 	"github.com/wzhqwq/VRCDancePreloader/internal/config"
+	"github.com/wzhqwq/VRCDancePreloader/internal/exposure"
 	"github.com/wzhqwq/VRCDancePreloader/internal/gui/button"
 	"github.com/wzhqwq/VRCDancePreloader/internal/gui/cache_window"
+	"github.com/wzhqwq/VRCDancePreloader/internal/gui/custom_fyne"
 	"github.com/wzhqwq/VRCDancePreloader/internal/gui/input"
+	"github.com/wzhqwq/VRCDancePreloader/internal/gui/request_window"
+	"github.com/wzhqwq/VRCDancePreloader/internal/hijack"
 	"github.com/wzhqwq/VRCDancePreloader/internal/i18n"
+	"github.com/wzhqwq/VRCDancePreloader/internal/thumbnail_cache"
+	"github.com/wzhqwq/VRCDancePreloader/internal/utils"
 )
 
 func createHijackSettingsContent() fyne.CanvasObject {
@@ -26,6 +36,27 @@ func createHijackSettingsContent() fyne.CanvasObject {
 	))
 
 	wholeContent.Add(hijackConfig.HijackRunner.GetInput(i18n.T("label_hijack_proxy_port")))
+	lanHint := widget.NewLabel("")
+	lanHint.Wrapping = fyne.TextWrapWord
+	updateLanHint := func() {
+		if hijackConfig.Exposure.Bind != exposure.BindLAN {
+			lanHint.Hide()
+			return
+		}
+		addresses := lo.Map(hijack.GetLANAddresses(), func(addr string, _ int) string {
+			return addr + ":" + strconv.Itoa(hijackConfig.ProxyPort)
+		})
+		lanHint.SetText(i18n.T("label_lan_proxy_hint", goeasyi18n.Options{
+			Data: map[string]any{"Addresses": strings.Join(addresses, ", ")},
+		}))
+		lanHint.Show()
+	}
+	updateLanHint()
+	wholeContent.Add(config.NewExposureEditor(&hijackConfig.Exposure, true, func() {
+		hijackConfig.UpdateExposure()
+		updateLanHint()
+	}))
+	wholeContent.Add(lanHint)
 
 	enableHttpsCb := widget.NewCheck(i18n.T("label_hijack_enable_https"), func(b bool) {
 		if hijackConfig.EnableHttps == b {
@@ -47,6 +78,165 @@ func createHijackSettingsContent() fyne.CanvasObject {
 
 	wholeContent.Add(config.NewMultiSelectSites(hijackConfig.InterceptedSites))
 
+	upstreamInput := input.NewInputWithSave(hijackConfig.UpstreamProxy, i18n.T("label_hijack_upstream_proxy"))
+	upstreamInput.OnSave = func() error {
+		return hijackConfig.UpdateUpstreamProxy(strings.TrimSpace(upstreamInput.Value))
+	}
+	wholeContent.Add(upstreamInput)
+
+	upstreamForMissesCb := widget.NewCheck(i18n.T("label_hijack_upstream_for_misses"), func(b bool) {
+		if hijackConfig.UpstreamForMisses == b {
+			return
+		}
+		hijackConfig.UpdateUpstreamForMisses(b)
+	})
+	upstreamForMissesCb.Checked = hijackConfig.UpstreamForMisses
+	wholeContent.Add(upstreamForMissesCb)
+
+	transparentCb := widget.NewCheck(i18n.T("label_hijack_transparent"), func(b bool) {
+		if hijackConfig.Transparent.Enabled == b {
+			return
+		}
+		hijackConfig.UpdateTransparent(b)
+	})
+	transparentCb.Checked = hijackConfig.Transparent.Enabled
+	wholeContent.Add(container.NewHBox(
+		transparentCb,
+		container.NewCenter(button.NewTipButton("tip_on_transparent")),
+	))
+
+	resolverInput := input.NewInputWithSave(hijackConfig.Transparent.Resolver, i18n.T("label_hijack_transparent_resolver"))
+	resolverInput.OnSave = func() error {
+		return hijackConfig.UpdateTransparentResolver(strings.TrimSpace(resolverInput.Value))
+	}
+	wholeContent.Add(resolverInput)
+
+	persistRequestsCb := widget.NewCheck(i18n.T("label_hijack_persist_requests"), func(b bool) {
+		if hijackConfig.PersistRequests == b {
+			return
+		}
+		hijackConfig.UpdatePersistRequests(b)
+	})
+	persistRequestsCb.Checked = hijackConfig.PersistRequests
+	wholeContent.Add(persistRequestsCb)
+
+	inspectBtn := widget.NewButton(i18n.T("btn_inspect_requests"), func() {
+		request_window.OpenRequestWindow()
+	})
+	wholeContent.Add(container.NewHBox(inspectBtn, newSelfCheckButton()))
+
+	return wholeContent
+}
+
+func createCASettingsContent() fyne.CanvasObject {
+	hijackConfig := config.GetHijackConfig()
+
+	wholeContent := container.NewVBox()
+	wholeContent.Add(container.NewHBox(
+		widget.NewLabel(i18n.T("label_ca")),
+		container.NewCenter(button.NewTipButton("tip_on_ca")),
+	))
+
+	infoLabel := widget.NewLabel("")
+	infoLabel.Wrapping = fyne.TextWrapWord
+	trustText := canvas.NewText("", theme.Color(theme.ColorNameForeground))
+	trustText.TextSize = 12
+	resultText := canvas.NewText("", theme.Color(theme.ColorNameForeground))
+	resultText.TextSize = 12
+
+	updateInfo := func() {
+		info := hijack.GetCAInfo()
+		if info == nil {
+			infoLabel.SetText(i18n.T("tip_ca_missing"))
+			trustText.Text = ""
+			trustText.Refresh()
+			return
+		}
+		infoLabel.SetText(i18n.T("wrapper_ca_info", goeasyi18n.Options{
+			Data: map[string]any{
+				"Subject":     info.Subject,
+				"Fingerprint": info.Fingerprint[:16],
+				"Expiry":      info.NotAfter.Format("2006-01-02"),
+				"Path":        info.CertPath,
+			},
+		}))
+		if info.Trusted {
+			trustText.Text = i18n.T("tip_ca_trusted")
+			trustText.Color = theme.Color(theme.ColorNameSuccess)
+		} else {
+			trustText.Text = i18n.T("tip_ca_not_trusted")
+			trustText.Color = theme.Color(theme.ColorNameWarning)
+		}
+		trustText.Refresh()
+	}
+	updateInfo()
+
+	showResult := func(err error, okKey string) {
+		fyne.Do(func() {
+			if err != nil {
+				resultText.Text = err.Error()
+				resultText.Color = theme.Color(theme.ColorNameError)
+			} else {
+				resultText.Text = i18n.T(okKey)
+				resultText.Color = theme.Color(theme.ColorNameSuccess)
+			}
+			resultText.Refresh()
+		})
+	}
+
+	exportBtn := widget.NewButton(i18n.T("btn_export_ca"), func() {
+		saveDialog := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
+			if err != nil || writer == nil {
+				return
+			}
+			defer writer.Close()
+			showResult(hijack.ExportCA(writer, hijack.CAFormatFromPath(writer.URI().Path())), "tip_ca_exported")
+		}, custom_fyne.GetParent())
+		saveDialog.SetFileName("VRCDancePreloader.crt")
+		saveDialog.Show()
+	})
+
+	var selfTestBtn *widget.Button
+	selfTestBtn = widget.NewButton(i18n.T("btn_test"), func() {
+		selfTestBtn.SetText(i18n.T("btn_testing"))
+		selfTestBtn.Disable()
+		go func() {
+			err := hijack.SelfTestCA()
+			showResult(err, "tip_ca_self_test_pass")
+			fyne.Do(func() {
+				selfTestBtn.SetText(i18n.T("btn_test"))
+				selfTestBtn.Enable()
+				updateInfo()
+			})
+		}()
+	})
+
+	rotateBtn := widget.NewButton(i18n.T("btn_rotate_ca"), func() {
+		dialog.NewCustomConfirm(
+			i18n.T("message_title_rotate_ca"),
+			i18n.T("confirm_rotate_ca"),
+			i18n.T("reject_rotate_ca"),
+			&widget.Label{
+				Alignment: fyne.TextAlignCenter,
+				Text:      i18n.T("message_rotate_ca"),
+				Wrapping:  fyne.TextWrapWord,
+			},
+			func(confirmed bool) {
+				if !confirmed {
+					return
+				}
+				showResult(hijackConfig.RotateCA(), "tip_ca_rotated")
+				updateInfo()
+			},
+			custom_fyne.GetParent(),
+		).Show()
+	})
+
+	wholeContent.Add(infoLabel)
+	wholeContent.Add(trustText)
+	wholeContent.Add(container.NewHBox(exportBtn, selfTestBtn, rotateBtn))
+	wholeContent.Add(resultText)
+
 	return wholeContent
 }
 
@@ -62,8 +252,7 @@ func createProxySettingsContent() fyne.CanvasObject {
 	wholeContent.Add(proxyConfig.ProxyControllers["wannadance-api"].GetInput(i18n.T("label_wanna_proxy")))
 	wholeContent.Add(proxyConfig.ProxyControllers["dudu-fitdance-api"].GetInput(i18n.T("label_dudu_proxy")))
 	wholeContent.Add(proxyConfig.ProxyControllers["bilibili-api"].GetInput(i18n.T("label_bili_proxy")))
-	//TODO cancel comment after implemented youtube preloading
-	//wholeContent.Add(proxyConfig.ProxyControllers["youtube-video"].GetInput(i18n.T("label_yt_video_proxy")))
+	wholeContent.Add(proxyConfig.ProxyControllers["youtube-video"].GetInput(i18n.T("label_yt_video_proxy")))
 	wholeContent.Add(proxyConfig.ProxyControllers["youtube-api"].GetInput(i18n.T("label_yt_api_proxy")))
 	wholeContent.Add(proxyConfig.ProxyControllers["youtube-image"].GetInput(i18n.T("label_yt_image_proxy")))
 
@@ -121,19 +310,31 @@ func createYoutubeSettingsContent() fyne.CanvasObject {
 	enableThumbnailCheck.Checked = youtubeConfig.EnableThumbnail
 	wholeContent.Add(enableThumbnailCheck)
 
-	//TODO cancel comment after implemented youtube preloading
-	//enableVideoCheck := widget.NewCheck(i18n.T("label_yt_video_enable"), func(b bool) {
-	//	if config.Youtube.EnableVideo == b {
-	//		return
-	//	}
-	//	config.Youtube.EnableVideo = b
-	//	SaveConfig()
-	//	if b && config.Proxy.ProxyControllers["youtube-video"].Status != ProxyStatusOk {
-	//		config.Proxy.ProxyControllers["youtube-video"].Test()
-	//	}
-	//})
-	//enableVideoCheck.Checked = config.Youtube.EnableVideo
-	//wholeContent.Add(enableVideoCheck)
+	enableVideoCheck := widget.NewCheck(i18n.T("label_yt_video_enable"), func(b bool) {
+		if youtubeConfig.EnableVideo == b {
+			return
+		}
+		youtubeConfig.UpdateEnableVideo(b)
+		if b {
+			proxyConfig.ProxyControllers["youtube-video"].TestIfNotOk()
+		}
+	})
+	enableVideoCheck.Checked = youtubeConfig.EnableVideo
+	wholeContent.Add(enableVideoCheck)
+
+	ytDlpPathInput := input.NewInputWithSave(youtubeConfig.YtDlpPath, i18n.T("label_yt_dlp_path"))
+	ytDlpPathInput.OnSave = func() error {
+		youtubeConfig.UpdateYtDlpPath(ytDlpPathInput.Value)
+		return nil
+	}
+	wholeContent.Add(ytDlpPathInput)
+
+	ytDlpFormatInput := input.NewInputWithSave(youtubeConfig.YtDlpFormat, i18n.T("label_yt_dlp_format"))
+	ytDlpFormatInput.OnSave = func() error {
+		youtubeConfig.UpdateYtDlpFormat(ytDlpFormatInput.Value)
+		return nil
+	}
+	wholeContent.Add(ytDlpFormatInput)
 
 	return wholeContent
 }
@@ -216,6 +417,12 @@ func createCacheSettingsContent() fyne.CanvasObject {
 	formatSelect.Horizontal = true
 	wholeContent.Add(container.NewVBox(formatLabel, formatSelect))
 
+	migrateFormatCheck := widget.NewCheck(i18n.T("label_migrate_format"), func(b bool) {
+		cacheConfig.UpdateMigrateFormat(b)
+	})
+	migrateFormatCheck.Checked = cacheConfig.MigrateFormat
+	wholeContent.Add(migrateFormatCheck)
+
 	maxCacheInput := input.NewInputWithSave(strconv.Itoa(cacheConfig.MaxCacheSize), i18n.T("label_max_cache_size"))
 	maxCacheInput.ForceDigits = true
 	maxCacheInput.OnSave = func() error {
@@ -229,6 +436,45 @@ func createCacheSettingsContent() fyne.CanvasObject {
 	maxCacheInput.InputAppendItems = []fyne.CanvasObject{widget.NewLabel("MB")}
 	wholeContent.Add(maxCacheInput)
 
+	quotaLabel := canvas.NewText(i18n.T("label_platform_quota"), theme.Color(theme.ColorNamePlaceHolder))
+	quotaLabel.TextSize = 12
+	wholeContent.Add(quotaLabel)
+	for _, platform := range cache.Platforms {
+		quotaInput := input.NewInputWithSave(
+			strconv.Itoa(cacheConfig.PlatformQuotas[platform]),
+			i18n.T("label_quota_"+platform),
+		)
+		quotaInput.ForceDigits = true
+		quotaInput.OnSave = func() error {
+			size, err := strconv.Atoi(quotaInput.Value)
+			if err != nil {
+				return err
+			}
+			cacheConfig.UpdatePlatformQuota(platform, size)
+			return nil
+		}
+		quotaInput.InputAppendItems = []fyne.CanvasObject{widget.NewLabel("MB")}
+		wholeContent.Add(quotaInput)
+	}
+
+	evictionPolicies := cache.AllEvictionPolicies()
+	evictionOptions := lo.Map(evictionPolicies, func(policy string, _ int) string {
+		return i18n.T("option_eviction_" + policy)
+	})
+
+	evictionLabel := canvas.NewText(i18n.T("label_eviction_policy"), theme.Color(theme.ColorNamePlaceHolder))
+	evictionLabel.TextSize = 12
+	evictionSelect := widget.NewRadioGroup(evictionOptions, func(option string) {
+		index := lo.IndexOf(evictionOptions, option)
+		if index == -1 {
+			index = 0
+		}
+		cacheConfig.UpdateEvictionPolicy(evictionPolicies[index])
+	})
+	evictionSelect.Selected = evictionOptions[max(lo.IndexOf(evictionPolicies, cacheConfig.EvictionPolicy), 0)]
+	evictionSelect.Horizontal = true
+	wholeContent.Add(container.NewVBox(evictionLabel, evictionSelect))
+
 	keepFavoriteCheck := widget.NewCheck(i18n.T("label_keep_favorites"), func(b bool) {
 		cacheConfig.UpdateKeepFavorites(b)
 	})
@@ -248,3 +494,106 @@ func createCacheSettingsContent() fyne.CanvasObject {
 
 	return wholeContent
 }
+
+func createThumbnailSettingsContent() fyne.CanvasObject {
+	thumbnailConfig := config.GetThumbnailConfig()
+
+	wholeContent := container.NewVBox()
+	wholeContent.Add(widget.NewLabel(i18n.T("label_thumbnail")))
+
+	pathInput := input.NewInputWithSave(thumbnailConfig.Path, i18n.T("label_thumbnail_path"))
+	pathInput.OnSave = func() error {
+		thumbnailConfig.Path = pathInput.Value
+		config.SaveConfig()
+		return nil
+	}
+	wholeContent.Add(pathInput)
+
+	maxSizeInput := input.NewInputWithSave(strconv.Itoa(thumbnailConfig.MaxSize), i18n.T("label_thumbnail_max_size"))
+	maxSizeInput.ForceDigits = true
+	maxSizeInput.OnSave = func() error {
+		size, err := strconv.Atoi(maxSizeInput.Value)
+		if err != nil {
+			return err
+		}
+		thumbnailConfig.UpdateMaxSize(size)
+		return nil
+	}
+	maxSizeInput.InputAppendItems = []fyne.CanvasObject{widget.NewLabel("MB")}
+	wholeContent.Add(maxSizeInput)
+
+	ttlInput := input.NewInputWithSave(strconv.Itoa(thumbnailConfig.TTL), i18n.T("label_thumbnail_ttl"))
+	ttlInput.ForceDigits = true
+	ttlInput.OnSave = func() error {
+		hours, err := strconv.Atoi(ttlInput.Value)
+		if err != nil {
+			return err
+		}
+		thumbnailConfig.UpdateTTL(hours)
+		return nil
+	}
+	ttlInput.InputAppendItems = []fyne.CanvasObject{widget.NewLabel(i18n.T("label_hours"))}
+	wholeContent.Add(ttlInput)
+
+	interceptCheck := widget.NewCheck(i18n.T("label_thumbnail_intercept"), func(b bool) {
+		if thumbnailConfig.Intercept == b {
+			return
+		}
+		thumbnailConfig.UpdateIntercept(b)
+	})
+	interceptCheck.Checked = thumbnailConfig.Intercept
+	wholeContent.Add(interceptCheck)
+
+	clearBtn := widget.NewButton(i18n.T("btn_clear_thumbnails"), func() {
+		thumbnail_cache.Clear()
+	})
+	wholeContent.Add(clearBtn)
+
+	return wholeContent
+}
+
+func createPeerSettingsContent() fyne.CanvasObject {
+	peerConfig := config.GetPeerConfig()
+
+	wholeContent := container.NewVBox()
+	wholeContent.Add(container.NewHBox(
+		widget.NewLabel(i18n.T("label_peer")),
+		container.NewCenter(button.NewTipButton("tip_on_peer")),
+	))
+
+	enableCheck := widget.NewCheck(i18n.T("label_peer_enable"), func(b bool) {
+		if peerConfig.Enabled == b {
+			return
+		}
+		peerConfig.UpdateEnable(b)
+	})
+	enableCheck.Checked = peerConfig.Enabled
+	wholeContent.Add(enableCheck)
+
+	wholeContent.Add(peerConfig.PeerRunner.GetInput(i18n.T("label_peer_port")))
+
+	discoveryCheck := widget.NewCheck(i18n.T("label_peer_discovery"), func(b bool) {
+		if peerConfig.Discovery == b {
+			return
+		}
+		peerConfig.UpdateDiscovery(b)
+	})
+	discoveryCheck.Checked = peerConfig.Discovery
+	wholeContent.Add(discoveryCheck)
+
+	peersInput := input.NewInputWithSave(strings.Join(peerConfig.Peers, ", "), i18n.T("label_static_peers"))
+	peersInput.OnSave = func() error {
+		peerConfig.UpdatePeers(utils.SplitList(peersInput.Value))
+		return nil
+	}
+	wholeContent.Add(peersInput)
+
+	allowListInput := input.NewInputWithSave(strings.Join(peerConfig.AllowList, ", "), i18n.T("label_peer_allow_list"))
+	allowListInput.OnSave = func() error {
+		peerConfig.UpdateAllowList(utils.SplitList(allowListInput.Value))
+		return nil
+	}
+	wholeContent.Add(allowListInput)
+
+	return wholeContent
+}
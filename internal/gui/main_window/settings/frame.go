@@ -16,12 +16,15 @@ func CreateSettingsContainer() fyne.CanvasObject {
 			3,
 			300,
 			widgets.NewCard(createHijackSettingsContent()),
+			widgets.NewCard(createCASettingsContent()),
 			widgets.NewCard(createProxySettingsContent()),
 			widgets.NewCard(createKeySettingsContent()),
 			widgets.NewCard(createYoutubeSettingsContent()),
 			widgets.NewCard(createPreloadSettingsContent()),
 			widgets.NewCard(createDownloadSettingsContent()),
 			widgets.NewCard(createCacheSettingsContent()),
+			widgets.NewCard(createThumbnailSettingsContent()),
+			widgets.NewCard(createPeerSettingsContent()),
 		),
 	)
 	scroll.SetMinSize(fyne.NewSize(300, 300))
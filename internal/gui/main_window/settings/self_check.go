@@ -0,0 +1,73 @@
+package settings
+
+import (
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"github.com/wzhqwq/VRCDancePreloader/internal/config"
+	"github.com/wzhqwq/VRCDancePreloader/internal/gui/custom_fyne"
+	"github.com/wzhqwq/VRCDancePreloader/internal/hijack"
+	"github.com/wzhqwq/VRCDancePreloader/internal/i18n"
+)
+
+func statusColorOf(status hijack.CheckStatus) fyne.ThemeColorName {
+	switch status {
+	case hijack.CheckPassed:
+		return theme.ColorNameSuccess
+	case hijack.CheckWarning:
+		return theme.ColorNameWarning
+	case hijack.CheckFailed:
+		return theme.ColorNameError
+	}
+	return theme.ColorNamePlaceHolder
+}
+
+func newSelfCheckRow(result hijack.CheckResult) fyne.CanvasObject {
+	status := canvas.NewText(i18n.T("status_check_"+string(result.Status)), theme.Color(statusColorOf(result.Status)))
+	status.TextStyle.Bold = true
+
+	text := result.Name
+	if result.Detail != "" {
+		text += ": " + result.Detail
+	}
+	detail := widget.NewLabel(text)
+	detail.Wrapping = fyne.TextWrapWord
+
+	return container.NewBorder(nil, nil, container.NewCenter(status), nil, detail)
+}
+
+func showSelfCheckResults(results []hijack.CheckResult) {
+	list := container.NewVBox()
+	for _, result := range results {
+		list.Add(newSelfCheckRow(result))
+	}
+	scroll := container.NewVScroll(list)
+	scroll.SetMinSize(fyne.NewSize(600, 400))
+
+	dialog.NewCustom(
+		i18n.T("message_title_self_check"),
+		i18n.T("btn_close"),
+		scroll,
+		custom_fyne.GetParent(),
+	).Show()
+}
+
+func newSelfCheckButton() *widget.Button {
+	var btn *widget.Button
+	btn = widget.NewButton(i18n.T("btn_self_check"), func() {
+		btn.SetText(i18n.T("btn_testing"))
+		btn.Disable()
+		go func() {
+			results := config.GetHijackConfig().SelfCheck()
+			fyne.Do(func() {
+				btn.SetText(i18n.T("btn_self_check"))
+				btn.Enable()
+				showSelfCheckResults(results)
+			})
+		}()
+	})
+	return btn
+}
@@ -0,0 +1,211 @@
+package statistics
+
+import (
+	"fmt"
+	"strconv"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"github.com/eduardolat/goeasyi18n"
+	"github.com/wzhqwq/VRCDancePreloader/internal/i18n"
+	"github.com/wzhqwq/VRCDancePreloader/internal/stats"
+	"github.com/wzhqwq/VRCDancePreloader/internal/utils"
+)
+
+var logger = utils.NewLogger("Stats GUI")
+
+const statsTop = 10
+
+// only the latest days and weeks are charted
+const maxDays = 14
+const maxWeeks = 8
+
+var rangeNames = []string{"7d", "30d", "year", "all"}
+
+type StatsGui struct {
+	widget.BaseWidget
+
+	rangeName string
+
+	content *fyne.Container
+}
+
+func NewStatsGui() *StatsGui {
+	g := &StatsGui{
+		rangeName: "30d",
+		content:   container.NewVBox(),
+	}
+	g.ExtendBaseWidget(g)
+	return g
+}
+
+// Activate recomputes the stats, they're not updated while the tab is hidden
+func (g *StatsGui) Activate() {
+	g.refreshStats()
+}
+
+func (g *StatsGui) refreshStats() {
+	statsRange, _ := stats.RangeByName(g.rangeName)
+	go func() {
+		summary, err := stats.Compute(statsRange, statsTop)
+		if err != nil {
+			logger.ErrorLn("Failed to compute stats:", err)
+			return
+		}
+		fyne.Do(func() {
+			g.showSummary(summary)
+		})
+	}()
+}
+
+func formatMinutes(minutes float64) string {
+	return strconv.FormatFloat(minutes, 'f', 0, 64)
+}
+
+func sectionTitle(key string) fyne.CanvasObject {
+	return widget.NewLabelWithStyle(i18n.T(key), fyne.TextAlignLeading, fyne.TextStyle{Bold: true})
+}
+
+func newCountRow(rank int, name string, plays int, minutes float64) fyne.CanvasObject {
+	label := widget.NewLabel(fmt.Sprintf("%d. %s", rank, name))
+	label.Truncation = fyne.TextTruncateEllipsis
+	detail := widget.NewLabel(i18n.T("label_stats_plays", goeasyi18n.Options{
+		Data: map[string]string{"Plays": strconv.Itoa(plays), "Minutes": formatMinutes(minutes)},
+	}))
+	return container.NewBorder(nil, nil, nil, detail, label)
+}
+
+func countRows(key string, counts []stats.Count) []fyne.CanvasObject {
+	if len(counts) == 0 {
+		return nil
+	}
+	rows := []fyne.CanvasObject{sectionTitle(key)}
+	for i, c := range counts {
+		rows = append(rows, newCountRow(i+1, c.Name, c.Plays, c.Minutes))
+	}
+	return rows
+}
+
+func songRows(key string, songs []stats.SongCount) []fyne.CanvasObject {
+	if len(songs) == 0 {
+		return nil
+	}
+	rows := []fyne.CanvasObject{sectionTitle(key)}
+	for i, s := range songs {
+		name := s.Title
+		if name == "" {
+			name = s.ID
+		}
+		if s.Group != "" {
+			name = fmt.Sprintf("%s (%s)", name, s.Group)
+		}
+		rows = append(rows, newCountRow(i+1, name, s.Plays, s.Minutes))
+	}
+	return rows
+}
+
+// periodRows charts the minutes of the latest n periods, labeled by the date key
+func periodRows(key, dateKey string, periods []stats.Period, n int) []fyne.CanvasObject {
+	if len(periods) == 0 {
+		return nil
+	}
+	periods = periods[max(0, len(periods)-n):]
+
+	maxMinutes := 1.0
+	for _, p := range periods {
+		maxMinutes = max(maxMinutes, p.Minutes)
+	}
+
+	rows := []fyne.CanvasObject{sectionTitle(key)}
+	for _, p := range periods {
+		bar := widget.NewProgressBar()
+		bar.Max = maxMinutes
+		bar.SetValue(p.Minutes)
+		bar.TextFormatter = func() string {
+			return i18n.T("label_stats_minutes", goeasyi18n.Options{
+				Data: map[string]string{"Minutes": formatMinutes(p.Minutes)},
+			})
+		}
+		date := widget.NewLabel(i18n.T(dateKey, goeasyi18n.Options{Data: i18n.ParseDate(p.Start)}))
+		rows = append(rows, container.NewBorder(nil, nil, date, nil, bar))
+	}
+	return rows
+}
+
+func overviewRows(summary *stats.Summary) []fyne.CanvasObject {
+	overview := widget.NewLabel(i18n.T("label_stats_overview", goeasyi18n.Options{
+		Data: map[string]string{
+			"Plays":   strconv.Itoa(summary.Plays),
+			"Songs":   strconv.Itoa(summary.Songs),
+			"Minutes": formatMinutes(summary.Minutes),
+			"Days":    strconv.Itoa(summary.DanceDays),
+		},
+	}))
+	overview.Wrapping = fyne.TextWrapWord
+	streak := widget.NewLabel(i18n.T("label_stats_streak", goeasyi18n.Options{
+		Data: map[string]string{
+			"Longest": strconv.Itoa(summary.LongestStreak.Days),
+			"Current": strconv.Itoa(summary.CurrentStreak.Days),
+		},
+	}))
+	return []fyne.CanvasObject{overview, streak}
+}
+
+func (g *StatsGui) showSummary(summary *stats.Summary) {
+	g.content.RemoveAll()
+	if summary.Plays == 0 {
+		g.content.Add(widget.NewLabel(i18n.T("label_stats_empty")))
+		g.content.Refresh()
+		return
+	}
+
+	sections := [][]fyne.CanvasObject{
+		overviewRows(summary),
+		periodRows("title_stats_daily", "label_stats_date", summary.Daily, maxDays),
+		periodRows("title_stats_weekly", "label_stats_week", summary.Weekly, maxWeeks),
+		songRows("title_stats_top_songs", summary.TopSongs),
+		countRows("title_stats_top_adders", summary.TopAdders),
+		countRows("title_stats_top_groups", summary.TopGroups),
+		countRows("title_stats_rooms", summary.Rooms),
+		songRows("title_stats_new_songs", summary.NewSongs),
+	}
+	for _, rows := range sections {
+		if len(rows) == 0 {
+			continue
+		}
+		for _, row := range rows {
+			g.content.Add(row)
+		}
+		g.content.Add(widget.NewSeparator())
+	}
+	g.content.Refresh()
+}
+
+func (g *StatsGui) CreateRenderer() fyne.WidgetRenderer {
+	options := make([]string, len(rangeNames))
+	for i, name := range rangeNames {
+		options[i] = i18n.T("option_range_" + name)
+	}
+	rangeSelect := widget.NewSelect(options, func(value string) {
+		for i, option := range options {
+			if option == value {
+				g.rangeName = rangeNames[i]
+			}
+		}
+		g.refreshStats()
+	})
+	rangeSelect.Selected = i18n.T("option_range_" + g.rangeName)
+
+	refreshBtn := widget.NewButtonWithIcon("", theme.ViewRefreshIcon(), func() {
+		g.refreshStats()
+	})
+
+	topBar := container.NewHBox(rangeSelect, refreshBtn, newWrappedButton())
+
+	scroll := container.NewVScroll(container.NewPadded(g.content))
+	scroll.SetMinSize(fyne.NewSize(300, 400))
+
+	return widget.NewSimpleRenderer(container.NewBorder(container.NewPadded(topBar), nil, nil, nil, scroll))
+}
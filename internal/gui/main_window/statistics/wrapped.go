@@ -0,0 +1,101 @@
+package statistics
+
+import (
+	"strconv"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	"github.com/eduardolat/goeasyi18n"
+	"github.com/wzhqwq/VRCDancePreloader/internal/gui/custom_fyne"
+	"github.com/wzhqwq/VRCDancePreloader/internal/i18n"
+	"github.com/wzhqwq/VRCDancePreloader/internal/stats"
+)
+
+func wrappedData(t time.Time, minutes float64) map[string]string {
+	data := i18n.ParseDate(t)
+	data["Minutes"] = formatMinutes(minutes)
+	return data
+}
+
+func highlightRows(wrapped *stats.Wrapped) []fyne.CanvasObject {
+	var rows []fyne.CanvasObject
+	add := func(key string, data map[string]string) {
+		label := widget.NewLabel(i18n.T(key, goeasyi18n.Options{Data: data}))
+		label.Wrapping = fyne.TextWrapWord
+		rows = append(rows, label)
+	}
+
+	if first := wrapped.FirstSong; first != nil {
+		data := i18n.ParseDate(first.Time)
+		data["Title"] = first.Title
+		add("label_wrapped_first_song", data)
+	}
+	if len(wrapped.TopSongs) > 0 {
+		add("label_wrapped_top_song", map[string]string{
+			"Title": wrapped.TopSongs[0].Title,
+			"Plays": strconv.Itoa(wrapped.TopSongs[0].Plays),
+		})
+	}
+	if len(wrapped.TopAdders) > 0 {
+		add("label_wrapped_top_adder", map[string]string{
+			"Name":  wrapped.TopAdders[0].Name,
+			"Plays": strconv.Itoa(wrapped.TopAdders[0].Plays),
+		})
+	}
+	if day := wrapped.BusiestDay; day != nil {
+		add("label_wrapped_busiest_day", wrappedData(day.Start, day.Minutes))
+	}
+	if month := wrapped.BusiestMonth; month != nil {
+		add("label_wrapped_busiest_month", wrappedData(month.Start, month.Minutes))
+	}
+	add("label_wrapped_new_songs", map[string]string{"Count": strconv.Itoa(len(wrapped.NewSongs))})
+	return rows
+}
+
+func showWrapped(wrapped *stats.Wrapped) {
+	list := container.NewVBox()
+	if wrapped.Plays == 0 {
+		list.Add(widget.NewLabel(i18n.T("label_stats_empty")))
+	} else {
+		for _, row := range overviewRows(&wrapped.Summary) {
+			list.Add(row)
+		}
+		list.Add(widget.NewSeparator())
+		for _, row := range highlightRows(wrapped) {
+			list.Add(row)
+		}
+		list.Add(widget.NewSeparator())
+		for _, row := range periodRows("title_stats_monthly", "label_stats_month", wrapped.Monthly, 12) {
+			list.Add(row)
+		}
+	}
+	scroll := container.NewVScroll(list)
+	scroll.SetMinSize(fyne.NewSize(400, 400))
+
+	dialog.NewCustom(
+		i18n.T("message_title_wrapped", goeasyi18n.Options{
+			Data: map[string]string{"Year": strconv.Itoa(wrapped.Year)},
+		}),
+		i18n.T("btn_close"),
+		scroll,
+		custom_fyne.GetParent(),
+	).Show()
+}
+
+func newWrappedButton() *widget.Button {
+	return widget.NewButton(i18n.T("btn_wrapped"), func() {
+		go func() {
+			wrapped, err := stats.ComputeWrapped(time.Now().Year())
+			if err != nil {
+				logger.ErrorLn("Failed to compute wrapped:", err)
+				return
+			}
+			fyne.Do(func() {
+				showWrapped(wrapped)
+			})
+		}()
+	})
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/wzhqwq/VRCDancePreloader/internal/gui/main_window/history"
 	"github.com/wzhqwq/VRCDancePreloader/internal/gui/main_window/playlist"
 	"github.com/wzhqwq/VRCDancePreloader/internal/gui/main_window/settings"
+	"github.com/wzhqwq/VRCDancePreloader/internal/gui/main_window/statistics"
 	"github.com/wzhqwq/VRCDancePreloader/internal/i18n"
 )
 
@@ -24,18 +25,23 @@ func MainWindow() fyne.Window {
 	playlistGui := playlist.NewPlaylistManager()
 	historyGui := history.NewGui()
 	favoritesGui := favorite.NewFavoritesGui()
+	statsGui := statistics.NewStatsGui()
 	settingsGui := settings.CreateSettingsContainer()
 
 	tabs := container.NewAppTabs(
 		container.NewTabItem(i18n.T("btn_playlist"), playlistGui),
 		container.NewTabItem(i18n.T("btn_history"), historyGui),
 		container.NewTabItem(i18n.T("btn_favorites"), favoritesGui),
+		container.NewTabItem(i18n.T("btn_stats"), statsGui),
 		container.NewTabItem(i18n.T("btn_settings"), settingsGui),
 	)
 	tabs.OnSelected = func(item *container.TabItem) {
 		if tabs.SelectedIndex() == 2 {
 			favoritesGui.Activate()
 		}
+		if tabs.SelectedIndex() == 3 {
+			statsGui.Activate()
+		}
 	}
 	w.SetContent(tabs)
 	w.SetPadded(false)
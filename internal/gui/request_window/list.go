@@ -0,0 +1,168 @@
+package request_window
+
+import (
+	"fmt"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"github.com/eduardolat/goeasyi18n"
+	"github.com/wzhqwq/VRCDancePreloader/internal/hijack"
+	"github.com/wzhqwq/VRCDancePreloader/internal/i18n"
+	"github.com/wzhqwq/VRCDancePreloader/internal/persistence"
+	"github.com/wzhqwq/VRCDancePreloader/internal/types"
+	"github.com/wzhqwq/VRCDancePreloader/internal/utils"
+)
+
+const persistedLimit = 200
+
+type RequestsGui struct {
+	widget.BaseWidget
+
+	requests []types.InterceptedRequest
+	// show the requests in the database instead of the recent ones
+	showPersisted bool
+
+	list *widget.List
+
+	stopCh chan struct{}
+}
+
+func NewRequestsGui() *RequestsGui {
+	g := &RequestsGui{
+		requests: hijack.GetInterceptedRequests(),
+		stopCh:   make(chan struct{}),
+	}
+	g.ExtendBaseWidget(g)
+
+	go g.RenderLoop()
+
+	return g
+}
+
+func (g *RequestsGui) RenderLoop() {
+	ch := hijack.SubscribeInterceptedRequestEvent()
+	defer ch.Close()
+
+	for {
+		select {
+		case <-g.stopCh:
+			return
+		case <-ch.Channel:
+			if !g.showPersisted {
+				g.reload()
+			}
+		}
+	}
+}
+
+func (g *RequestsGui) reload() {
+	var requests []types.InterceptedRequest
+	if g.showPersisted {
+		requests = persistence.ListRequestLogs(persistedLimit)
+	} else {
+		requests = hijack.GetInterceptedRequests()
+	}
+	fyne.Do(func() {
+		g.requests = requests
+		if g.list != nil {
+			g.list.Refresh()
+		}
+	})
+}
+
+func (g *RequestsGui) Stop() {
+	close(g.stopCh)
+}
+
+func (g *RequestsGui) CreateRenderer() fyne.WidgetRenderer {
+	g.list = widget.NewList(
+		func() int {
+			return len(g.requests)
+		},
+		func() fyne.CanvasObject {
+			title := canvas.NewText("", theme.Color(theme.ColorNameForeground))
+			title.TextSize = 14
+			detail := canvas.NewText("", theme.Color(theme.ColorNamePlaceHolder))
+			detail.TextSize = 12
+			return container.NewVBox(title, detail)
+		},
+		func(id widget.ListItemID, object fyne.CanvasObject) {
+			if id >= len(g.requests) {
+				return
+			}
+			r := g.requests[id]
+			texts := object.(*fyne.Container).Objects
+			title := texts[0].(*canvas.Text)
+			detail := texts[1].(*canvas.Text)
+
+			title.Text = requestTitle(r)
+			if r.IsServed() {
+				title.Color = theme.Color(theme.ColorNameForeground)
+			} else {
+				title.Color = theme.Color(theme.ColorNameError)
+			}
+			title.Refresh()
+
+			detail.Text = requestDetail(r)
+			detail.Refresh()
+		},
+	)
+
+	sourceCheck := widget.NewCheck(i18n.T("label_requests_show_persisted"), func(b bool) {
+		g.showPersisted = b
+		go g.reload()
+	})
+
+	clearBtn := widget.NewButton(i18n.T("btn_clear_requests"), func() {
+		if g.showPersisted {
+			persistence.ClearRequestLogs()
+			go g.reload()
+		} else {
+			hijack.ClearInterceptedRequests()
+		}
+	})
+
+	toolbar := container.NewHBox(sourceCheck, clearBtn)
+
+	return widget.NewSimpleRenderer(container.NewBorder(toolbar, nil, nil, nil, g.list))
+}
+
+func requestTitle(r types.InterceptedRequest) string {
+	rangeHeader := r.Range
+	if rangeHeader == "" {
+		rangeHeader = i18n.T("label_request_full")
+	}
+	return fmt.Sprintf("#%d %s %s %s  %s", r.ID, r.Time.Format("15:04:05"), r.Platform, r.VideoID, rangeHeader)
+}
+
+func requestDetail(r types.InterceptedRequest) string {
+	parts := []string{
+		i18n.T("label_request_cache_"+r.CacheState, goeasyi18n.Options{
+			Data: map[string]any{"Size": utils.PrettyByteSize(r.CachedSize)},
+		}),
+	}
+	if !r.IsServed() {
+		parts = append(parts, i18n.T("wrapper_request_fallback", goeasyi18n.Options{
+			Data: map[string]any{"Reason": r.Fallback},
+		}))
+		return strings.Join(parts, " · ")
+	}
+	if r.Status > 0 {
+		parts = append(parts, fmt.Sprintf("%d", r.Status))
+	}
+	parts = append(parts, utils.PrettyByteSize(r.BytesServed))
+	parts = append(parts, i18n.T("wrapper_request_timing", goeasyi18n.Options{
+		Data: map[string]any{"TTFB": r.TTFB, "Duration": r.Duration},
+	}))
+	if !r.Finished {
+		parts = append(parts, i18n.T("label_request_serving"))
+	}
+	if r.Client != "" {
+		parts = append(parts, r.Client)
+	}
+	return strings.Join(parts, " · ")
+}
@@ -0,0 +1,28 @@
+package request_window
+
+import (
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"github.com/wzhqwq/VRCDancePreloader/internal/gui/custom_fyne"
+	"github.com/wzhqwq/VRCDancePreloader/internal/i18n"
+)
+
+var openedWindow fyne.Window
+
+func OpenRequestWindow() {
+	if openedWindow != nil {
+		openedWindow.RequestFocus()
+		return
+	}
+
+	openedWindow = custom_fyne.NewWindow(i18n.T("label_requests"))
+	requests := NewRequestsGui()
+
+	openedWindow.SetContent(container.NewPadded(requests))
+	openedWindow.Resize(fyne.NewSize(600, 500))
+	openedWindow.Show()
+	openedWindow.SetOnClosed(func() {
+		requests.Stop()
+		openedWindow = nil
+	})
+}
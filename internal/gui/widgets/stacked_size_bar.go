@@ -0,0 +1,156 @@
+package widgets
+
+import (
+	"image/color"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"github.com/samber/lo"
+	"github.com/wzhqwq/VRCDancePreloader/internal/gui/custom_fyne"
+	"github.com/wzhqwq/VRCDancePreloader/internal/utils"
+)
+
+type SizeSegment struct {
+	Size  int64
+	Color color.Color
+}
+
+// StackedSizeBar shows sizes of several parts in one bar, the limit is marked if they exceed it
+type StackedSizeBar struct {
+	widget.BaseWidget
+
+	Background *canvas.Rectangle
+	Bars       []*canvas.Rectangle
+	LimitLine  *canvas.Rectangle
+	Text       *canvas.Text
+
+	TotalSize int64
+	Segments  []SizeSegment
+}
+
+func NewStackedSizeBar(totalSize int64) *StackedSizeBar {
+	background := canvas.NewRectangle(theme.Color(custom_fyne.ColorNamePrimaryGrayscale))
+	background.CornerRadius = cornerRadius
+	limitLine := canvas.NewRectangle(theme.Color(theme.ColorNameError))
+	text := canvas.NewText("", theme.Color(theme.ColorNameForegroundOnPrimary))
+	text.TextSize = 12
+
+	g := &StackedSizeBar{
+		Background: background,
+		LimitLine:  limitLine,
+		Text:       text,
+
+		TotalSize: totalSize,
+	}
+
+	g.ExtendBaseWidget(g)
+
+	return g
+}
+
+func (g *StackedSizeBar) SetSegments(segments []SizeSegment) {
+	g.Segments = segments
+	g.Refresh()
+}
+
+func (g *StackedSizeBar) SetTotalSize(size int64) {
+	if size < 0 {
+		size = 0
+	}
+	if size == g.TotalSize {
+		return
+	}
+	g.TotalSize = size
+	g.Refresh()
+}
+
+func (g *StackedSizeBar) currentSize() int64 {
+	return lo.SumBy(g.Segments, func(s SizeSegment) int64 {
+		return s.Size
+	})
+}
+
+func (g *StackedSizeBar) updateBars() {
+	for len(g.Bars) < len(g.Segments) {
+		g.Bars = append(g.Bars, canvas.NewRectangle(color.Transparent))
+	}
+
+	current := g.currentSize()
+	scale := max(current, g.TotalSize)
+	size := g.Background.Size()
+
+	x := float32(0)
+	for i, bar := range g.Bars {
+		if i >= len(g.Segments) || scale == 0 {
+			bar.Hide()
+			continue
+		}
+		segment := g.Segments[i]
+		width := size.Width * float32(segment.Size) / float32(scale)
+		bar.FillColor = segment.Color
+		bar.Resize(fyne.NewSize(width, size.Height))
+		bar.Move(fyne.NewPos(x, 0))
+		bar.Show()
+		bar.Refresh()
+		x += width
+	}
+
+	if current > g.TotalSize && g.TotalSize > 0 {
+		limitX := size.Width * float32(g.TotalSize) / float32(scale)
+		g.LimitLine.Resize(fyne.NewSize(barGap, size.Height))
+		g.LimitLine.Move(fyne.NewPos(limitX-barGap/2, 0))
+		g.LimitLine.Show()
+	} else {
+		g.LimitLine.Hide()
+	}
+}
+
+func (g *StackedSizeBar) updateText() {
+	g.Text.Text = utils.PrettyByteSize(g.currentSize()) + " / " + utils.PrettyByteSize(g.TotalSize)
+
+	textSize := g.Text.MinSize()
+	textX := (g.Background.Size().Width - textSize.Width) / 2
+	textY := (g.Background.Size().Height - textSize.Height) / 2
+	g.Text.Move(fyne.NewPos(textX, textY))
+}
+
+func (g *StackedSizeBar) CreateRenderer() fyne.WidgetRenderer {
+	return &StackedSizeBarRenderer{
+		g: g,
+	}
+}
+
+type StackedSizeBarRenderer struct {
+	g *StackedSizeBar
+}
+
+func (r *StackedSizeBarRenderer) MinSize() fyne.Size {
+	return fyne.NewSize(100, 20)
+}
+
+func (r *StackedSizeBarRenderer) Layout(size fyne.Size) {
+	r.g.Background.Resize(size)
+	r.g.Background.Move(fyne.NewPos(0, 0))
+
+	r.g.updateBars()
+	r.g.updateText()
+}
+
+func (r *StackedSizeBarRenderer) Refresh() {
+	r.g.updateBars()
+	r.g.updateText()
+	canvas.Refresh(r.g)
+}
+
+func (r *StackedSizeBarRenderer) Objects() []fyne.CanvasObject {
+	objects := []fyne.CanvasObject{r.g.Background}
+	for _, bar := range r.g.Bars {
+		objects = append(objects, bar)
+	}
+	return append(objects, r.g.LimitLine, r.g.Text)
+}
+
+func (r *StackedSizeBarRenderer) Destroy() {
+}
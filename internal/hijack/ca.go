@@ -0,0 +1,430 @@
+package hijack
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elazarl/goproxy"
+)
+
+// Every installation generates its own CA for HTTPS interception, so that nobody else holds the key
+// of a root certificate trusted by the user. The key never leaves caDir and is only readable by the user.
+
+const caCertName = "ca.crt"
+const caKeyName = "ca.key"
+const caBackupSuffix = ".bak"
+
+const caValidity = 10 * 365 * 24 * time.Hour
+
+// leaf certificates signed by goproxy are valid for a year, the CA is renewed before it can't cover them
+const caRenewBefore = 366 * 24 * time.Hour
+
+var ErrNoCA = errors.New("CA is not initialized")
+
+var caDir = "."
+var currentCA *tls.Certificate
+var caMutex sync.RWMutex
+
+type CAFormat int
+
+const (
+	CAFormatPEM CAFormat = iota
+	CAFormatDER
+)
+
+// CAFormatFromPath guesses the export format from the file extension, PEM by default
+func CAFormatFromPath(path string) CAFormat {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".der", ".cer":
+		return CAFormatDER
+	}
+	return CAFormatPEM
+}
+
+// CAInfo describes the current CA for diagnostics
+type CAInfo struct {
+	Subject     string
+	NotBefore   time.Time
+	NotAfter    time.Time
+	Fingerprint string
+	CertPath    string
+	// trusted by the system, i.e. installed into trusted root certification authorities
+	Trusted bool
+}
+
+func caCertPath() string {
+	return filepath.Join(caDir, caCertName)
+}
+
+func caKeyPath() string {
+	return filepath.Join(caDir, caKeyName)
+}
+
+func selfSign(template *x509.Certificate, key crypto.Signer) (*tls.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template.SerialNumber = serial
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+func caTemplate() *x509.Certificate {
+	hostname, _ := os.Hostname()
+	now := time.Now()
+	return &x509.Certificate{
+		Subject: pkix.Name{
+			CommonName:   fmt.Sprintf("VRCDancePreloader CA (%s)", hostname),
+			Organization: []string{"VRCDancePreloader"},
+		},
+		NotBefore:             now.Add(-24 * time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+}
+
+// issueCA issues a self-signed CA certificate, a new key is generated if key is nil
+func issueCA(key *rsa.PrivateKey) (*tls.Certificate, error) {
+	if key == nil {
+		var err error
+		// RSA is the most compatible choice for players and yt-dlp
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return selfSign(caTemplate(), key)
+}
+
+func loadCA() (*tls.Certificate, error) {
+	certPEM, err := os.ReadFile(caCertPath())
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := os.ReadFile(caKeyPath())
+	if err != nil {
+		return nil, err
+	}
+	ca, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	if ca.Leaf == nil {
+		if ca.Leaf, err = x509.ParseCertificate(ca.Certificate[0]); err != nil {
+			return nil, err
+		}
+	}
+	if !ca.Leaf.IsCA {
+		return nil, errors.New("certificate is not a CA")
+	}
+	if _, ok := ca.PrivateKey.(*rsa.PrivateKey); !ok {
+		return nil, errors.New("CA key is not an RSA key")
+	}
+	return &ca, nil
+}
+
+// saveCA writes the certificate and the key, the key is only accessible by the current user
+func saveCA(ca *tls.Certificate) error {
+	if err := os.MkdirAll(caDir, 0700); err != nil {
+		return err
+	}
+
+	keyDER := x509.MarshalPKCS1PrivateKey(ca.PrivateKey.(*rsa.PrivateKey))
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: keyDER})
+	if err := writeFileAtomic(caKeyPath(), keyPEM, 0600); err != nil {
+		return err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate[0]})
+	return writeFileAtomic(caCertPath(), certPEM, 0644)
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	// WriteFile doesn't change the permission of an existing file
+	if err := os.Chmod(tmp, perm); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// restrictKeyPermission fixes a key file that can be read by others
+func restrictKeyPermission() {
+	info, err := os.Stat(caKeyPath())
+	if err != nil {
+		return
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		if err := os.Chmod(caKeyPath(), 0600); err != nil {
+			logger.WarnLn("Failed to restrict the permission of CA key:", err)
+		}
+	}
+}
+
+func backupCA() {
+	for _, path := range []string{caCertPath(), caKeyPath()} {
+		if _, err := os.Stat(path); err == nil {
+			if err := os.Rename(path, path+caBackupSuffix); err != nil {
+				logger.WarnLn("Failed to back up", path, ":", err)
+			}
+		}
+	}
+}
+
+// InitCA loads the CA in dir, a new one is generated if it doesn't exist or can't be used
+func InitCA(dir string) error {
+	caMutex.Lock()
+	defer caMutex.Unlock()
+
+	caDir = dir
+	ca, err := loadCA()
+	if err == nil {
+		restrictKeyPermission()
+		if time.Until(ca.Leaf.NotAfter) < caRenewBefore {
+			logger.InfoLn("CA is about to expire, renewing it with the same key")
+			if ca, err = issueCA(ca.PrivateKey.(*rsa.PrivateKey)); err != nil {
+				return err
+			}
+			if err = saveCA(ca); err != nil {
+				return err
+			}
+		}
+		currentCA = ca
+		return nil
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		logger.WarnLn("Existing CA is invalid, generating a new one:", err)
+		backupCA()
+	}
+	ca, err = issueCA(nil)
+	if err != nil {
+		return err
+	}
+	if err = saveCA(ca); err != nil {
+		return err
+	}
+	currentCA = ca
+	logger.InfoLn("Generated a new CA, install", caCertPath(), "as a trusted root certificate to enable HTTPS hijacking")
+	return nil
+}
+
+// RotateCA replaces the CA with a new key, the old one is kept as a backup so that it can be untrusted later.
+// The proxy server should be restarted afterward
+func RotateCA() error {
+	caMutex.Lock()
+	defer caMutex.Unlock()
+
+	ca, err := issueCA(nil)
+	if err != nil {
+		return err
+	}
+	backupCA()
+	if err := saveCA(ca); err != nil {
+		return err
+	}
+	currentCA = ca
+	logger.InfoLn("Rotated CA, the old one is backed up with suffix", caBackupSuffix)
+	return nil
+}
+
+// RenewCA issues the CA certificate again with the current key, e.g. when it's about to expire.
+// The proxy server should be restarted afterward
+func RenewCA() error {
+	caMutex.Lock()
+	defer caMutex.Unlock()
+
+	if currentCA == nil {
+		return ErrNoCA
+	}
+	ca, err := issueCA(currentCA.PrivateKey.(*rsa.PrivateKey))
+	if err != nil {
+		return err
+	}
+	if err := saveCA(ca); err != nil {
+		return err
+	}
+	currentCA = ca
+	return nil
+}
+
+func getCA() *tls.Certificate {
+	caMutex.RLock()
+	defer caMutex.RUnlock()
+	return currentCA
+}
+
+// ExportCA writes the CA certificate (without the key) in the given format
+func ExportCA(w io.Writer, format CAFormat) error {
+	ca := getCA()
+	if ca == nil {
+		return ErrNoCA
+	}
+	if format == CAFormatDER {
+		_, err := w.Write(ca.Certificate[0])
+		return err
+	}
+	return pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate[0]})
+}
+
+// ExportCAFile exports the CA certificate into path, the format is decided by the extension
+func ExportCAFile(path string) error {
+	var buf bytes.Buffer
+	if err := ExportCA(&buf, CAFormatFromPath(path)); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// IsCATrusted reports whether the system trusts the current CA
+func IsCATrusted() bool {
+	ca := getCA()
+	if ca == nil {
+		return false
+	}
+	_, err := ca.Leaf.Verify(x509.VerifyOptions{
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err == nil
+}
+
+func GetCAInfo() *CAInfo {
+	ca := getCA()
+	if ca == nil {
+		return nil
+	}
+	sum := sha256.Sum256(ca.Certificate[0])
+	return &CAInfo{
+		Subject:     ca.Leaf.Subject.CommonName,
+		NotBefore:   ca.Leaf.NotBefore,
+		NotAfter:    ca.Leaf.NotAfter,
+		Fingerprint: strings.ToUpper(hex.EncodeToString(sum[:])),
+		CertPath:    caCertPath(),
+		Trusted:     IsCATrusted(),
+	}
+}
+
+// mitmConnect signs leaf certificates with the CA of this installation
+func mitmConnect() (*goproxy.ConnectAction, error) {
+	ca := getCA()
+	if ca == nil {
+		return nil, ErrNoCA
+	}
+	return &goproxy.ConnectAction{
+		Action:    goproxy.ConnectMitm,
+		TLSConfig: goproxy.TLSConfigFromCA(ca),
+	}, nil
+}
+
+// leafValidity is the same as goproxy
+const leafValidity = 365 * 24 * time.Hour
+
+// signLeaf issues a certificate for host with the CA, used for the TLS servers of transparent hijacking
+func signLeaf(ca *tls.Certificate, host string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	notAfter := now.Add(leafValidity)
+	if notAfter.After(ca.Leaf.NotAfter) {
+		notAfter = ca.Leaf.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host, Organization: []string{"VRCDancePreloader"}},
+		NotBefore:    now.Add(-24 * time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Leaf, key.Public(), ca.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{der, ca.Certificate[0]},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+// leafStore caches signed leaf certificates for a proxy server, signing with RSA is slow
+type leafStore struct {
+	certs map[string]*tls.Certificate
+	mutex sync.Mutex
+}
+
+func newLeafStore() *leafStore {
+	return &leafStore{certs: make(map[string]*tls.Certificate)}
+}
+
+func (s *leafStore) Fetch(hostname string, gen func() (*tls.Certificate, error)) (*tls.Certificate, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if cert, ok := s.certs[hostname]; ok {
+		return cert, nil
+	}
+	cert, err := gen()
+	if err != nil {
+		return nil, err
+	}
+	s.certs[hostname] = cert
+	return cert, nil
+}
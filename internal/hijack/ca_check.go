@@ -0,0 +1,142 @@
+package hijack
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/elazarl/goproxy"
+)
+
+const caSelfTestTimeout = 10 * time.Second
+const caSelfTestBody = "vrcdp-ca-self-test"
+
+// serveLocalTLS starts a TLS server on loopback with a throwaway certificate, like a video server we intercept
+func serveLocalTLS() (*http.Server, string, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, "", err
+	}
+	cert, err := selfSign(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:   time.Now().Add(-time.Hour),
+		NotAfter:    time.Now().Add(time.Hour),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{*cert}})
+	if err != nil {
+		return nil, "", err
+	}
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(caSelfTestBody))
+		}),
+	}
+	go server.Serve(listener)
+	return server, listener.Addr().String(), nil
+}
+
+// serveMitmProxy starts a proxy on loopback that intercepts every HTTPS connection with the CA
+func serveMitmProxy() (*http.Server, string, error) {
+	action, err := mitmConnect()
+	if err != nil {
+		return nil, "", err
+	}
+
+	p := goproxy.NewProxyHttpServer()
+	p.CertStore = newLeafStore()
+	p.OnRequest().HandleConnect(goproxy.FuncHttpsHandler(func(host string, ctx *goproxy.ProxyCtx) (*goproxy.ConnectAction, string) {
+		return action, host
+	}))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, "", err
+	}
+	server := &http.Server{Handler: p}
+	go server.Serve(listener)
+	return server, listener.Addr().String(), nil
+}
+
+// SelfTestCA performs a MITM handshake against a local TLS server through a proxy using the CA,
+// and confirms that the leaf certificate is generated for the host and chains up to the CA
+func SelfTestCA() error {
+	ca := getCA()
+	if ca == nil {
+		return ErrNoCA
+	}
+
+	target, targetAddr, err := serveLocalTLS()
+	if err != nil {
+		return fmt.Errorf("failed to start local TLS server: %w", err)
+	}
+	defer target.Close()
+
+	mitm, mitmAddr, err := serveMitmProxy()
+	if err != nil {
+		return fmt.Errorf("failed to start MITM proxy: %w", err)
+	}
+	defer mitm.Close()
+
+	// only the CA is trusted, so the handshake succeeds only if the certificate comes from the proxy
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyURL(&url.URL{Scheme: "http", Host: mitmAddr}),
+			TLSClientConfig: &tls.Config{RootCAs: roots},
+		},
+		Timeout: caSelfTestTimeout,
+	}
+	defer client.CloseIdleConnections()
+
+	ctx, cancel := context.WithTimeout(context.Background(), caSelfTestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+targetAddr+"/", nil)
+	if err != nil {
+		return err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("MITM handshake failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.TLS == nil || len(res.TLS.PeerCertificates) == 0 {
+		return errors.New("no certificate is presented by the proxy")
+	}
+	leaf := res.TLS.PeerCertificates[0]
+	if !bytes.Equal(leaf.RawIssuer, ca.Leaf.RawSubject) {
+		return fmt.Errorf("leaf certificate is issued by %s instead of the CA", leaf.Issuer.CommonName)
+	}
+	if err := leaf.VerifyHostname("127.0.0.1"); err != nil {
+		return fmt.Errorf("leaf certificate doesn't match the host: %w", err)
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read through the proxy: %w", err)
+	}
+	if string(body) != caSelfTestBody {
+		return errors.New("unexpected response through the proxy")
+	}
+	return nil
+}
@@ -1,7 +1,16 @@
 package hijack
 
+import "github.com/wzhqwq/VRCDancePreloader/internal/exposure"
+
 var limitBandwidth = false
 
 func SetLimitBandwidth(limit bool) {
 	limitBandwidth = limit
 }
+
+var proxyGuard = exposure.NewGuard(exposure.Settings{Bind: exposure.BindLoopback})
+
+// SetExposure decides who can use the proxy, it's applied when the proxy starts
+func SetExposure(settings exposure.Settings) {
+	proxyGuard = exposure.NewGuard(settings)
+}
@@ -0,0 +1,162 @@
+package hijack
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/cache"
+	"github.com/wzhqwq/VRCDancePreloader/internal/persistence"
+	"github.com/wzhqwq/VRCDancePreloader/internal/types"
+	"github.com/wzhqwq/VRCDancePreloader/internal/utils"
+)
+
+// Every intercepted video request is recorded for answering "why didn't it hit the cache"
+
+const inspectorCapacity = 200
+
+var inspectedRequests = utils.NewRingBuffer[*types.InterceptedRequest](inspectorCapacity)
+
+// guards the fields of records in inspectedRequests, they are updated while being served
+var inspectorMutex sync.Mutex
+
+var inspectorEm = utils.NewEventManager[int]()
+
+var persistRequests = false
+
+func SetPersistRequests(b bool) {
+	persistRequests = b
+}
+
+// GetInterceptedRequests returns the recent intercepted requests, the latest goes first
+func GetInterceptedRequests() []types.InterceptedRequest {
+	records := inspectedRequests.Items()
+
+	inspectorMutex.Lock()
+	defer inspectorMutex.Unlock()
+
+	result := make([]types.InterceptedRequest, len(records))
+	for i, r := range records {
+		result[len(records)-1-i] = *r
+	}
+	return result
+}
+
+func ClearInterceptedRequests() {
+	inspectedRequests.Clear()
+	inspectorEm.NotifySubscribers(0)
+}
+
+// SubscribeInterceptedRequestEvent notifies the id of a request when it comes or finishes
+func SubscribeInterceptedRequestEvent() *utils.EventSubscriber[int] {
+	return inspectorEm.SubscribeEvent()
+}
+
+func cacheStateOf(id string) (string, int64) {
+	info := cache.GetLocalCacheInfo(id)
+	if info.IsComplete {
+		return types.CacheStateComplete, info.FullSize
+	}
+	if info.Downloaded > 0 {
+		return types.CacheStatePartial, info.Downloaded
+	}
+	return types.CacheStateMissing, 0
+}
+
+// requestInspector records one intercepted request
+type requestInspector struct {
+	record *types.InterceptedRequest
+	start  time.Time
+}
+
+func inspectRequest(reqId int, platform, id string, req *http.Request) *requestInspector {
+	state, cachedSize := cacheStateOf(utils.GetPlatformId(platform, id))
+	i := &requestInspector{
+		record: &types.InterceptedRequest{
+			ID:        reqId,
+			Time:      time.Now(),
+			Platform:  platform,
+			VideoID:   id,
+			Range:     req.Header.Get("Range"),
+			Client:    req.RemoteAddr,
+			UserAgent: req.UserAgent(),
+
+			CacheState: state,
+			CachedSize: cachedSize,
+		},
+		start: time.Now(),
+	}
+	inspectedRequests.Push(i.record)
+	inspectorEm.NotifySubscribers(reqId)
+	return i
+}
+
+func (i *requestInspector) update(f func(r *types.InterceptedRequest)) {
+	inspectorMutex.Lock()
+	f(i.record)
+	inspectorMutex.Unlock()
+}
+
+// Fallback records the reason why the request is left to the remote server
+func (i *requestInspector) Fallback(err error) {
+	i.update(func(r *types.InterceptedRequest) {
+		r.Fallback = err.Error()
+	})
+	i.Finish()
+}
+
+func (i *requestInspector) Finish() {
+	var snapshot types.InterceptedRequest
+	i.update(func(r *types.InterceptedRequest) {
+		r.Duration = time.Since(i.start).Milliseconds()
+		r.Finished = true
+		snapshot = *r
+	})
+	inspectorEm.NotifySubscribers(snapshot.ID)
+
+	if persistRequests {
+		persistence.AddRequestLog(&snapshot)
+	}
+}
+
+// Wrap counts what is written into w
+func (i *requestInspector) Wrap(w http.ResponseWriter) http.ResponseWriter {
+	return &inspectedWriter{ResponseWriter: w, i: i}
+}
+
+type inspectedWriter struct {
+	http.ResponseWriter
+
+	i             *requestInspector
+	headerWritten bool
+	bodyWritten   bool
+}
+
+func (w *inspectedWriter) WriteHeader(statusCode int) {
+	if !w.headerWritten {
+		w.headerWritten = true
+		w.i.update(func(r *types.InterceptedRequest) {
+			r.Status = statusCode
+		})
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *inspectedWriter) Write(data []byte) (int, error) {
+	if !w.headerWritten {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(data)
+
+	first := !w.bodyWritten && n > 0
+	if first {
+		w.bodyWritten = true
+	}
+	w.i.update(func(r *types.InterceptedRequest) {
+		r.BytesServed += int64(n)
+		if first {
+			r.TTFB = time.Since(w.i.start).Milliseconds()
+		}
+	})
+	return n, err
+}
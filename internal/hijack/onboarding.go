@@ -0,0 +1,144 @@
+package hijack
+
+import (
+	_ "embed"
+	"html/template"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/samber/lo"
+	"github.com/wzhqwq/VRCDancePreloader/internal/i18n"
+	"github.com/wzhqwq/VRCDancePreloader/internal/playlist"
+	"github.com/wzhqwq/VRCDancePreloader/internal/song"
+)
+
+// Devices that can't run this tool (e.g. standalone Quest) can still use the proxy in LAN. They open the proxy
+// address in a browser to get the CA and see what the host is preloading.
+
+//go:embed onboarding.html
+var onboardingPage string
+
+var onboardingTemplate = template.Must(template.New("onboarding").Funcs(template.FuncMap{
+	"t": func(key string) string {
+		return i18n.T(key)
+	},
+}).Parse(onboardingPage))
+
+type onboardingSong struct {
+	Title  string
+	Group  string
+	Status string
+}
+
+type onboardingData struct {
+	ProxyAddr   string
+	EnableHttps bool
+	Fingerprint string
+	Sites       []string
+
+	RoomName string
+	Songs    []onboardingSong
+}
+
+// GetLANAddresses lists the IPv4 addresses of this machine that other devices in LAN may reach
+func GetLANAddresses() []string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		logger.WarnLn("Failed to list network interfaces:", err)
+		return nil
+	}
+	var result []string
+	for _, addr := range addrs {
+		n, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ip := n.IP.To4(); ip != nil && ip.IsPrivate() {
+			result = append(result, ip.String())
+		}
+	}
+	return result
+}
+
+// isLocalIP reports whether ip belongs to this machine
+func isLocalIP(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if ip.IsLoopback() || ip.IsUnspecified() {
+		return true
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	return lo.ContainsBy(addrs, func(addr net.Addr) bool {
+		n, ok := addr.(*net.IPNet)
+		return ok && n.IP.Equal(ip)
+	})
+}
+
+// serveSelfRequests turns requests to the proxy itself sent through the proxy (e.g. a browser on a device whose
+// Wi-Fi proxy is already set to us) into direct ones, otherwise the proxy would dial itself
+func serveSelfRequests(next http.Handler, port int) http.Handler {
+	portStr := strconv.Itoa(port)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect && r.URL.IsAbs() {
+			host, p, err := net.SplitHostPort(r.URL.Host)
+			if err == nil && p == portStr && isLocalIP(net.ParseIP(host)) {
+				r.Host = r.URL.Host
+				r.URL.Scheme = ""
+				r.URL.Host = ""
+				r.RequestURI = r.URL.RequestURI()
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func currentSongs() (string, []onboardingSong) {
+	pl := playlist.GetCurrentPlaylist()
+	if pl == nil {
+		return "", nil
+	}
+	songs := lo.Map(pl.GetItemsSnapshot(), func(ps *song.PreloadedSong, _ int) onboardingSong {
+		info := ps.GetInfo()
+		return onboardingSong{
+			Title:  info.Title,
+			Group:  info.Group,
+			Status: ps.GetStatusInfo().Status,
+		}
+	})
+	return pl.RoomName, songs
+}
+
+func handleOnboarding(sites []string, enableHttps bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data := onboardingData{
+			ProxyAddr:   r.Host,
+			EnableHttps: enableHttps,
+			Sites:       lo.Uniq(sites),
+		}
+		if info := GetCAInfo(); info != nil {
+			data.Fingerprint = info.Fingerprint
+		}
+		data.RoomName, data.Songs = currentSongs()
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		if err := onboardingTemplate.Execute(w, data); err != nil {
+			logger.WarnLn("Failed to render onboarding page:", err)
+		}
+	}
+}
+
+func handleCADownload(format CAFormat, name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-x509-ca-cert")
+		w.Header().Set("Content-Disposition", "attachment; filename=\""+name+"\"")
+		if err := ExportCA(w, format); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package hijack
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/samber/lo"
+	"github.com/wzhqwq/VRCDancePreloader/internal/constants"
+)
+
+// processes whose requests to intercepted sites should go through us
+var clientProcesses = []string{"VRChat", "yt-dlp"}
+
+const clashProxyName = "vrcDancePreload"
+
+func proxyAddr(port int) string {
+	return fmt.Sprintf("127.0.0.1:%d", port)
+}
+
+// GeneratePAC generates a proxy auto-config script that only routes intercepted sites to the proxy
+func GeneratePAC(sites []string, port int) string {
+	return generatePAC(sites, proxyAddr(port))
+}
+
+func generatePAC(sites []string, addr string) string {
+	sitesJson, _ := json.Marshal(lo.Uniq(sites))
+
+	var sb strings.Builder
+	sb.WriteString("function FindProxyForURL(url, host) {\n")
+	fmt.Fprintf(&sb, "  var sites = %s;\n", sitesJson)
+	sb.WriteString("  host = host.toLowerCase();\n")
+	sb.WriteString("  for (var i = 0; i < sites.length; i++) {\n")
+	sb.WriteString("    var site = sites[i];\n")
+	sb.WriteString("    if (host === site || (site.indexOf(\"*.\") === 0 && dnsDomainIs(host, site.substring(1)))) {\n")
+	fmt.Fprintf(&sb, "      return \"PROXY %s\";\n", addr)
+	sb.WriteString("    }\n")
+	sb.WriteString("  }\n")
+	sb.WriteString("  return \"DIRECT\";\n")
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+func clashDomainRule(site string) string {
+	if suffix, ok := constants.WildcardSuffix(site); ok {
+		return "DOMAIN-SUFFIX," + suffix[1:]
+	}
+	return "DOMAIN," + site
+}
+
+// GenerateClashRules generates the proxy and rule snippets for Clash Verge Rev, they're meant to be prepended
+func GenerateClashRules(sites []string, port int) string {
+	var sb strings.Builder
+	sb.WriteString("# Clash Verge Rev: edit nodes -> advanced\n")
+	sb.WriteString("prepend:\n")
+	fmt.Fprintf(&sb, "  - name: '%s'\n", clashProxyName)
+	sb.WriteString("    type: 'http'\n")
+	sb.WriteString("    server: '127.0.0.1'\n")
+	fmt.Fprintf(&sb, "    port: %d\n", port)
+	sb.WriteString("append: [ ]\n")
+	sb.WriteString("delete: [ ]\n")
+	sb.WriteString("\n")
+	sb.WriteString("# Clash Verge Rev: edit rules -> advanced\n")
+	sb.WriteString("prepend:\n")
+	for _, site := range lo.Uniq(sites) {
+		for _, process := range clientProcesses {
+			fmt.Fprintf(&sb, "  - 'AND,((%s),(PROCESS-NAME-REGEX,%s)),%s'\n", clashDomainRule(site), process, clashProxyName)
+		}
+	}
+	sb.WriteString("append: [ ]\n")
+	sb.WriteString("delete: [ ]\n")
+	return sb.String()
+}
+
+// GenerateProxifierRules describes the proxy server and the rule to add in Proxifier
+func GenerateProxifierRules(sites []string, port int) string {
+	applications := lo.Map(clientProcesses, func(p string, _ int) string {
+		return p + ".exe"
+	})
+
+	var sb strings.Builder
+	sb.WriteString("# Proxifier: Profile -> Proxy Servers -> Add\n")
+	fmt.Fprintf(&sb, "Address: 127.0.0.1\nPort: %d\nProtocol: HTTPS\n", port)
+	sb.WriteString("\n")
+	sb.WriteString("# Proxifier: Profile -> Proxification Rules -> Add\n")
+	fmt.Fprintf(&sb, "Applications: %s\n", strings.Join(applications, "; "))
+	fmt.Fprintf(&sb, "Target hosts: %s\n", strings.Join(lo.Uniq(sites), "; "))
+	fmt.Fprintf(&sb, "Action: Proxy HTTPS 127.0.0.1:%d\n", port)
+	return sb.String()
+}
+
+// newNonProxyHandler serves requests sent to the proxy itself rather than through it
+func newNonProxyHandler(sites []string, enableHttps bool, port int) http.Handler {
+	pac := GeneratePAC(sites, port)
+	lan := proxyGuard.IsLAN()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /proxy.pac", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
+		w.Header().Set("Cache-Control", "no-cache")
+		if lan && r.Host != "" {
+			// other devices reach us by the address they fetched the PAC file from
+			w.Write([]byte(generatePAC(sites, r.Host)))
+			return
+		}
+		w.Write([]byte(pac))
+	})
+	mux.HandleFunc("GET /{$}", handleOnboarding(sites, enableHttps))
+	mux.HandleFunc("GET /ca.crt", handleCADownload(CAFormatPEM, "VRCDancePreloader.crt"))
+	mux.HandleFunc("GET /ca.der", handleCADownload(CAFormatDER, "VRCDancePreloader.der"))
+	return mux
+}
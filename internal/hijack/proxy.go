@@ -1,13 +1,11 @@
 package hijack
 
 import (
-	"bufio"
 	"context"
 	"errors"
 	"net"
 	"net/http"
 	"runtime/debug"
-	"strconv"
 	"sync"
 	"time"
 
@@ -22,12 +20,6 @@ var proxy *goproxy.ProxyHttpServer
 
 var logger = utils.NewLogger("Hijacking")
 
-func orPanic(err error) {
-	if err != nil {
-		panic(err)
-	}
-}
-
 // copied/converted from https.go
 func dial(ctx context.Context, network, addr string) (c net.Conn, err error) {
 	if proxy.Tr.DialContext != nil {
@@ -55,54 +47,30 @@ func handleVideoRequest(w http.ResponseWriter, req *http.Request) (bool, *sync.W
 	}()
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	if handlePypyRequest(w, req, wg) ||
+	if handleSelfCheckRequest(w, req, wg) ||
+		handlePypyRequest(w, req, wg) ||
 		handleWannaRequest(w, req, wg) ||
 		handleDuDuRequest(w, req, wg) ||
-		handleBiliRequest(w, req, wg) {
+		handleBiliRequest(w, req, wg) ||
+		handleYoutubeRequest(w, req, wg) ||
+		handleThumbnailRequest(w, req, wg) {
 		return true, wg
 	}
 	return false, nil
 }
 
-func handleConnect(_ *http.Request, client net.Conn, _ *goproxy.ProxyCtx) {
-	defer func() {
-		if e := recover(); e != nil {
-			logger.ErrorLn("error connecting to remote:", e)
-			client.Write([]byte("HTTP/1.1 500 Cannot reach destination\r\n\r\n"))
-		}
-		client.Close()
-	}()
-	clientBuf := bufio.NewReadWriter(bufio.NewReader(client), bufio.NewWriter(client))
-	client.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+func handleConnect(req *http.Request, client net.Conn, _ *goproxy.ProxyCtx) {
+	relay := newConnRelay(connectTarget(req), client)
+	defer relay.Close()
 
-	for {
-		req, err := http.ReadRequest(clientBuf.Reader)
-		orPanic(err)
-
-		if req.Method == http.MethodGet {
-			rw := NewWriterGivenRespWriter(client)
-			if ok, wg := handleVideoRequest(rw, req); ok {
-				wg.Wait()
-				continue
-			}
-		}
-
-		remote, err := connectDial(req.Context(), "tcp", req.Host+":80")
-		orPanic(err)
-		remoteBuf := bufio.NewReadWriter(bufio.NewReader(remote), bufio.NewWriter(remote))
-		orPanic(req.Write(remoteBuf))
-		orPanic(remoteBuf.Flush())
-		resp, err := http.ReadResponse(remoteBuf.Reader, req)
-		orPanic(err)
-		orPanic(resp.Write(clientBuf.Writer))
-		orPanic(clientBuf.Flush())
-
-		remote.Close()
+	if _, err := client.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		return
 	}
+	relay.Serve()
 }
 
 // for common request
-func handleRequest(req *http.Request, _ *goproxy.ProxyCtx) (*http.Request, *http.Response) {
+func handleRequest(req *http.Request, ctx *goproxy.ProxyCtx) (*http.Request, *http.Response) {
 	defer func() {
 		if e := recover(); e != nil {
 			logger.ErrorLn("Error when processing request:", e)
@@ -121,34 +89,71 @@ func handleRequest(req *http.Request, _ *goproxy.ProxyCtx) (*http.Request, *http
 			return req, <-respCh
 		}
 	}
+	useMissRoute(ctx)
 	return req, nil
 }
 
+// siteIs matches requests to site on port, or requests without port if port is empty
+func siteIs(site, port string) goproxy.ReqConditionFunc {
+	return func(req *http.Request, _ *goproxy.ProxyCtx) bool {
+		host := req.URL.Host
+		if port != "" {
+			h, p, err := net.SplitHostPort(host)
+			if err != nil || p != port {
+				return false
+			}
+			host = h
+		}
+		return constants.MatchSite(site, host)
+	}
+}
+
 func Start(sites []string, enableHttps bool, port int) error {
 	proxy = goproxy.NewProxyHttpServer()
+	// e.g. http://127.0.0.1:7653/proxy.pac, or http://192.168.1.2:7653/ for other devices
+	proxy.NonproxyHandler = newNonProxyHandler(sites, enableHttps, port)
+	if err := setupUpstream(proxy); err != nil {
+		return err
+	}
 
 	// for http proxy using CONNECT first
 	for _, site := range sites {
-		proxy.OnRequest(goproxy.ReqHostIs(site + ":80")).HijackConnect(handleConnect)
+		proxy.OnRequest(siteIs(site, "80")).HijackConnect(handleConnect)
 	}
 
 	// for https proxy
 	if enableHttps {
+		action, err := mitmConnect()
+		if err != nil {
+			return err
+		}
+		proxy.CertStore = newLeafStore()
+		mitm := goproxy.FuncHttpsHandler(func(host string, _ *goproxy.ProxyCtx) (*goproxy.ConnectAction, string) {
+			return action, host
+		})
 		for _, site := range sites {
 			if constants.IsHttpsSite(site) {
-				proxy.OnRequest(goproxy.ReqHostIs(site + ":443")).HandleConnect(goproxy.AlwaysMitm)
-				proxy.OnRequest(goproxy.ReqHostIs(site + ":443")).DoFunc(handleRequest)
+				proxy.OnRequest(siteIs(site, "443")).HandleConnect(mitm)
+				proxy.OnRequest(siteIs(site, "443")).DoFunc(handleRequest)
 			}
 		}
 	}
 
 	// for Windows system proxy which won't start with CONNECT
 	for _, site := range sites {
-		proxy.OnRequest(goproxy.ReqHostIs(site)).DoFunc(handleRequest)
+		proxy.OnRequest(siteIs(site, "")).DoFunc(handleRequest)
 	}
 
-	runningServer = &http.Server{Addr: "127.0.0.1:" + strconv.Itoa(port), Handler: proxy}
-	logger.InfoLn("Starting server on port", port)
+	runningServer = &http.Server{
+		Addr:    proxyGuard.Addr(port),
+		Handler: serveSelfRequests(proxyGuard.WrapProxy(proxy), port),
+	}
+	logger.InfoLn("Starting server on", runningServer.Addr)
+	if proxyGuard.IsLAN() {
+		for _, addr := range GetLANAddresses() {
+			logger.InfoLnf("Other devices can set up the proxy on http://%s:%d/", addr, port)
+		}
+	}
 
 	if err := runningServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
 		return err
@@ -156,10 +161,6 @@ func Start(sites []string, enableHttps bool, port int) error {
 	return nil
 }
 
-func SelfCheck() {
-	// check for dial loop
-}
-
 func Stop() {
 	if runningServer != nil {
 		shutdownCtx, shutdownRelease := context.WithTimeout(context.Background(), 10*time.Second)
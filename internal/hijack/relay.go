@@ -0,0 +1,245 @@
+package hijack
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+)
+
+// connRelay forwards requests coming through one CONNECT tunnel to its target,
+// the upstream connection is kept alive and reused until either side wants to close it
+type connRelay struct {
+	addr string
+
+	client       net.Conn
+	clientReader *bufio.Reader
+
+	remote       net.Conn
+	remoteReader *bufio.Reader
+}
+
+func newConnRelay(addr string, client net.Conn) *connRelay {
+	return &connRelay{
+		addr:         addr,
+		client:       client,
+		clientReader: bufio.NewReader(client),
+	}
+}
+
+// connectTarget returns host:port of a CONNECT request, port 80 is assumed if it's missing
+func connectTarget(req *http.Request) string {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		return net.JoinHostPort(strings.Trim(host, "[]"), "80")
+	}
+	return host
+}
+
+func (r *connRelay) dialRemote(ctx context.Context) error {
+	remote, err := dialMiss(ctx, "tcp", r.addr)
+	if err != nil {
+		return err
+	}
+	r.remote = remote
+	r.remoteReader = bufio.NewReader(remote)
+	return nil
+}
+
+func (r *connRelay) closeRemote() {
+	if r.remote != nil {
+		r.remote.Close()
+		r.remote = nil
+		r.remoteReader = nil
+	}
+}
+
+func (r *connRelay) Close() {
+	r.closeRemote()
+	r.client.Close()
+}
+
+// roundTrip sends req to the target and reads the response header, a stale kept-alive connection is redialed once
+func (r *connRelay) roundTrip(req *http.Request) (*http.Response, error) {
+	reused := r.remote != nil
+	if !reused {
+		if err := r.dialRemote(req.Context()); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := r.send(req)
+	if err != nil && reused && canRetry(req) {
+		// the server may have closed the idle connection
+		r.closeRemote()
+		if err := r.dialRemote(req.Context()); err != nil {
+			return nil, err
+		}
+		resp, err = r.send(req)
+	}
+	return resp, err
+}
+
+func (r *connRelay) send(req *http.Request) (*http.Response, error) {
+	w := bufio.NewWriter(r.remote)
+	if err := req.Write(w); err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	return http.ReadResponse(r.remoteReader, req)
+}
+
+// canRetry reports whether req can be sent again, i.e. it has no body that is already consumed
+func canRetry(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.ContentLength == 0
+}
+
+// bodyAllowed reports whether a response to this request with this status has a body
+func bodyAllowed(req *http.Request, status int) bool {
+	if req.Method == http.MethodHead {
+		return false
+	}
+	return !(status >= 100 && status < 200) && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+// writeResponse streams resp to the client, it returns false if the client connection can't be kept alive
+func (r *connRelay) writeResponse(resp *http.Response, clientClose bool) (bool, error) {
+	defer resp.Body.Close()
+
+	hasBody := bodyAllowed(resp.Request, resp.StatusCode)
+	chunked := hasBody && len(resp.TransferEncoding) > 0 && resp.TransferEncoding[0] == "chunked"
+	// without length or chunked encoding, the body ends when the connection closes
+	untilClose := hasBody && !chunked && resp.ContentLength < 0
+	keepAlive := !clientClose && !untilClose
+
+	header := resp.Header.Clone()
+	header.Del("Transfer-Encoding")
+	if chunked {
+		header.Del("Content-Length")
+		header.Set("Transfer-Encoding", "chunked")
+	} else if hasBody && resp.ContentLength >= 0 {
+		header.Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	}
+	if keepAlive {
+		header.Del("Connection")
+	} else {
+		header.Set("Connection", "close")
+	}
+
+	w := bufio.NewWriter(r.client)
+	if _, err := fmt.Fprintf(w, "HTTP/1.1 %s\r\n", resp.Status); err != nil {
+		return false, err
+	}
+	if err := header.Write(w); err != nil {
+		return false, err
+	}
+	if _, err := w.WriteString("\r\n"); err != nil {
+		return false, err
+	}
+	if err := w.Flush(); err != nil {
+		return false, err
+	}
+
+	if !hasBody {
+		return keepAlive, nil
+	}
+
+	if chunked {
+		cw := httputil.NewChunkedWriter(r.client)
+		if _, err := io.Copy(cw, resp.Body); err != nil {
+			return false, err
+		}
+		if err := cw.Close(); err != nil {
+			return false, err
+		}
+		if err := resp.Trailer.Write(w); err != nil {
+			return false, err
+		}
+		if _, err := w.WriteString("\r\n"); err != nil {
+			return false, err
+		}
+		return keepAlive, w.Flush()
+	}
+
+	if _, err := io.Copy(r.client, resp.Body); err != nil {
+		return false, err
+	}
+	return keepAlive, nil
+}
+
+// writeBadGateway tells the client that the target is unreachable, the tunnel is closed afterward
+func (r *connRelay) writeBadGateway(err error) {
+	msg := fmt.Sprintf("Cannot reach %s: %v", r.addr, err)
+	_, _ = fmt.Fprintf(
+		r.client,
+		"HTTP/1.1 502 Bad Gateway\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s",
+		len(msg), msg,
+	)
+}
+
+// serveVideo lets the video handlers respond, false is returned if no handler takes the request
+func (r *connRelay) serveVideo(req *http.Request) (handled bool, keepAlive bool) {
+	rw := NewWriterGivenRespWriter(r.client)
+	ok, wg := handleVideoRequest(rw, req)
+	if !ok {
+		return false, false
+	}
+	wg.Wait()
+
+	// the client can't find the end of the body without length
+	return true, !req.Close && rw.Header().Get("Content-Length") != ""
+}
+
+// Serve relays requests until the client or the target closes the connection
+func (r *connRelay) Serve() {
+	for {
+		req, err := http.ReadRequest(r.clientReader)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				logger.WarnLn("Failed to read request from client:", err)
+			}
+			return
+		}
+		req.RemoteAddr = r.client.RemoteAddr().String()
+
+		if req.Method == http.MethodGet {
+			if handled, keepAlive := r.serveVideo(req); handled {
+				if !keepAlive {
+					return
+				}
+				continue
+			}
+		}
+
+		resp, err := r.roundTrip(req)
+		if err != nil {
+			logger.WarnLnf("Failed to forward request to %s: %v", r.addr, err)
+			r.writeBadGateway(err)
+			return
+		}
+		remoteClose := resp.Close
+
+		keepAlive, err := r.writeResponse(resp, req.Close)
+		if err != nil {
+			logger.WarnLnf("Failed to relay response from %s: %v", r.addr, err)
+			return
+		}
+		if remoteClose {
+			r.closeRemote()
+		}
+		if !keepAlive {
+			return
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package hijack
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/wzhqwq/VRCDancePreloader/internal/constants"
+	"github.com/wzhqwq/VRCDancePreloader/internal/requesting"
+)
+
+// SelfCheck walks through what the proxy depends on: nothing routes back into the proxy, the intercepted sites are
+// reachable by the clients downloading from them, HTTPS interception works with the CA, and requests sent to the
+// proxy are really intercepted. The last one is done with a probe path answered by the proxy itself.
+
+const selfCheckTimeout = 10 * time.Second
+
+const selfCheckPath = "/.vrcdp-self-check"
+const selfCheckHeader = "X-Vrcdp-Self-Check"
+
+type CheckStatus string
+
+const (
+	CheckPassed  CheckStatus = "passed"
+	CheckWarning CheckStatus = "warning"
+	CheckFailed  CheckStatus = "failed"
+	CheckSkipped CheckStatus = "skipped"
+)
+
+type CheckResult struct {
+	Name   string
+	Status CheckStatus
+	Detail string
+}
+
+func (r CheckResult) String() string {
+	if r.Detail == "" {
+		return fmt.Sprintf("[%s] %s", strings.ToUpper(string(r.Status)), r.Name)
+	}
+	return fmt.Sprintf("[%s] %s: %s", strings.ToUpper(string(r.Status)), r.Name, r.Detail)
+}
+
+func handleSelfCheckRequest(w http.ResponseWriter, req *http.Request, wg *sync.WaitGroup) bool {
+	if req.URL.Path != selfCheckPath {
+		return false
+	}
+	go func() {
+		defer wg.Done()
+		w.Header().Set(selfCheckHeader, "1")
+		w.Write([]byte("ok"))
+	}()
+	return true
+}
+
+// pointsToProxy reports whether rawUrl is the proxy on port of this machine
+func pointsToProxy(rawUrl string, port int) bool {
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.Port() != strconv.Itoa(port) {
+		return false
+	}
+	return u.Hostname() == "localhost" || isLocalIP(net.ParseIP(u.Hostname()))
+}
+
+func checkProxyLoop(port int) []CheckResult {
+	var results []CheckResult
+
+	upstream := CheckResult{Name: "Upstream proxy loop", Status: CheckPassed}
+	if upstreamUrl == nil {
+		upstream.Detail = "no upstream proxy"
+	} else if pointsToProxy(upstreamUrl.String(), port) {
+		upstream.Status = CheckFailed
+		upstream.Detail = fmt.Sprintf("upstream proxy %s is this proxy, pass-through traffic would loop", upstreamUrl.Host)
+	} else {
+		upstream.Detail = upstreamUrl.Host
+	}
+	results = append(results, upstream)
+
+	for _, client := range requesting.AllClients() {
+		result := CheckResult{Name: fmt.Sprintf("Proxy of %s client", client.Name()), Status: CheckPassed, Detail: "direct"}
+		if proxyUrl := client.ProxyURL(); proxyUrl != "" {
+			result.Detail = proxyUrl
+			if pointsToProxy(proxyUrl, port) {
+				result.Status = CheckFailed
+				result.Detail = fmt.Sprintf("%s is this proxy, downloads would be intercepted by ourselves", proxyUrl)
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// clientOfSite gives the client downloading from site, the direct one if no client is configured for it
+func clientOfSite(site string) *requesting.ClientProvider {
+	var client *requesting.ClientProvider
+	switch {
+	case constants.IsPyPySite(site):
+		client = requesting.GetClient(requesting.PyPyDance)
+	case constants.IsWannaSite(site):
+		client = requesting.GetClient(requesting.WannaDance)
+	case constants.IsDuDuSite(site):
+		client = requesting.GetClient(requesting.DuDuFitDance)
+	case constants.IsBiliSite(site):
+		client = requesting.GetClient(requesting.BiliBiliApi)
+	}
+	if client == nil {
+		client = requesting.NewProxyProvider("", "direct")
+	}
+	return client
+}
+
+func siteScheme(site string, enableHttps bool) string {
+	if enableHttps && constants.IsHttpsSite(site) {
+		return "https"
+	}
+	return "http"
+}
+
+func checkSiteResolve(site string) CheckResult {
+	result := CheckResult{Name: "Resolve " + site}
+
+	ctx, cancel := context.WithTimeout(context.Background(), selfCheckTimeout)
+	defer cancel()
+	ips, err := requesting.LookupOrigin(ctx, site)
+	if err != nil {
+		result.Status = CheckFailed
+		result.Detail = err.Error()
+		return result
+	}
+	if len(ips) == 0 {
+		result.Status = CheckFailed
+		result.Detail = requesting.ErrNoAddress.Error()
+		return result
+	}
+
+	result.Status = CheckPassed
+	result.Detail = strings.Join(lo.Map(ips, func(ip net.IP, _ int) string {
+		return ip.String()
+	}), ", ")
+	if lo.ContainsBy(ips, isLocalIP) {
+		result.Status = CheckWarning
+		result.Detail += " (this machine, set the resolver of transparent mode if it's overridden by the hosts file)"
+	}
+	return result
+}
+
+func checkSiteReach(site string, enableHttps bool) CheckResult {
+	client := clientOfSite(site)
+	result := CheckResult{Name: fmt.Sprintf("Reach %s with %s client", site, client.Name())}
+
+	ctx, cancel := context.WithTimeout(context.Background(), selfCheckTimeout)
+	defer cancel()
+	res, err := client.Head(fmt.Sprintf("%s://%s/", siteScheme(site, enableHttps), site), ctx)
+	if err != nil {
+		result.Status = CheckFailed
+		result.Detail = err.Error()
+		return result
+	}
+	res.Body.Close()
+
+	// any response means the site is reachable, the root path isn't meant to be served
+	result.Status = CheckPassed
+	result.Detail = res.Status
+	return result
+}
+
+func checkMitm(enableHttps bool) []CheckResult {
+	if !enableHttps {
+		return []CheckResult{{Name: "HTTPS interception", Status: CheckSkipped, Detail: "HTTPS hijacking is disabled"}}
+	}
+
+	mitm := CheckResult{Name: "HTTPS interception", Status: CheckPassed, Detail: "leaf certificates are signed by the CA"}
+	if err := SelfTestCA(); err != nil {
+		mitm.Status = CheckFailed
+		mitm.Detail = err.Error()
+	}
+	trust := CheckResult{Name: "CA trust", Status: CheckPassed, Detail: "trusted by the system"}
+	if !IsCATrusted() {
+		trust.Status = CheckWarning
+		trust.Detail = "not trusted by the system, install " + caCertPath() + " into trusted root certification authorities"
+	}
+	return []CheckResult{mitm, trust}
+}
+
+// probeHost gives a host matching site, a subdomain for wildcard sites
+func probeHost(site string) string {
+	if suffix, ok := constants.WildcardSuffix(site); ok {
+		return "vrcdp-self-check" + suffix
+	}
+	return site
+}
+
+func checkInterception(site string, enableHttps bool, port int) CheckResult {
+	scheme := siteScheme(site, enableHttps)
+	result := CheckResult{Name: fmt.Sprintf("Intercept %s://%s", scheme, site)}
+
+	proxyUrl := &url.URL{
+		Scheme: "http",
+		Host:   net.JoinHostPort("127.0.0.1", strconv.Itoa(port)),
+		User:   proxyGuard.ProxyUserinfo(),
+	}
+	transport := &http.Transport{Proxy: http.ProxyURL(proxyUrl)}
+	if scheme == "https" {
+		ca := getCA()
+		if ca == nil {
+			result.Status = CheckSkipped
+			result.Detail = ErrNoCA.Error()
+			return result
+		}
+		// only the CA is trusted, so the request succeeds only if it's intercepted by us
+		roots := x509.NewCertPool()
+		roots.AddCert(ca.Leaf)
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+	}
+	client := &http.Client{Transport: transport, Timeout: selfCheckTimeout}
+	defer client.CloseIdleConnections()
+
+	res, err := client.Get(fmt.Sprintf("%s://%s%s", scheme, probeHost(site), selfCheckPath))
+	if err != nil {
+		result.Status = CheckFailed
+		var opErr *net.OpError
+		if (errors.As(err, &opErr) && opErr.Op == "proxyconnect") || errors.Is(err, context.DeadlineExceeded) {
+			result.Detail = fmt.Sprintf("proxy on port %d is not reachable: %v", port, err)
+		} else {
+			result.Detail = err.Error()
+		}
+		return result
+	}
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+
+	if res.Header.Get(selfCheckHeader) == "" {
+		result.Status = CheckFailed
+		switch res.StatusCode {
+		case http.StatusForbidden:
+			result.Detail = "rejected by the allow list of the proxy"
+		case http.StatusProxyAuthRequired:
+			result.Detail = "rejected by the authentication of the proxy"
+		default:
+			result.Detail = fmt.Sprintf("not intercepted, got %s from somewhere else", res.Status)
+		}
+		return result
+	}
+	result.Status = CheckPassed
+	return result
+}
+
+// SelfCheck runs the diagnostics for the proxy serving sites on port, the interception checks need the proxy running
+func SelfCheck(sites []string, enableHttps bool, port int) []CheckResult {
+	results := checkProxyLoop(port)
+	results = append(results, checkMitm(enableHttps)...)
+
+	sites = lo.Uniq(sites)
+	siteResults := make([][]CheckResult, len(sites))
+	wg := sync.WaitGroup{}
+	for i, site := range sites {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok := constants.WildcardSuffix(site); ok {
+				siteResults[i] = []CheckResult{{Name: "Resolve " + site, Status: CheckSkipped, Detail: "wildcard site"}}
+			} else {
+				siteResults[i] = []CheckResult{checkSiteResolve(site), checkSiteReach(site, enableHttps)}
+			}
+			siteResults[i] = append(siteResults[i], checkInterception(site, enableHttps, port))
+		}()
+	}
+	wg.Wait()
+
+	return append(results, lo.Flatten(siteResults)...)
+}
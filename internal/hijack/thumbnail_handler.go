@@ -0,0 +1,61 @@
+package hijack
+
+import (
+	"bytes"
+	"net/http"
+	"path"
+	"sync"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/constants"
+	"github.com/wzhqwq/VRCDancePreloader/internal/thumbnail_cache"
+	"github.com/wzhqwq/VRCDancePreloader/internal/utils"
+)
+
+var interceptThumbnails = false
+
+// SetInterceptThumbnails serves the thumbnails requested by dance worlds from the thumbnail cache
+func SetInterceptThumbnails(b bool) {
+	interceptThumbnails = b
+}
+
+// thumbnailUrlOf gives the URL that the thumbnail cache knows the requested thumbnail by
+func thumbnailUrlOf(req *http.Request) (string, bool) {
+	switch {
+	case constants.IsPyPySite(req.Host):
+		if id, ok := utils.CheckPyPyThumbnailRequest(req); ok {
+			return utils.GetPyPyThumbnailUrl(id), true
+		}
+	case constants.IsThumbnailSite(req.Host):
+		if id, ok := utils.CheckWannaThumbnailRequest(req); ok {
+			return utils.GetWannaThumbnailUrl(id), true
+		}
+	case constants.IsDuDuSite(req.Host):
+		if id, ok := utils.CheckDuDuThumbnailRequest(req); ok {
+			return utils.GetDuDuThumbnailUrl(id), true
+		}
+	}
+	return "", false
+}
+
+func handleThumbnailRequest(w http.ResponseWriter, req *http.Request, wg *sync.WaitGroup) bool {
+	if !interceptThumbnails {
+		return false
+	}
+	url, ok := thumbnailUrlOf(req)
+	if !ok {
+		return false
+	}
+
+	data, modTime, err := thumbnail_cache.GetOriginal(url)
+	if err != nil {
+		logger.WarnLn("Failed to get thumbnail, fallback to direct access:", err)
+		return false
+	}
+
+	go func() {
+		defer wg.Done()
+		w.Header().Set("Content-Type", http.DetectContentType(data))
+		http.ServeContent(w, req, path.Base(req.URL.Path), modTime, bytes.NewReader(data))
+	}()
+	return true
+}
@@ -0,0 +1,178 @@
+package hijack
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/wzhqwq/VRCDancePreloader/internal/constants"
+	"github.com/wzhqwq/VRCDancePreloader/internal/requesting"
+)
+
+// In transparent mode, we serve the intercepted sites on local 80/443 as a reverse proxy, and the sites are pointed
+// to this machine by the hosts file or DNS overrides, so no proxy needs to be configured in the system or Clash.
+// The leaf certificate is chosen by SNI, and the real origins are resolved by a resolver bypassing the overrides.
+
+var ErrNoSNI = errors.New("client didn't send SNI")
+
+var transparentServers []*http.Server
+var transparentMutex sync.Mutex
+
+// transparentHost strips the port of Host, the port may be mapped to another one locally
+func transparentHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return strings.Trim(host, "[]")
+}
+
+func newOriginTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	if upstreamUrl != nil && upstreamForMisses {
+		transport.Proxy = http.ProxyURL(upstreamUrl)
+	}
+	transport.DialContext = requesting.DialOrigin
+	return transport
+}
+
+func newTransparentHandler(sites []string, scheme string) http.Handler {
+	origin := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.URL.Scheme = scheme
+			pr.Out.URL.Host = pr.In.Host
+			pr.Out.Host = pr.In.Host
+		},
+		Transport: newOriginTransport(),
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			logger.WarnLnf("Failed to forward request to %s: %v", req.Host, err)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host := transparentHost(req.Host)
+		if !constants.MatchAnySite(sites, host) {
+			http.Error(w, fmt.Sprintf("%s is not intercepted", host), http.StatusMisdirectedRequest)
+			return
+		}
+		// the video handlers match hosts without port
+		req.Host = host
+
+		if req.Method == http.MethodGet {
+			if ok, wg := handleVideoRequest(w, req); ok {
+				wg.Wait()
+				return
+			}
+		}
+		origin.ServeHTTP(w, req)
+	})
+}
+
+// newSNIConfig signs a certificate for the server name that the client asks for
+func newSNIConfig(sites []string) (*tls.Config, error) {
+	ca := getCA()
+	if ca == nil {
+		return nil, ErrNoCA
+	}
+	store := newLeafStore()
+
+	return &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			host := hello.ServerName
+			if host == "" {
+				return nil, ErrNoSNI
+			}
+			if !constants.MatchAnySite(sites, host) {
+				return nil, fmt.Errorf("%s is not intercepted", host)
+			}
+			return store.Fetch(host, func() (*tls.Certificate, error) {
+				return signLeaf(ca, host)
+			})
+		},
+		NextProtos: []string{"http/1.1"},
+	}, nil
+}
+
+func serveTransparent(listener net.Listener, handler http.Handler) *http.Server {
+	server := &http.Server{Handler: handler}
+	go func() {
+		if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			logger.ErrorLn("Transparent server stopped:", err)
+		}
+	}()
+	return server
+}
+
+// StartTransparent listens on httpPort, and httpsPort if enableHttps is set, 0 disables a port
+func StartTransparent(sites []string, enableHttps bool, httpPort, httpsPort int) error {
+	StopTransparent()
+
+	transparentMutex.Lock()
+	defer transparentMutex.Unlock()
+
+	if requesting.GetBypassResolver() == nil {
+		logger.WarnLn("No resolver is set for transparent hijacking, requests we don't handle may come back to us")
+	}
+
+	var servers []*http.Server
+	closeAll := func() {
+		for _, s := range servers {
+			s.Close()
+		}
+	}
+
+	if httpPort > 0 {
+		listener, err := net.Listen("tcp", "127.0.0.1:"+strconv.Itoa(httpPort))
+		if err != nil {
+			return err
+		}
+		servers = append(servers, serveTransparent(listener, newTransparentHandler(sites, "http")))
+		logger.InfoLn("Serving intercepted sites transparently on port", httpPort)
+	}
+
+	if enableHttps && httpsPort > 0 {
+		httpsSites := lo.Filter(sites, func(site string, _ int) bool {
+			return constants.IsHttpsSite(site)
+		})
+		tlsConfig, err := newSNIConfig(httpsSites)
+		if err != nil {
+			closeAll()
+			return err
+		}
+		listener, err := net.Listen("tcp", "127.0.0.1:"+strconv.Itoa(httpsPort))
+		if err != nil {
+			closeAll()
+			return err
+		}
+		handler := newTransparentHandler(httpsSites, "https")
+		servers = append(servers, serveTransparent(tls.NewListener(listener, tlsConfig), handler))
+		logger.InfoLn("Serving intercepted HTTPS sites transparently on port", httpsPort)
+	}
+
+	transparentServers = servers
+	return nil
+}
+
+func StopTransparent() {
+	transparentMutex.Lock()
+	defer transparentMutex.Unlock()
+
+	for _, server := range transparentServers {
+		shutdownCtx, shutdownRelease := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logger.WarnLn("Transparent server shutdown error:", err)
+		}
+		shutdownRelease()
+	}
+	transparentServers = nil
+}
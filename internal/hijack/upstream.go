@@ -0,0 +1,132 @@
+package hijack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/elazarl/goproxy"
+	"github.com/wzhqwq/VRCDancePreloader/internal/requesting"
+	xproxy "golang.org/x/net/proxy"
+)
+
+// Traffic that goes through us but isn't intercepted is sent to the upstream proxy, so that we can be the first one
+// in the chain (e.g. before Clash). Requests to intercepted sites that the video handlers don't take (misses) are
+// sent directly unless upstreamForMisses is set.
+
+var ErrUnsupportedUpstream = errors.New("unsupported upstream proxy, only http and socks5 are supported")
+
+var upstreamUrl *url.URL
+var upstreamForMisses = false
+
+// direct transport for misses, it keeps skipping verification like the transport of goproxy
+var directTransport *http.Transport
+
+// ParseUpstreamProxy checks the upstream proxy URL, empty string means no upstream proxy
+func ParseUpstreamProxy(rawUrl string) (*url.URL, error) {
+	if rawUrl == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, ErrUnsupportedUpstream
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing host in upstream proxy %s", rawUrl)
+	}
+	return u, nil
+}
+
+// SetUpstreamProxy takes effect after restarting the proxy server
+func SetUpstreamProxy(rawUrl string) error {
+	u, err := ParseUpstreamProxy(rawUrl)
+	if err != nil {
+		return err
+	}
+	upstreamUrl = u
+	return nil
+}
+
+func SetUpstreamForMisses(b bool) {
+	upstreamForMisses = b
+}
+
+func socks5Dialer(u *url.URL) (xproxy.ContextDialer, error) {
+	var auth *xproxy.Auth
+	if u.User != nil {
+		password, _ := u.User.Password()
+		auth = &xproxy.Auth{User: u.User.Username(), Password: password}
+	}
+	d, err := xproxy.SOCKS5("tcp", u.Host, auth, xproxy.Direct)
+	if err != nil {
+		return nil, err
+	}
+	return d.(xproxy.ContextDialer), nil
+}
+
+// setupUpstream routes pass-through traffic of p to the upstream proxy, the default of goproxy is kept without it
+func setupUpstream(p *goproxy.ProxyHttpServer) error {
+	// the real origins are reached even if the intercepted sites are overridden to us
+	p.Tr.DialContext = requesting.DialOrigin
+	directTransport = &http.Transport{
+		TLSClientConfig: p.Tr.TLSClientConfig,
+		Proxy:           nil,
+		DialContext:     requesting.DialOrigin,
+	}
+
+	u := upstreamUrl
+	if u == nil {
+		return nil
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		p.Tr.Proxy = http.ProxyURL(u)
+		p.ConnectDial = p.NewConnectDialToProxy(u.String())
+	case "socks5", "socks5h":
+		d, err := socks5Dialer(u)
+		if err != nil {
+			return err
+		}
+		p.Tr.Proxy = nil
+		p.Tr.DialContext = d.DialContext
+		p.ConnectDial = func(network, addr string) (net.Conn, error) {
+			return d.DialContext(context.Background(), network, addr)
+		}
+	}
+	if p.ConnectDial == nil {
+		return fmt.Errorf("cannot connect through upstream proxy %s", u.Redacted())
+	}
+
+	logger.InfoLn("Pass-through traffic goes to upstream proxy", u.Redacted())
+	return nil
+}
+
+func missesGoDirect() bool {
+	return upstreamUrl != nil && !upstreamForMisses
+}
+
+// dialMiss connects to an intercepted site for a request that isn't handled by us
+func dialMiss(ctx context.Context, network, addr string) (net.Conn, error) {
+	if missesGoDirect() {
+		return requesting.DialOrigin(ctx, network, addr)
+	}
+	return connectDial(ctx, network, addr)
+}
+
+// useMissRoute lets goproxy send a request to an intercepted site that isn't handled by us
+func useMissRoute(ctx *goproxy.ProxyCtx) {
+	if missesGoDirect() {
+		ctx.RoundTripper = goproxy.RoundTripperFunc(func(req *http.Request, _ *goproxy.ProxyCtx) (*http.Response, error) {
+			return directTransport.RoundTrip(req)
+		})
+	}
+}
@@ -9,6 +9,8 @@ import (
 
 	"github.com/wzhqwq/VRCDancePreloader/internal/constants"
 	"github.com/wzhqwq/VRCDancePreloader/internal/playlist"
+	"github.com/wzhqwq/VRCDancePreloader/internal/rw_file"
+	"github.com/wzhqwq/VRCDancePreloader/internal/third_party_api"
 	"github.com/wzhqwq/VRCDancePreloader/internal/utils"
 )
 
@@ -23,6 +25,7 @@ func handlePlatformVideoRequest(platform, id string, w http.ResponseWriter, req
 		reqIncrement = (reqIncrement + 1) % reqIdMax
 		reqId := reqIncrement
 		requestLogger := utils.NewLogger(fmt.Sprintf("Request %d", reqId))
+		inspector := inspectRequest(reqId, platform, id, req)
 
 		rangeHeader := req.Header.Get("Range")
 		if rangeHeader == "" {
@@ -48,6 +51,7 @@ func handlePlatformVideoRequest(platform, id string, w http.ResponseWriter, req
 		entry, err := playlist.Request(platform, id, ctx)
 		if err != nil {
 			requestLogger.ErrorLnf("Failed to load %s video, reason: %v", platform, err)
+			inspector.Fallback(err)
 			handledCh <- false
 			return
 		}
@@ -55,6 +59,7 @@ func handlePlatformVideoRequest(platform, id string, w http.ResponseWriter, req
 		rs, err := entry.GetReadSeeker(ctx)
 		if err != nil {
 			requestLogger.ErrorLnf("Failed to load %s video, reason: %v", platform, err)
+			inspector.Fallback(err)
 			handledCh <- false
 			return
 		}
@@ -62,6 +67,7 @@ func handlePlatformVideoRequest(platform, id string, w http.ResponseWriter, req
 		contentLength, err := entry.TotalLen()
 		if err != nil {
 			requestLogger.ErrorLnf("Failed to load %s video, reason: %v", platform, err)
+			inspector.Fallback(err)
 			handledCh <- false
 			return
 		}
@@ -69,15 +75,16 @@ func handlePlatformVideoRequest(platform, id string, w http.ResponseWriter, req
 		requestLogger.InfoLnf("Requested %s video %s is available", platform, id)
 		handledCh <- true
 
-		if rangeHeader != "" {
-			entry.UpdateReqRangeStart(parseRange(rangeHeader, contentLength))
+		if ranges := rw_file.ParseRangeHeader(rangeHeader, contentLength); len(ranges) > 0 {
+			entry.UpdateReqRanges(ranges)
 		}
 
 		if limitBandwidth {
 			rs = utils.NewPacingReader(rs, 25)
 		}
 
-		http.ServeContent(w, req, "video.mp4", entry.ModTime(), rs)
+		http.ServeContent(inspector.Wrap(w), req, "video.mp4", entry.ModTime(), rs)
+		inspector.Finish()
 	}()
 
 	return <-handledCh
@@ -122,3 +129,16 @@ func handleBiliRequest(w http.ResponseWriter, req *http.Request, wg *sync.WaitGr
 	}
 	return false
 }
+
+func handleYoutubeRequest(w http.ResponseWriter, req *http.Request, wg *sync.WaitGroup) bool {
+	if !constants.IsYoutubeSite(req.Host) {
+		return false
+	}
+	if key, ok := utils.CheckGoogleVideoRequest(req); ok {
+		// only the streams resolved by us are known, others are left to googlevideo.com
+		if id, ok := third_party_api.FindYoutubeVideoByStreamKey(key); ok {
+			return handlePlatformVideoRequest("YouTube", id, w, req, wg)
+		}
+	}
+	return false
+}
@@ -1,15 +1,24 @@
 package live
 
+import "github.com/wzhqwq/VRCDancePreloader/internal/exposure"
+
 var currentLiveServer *Server
 
+var guard = exposure.NewGuard(exposure.Settings{Bind: exposure.BindLoopback})
+
 var OnSettingsChanged func(settings string)
 var GetSettings func() string
 
+// SetExposure decides who can visit the live page, it's applied when the server starts
+func SetExposure(settings exposure.Settings) {
+	guard = exposure.NewGuard(settings)
+}
+
 func StartLiveServer(port int) error {
 	if currentLiveServer != nil {
 		currentLiveServer.Stop()
 	}
-	currentLiveServer = NewLiveServer(port)
+	currentLiveServer = NewLiveServer(port, guard)
 	return currentLiveServer.Start()
 }
 
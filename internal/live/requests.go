@@ -0,0 +1,26 @@
+package live
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/hijack"
+	"github.com/wzhqwq/VRCDancePreloader/internal/persistence"
+)
+
+const defaultRequestLogLimit = 100
+const maxRequestLogLimit = 1000
+
+// handleRequests lists recent intercepted requests, ?source=db lists the persisted ones
+func (s *Server) handleRequests(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("source") != "db" {
+		writeOk(w, hijack.GetInterceptedRequests())
+		return
+	}
+
+	limit := defaultRequestLogLimit
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 {
+		limit = min(l, maxRequestLogLimit)
+	}
+	writeOk(w, persistence.ListRequestLogs(limit))
+}
@@ -4,12 +4,18 @@ import (
 	"bytes"
 	"image/jpeg"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/wzhqwq/VRCDancePreloader/internal/gui/images/thumbnails"
 	"github.com/wzhqwq/VRCDancePreloader/internal/third_party_api"
+	"github.com/wzhqwq/VRCDancePreloader/internal/thumbnail_cache"
 )
 
+// default width of thumbnails, the same as the ones in GUI
+const thumbnailWidth = 320
+
 func (s *Server) handleThumbnail(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 	if id == "" {
@@ -18,9 +24,26 @@ func (s *Server) handleThumbnail(w http.ResponseWriter, r *http.Request) {
 	}
 
 	url := third_party_api.GetThumbnailByInternalID(id).Get()
+	if url != "" && !strings.HasPrefix(url, "group:") {
+		// e.g. /thumbnail/pypy_123?width=640
+		width := uint(thumbnailWidth)
+		if v, err := strconv.Atoi(r.URL.Query().Get("width")); err == nil && v > 0 {
+			width = uint(v)
+		}
+		data, modTime, err := thumbnail_cache.GetResized(url, width)
+		if err == nil {
+			w.Header().Set("Content-Type", "image/jpeg")
+			http.ServeContent(w, r, id+".jpg", modTime, bytes.NewReader(data))
+			return
+		}
+		logger.WarnLn("Failed to get thumbnail of", id, err)
+		url = ""
+	}
+
 	i := thumbnails.GetThumbnailImage(id, url)
 	if i == nil {
 		w.WriteHeader(http.StatusNotFound)
+		return
 	}
 
 	buf := new(bytes.Buffer)
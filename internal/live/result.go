@@ -27,3 +27,7 @@ func writeJSON(w http.ResponseWriter, code int, v interface{}) {
 func writeOk(w http.ResponseWriter, v interface{}) {
 	writeJSON(w, http.StatusOK, &Result[any]{Ok: true, Data: v})
 }
+
+func writeFail(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, &Result[any]{Ok: false, Message: message})
+}
@@ -3,11 +3,11 @@ package live
 import (
 	"context"
 	"errors"
-	"fmt"
 	"net/http"
 	"slices"
 	"time"
 
+	"github.com/wzhqwq/VRCDancePreloader/internal/exposure"
 	"github.com/wzhqwq/VRCDancePreloader/internal/playlist"
 	"github.com/wzhqwq/VRCDancePreloader/internal/utils"
 )
@@ -17,6 +17,8 @@ var logger = utils.NewLogger("Live")
 type Server struct {
 	http.Server
 
+	guard *exposure.Guard
+
 	sessions []*WsSession
 
 	watcher *PlaylistWatcher
@@ -32,14 +34,15 @@ type Server struct {
 	running bool
 }
 
-func NewLiveServer(port int) *Server {
+func NewLiveServer(port int, guard *exposure.Guard) *Server {
 	mux := http.NewServeMux()
 
 	s := &Server{
 		Server: http.Server{
-			Addr:    fmt.Sprintf(":%d", port),
-			Handler: mux,
+			Addr:    guard.Addr(port),
+			Handler: guard.Wrap(mux),
 		},
+		guard: guard,
 
 		newSession:    make(chan *WsSession, 10),
 		closedSession: make(chan *WsSession, 10),
@@ -62,6 +65,9 @@ func (s *Server) RegisterHandlers(mux *http.ServeMux) {
 	mux.HandleFunc("/playlist", s.handlePlaylist)
 	mux.HandleFunc("/ws", s.handleWs)
 	mux.HandleFunc("/settings", s.handleSettings)
+	mux.HandleFunc("/requests", s.handleRequests)
+	mux.HandleFunc("/stats", s.handleStats)
+	mux.HandleFunc("/stats/wrapped", s.handleWrapped)
 	// static
 	mux.Handle("/", http.FileServerFS(staticFS{}))
 }
@@ -107,7 +113,7 @@ func (s *Server) Loop() {
 func (s *Server) Start() error {
 	s.running = true
 	go s.Loop()
-	logger.InfoLn("Starting server on port", s.Server.Addr[1:])
+	logger.InfoLn("Starting server on", s.Server.Addr)
 	if err := s.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
 		logger.ErrorLn("Error starting Live Server:", err)
 		s.running = false
@@ -0,0 +1,75 @@
+package live
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/stats"
+)
+
+const defaultStatsTop = 10
+
+// handleStats summarizes the dance history in ?range=7d|30d|year|all (30d by default), or between the dates
+// ?from=2006-01-02&to=2006-01-02 with to excluded. ?top limits the rankings.
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	rangeName := q.Get("range")
+	if rangeName == "" {
+		rangeName = "30d"
+	}
+	statsRange, ok := stats.RangeByName(rangeName)
+	if !ok {
+		writeFail(w, http.StatusBadRequest, "unknown range "+rangeName)
+		return
+	}
+	if from := q.Get("from"); from != "" {
+		t, err := time.ParseInLocation(time.DateOnly, from, time.Local)
+		if err != nil {
+			writeFail(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		statsRange = stats.AllTime()
+		statsRange.From = t
+		if to := q.Get("to"); to != "" {
+			t, err := time.ParseInLocation(time.DateOnly, to, time.Local)
+			if err != nil {
+				writeFail(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			statsRange.To = t
+		}
+	}
+
+	top := defaultStatsTop
+	if t, err := strconv.Atoi(q.Get("top")); err == nil {
+		top = t
+	}
+
+	summary, err := stats.Compute(statsRange, top)
+	if err != nil {
+		writeFail(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeOk(w, summary)
+}
+
+// handleWrapped summarizes ?year, the current one by default
+func (s *Server) handleWrapped(w http.ResponseWriter, r *http.Request) {
+	year := time.Now().Year()
+	if y := r.URL.Query().Get("year"); y != "" {
+		var err error
+		if year, err = strconv.Atoi(y); err != nil {
+			writeFail(w, http.StatusBadRequest, "invalid year "+y)
+			return
+		}
+	}
+
+	wrapped, err := stats.ComputeWrapped(year)
+	if err != nil {
+		writeFail(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeOk(w, wrapped)
+}
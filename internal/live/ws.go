@@ -10,9 +10,6 @@ import (
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
 }
 
 type WsSession struct {
@@ -43,7 +40,9 @@ func (ws *WsSession) SendText(text []byte) {
 }
 
 func (s *Server) handleWs(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	u := upgrader
+	u.CheckOrigin = s.guard.CheckOrigin
+	conn, err := u.Upgrade(w, r, nil)
 	if err != nil {
 		logger.ErrorLn("Error upgrading to websocket:", err)
 		return
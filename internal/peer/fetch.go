@@ -0,0 +1,159 @@
+package peer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var errNotFound = errors.New("peer does not have it")
+var errMismatch = errors.New("peer has a different file")
+var errNotAllowed = errors.New("peer is not in the allow list")
+
+// peers are in LAN, so they are accessed directly
+var client = &http.Client{
+	Transport: &http.Transport{
+		Proxy:       nil,
+		DialContext: dialAllowed,
+	},
+}
+
+// dialAllowed only connects to peers in the allow list, the same list that guards our own server
+func dialAllowed(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var dialer net.Dialer
+	for _, ip := range ips {
+		if !isAllowed(ip.IP) {
+			continue
+		}
+		return dialer.DialContext(ctx, network, net.JoinHostPort(ip.IP.String(), port))
+	}
+	return nil, errNotAllowed
+}
+
+func isStrongETag(etag string) bool {
+	return etag != "" && !strings.HasPrefix(etag, "W/")
+}
+
+// a peer that does not answer in time is skipped
+const probeTimeout = 2 * time.Second
+
+func getCacheUrl(p Peer, id string) string {
+	return fmt.Sprintf("http://%s/peer/cache/%s", p.Addr, url.PathEscape(id))
+}
+
+// Fetch asks peers one by one and returns the body from offset of the first matched copy, nil if no peer has it.
+// A copy can only be identified by a strong ETag, so nothing is fetched without one.
+func Fetch(ctx context.Context, id string, size int64, etag string, offset int64) io.ReadCloser {
+	if !enabled.Load() || !isStrongETag(etag) {
+		return nil
+	}
+
+	for _, p := range ListPeers() {
+		body, err := fetchFrom(ctx, p, id, size, etag, offset)
+		if err != nil {
+			if !errors.Is(err, errNotFound) {
+				logger.WarnLn("Failed to fetch", id, "from", p.Name, ":", err)
+			}
+			continue
+		}
+		logger.InfoLnf("Fetching %s from peer %s, start from %d", id, p.Name, offset)
+		return body
+	}
+	return nil
+}
+
+func probe(ctx context.Context, cacheUrl string, size int64, etag string) error {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, cacheUrl, nil)
+	if err != nil {
+		return err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+	if res.ContentLength != size {
+		return errMismatch
+	}
+	if res.Header.Get("ETag") != etag {
+		return errMismatch
+	}
+	return nil
+}
+
+// checkRange makes sure the response starts from offset and ends at the end of the file
+func checkRange(res *http.Response, size int64, offset int64) error {
+	if offset == 0 {
+		if res.ContentLength != size {
+			return errMismatch
+		}
+		return nil
+	}
+
+	var start, end, total int64
+	contentRange := res.Header.Get("Content-Range")
+	if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/%d", &start, &end, &total); err != nil {
+		return fmt.Errorf("invalid Content-Range %q", contentRange)
+	}
+	if start != offset || end != size-1 || total != size {
+		return fmt.Errorf("unexpected Content-Range %q", contentRange)
+	}
+	return nil
+}
+
+func fetchFrom(ctx context.Context, p Peer, id string, size int64, etag string, offset int64) (io.ReadCloser, error) {
+	cacheUrl := getCacheUrl(p, id)
+
+	if err := probe(ctx, cacheUrl, size, etag); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cacheUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	expected := http.StatusOK
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", etag)
+		expected = http.StatusPartialContent
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != expected {
+		res.Body.Close()
+		return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+	if err := checkRange(res, size, offset); err != nil {
+		res.Body.Close()
+		return nil, err
+	}
+	return res.Body, nil
+}
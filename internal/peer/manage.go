@@ -0,0 +1,41 @@
+package peer
+
+import (
+	"github.com/wzhqwq/VRCDancePreloader/internal/cache"
+)
+
+var currentServer *Server
+
+// Start serves complete cache files to allowed peers and lets downloads ask peers first, it blocks until stopped
+func Start(port int, discovery bool) error {
+	Stop()
+
+	if discovery {
+		if err := startDiscovery(port); err != nil {
+			logger.WarnLn("Failed to start peer discovery, only static peers are used:", err)
+		}
+	}
+
+	enabled.Store(true)
+	currentServer = NewServer(port, cache.OpenCompleteCache)
+	err := currentServer.Start()
+	if err != nil {
+		enabled.Store(false)
+		stopDiscovery()
+	}
+	return err
+}
+
+func Stop() {
+	enabled.Store(false)
+	stopDiscovery()
+	if currentServer != nil {
+		currentServer.Stop()
+		currentServer = nil
+	}
+}
+
+// GetInstanceName returns the name announced to other instances
+func GetInstanceName() string {
+	return instanceName
+}
@@ -0,0 +1,219 @@
+package peer
+
+import (
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Instances announce themselves as DNS-SD service over mDNS, so they can find each other in LAN without configuration.
+
+const serviceName = "_vrcdp._tcp.local."
+const queryInterval = 30 * time.Second
+const recordTTL = uint32(peerTTL / time.Second)
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+var instanceName = getInstanceName()
+
+var discoveryConn *net.UDPConn
+var discoveryStopCh chan struct{}
+var discoveryMutex sync.Mutex
+
+var labelRegex = regexp.MustCompile(`[^a-zA-Z0-9-]`)
+
+func getInstanceName() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "preloader"
+	}
+	hostname = labelRegex.ReplaceAllString(hostname, "-")
+	if len(hostname) > 32 {
+		hostname = hostname[:32]
+	}
+	// several instances may run on the same host
+	return hostname + "-" + uuid.NewString()[:8]
+}
+
+func getInstanceFullName() string {
+	return instanceName + "." + serviceName
+}
+
+// startDiscovery answers mDNS queries for this instance and queries other instances periodically
+func startDiscovery(port int) error {
+	discoveryMutex.Lock()
+	defer discoveryMutex.Unlock()
+
+	if discoveryConn != nil {
+		return nil
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		return err
+	}
+	discoveryConn = conn
+	discoveryStopCh = make(chan struct{})
+
+	go receiveLoop(conn, port)
+	go queryLoop(conn, discoveryStopCh)
+
+	logger.InfoLn("Discovering peers as", instanceName)
+	return nil
+}
+
+func stopDiscovery() {
+	discoveryMutex.Lock()
+	defer discoveryMutex.Unlock()
+
+	if discoveryConn == nil {
+		return
+	}
+	close(discoveryStopCh)
+	discoveryConn.Close()
+	discoveryConn = nil
+	clearDiscoveredPeers()
+}
+
+func queryLoop(conn *net.UDPConn, stopCh chan struct{}) {
+	ticker := time.NewTicker(queryInterval)
+	defer ticker.Stop()
+
+	for {
+		sendQuery(conn)
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func sendQuery(conn *net.UDPConn) {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{})
+	b.EnableCompression()
+	_ = b.StartQuestions()
+	_ = b.Question(dnsmessage.Question{
+		Name:  dnsmessage.MustNewName(serviceName),
+		Type:  dnsmessage.TypePTR,
+		Class: dnsmessage.ClassINET,
+	})
+	msg, err := b.Finish()
+	if err != nil {
+		logger.ErrorLn("Failed to build mDNS query:", err)
+		return
+	}
+	if _, err := conn.WriteToUDP(msg, mdnsGroup); err != nil {
+		logger.WarnLn("Failed to send mDNS query:", err)
+	}
+}
+
+func buildAnswer(port int) ([]byte, error) {
+	service := dnsmessage.MustNewName(serviceName)
+	instance, err := dnsmessage.NewName(getInstanceFullName())
+	if err != nil {
+		return nil, err
+	}
+	target, err := dnsmessage.NewName(instanceName + ".local.")
+	if err != nil {
+		return nil, err
+	}
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{Response: true, Authoritative: true})
+	b.EnableCompression()
+	_ = b.StartAnswers()
+	_ = b.PTRResource(
+		dnsmessage.ResourceHeader{Name: service, Class: dnsmessage.ClassINET, TTL: recordTTL},
+		dnsmessage.PTRResource{PTR: instance},
+	)
+	_ = b.StartAdditionals()
+	_ = b.SRVResource(
+		dnsmessage.ResourceHeader{Name: instance, Class: dnsmessage.ClassINET, TTL: recordTTL},
+		dnsmessage.SRVResource{Port: uint16(port), Target: target},
+	)
+	return b.Finish()
+}
+
+func sendAnswer(conn *net.UDPConn, port int) {
+	msg, err := buildAnswer(port)
+	if err != nil {
+		logger.ErrorLn("Failed to build mDNS answer:", err)
+		return
+	}
+	if _, err := conn.WriteToUDP(msg, mdnsGroup); err != nil {
+		logger.WarnLn("Failed to send mDNS answer:", err)
+	}
+}
+
+func receiveLoop(conn *net.UDPConn, port int) {
+	buf := make([]byte, 9000)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			// closed
+			return
+		}
+		handleMessage(conn, buf[:n], src, port)
+	}
+}
+
+func handleMessage(conn *net.UDPConn, msg []byte, src *net.UDPAddr, port int) {
+	var p dnsmessage.Parser
+	header, err := p.Start(msg)
+	if err != nil {
+		return
+	}
+
+	if !header.Response {
+		questions, err := p.AllQuestions()
+		if err != nil {
+			return
+		}
+		for _, q := range questions {
+			if q.Type == dnsmessage.TypePTR && strings.EqualFold(q.Name.String(), serviceName) {
+				sendAnswer(conn, port)
+				return
+			}
+		}
+		return
+	}
+
+	if err := p.SkipAllQuestions(); err != nil {
+		return
+	}
+	answers, err := p.AllAnswers()
+	if err != nil {
+		return
+	}
+	if err := p.SkipAllAuthorities(); err != nil {
+		return
+	}
+	additionals, err := p.AllAdditionals()
+	if err != nil {
+		return
+	}
+
+	for _, r := range append(answers, additionals...) {
+		srv, ok := r.Body.(*dnsmessage.SRVResource)
+		if !ok {
+			continue
+		}
+		name := r.Header.Name.String()
+		if !strings.HasSuffix(strings.ToLower(name), serviceName) {
+			continue
+		}
+		name = strings.TrimSuffix(name, "."+serviceName)
+		if name == instanceName {
+			continue
+		}
+		// the announcer is where the answer comes from
+		addDiscoveredPeer(name, net.JoinHostPort(src.IP.String(), strconv.Itoa(int(srv.Port))))
+	}
+}
@@ -0,0 +1,107 @@
+package peer
+
+import (
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/wzhqwq/VRCDancePreloader/internal/exposure"
+	"github.com/wzhqwq/VRCDancePreloader/internal/utils"
+)
+
+var logger = utils.NewLogger("Peer")
+
+// a discovered peer is forgotten if it's not seen within this duration
+const peerTTL = 2 * time.Minute
+
+type Peer struct {
+	Name string
+	// host:port of its peer server
+	Addr     string
+	Static   bool
+	LastSeen time.Time
+}
+
+var enabled atomic.Bool
+
+var staticPeers []string
+var discoveredPeers = make(map[string]*Peer)
+var peersMutex sync.RWMutex
+
+var allowedNets []*net.IPNet
+var allowedNetsMutex sync.RWMutex
+
+// SetStaticPeers sets host:port of instances that are always asked
+func SetStaticPeers(addrs []string) {
+	peersMutex.Lock()
+	defer peersMutex.Unlock()
+
+	staticPeers = lo.Compact(lo.Map(addrs, func(addr string, _ int) string {
+		return strings.TrimSpace(addr)
+	}))
+}
+
+// SetAllowList sets IPs or CIDRs that are allowed to fetch files from this instance
+func SetAllowList(entries []string) {
+	nets := exposure.ParseAllowList(entries)
+
+	allowedNetsMutex.Lock()
+	allowedNets = nets
+	allowedNetsMutex.Unlock()
+}
+
+func isAllowed(ip net.IP) bool {
+	allowedNetsMutex.RLock()
+	defer allowedNetsMutex.RUnlock()
+
+	return exposure.ContainsIP(allowedNets, ip)
+}
+
+func addDiscoveredPeer(name, addr string) {
+	peersMutex.Lock()
+	defer peersMutex.Unlock()
+
+	if p, ok := discoveredPeers[name]; ok {
+		p.Addr = addr
+		p.LastSeen = time.Now()
+		return
+	}
+	discoveredPeers[name] = &Peer{
+		Name:     name,
+		Addr:     addr,
+		LastSeen: time.Now(),
+	}
+	logger.InfoLn("Found peer", name, "at", addr)
+}
+
+func clearDiscoveredPeers() {
+	peersMutex.Lock()
+	defer peersMutex.Unlock()
+
+	discoveredPeers = make(map[string]*Peer)
+}
+
+// ListPeers returns static peers and peers discovered recently, static ones go first
+func ListPeers() []Peer {
+	peersMutex.RLock()
+	defer peersMutex.RUnlock()
+
+	peers := lo.Map(staticPeers, func(addr string, _ int) Peer {
+		return Peer{
+			Name:   addr,
+			Addr:   addr,
+			Static: true,
+		}
+	})
+	for _, p := range discoveredPeers {
+		if time.Since(p.LastSeen) > peerTTL || slices.Contains(staticPeers, p.Addr) {
+			continue
+		}
+		peers = append(peers, *p)
+	}
+	return peers
+}
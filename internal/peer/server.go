@@ -0,0 +1,109 @@
+package peer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/cache"
+)
+
+// Opener opens a complete cache file for serving
+type Opener func(id string) (*cache.CompleteFile, error)
+
+type Server struct {
+	http.Server
+
+	open Opener
+
+	running bool
+}
+
+type peerInfo struct {
+	Name string `json:"name"`
+}
+
+func NewServer(port int, open Opener) *Server {
+	mux := http.NewServeMux()
+
+	s := &Server{
+		Server: http.Server{
+			Addr:    fmt.Sprintf(":%d", port),
+			Handler: allowListMiddleware(mux),
+		},
+		open: open,
+	}
+
+	mux.HandleFunc("GET /peer/info", s.handleInfo)
+	mux.HandleFunc("GET /peer/cache/{id}", s.handleCache)
+
+	return s
+}
+
+func allowListMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil || !isAllowed(net.ParseIP(host)) {
+			logger.WarnLn("Rejected request from", r.RemoteAddr)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleInfo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(peerInfo{Name: instanceName})
+}
+
+func (s *Server) handleCache(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	f, err := s.open(id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	// the body is the same as the remote file, so its ETag works for If-Range as well
+	if f.ETag != "" && !strings.HasPrefix(f.ETag, "W/") {
+		w.Header().Set("ETag", f.ETag)
+	}
+	w.Header().Set("Content-Type", "video/mp4")
+	if r.Method == http.MethodGet {
+		logger.InfoLn("Serving", id, "to", r.RemoteAddr, r.Header.Get("Range"))
+	}
+	http.ServeContent(w, r, id+".mp4", f.ModTime, f)
+}
+
+func (s *Server) Start() error {
+	s.running = true
+	logger.InfoLn("Starting peer server on port", s.Addr[1:])
+	if err := s.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		logger.ErrorLn("Error starting peer server:", err)
+		s.running = false
+		return err
+	}
+	return nil
+}
+
+func (s *Server) Stop() {
+	if !s.running {
+		return
+	}
+	s.running = false
+
+	shutdownCtx, shutdownRelease := context.WithTimeout(context.Background(), 10*time.Second)
+	defer shutdownRelease()
+
+	if err := s.Shutdown(shutdownCtx); err != nil {
+		logger.ErrorLn("Peer server shutdown error:", err)
+	}
+}
@@ -7,13 +7,6 @@ import (
 	"github.com/wzhqwq/VRCDancePreloader/internal/utils"
 )
 
-const allowListTableSQL = `
-CREATE TABLE IF NOT EXISTS allow_list (
-    		id TEXT PRIMARY KEY,
-    		size INTEGER
-);
-`
-
 type AllowList struct {
 	sync.Mutex
 	Entries map[string]int64
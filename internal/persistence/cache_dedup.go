@@ -0,0 +1,189 @@
+package persistence
+
+import (
+	"time"
+)
+
+// CacheFingerprint identifies the content of a complete cache file.
+// Size, HeadHash and TailHash can be compared with a remote file through range requests,
+// while FullHash confirms that two local files are the same.
+type CacheFingerprint struct {
+	ID       string
+	Size     int64
+	ModTime  time.Time
+	HeadHash string
+	TailHash string
+	FullHash string
+}
+
+const cacheFingerprintColumns = "id, size, mod_time, head_hash, tail_hash, full_hash"
+
+func scanCacheFingerprint(row scanner) (*CacheFingerprint, error) {
+	var fp CacheFingerprint
+	var modTime int64
+	err := row.Scan(&fp.ID, &fp.Size, &modTime, &fp.HeadHash, &fp.TailHash, &fp.FullHash)
+	if err != nil {
+		return nil, err
+	}
+	fp.ModTime = time.Unix(modTime, 0)
+	return &fp, nil
+}
+
+func queryCacheFingerprints(query string, args ...any) []*CacheFingerprint {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		logger.ErrorLn("Failed to load cache fingerprints:", err)
+		return nil
+	}
+	defer rows.Close()
+
+	var fps []*CacheFingerprint
+	for rows.Next() {
+		fp, err := scanCacheFingerprint(rows)
+		if err != nil {
+			logger.ErrorLn("Failed to scan cache fingerprint:", err)
+			continue
+		}
+		fps = append(fps, fp)
+	}
+	return fps
+}
+
+func GetCacheFingerprint(id string) *CacheFingerprint {
+	row := DB.QueryRow("SELECT "+cacheFingerprintColumns+" FROM cache_fingerprint WHERE id = ?", id)
+	fp, err := scanCacheFingerprint(row)
+	if err != nil {
+		return nil
+	}
+	return fp
+}
+
+func SaveCacheFingerprint(fp *CacheFingerprint) {
+	query := `
+INSERT INTO cache_fingerprint (id, size, mod_time, head_hash, tail_hash, full_hash)
+VALUES (?, ?, ?, ?, ?, ?)
+ON CONFLICT(id) DO UPDATE SET
+	size = excluded.size,
+	mod_time = excluded.mod_time,
+	head_hash = excluded.head_hash,
+	tail_hash = excluded.tail_hash,
+	full_hash = excluded.full_hash
+`
+	_, err := DB.Exec(query, fp.ID, fp.Size, fp.ModTime.Unix(), fp.HeadHash, fp.TailHash, fp.FullHash)
+	if err != nil {
+		logger.ErrorLn("Failed to save cache fingerprint:", err)
+	}
+}
+
+func RemoveCacheFingerprint(id string) {
+	_, err := DB.Exec("DELETE FROM cache_fingerprint WHERE id = ?", id)
+	if err != nil {
+		logger.ErrorLn("Failed to remove cache fingerprint:", err)
+	}
+}
+
+// FindCacheFingerprintsBySize returns fingerprints of complete files in this size
+func FindCacheFingerprintsBySize(size int64) []*CacheFingerprint {
+	query := `
+SELECT f.id, f.size, f.mod_time, f.head_hash, f.tail_hash, f.full_hash
+FROM cache_fingerprint f JOIN cache_index i ON f.id = i.id
+WHERE f.size = ? AND i.is_complete
+`
+	return queryCacheFingerprints(query, size)
+}
+
+// ListCacheFingerprints returns all fingerprints with full hash, the oldest file first
+func ListCacheFingerprints() []*CacheFingerprint {
+	return queryCacheFingerprints("SELECT " + cacheFingerprintColumns + " FROM cache_fingerprint WHERE full_hash != '' ORDER BY mod_time")
+}
+
+// ListUnfingerprintedCacheFiles returns ids of complete files whose fingerprint is missing or outdated
+func ListUnfingerprintedCacheFiles() []string {
+	query := `
+SELECT i.id FROM cache_index i LEFT JOIN cache_fingerprint f ON i.id = f.id
+WHERE i.is_complete AND (f.id IS NULL OR f.size != i.full_size OR f.mod_time != i.mod_time)
+`
+	rows, err := DB.Query(query)
+	if err != nil {
+		logger.ErrorLn("Failed to load cache index:", err)
+		return nil
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// GetCacheAlias returns the id whose file is shared with this id, or empty string
+func GetCacheAlias(id string) string {
+	var target string
+	err := DB.QueryRow("SELECT target FROM cache_alias WHERE id = ?", id).Scan(&target)
+	if err != nil {
+		return ""
+	}
+	return target
+}
+
+// GetCacheAliases returns ids that share the file of target
+func GetCacheAliases(target string) []string {
+	rows, err := DB.Query("SELECT id FROM cache_alias WHERE target = ?", target)
+	if err != nil {
+		logger.ErrorLn("Failed to load cache aliases:", err)
+		return nil
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func SaveCacheAlias(id, target string) {
+	query := `
+INSERT INTO cache_alias (id, target, created_at)
+VALUES (?, ?, ?)
+ON CONFLICT(id) DO UPDATE SET
+	target = excluded.target,
+	created_at = excluded.created_at
+`
+	_, err := DB.Exec(query, id, target, time.Now().Unix())
+	if err != nil {
+		logger.ErrorLn("Failed to save cache alias:", err)
+	}
+}
+
+// RetargetCacheAliases moves aliases of from to target
+func RetargetCacheAliases(from, target string) {
+	_, err := DB.Exec("UPDATE cache_alias SET target = ? WHERE target = ?", target, from)
+	if err != nil {
+		logger.ErrorLn("Failed to update cache aliases:", err)
+	}
+}
+
+func RemoveCacheAlias(id string) {
+	_, err := DB.Exec("DELETE FROM cache_alias WHERE id = ?", id)
+	if err != nil {
+		logger.ErrorLn("Failed to remove cache alias:", err)
+	}
+}
+
+// RemoveCacheAliasesTo drops all aliases of target, it's called when the file of target is gone
+func RemoveCacheAliasesTo(target string) {
+	_, err := DB.Exec("DELETE FROM cache_alias WHERE target = ?", target)
+	if err != nil {
+		logger.ErrorLn("Failed to remove cache aliases:", err)
+	}
+}
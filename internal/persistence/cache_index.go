@@ -0,0 +1,174 @@
+package persistence
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/types"
+)
+
+const cacheIndexColumns = "id, format, size, full_size, downloaded, is_complete, mod_time, last_served, serve_count, origin"
+
+// CacheFile is a row of the cache index
+type CacheFile struct {
+	ID         string
+	Format     string
+	Size       int64
+	FullSize   int64
+	Downloaded int64
+	IsComplete bool
+	ModTime    time.Time
+	LastServed time.Time
+	ServeCount int
+	Origin     string
+}
+
+func (f *CacheFile) ToInfo() types.CacheFileInfo {
+	return types.CacheFileInfo{
+		ID:   f.ID,
+		Size: f.Size,
+
+		Format:     f.Format,
+		FullSize:   f.FullSize,
+		Downloaded: f.Downloaded,
+		IsComplete: f.IsComplete,
+		ModTime:    f.ModTime,
+		LastServed: f.LastServed,
+		ServeCount: f.ServeCount,
+		Origin:     f.Origin,
+
+		IsPartial: !f.IsComplete,
+	}
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanCacheFile(row scanner) (*CacheFile, error) {
+	var f CacheFile
+	var modTime, lastServed int64
+	err := row.Scan(
+		&f.ID, &f.Format, &f.Size, &f.FullSize, &f.Downloaded, &f.IsComplete,
+		&modTime, &lastServed, &f.ServeCount, &f.Origin,
+	)
+	if err != nil {
+		return nil, err
+	}
+	f.ModTime = time.Unix(modTime, 0)
+	if lastServed > 0 {
+		f.LastServed = time.Unix(lastServed, 0)
+	}
+	return &f, nil
+}
+
+// SaveCacheFile creates or updates the file part of the index, while the serving records are kept.
+// The full size and the origin are only updated when they are provided.
+func SaveCacheFile(f *CacheFile) {
+	query := `
+INSERT INTO cache_index (id, format, size, full_size, downloaded, is_complete, mod_time, origin)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(id) DO UPDATE SET
+	format = excluded.format,
+	size = excluded.size,
+	full_size = CASE WHEN excluded.full_size = 0 THEN full_size ELSE excluded.full_size END,
+	downloaded = excluded.downloaded,
+	is_complete = excluded.is_complete,
+	mod_time = excluded.mod_time,
+	origin = CASE WHEN excluded.origin = '' THEN origin ELSE excluded.origin END
+`
+	_, err := DB.Exec(query, f.ID, f.Format, f.Size, f.FullSize, f.Downloaded, f.IsComplete, f.ModTime.Unix(), f.Origin)
+	if err != nil {
+		logger.ErrorLn("Failed to save cache index:", err)
+	}
+}
+
+// RemoveCacheFile removes the file from the index along with its fingerprint
+func RemoveCacheFile(id string) {
+	_, err := DB.Exec("DELETE FROM cache_index WHERE id = ?", id)
+	if err != nil {
+		logger.ErrorLn("Failed to remove cache index:", err)
+	}
+	RemoveCacheFingerprint(id)
+}
+
+// MarkCacheServed records a serve, the row is created if the file is not indexed yet and filled by SaveCacheFile later
+func MarkCacheServed(id string) {
+	query := `
+INSERT INTO cache_index (id, format, size, full_size, downloaded, is_complete, mod_time, last_served, serve_count)
+VALUES (?, '', 0, 0, 0, FALSE, 0, ?, 1)
+ON CONFLICT(id) DO UPDATE SET
+	last_served = excluded.last_served,
+	serve_count = serve_count + 1
+`
+	_, err := DB.Exec(query, id, time.Now().Unix())
+	if err != nil {
+		logger.ErrorLn("Failed to update cache index:", err)
+	}
+}
+
+func GetCacheFile(id string) (*CacheFile, error) {
+	row := DB.QueryRow("SELECT "+cacheIndexColumns+" FROM cache_index WHERE id = ?", id)
+	f, err := scanCacheFile(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("cache file not found")
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// ListCacheFiles returns all indexed cache files, the largest first
+func ListCacheFiles() []*CacheFile {
+	rows, err := DB.Query("SELECT " + cacheIndexColumns + " FROM cache_index ORDER BY size DESC")
+	if err != nil {
+		logger.ErrorLn("Failed to load cache index:", err)
+		return nil
+	}
+	defer rows.Close()
+
+	var files []*CacheFile
+	for rows.Next() {
+		f, err := scanCacheFile(rows)
+		if err != nil {
+			logger.ErrorLn("Failed to scan cache index:", err)
+			continue
+		}
+		files = append(files, f)
+	}
+
+	return files
+}
+
+func ListCacheFileIds() map[string]struct{} {
+	ids := make(map[string]struct{})
+
+	rows, err := DB.Query("SELECT id FROM cache_index")
+	if err != nil {
+		logger.ErrorLn("Failed to load cache index:", err)
+		return ids
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			continue
+		}
+		ids[id] = struct{}{}
+	}
+
+	return ids
+}
+
+func GetCacheTotalSize() int64 {
+	var total sql.NullInt64
+	err := DB.QueryRow("SELECT SUM(size) FROM cache_index").Scan(&total)
+	if err != nil {
+		logger.ErrorLn("Failed to sum cache size:", err)
+		return 0
+	}
+	return total.Int64
+}
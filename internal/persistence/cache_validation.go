@@ -0,0 +1,95 @@
+package persistence
+
+import (
+	"time"
+)
+
+// results of revalidation
+const (
+	// the server responded 304
+	RevalidationNotModified = "not_modified"
+	// the server responded the full file with the same validators
+	RevalidationUnchanged = "unchanged"
+	// the remote file changed and the cache is dropped
+	RevalidationModified = "modified"
+	RevalidationFailed   = "failed"
+)
+
+// CacheValidation keeps the validators of the remote file that a cache file is downloaded from
+type CacheValidation struct {
+	ID   string
+	ETag string
+	// the raw Last-Modified header, so that it can be sent back as it is
+	LastModified string
+	FullSize     int64
+
+	CheckedAt        time.Time
+	Result           string
+	CheckCount       int
+	NotModifiedCount int
+}
+
+func (v *CacheValidation) HasValidator() bool {
+	return v.FullSize > 0 && (v.ETag != "" || v.LastModified != "")
+}
+
+func GetCacheValidation(id string) *CacheValidation {
+	query := "SELECT id, etag, last_modified, full_size, checked_at, result, check_count, not_modified_count FROM cache_validation WHERE id = ?"
+
+	var v CacheValidation
+	var checkedAt int64
+	err := DB.QueryRow(query, id).Scan(
+		&v.ID, &v.ETag, &v.LastModified, &v.FullSize, &checkedAt, &v.Result, &v.CheckCount, &v.NotModifiedCount,
+	)
+	if err != nil {
+		return nil
+	}
+	if checkedAt > 0 {
+		v.CheckedAt = time.Unix(checkedAt, 0)
+	}
+	return &v
+}
+
+// SaveCacheValidators records the validators responded by the server
+func SaveCacheValidators(id, etag, lastModified string, fullSize int64) {
+	query := `
+INSERT INTO cache_validation (id, etag, last_modified, full_size)
+VALUES (?, ?, ?, ?)
+ON CONFLICT(id) DO UPDATE SET
+	etag = excluded.etag,
+	last_modified = excluded.last_modified,
+	full_size = excluded.full_size
+`
+	_, err := DB.Exec(query, id, etag, lastModified, fullSize)
+	if err != nil {
+		logger.ErrorLn("Failed to save cache validators:", err)
+	}
+}
+
+func RecordRevalidation(id, result string) {
+	notModified := 0
+	if result == RevalidationNotModified {
+		notModified = 1
+	}
+
+	query := `
+INSERT INTO cache_validation (id, checked_at, result, check_count, not_modified_count)
+VALUES (?, ?, ?, 1, ?)
+ON CONFLICT(id) DO UPDATE SET
+	checked_at = excluded.checked_at,
+	result = excluded.result,
+	check_count = check_count + 1,
+	not_modified_count = not_modified_count + excluded.not_modified_count
+`
+	_, err := DB.Exec(query, id, time.Now().Unix(), result, notModified)
+	if err != nil {
+		logger.ErrorLn("Failed to record revalidation:", err)
+	}
+}
+
+func RemoveCacheValidation(id string) {
+	_, err := DB.Exec("DELETE FROM cache_validation WHERE id = ?", id)
+	if err != nil {
+		logger.ErrorLn("Failed to remove cache validation:", err)
+	}
+}
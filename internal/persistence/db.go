@@ -2,6 +2,7 @@ package persistence
 
 import (
 	"database/sql"
+	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/wzhqwq/VRCDancePreloader/internal/utils"
@@ -18,29 +19,11 @@ func InitDB(dbFilePath string) error {
 		return err
 	}
 
-	_, err = DB.Exec(allowListTableSQL)
-	if err != nil {
-		return err
+	backupPath := dbFilePath
+	if dbFilePath == ":memory:" || strings.HasPrefix(dbFilePath, "file:") {
+		backupPath = ""
 	}
-
-	_, err = DB.Exec(danceRecordTableSQL)
-	if err != nil {
-		return err
-	}
-
-	_, err = DB.Exec(localSongTableSQL)
-	if err != nil {
-		return err
-	}
-	for _, query := range localSongTableIndicesSQLs {
-		_, err = DB.Exec(query)
-		if err != nil {
-			return err
-		}
-	}
-
-	_, err = DB.Exec(worldDataTableSQL)
-	if err != nil {
+	if err = Migrate(DB, backupPath, migrations); err != nil {
 		return err
 	}
 
@@ -11,26 +11,6 @@ import (
 
 var currentLocalSongs *LocalSongs
 
-const localSongTableSQL = `
-CREATE TABLE IF NOT EXISTS local_song (
-    	id TEXT PRIMARY KEY,
-    	title TEXT,
-    	
-    	like INTEGER,
-    	skill INTEGER,
-                                    
-    	is_favorite BOOLEAN,
-        sync_in_game BOOLEAN
-);
-`
-
-var localSongTableIndicesSQLs = []string{
-	"CREATE INDEX IF NOT EXISTS idx_local_song_is_favorite ON local_song (is_favorite)",
-	"CREATE INDEX IF NOT EXISTS idx_local_song_like ON local_song (like)",
-	"CREATE INDEX IF NOT EXISTS idx_local_song_skill ON local_song (skill)",
-	"CREATE INDEX IF NOT EXISTS idx_local_song_sync_in_game ON local_song (sync_in_game)",
-}
-
 type LocalSongs struct {
 	sync.Mutex
 	FavoriteMap map[string]struct{}
@@ -0,0 +1,342 @@
+package persistence
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+)
+
+// The schema is changed only by migrations, which are applied in the order of their versions and recorded in
+// schema_version. Each migration runs in its own transaction, so a failed one leaves the database at the previous
+// version. The database is copied aside before any pending migration is applied.
+//
+// Never edit a migration after it's released, add a new one instead.
+
+const schemaVersionTableSQL = `
+CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		name TEXT,
+		applied_at INTEGER
+);
+`
+
+// Migration changes the schema from Version-1 to Version, by SQL statements followed by Up if they're given
+type Migration struct {
+	Version int
+	Name    string
+	SQL     []string
+	Up      func(tx *sql.Tx) error
+}
+
+var ErrMigrationOrder = errors.New("migrations are not ordered by version")
+
+// the SQL of a migration is written out here rather than shared with the code, so it stays as it was released
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial schema",
+		// the tables created before migrations are introduced, they may already exist
+		SQL: []string{
+			`
+CREATE TABLE IF NOT EXISTS allow_list (
+		id TEXT PRIMARY KEY,
+		size INTEGER
+);
+`,
+			`
+CREATE TABLE IF NOT EXISTS dance_record (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		start_time INTEGER,
+		comment TEXT,
+		orders TEXT
+);
+`,
+			`
+CREATE TABLE IF NOT EXISTS local_song (
+		id TEXT PRIMARY KEY,
+		title TEXT,
+		like INTEGER,
+		skill INTEGER,
+		is_favorite BOOLEAN,
+		sync_in_game BOOLEAN
+);
+`,
+			"CREATE INDEX IF NOT EXISTS idx_local_song_is_favorite ON local_song (is_favorite)",
+			"CREATE INDEX IF NOT EXISTS idx_local_song_like ON local_song (like)",
+			"CREATE INDEX IF NOT EXISTS idx_local_song_skill ON local_song (skill)",
+			"CREATE INDEX IF NOT EXISTS idx_local_song_sync_in_game ON local_song (sync_in_game)",
+			`
+CREATE TABLE IF NOT EXISTS world_data (
+		world TEXT PRIMARY KEY,
+		data TEXT,
+		settings TEXT
+);
+`,
+			`
+CREATE TABLE IF NOT EXISTS cache_index (
+		id TEXT PRIMARY KEY,
+		format TEXT,
+		size INTEGER,
+		full_size INTEGER,
+		downloaded INTEGER,
+		is_complete BOOLEAN,
+		mod_time INTEGER,
+		last_served INTEGER DEFAULT 0,
+		serve_count INTEGER DEFAULT 0,
+		origin TEXT DEFAULT ''
+);
+`,
+			"CREATE INDEX IF NOT EXISTS idx_cache_index_size ON cache_index (size)",
+			"CREATE INDEX IF NOT EXISTS idx_cache_index_last_served ON cache_index (last_served)",
+			`
+CREATE TABLE IF NOT EXISTS cache_validation (
+		id TEXT PRIMARY KEY,
+		etag TEXT DEFAULT '',
+		last_modified TEXT DEFAULT '',
+		full_size INTEGER DEFAULT 0,
+		checked_at INTEGER DEFAULT 0,
+		result TEXT DEFAULT '',
+		check_count INTEGER DEFAULT 0,
+		not_modified_count INTEGER DEFAULT 0
+);
+`,
+			`
+CREATE TABLE IF NOT EXISTS cache_fingerprint (
+		id TEXT PRIMARY KEY,
+		size INTEGER,
+		mod_time INTEGER,
+		head_hash TEXT,
+		tail_hash TEXT,
+		full_hash TEXT DEFAULT ''
+);
+`,
+			"CREATE INDEX IF NOT EXISTS idx_cache_fingerprint_size ON cache_fingerprint (size)",
+			`
+CREATE TABLE IF NOT EXISTS cache_alias (
+		id TEXT PRIMARY KEY,
+		target TEXT NOT NULL,
+		created_at INTEGER
+);
+`,
+			"CREATE INDEX IF NOT EXISTS idx_cache_alias_target ON cache_alias (target)",
+			`
+CREATE TABLE IF NOT EXISTS request_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		time INTEGER,
+		platform TEXT,
+		video_id TEXT,
+		range_header TEXT DEFAULT '',
+		client TEXT DEFAULT '',
+		user_agent TEXT DEFAULT '',
+		cache_state TEXT,
+		cached_size INTEGER DEFAULT 0,
+		status INTEGER DEFAULT 0,
+		bytes_served INTEGER DEFAULT 0,
+		ttfb INTEGER DEFAULT 0,
+		duration INTEGER DEFAULT 0,
+		fallback TEXT DEFAULT ''
+);
+`,
+			"CREATE INDEX IF NOT EXISTS idx_request_log_time ON request_log (time)",
+			"CREATE INDEX IF NOT EXISTS idx_request_log_video_id ON request_log (video_id)",
+		},
+	},
+	{
+		Version: 2,
+		Name:    "dance order table",
+		SQL: []string{
+			`
+CREATE TABLE IF NOT EXISTS dance_order (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		record_id INTEGER NOT NULL,
+		song_id TEXT NOT NULL,
+		title TEXT DEFAULT '',
+		adder TEXT DEFAULT '',
+		time INTEGER NOT NULL,
+		room TEXT DEFAULT '',
+		world TEXT DEFAULT '',
+		instance TEXT DEFAULT ''
+);
+`,
+			"CREATE INDEX IF NOT EXISTS idx_dance_order_record_id ON dance_order (record_id, time)",
+			"CREATE INDEX IF NOT EXISTS idx_dance_order_song_id ON dance_order (song_id)",
+			"CREATE INDEX IF NOT EXISTS idx_dance_order_time ON dance_order (time)",
+			"CREATE INDEX IF NOT EXISTS idx_dance_order_adder ON dance_order (adder)",
+		},
+		Up: migrateOrderBlobs,
+	},
+}
+
+// v1Order is an order saved as JSON in dance_record before v2
+type v1Order struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	Username  string    `json:"username"`
+	Time      time.Time `json:"time"`
+	DanceRoom string    `json:"dance_room"`
+}
+
+// migrateOrderBlobs moves the orders saved as JSON in dance_record into dance_order
+func migrateOrderBlobs(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT id, orders FROM dance_record")
+	if err != nil {
+		return err
+	}
+	blobs := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var data sql.NullString
+		if err := rows.Scan(&id, &data); err != nil {
+			rows.Close()
+			return err
+		}
+		blobs[id] = data.String
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, data := range blobs {
+		if data == "" {
+			continue
+		}
+		var orders []v1Order
+		if err := json.Unmarshal([]byte(data), &orders); err != nil {
+			// the record is kept without orders rather than blocking the migration
+			logger.WarnLnf("Dropped malformed orders of dance record %d: %v", id, err)
+			continue
+		}
+		for _, order := range orders {
+			_, err := tx.Exec(
+				"INSERT INTO dance_order (record_id, song_id, title, adder, time, room) VALUES (?, ?, ?, ?, ?, ?)",
+				id, order.ID, order.Title, order.Username, order.Time.Unix(), order.DanceRoom,
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = tx.Exec("ALTER TABLE dance_record DROP COLUMN orders")
+	return err
+}
+
+// LatestSchemaVersion is the version of the schema the code works with
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// GetSchemaVersion returns the version of the schema in db, 0 if no migration is applied
+func GetSchemaVersion(db *sql.DB) (int, error) {
+	var tables int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_version'").Scan(&tables)
+	if err != nil || tables == 0 {
+		return 0, err
+	}
+	var version sql.NullInt64
+	if err := db.QueryRow("SELECT MAX(version) FROM schema_version").Scan(&version); err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+// hasUserTables tells whether db is created by an earlier version, rather than an empty file
+func hasUserTables(db *sql.DB) (bool, error) {
+	var count int
+	err := db.QueryRow(
+		"SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'",
+	).Scan(&count)
+	return count > 0, err
+}
+
+// backupDB copies db into a file next to dbFilePath before migrating from version
+func backupDB(db *sql.DB, dbFilePath string, version int) (string, error) {
+	backupPath := fmt.Sprintf("%s.v%d.bak", dbFilePath, version)
+	if err := os.Remove(backupPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	if _, err := db.Exec("VACUUM INTO ?", backupPath); err != nil {
+		return "", err
+	}
+	return backupPath, nil
+}
+
+func applyMigration(db *sql.DB, m Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, query := range m.SQL {
+		if _, err := tx.Exec(query); err != nil {
+			return err
+		}
+	}
+	if m.Up != nil {
+		if err := m.Up(tx); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(
+		"INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)",
+		m.Version, m.Name, time.Now().Unix(),
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Migrate applies the pending ones of ms to db, which is opened from dbFilePath (empty for no backup)
+func Migrate(db *sql.DB, dbFilePath string, ms []Migration) error {
+	for i := 1; i < len(ms); i++ {
+		if ms[i].Version <= ms[i-1].Version {
+			return ErrMigrationOrder
+		}
+	}
+
+	current, err := GetSchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if len(ms) > 0 && current > ms[len(ms)-1].Version {
+		logger.WarnLnf("Database is at version %d, newer than this program (%d), it may not work properly", current, ms[len(ms)-1].Version)
+	}
+	pending := slices.DeleteFunc(slices.Clone(ms), func(m Migration) bool {
+		return m.Version <= current
+	})
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if dbFilePath != "" {
+		existing, err := hasUserTables(db)
+		if err != nil {
+			return err
+		}
+		if existing {
+			backupPath, err := backupDB(db, dbFilePath, current)
+			if err != nil {
+				return fmt.Errorf("failed to back up database before migration: %w", err)
+			}
+			logger.InfoLn("Backed up database to", backupPath)
+		}
+	}
+
+	if _, err := db.Exec(schemaVersionTableSQL); err != nil {
+		return err
+	}
+	for _, m := range pending {
+		logger.InfoLnf("Migrating database to version %d: %s", m.Version, m.Name)
+		if err := applyMigration(db, m); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
@@ -8,14 +8,6 @@ import (
 
 var localWorlds *LocalWorlds
 
-const worldDataTableSQL = `
-CREATE TABLE IF NOT EXISTS world_data (
-		world TEXT PRIMARY KEY,
-		data TEXT,
-    	settings TEXT
-);
-`
-
 type WorldData struct {
 	sync.Mutex
 
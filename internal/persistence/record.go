@@ -2,27 +2,79 @@ package persistence
 
 import (
 	"database/sql"
-	"encoding/json"
 	"fmt"
 	"math"
 	"strconv"
 	"sync"
 	"time"
 
+	"github.com/samber/lo"
 	"github.com/wzhqwq/VRCDancePreloader/internal/utils"
 )
 
 var localRecords *LocalRecords
 var currentRoomName string
+var currentWorld string
+var currentInstance string
 
-const danceRecordTableSQL = `
-CREATE TABLE IF NOT EXISTS dance_record (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		start_time INTEGER,
-		comment TEXT,
-		orders TEXT
-);
-`
+const danceOrderColumns = "song_id, title, adder, time, room, world, instance"
+
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+func insertOrder(db execer, recordID int, order Order) error {
+	_, err := db.Exec(
+		"INSERT INTO dance_order (record_id, "+danceOrderColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		recordID, order.ID, order.Title, order.Username, order.Time.Unix(), order.DanceRoom, order.World, order.Instance,
+	)
+	return err
+}
+
+// scanOrder reads the columns of danceOrderColumns, the columns selected before them are scanned into dest
+func scanOrder(row scanner, dest ...any) (Order, error) {
+	var order Order
+	var t int64
+	err := row.Scan(append(dest, &order.ID, &order.Title, &order.Username, &t, &order.DanceRoom, &order.World, &order.Instance)...)
+	order.Time = time.Unix(t, 0)
+	return order, err
+}
+
+// loadOrders reads the orders of records, ordered by time
+func loadOrders(records []*DanceRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	byID := make(map[int]*DanceRecord, len(records))
+	for _, r := range records {
+		r.Orders = make([]Order, 0)
+		byID[r.ID] = r
+	}
+
+	query := "SELECT record_id, " + danceOrderColumns + " FROM dance_order"
+	var args []any
+	if len(records) == 1 {
+		query += " WHERE record_id = ?"
+		args = append(args, records[0].ID)
+	}
+	rows, err := DB.Query(query+" ORDER BY record_id, time, id", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var recordID int
+		order, err := scanOrder(rows, &recordID)
+		if err != nil {
+			return err
+		}
+		if r, ok := byID[recordID]; ok {
+			r.Orders = append(r.Orders, order)
+		}
+	}
+	return rows.Err()
+}
 
 type DanceRecord struct {
 	ID        int
@@ -54,26 +106,28 @@ func NewDanceRecord() *DanceRecord {
 	}
 }
 
+// NewDanceRecordFromScan reads a record without orders, they're loaded by loadOrders
 func NewDanceRecordFromScan(rows *sql.Rows) (*DanceRecord, error) {
 	record := NewEmptyDanceRecord()
-	var orders string
 	var startTimeInt int64
-	if err := rows.Scan(&record.ID, &startTimeInt, &record.Comment, &orders); err != nil {
+	if err := rows.Scan(&record.ID, &startTimeInt, &record.Comment); err != nil {
 		return nil, err
 	}
 	record.StartTime = time.Unix(startTimeInt, 0)
-	err := json.Unmarshal([]byte(orders), &record.Orders)
-	if err != nil {
-		logger.ErrorLn("Error unmarshalling dance record: ", err)
-	}
 	return record, nil
 }
 
+// AddOrder appends the order and saves it. The same song ordered again within a minute is taken as a duplicate,
+// it's dropped without notifying the subscribers since the orders are unchanged
 func (r *DanceRecord) AddOrder(order Order) {
-	r.doAdd(order)
+	if !r.doAdd(order) {
+		return
+	}
 	r.em.NotifySubscribers("+" + order.ID)
 	if r.ID != -1 {
-		r.updateOrders()
+		if err := insertOrder(DB, r.ID, order); err != nil {
+			logger.ErrorLn("Failed to save order:", err)
+		}
 	} else {
 		err := localRecords.addRecord(r)
 		if err != nil {
@@ -82,25 +136,26 @@ func (r *DanceRecord) AddOrder(order Order) {
 	}
 }
 
-func (r *DanceRecord) doAdd(order Order) {
+func (r *DanceRecord) doAdd(order Order) bool {
 	r.ordersMutex.Lock()
 	defer r.ordersMutex.Unlock()
 
 	if len(r.Orders) > 0 {
 		lastOrder := r.Orders[len(r.Orders)-1]
 		if lastOrder.ID == order.ID && math.Abs(float64(lastOrder.Time.Unix()-order.Time.Unix())) < 60 {
-			return
+			return false
 		}
 	}
 
 	r.Orders = append(r.Orders, order)
+	return true
 }
 
 func (r *DanceRecord) RemoveOrder(orderTime time.Time) {
 	if id := r.doRemove(orderTime); id != "" {
 		r.em.NotifySubscribers("-" + id)
 		if r.ID != -1 {
-			r.updateOrders()
+			r.deleteOrder(id, orderTime)
 		}
 	}
 }
@@ -142,13 +197,14 @@ func (r *DanceRecord) SetComment(comment string) {
 	}
 }
 
-func (r *DanceRecord) updateOrders() {
-	data, err := json.Marshal(r.GetOrdersSnapshot())
+func (r *DanceRecord) deleteOrder(id string, orderTime time.Time) {
+	_, err := DB.Exec(
+		"DELETE FROM dance_order WHERE id IN (SELECT id FROM dance_order WHERE record_id = ? AND song_id = ? AND time = ? LIMIT 1)",
+		r.ID, id, orderTime.Unix(),
+	)
 	if err != nil {
-		return
+		logger.ErrorLn("Failed to delete order:", err)
 	}
-
-	_, err = DB.Exec("UPDATE dance_record SET orders = ? WHERE id = ?", string(data), r.ID)
 }
 
 func (r *DanceRecord) updateComment() {
@@ -168,6 +224,9 @@ type Order struct {
 	Username  string    `json:"username"`
 	Time      time.Time `json:"time"`
 	DanceRoom string    `json:"dance_room"`
+	// e.g. wrld_f20326da-f1ac-45fc-a062-609723b097b1 and 29406~region(jp)
+	World    string `json:"world"`
+	Instance string `json:"instance"`
 }
 
 func (o Order) Key() string {
@@ -184,6 +243,8 @@ func AddToHistory(id, title, username string, time time.Time) {
 		Username:  username,
 		Time:      time,
 		DanceRoom: currentRoomName,
+		World:     currentWorld,
+		Instance:  currentInstance,
 	})
 }
 
@@ -216,68 +277,97 @@ func (l *LocalRecords) ReplaceIfExists(record *DanceRecord) *DanceRecord {
 }
 
 func (l *LocalRecords) GetRecords() ([]*DanceRecord, error) {
-	rows, err := DB.Query("SELECT id, start_time, comment, orders FROM dance_record ORDER BY start_time DESC")
+	records, err := queryRecords("SELECT id, start_time, comment FROM dance_record ORDER BY start_time DESC")
+	if err != nil {
+		return nil, err
+	}
+	return lo.Map(records, func(record *DanceRecord, _ int) *DanceRecord {
+		return l.ReplaceIfExists(record)
+	}), nil
+}
+
+// queryRecords reads the records selected by query along with their orders
+func queryRecords(query string, args ...any) ([]*DanceRecord, error) {
+	rows, err := DB.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
 	var records []*DanceRecord
 	for rows.Next() {
 		record, err := NewDanceRecordFromScan(rows)
 		if err != nil {
+			rows.Close()
 			return nil, err
 		}
-
-		records = append(records, l.ReplaceIfExists(record))
+		records = append(records, record)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
+	if err := loadOrders(records); err != nil {
+		return nil, err
+	}
 	return records, nil
 }
 
 func (l *LocalRecords) GetRecord(id int) (*DanceRecord, error) {
-	rows, err := DB.Query("SELECT id, start_time, comment, orders FROM dance_record WHERE id = ?", id)
+	records, err := queryRecords("SELECT id, start_time, comment FROM dance_record WHERE id = ?", id)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	if rows.Next() {
-		record, err := NewDanceRecordFromScan(rows)
-		if err != nil {
-			return nil, err
-		}
-		return l.ReplaceIfExists(record), nil
+	if len(records) > 0 {
+		return l.ReplaceIfExists(records[0]), nil
 	}
 
 	return nil, fmt.Errorf("record not found")
 }
 
 func (l *LocalRecords) DeleteRecord(id int) error {
-	_, err := DB.Exec("DELETE FROM dance_record WHERE id = ?", id)
+	tx, err := DB.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM dance_order WHERE record_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM dance_record WHERE id = ?", id); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
 	l.em.NotifySubscribers("-" + strconv.Itoa(id))
 	return nil
 }
 
 func (l *LocalRecords) addRecord(r *DanceRecord) error {
-	data, err := json.Marshal(r.GetOrdersSnapshot())
+	tx, err := DB.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	sql := "INSERT INTO dance_record (start_time, comment, orders) VALUES (?, ?, ?)"
-	result, err := DB.Exec(sql, r.StartTime.Unix(), r.Comment, string(data))
+	result, err := tx.Exec("INSERT INTO dance_record (start_time, comment) VALUES (?, ?)", r.StartTime.Unix(), r.Comment)
 	if err != nil {
 		return err
 	}
-
 	id, err := result.LastInsertId()
 	if err != nil {
 		return err
 	}
+	for _, order := range r.GetOrdersSnapshot() {
+		if err := insertOrder(tx, int(id), order); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
 
 	r.ID = int(id)
 	l.em.NotifySubscribers("+" + strconv.Itoa(r.ID))
@@ -287,24 +377,116 @@ func (l *LocalRecords) addRecord(r *DanceRecord) error {
 	return nil
 }
 
-func (l *LocalRecords) SubscribeEvent() *utils.EventSubscriber[string] {
-	return l.em.SubscribeEvent()
+// CountPlays counts how many times each song appears in all dance records
+func (l *LocalRecords) CountPlays() (map[string]int, error) {
+	rows, err := DB.Query("SELECT song_id, COUNT(*) FROM dance_order GROUP BY song_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var id string
+		var count int
+		if err := rows.Scan(&id, &count); err != nil {
+			return nil, err
+		}
+		counts[id] = count
+	}
+
+	return counts, rows.Err()
 }
 
-func (l *LocalRecords) getLatestRecord() (*DanceRecord, error) {
-	// get the latest record, which has the highest start_time
-	rows, err := DB.Query("SELECT id, start_time, comment, orders FROM dance_record ORDER BY start_time DESC LIMIT 1")
+// CountPlaysOf counts how many times the song appears in all dance records
+func (l *LocalRecords) CountPlaysOf(id string) (int, error) {
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM dance_order WHERE song_id = ?", id).Scan(&count)
+	return count, err
+}
+
+// RecordedOrder is an order along with the dance record it belongs to
+type RecordedOrder struct {
+	RecordID int
+	Order
+}
+
+// GetOrdersBetween lists the orders in [from, to) of all dance records, ordered by time
+func (l *LocalRecords) GetOrdersBetween(from, to time.Time) ([]RecordedOrder, error) {
+	rows, err := DB.Query(
+		"SELECT record_id, "+danceOrderColumns+" FROM dance_order WHERE time >= ? AND time < ? ORDER BY time, id",
+		from.Unix(), to.Unix(),
+	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	if rows.Next() {
-		record, err := NewDanceRecordFromScan(rows)
+	var orders []RecordedOrder
+	for rows.Next() {
+		var recordID int
+		order, err := scanOrder(rows, &recordID)
 		if err != nil {
 			return nil, err
 		}
-		return l.ReplaceIfExists(record), nil
+		orders = append(orders, RecordedOrder{RecordID: recordID, Order: order})
+	}
+	return orders, rows.Err()
+}
+
+// GetSongsFirstPlayedBetween lists the songs whose first order in all dance records is in [from, to)
+func (l *LocalRecords) GetSongsFirstPlayedBetween(from, to time.Time) ([]string, error) {
+	rows, err := DB.Query(
+		"SELECT song_id FROM dance_order GROUP BY song_id HAVING MIN(time) >= ? AND MIN(time) < ? ORDER BY MIN(time)",
+		from.Unix(), to.Unix(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetOrdersOf lists every order of the song in all dance records, the latest first
+func (l *LocalRecords) GetOrdersOf(id string) ([]Order, error) {
+	rows, err := DB.Query("SELECT "+danceOrderColumns+" FROM dance_order WHERE song_id = ? ORDER BY time DESC", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orders []Order
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+	return orders, rows.Err()
+}
+
+func (l *LocalRecords) SubscribeEvent() *utils.EventSubscriber[string] {
+	return l.em.SubscribeEvent()
+}
+
+func (l *LocalRecords) getLatestRecord() (*DanceRecord, error) {
+	// get the latest record, which has the highest start_time
+	records, err := queryRecords("SELECT id, start_time, comment FROM dance_record ORDER BY start_time DESC LIMIT 1")
+	if err != nil {
+		return nil, err
+	}
+	if len(records) > 0 {
+		return l.ReplaceIfExists(records[0]), nil
 	}
 
 	return nil, nil
@@ -317,6 +499,9 @@ func (l *LocalRecords) GetNearestRecord() *DanceRecord {
 	}
 
 	orders := latestRecord.GetOrdersSnapshot()
+	if len(orders) == 0 {
+		return nil
+	}
 	lastOrder := orders[len(orders)-1]
 	if time.Now().Unix()-lastOrder.Time.Unix() > 30*60 {
 		return nil
@@ -359,3 +544,9 @@ func GetLocalRecords() *LocalRecords {
 func SetCurrentRoomName(roomName string) {
 	currentRoomName = roomName
 }
+
+// SetCurrentInstance records the world and the instance that later orders are danced in
+func SetCurrentInstance(world, instance string) {
+	currentWorld = world
+	currentInstance = instance
+}
@@ -0,0 +1,66 @@
+package persistence
+
+import (
+	"time"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/types"
+)
+
+// AddRequestLog persists a finished intercepted request
+func AddRequestLog(r *types.InterceptedRequest) {
+	query := `
+INSERT INTO request_log (
+	time, platform, video_id, range_header, client, user_agent,
+	cache_state, cached_size, status, bytes_served, ttfb, duration, fallback
+) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+`
+	_, err := DB.Exec(
+		query,
+		r.Time.UnixMilli(), r.Platform, r.VideoID, r.Range, r.Client, r.UserAgent,
+		r.CacheState, r.CachedSize, r.Status, r.BytesServed, r.TTFB, r.Duration, r.Fallback,
+	)
+	if err != nil {
+		logger.ErrorLn("Failed to save request log:", err)
+	}
+}
+
+// ListRequestLogs returns the latest persisted requests, the latest goes first
+func ListRequestLogs(limit int) []types.InterceptedRequest {
+	query := `
+SELECT id, time, platform, video_id, range_header, client, user_agent,
+	cache_state, cached_size, status, bytes_served, ttfb, duration, fallback
+FROM request_log ORDER BY time DESC, id DESC LIMIT ?
+`
+	rows, err := DB.Query(query, limit)
+	if err != nil {
+		logger.ErrorLn("Failed to load request logs:", err)
+		return nil
+	}
+	defer rows.Close()
+
+	var logs []types.InterceptedRequest
+	for rows.Next() {
+		var r types.InterceptedRequest
+		var t int64
+		err := rows.Scan(
+			&r.ID, &t, &r.Platform, &r.VideoID, &r.Range, &r.Client, &r.UserAgent,
+			&r.CacheState, &r.CachedSize, &r.Status, &r.BytesServed, &r.TTFB, &r.Duration, &r.Fallback,
+		)
+		if err != nil {
+			logger.ErrorLn("Failed to load request log:", err)
+			continue
+		}
+		r.Time = time.UnixMilli(t)
+		r.Finished = true
+		logs = append(logs, r)
+	}
+
+	return logs
+}
+
+func ClearRequestLogs() {
+	_, err := DB.Exec("DELETE FROM request_log")
+	if err != nil {
+		logger.ErrorLn("Failed to clear request logs:", err)
+	}
+}
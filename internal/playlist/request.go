@@ -17,6 +17,9 @@ func SetAsyncDownload(async bool) {
 }
 
 func (pl *PlayList) FindPyPySong(id int) *song.PreloadedSong {
+	if pl == nil {
+		return nil
+	}
 	items := pl.GetItemsSnapshot()
 	for _, item := range items {
 		if item.MatchWithPyPyId(id) {
@@ -27,6 +30,9 @@ func (pl *PlayList) FindPyPySong(id int) *song.PreloadedSong {
 }
 
 func (pl *PlayList) FindWannaSong(id int) *song.PreloadedSong {
+	if pl == nil {
+		return nil
+	}
 	items := pl.GetItemsSnapshot()
 	for _, item := range items {
 		if item.MatchWithWannaId(id) {
@@ -37,6 +43,9 @@ func (pl *PlayList) FindWannaSong(id int) *song.PreloadedSong {
 }
 
 func (pl *PlayList) FindDuDuSong(id int) *song.PreloadedSong {
+	if pl == nil {
+		return nil
+	}
 	items := pl.GetItemsSnapshot()
 	for _, item := range items {
 		if item.MatchWithDuDuId(id) {
@@ -47,6 +56,9 @@ func (pl *PlayList) FindDuDuSong(id int) *song.PreloadedSong {
 }
 
 func (pl *PlayList) FindCustomSong(url string) *song.PreloadedSong {
+	if pl == nil {
+		return nil
+	}
 	items := pl.GetItemsSnapshot()
 	for _, item := range items {
 		if item.MatchWithCustomUrl(url) {
@@ -56,7 +68,10 @@ func (pl *PlayList) FindCustomSong(url string) *song.PreloadedSong {
 	return nil
 }
 
+// Request finds the song in the current playlist, or downloads it as a temporary song if it's not queued (e.g.
+// requested by other devices in LAN, or no room is entered yet)
 func Request(platform, id string, ctx context.Context) (cache.Entry, error) {
+	pl := currentPlaylist
 	var url string
 
 	switch platform {
@@ -66,7 +81,7 @@ func Request(platform, id string, ctx context.Context) (cache.Entry, error) {
 			return nil, err
 		}
 
-		item := currentPlaylist.FindPyPySong(numId)
+		item := pl.FindPyPySong(numId)
 		if item == nil {
 			item = song.GetTemporaryPyPySong(numId, ctx)
 		}
@@ -78,7 +93,7 @@ func Request(platform, id string, ctx context.Context) (cache.Entry, error) {
 			return nil, err
 		}
 
-		item := currentPlaylist.FindWannaSong(numId)
+		item := pl.FindWannaSong(numId)
 		if item == nil {
 			item = song.GetTemporaryWannaSong(numId, ctx)
 		}
@@ -90,7 +105,7 @@ func Request(platform, id string, ctx context.Context) (cache.Entry, error) {
 			return nil, err
 		}
 
-		item := currentPlaylist.FindDuDuSong(numId)
+		item := pl.FindDuDuSong(numId)
 		if item == nil {
 			item = song.GetTemporaryDuDuSong(numId, ctx)
 		}
@@ -98,12 +113,13 @@ func Request(platform, id string, ctx context.Context) (cache.Entry, error) {
 
 	case "BiliBili":
 		url = utils.GetStandardBiliURL(id)
-		// TODO youtube
+	case "YouTube":
+		url = utils.GetStandardYoutubeURL(id)
 	default:
 		return nil, errors.New("invalid platform")
 	}
 
-	item := currentPlaylist.FindCustomSong(url)
+	item := pl.FindCustomSong(url)
 	if item == nil {
 		item = song.GetTemporaryCustomSong(url, ctx)
 	}
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/samber/lo"
+	"github.com/wzhqwq/VRCDancePreloader/internal/cache"
 	"github.com/wzhqwq/VRCDancePreloader/internal/download"
 	"github.com/wzhqwq/VRCDancePreloader/internal/song"
 	"github.com/wzhqwq/VRCDancePreloader/internal/song/raw_song"
@@ -19,6 +20,8 @@ func (pl *PlayList) CriticalUpdate() {
 func (pl *PlayList) loop() {
 	listCh := raw_song.SubscribeSongListChange()
 	defer listCh.Close()
+	offlineCh := cache.SubscribeOfflineEvent()
+	defer offlineCh.Close()
 
 	pl.preload()
 	for {
@@ -30,6 +33,12 @@ func (pl *PlayList) loop() {
 			pl.healthCheck()
 		case <-listCh.Channel:
 			pl.refresh()
+		case id := <-offlineCh.Channel:
+			for _, item := range pl.GetItemsSnapshot() {
+				if item.GetSongId() == id {
+					item.NotifyOfflineChange()
+				}
+			}
 		case <-time.After(time.Minute):
 			pl.healthCheck()
 		}
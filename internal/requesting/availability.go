@@ -48,12 +48,12 @@ func accessClient(client *http.Client, tc testCase) error {
 	return nil
 }
 
-func testClient(client *http.Client, serviceName string, tc testCase) (bool, string) {
+func testClient(client *http.Client, serviceName string, proxied bool, tc testCase) (bool, string) {
 	logger.InfoLnf("Testing %s client", serviceName)
 
 	err := accessClient(client, tc)
 	if err != nil {
-		if client.Transport == nil {
+		if !proxied {
 			logger.WarnLnf("Cannot connect to %s service, maybe you should configure proxy: %v", serviceName, err)
 		} else {
 			logger.WarnLnf("Cannot connect to %s service through provided proxy: %v", serviceName, err)
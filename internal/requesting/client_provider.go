@@ -14,10 +14,24 @@ func createProxyClient(proxyURL string) *http.Client {
 	if err != nil {
 		logger.FatalLn("Error parsing proxy URL:", err)
 	}
+	transport := newOriginTransport()
+	transport.Proxy = http.ProxyURL(proxy)
 	return &http.Client{
-		Transport: &http.Transport{
-			Proxy: http.ProxyURL(proxy),
-		},
+		Transport: transport,
+	}
+}
+
+// newOriginTransport reaches the real origins even if they are overridden to us for transparent hijacking,
+// the proxy from environment is still used unless one is configured
+func newOriginTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = DialOrigin
+	return transport
+}
+
+func createDirectClient() *http.Client {
+	return &http.Client{
+		Transport: newOriginTransport(),
 	}
 }
 
@@ -30,8 +44,10 @@ const (
 var ErrClientChanged = errors.New("proxy configuration changed")
 
 type ClientProvider struct {
-	client *http.Client
-	name   string
+	client   *http.Client
+	proxied  bool
+	proxyUrl string
+	name     string
 
 	em *utils.EventManager[ClientEvent]
 }
@@ -41,27 +57,40 @@ func NewProxyProvider(proxyUrl, name string) *ClientProvider {
 	if proxyUrl != "" {
 		c = createProxyClient(proxyUrl)
 	} else {
-		c = &http.Client{}
+		c = createDirectClient()
 	}
 
 	return &ClientProvider{
-		client: c,
-		name:   name,
-		em:     utils.NewEventManager[ClientEvent](),
+		client:   c,
+		proxied:  proxyUrl != "",
+		proxyUrl: proxyUrl,
+		name:     name,
+		em:       utils.NewEventManager[ClientEvent](),
 	}
 }
 
 func (p *ClientProvider) SetProxy(proxyUrl string) {
+	p.proxied = proxyUrl != ""
+	p.proxyUrl = proxyUrl
 	if proxyUrl != "" {
 		p.client = createProxyClient(proxyUrl)
 	} else {
-		p.client = &http.Client{}
+		p.client = createDirectClient()
 	}
 	p.em.NotifySubscribers(ClientChanged)
 }
 
 func (p *ClientProvider) Test(tc testCase) (bool, string) {
-	return testClient(p.client, p.name, tc)
+	return testClient(p.client, p.name, p.proxied, tc)
+}
+
+func (p *ClientProvider) Name() string {
+	return p.name
+}
+
+// ProxyURL is the proxy used by the client, empty for direct access
+func (p *ClientProvider) ProxyURL() string {
+	return p.proxyUrl
 }
 
 func (p *ClientProvider) Client() *http.Client {
@@ -99,6 +128,17 @@ func (p *ClientProvider) Get(url string) (*http.Response, error) {
 	return p.Do(req)
 }
 
+func (p *ClientProvider) Head(url string, parent context.Context) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(p.Context(parent), "HEAD", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	SetupHeader(req, url)
+
+	return p.Do(req)
+}
+
 func (p *ClientProvider) Do(req *http.Request) (*http.Response, error) {
 	resp, err := p.client.Do(req)
 	if err != nil {
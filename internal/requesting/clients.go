@@ -2,7 +2,9 @@ package requesting
 
 import (
 	"context"
+	"slices"
 
+	"github.com/samber/lo"
 	"github.com/wzhqwq/VRCDancePreloader/internal/utils"
 )
 
@@ -59,3 +61,12 @@ func GetClient(name ClientName) *ClientProvider {
 func GetYoutubeApiContext(parent context.Context) context.Context {
 	return clients[YouTubeApi].Context(parent)
 }
+
+// AllClients lists the initialized clients in a stable order
+func AllClients() []*ClientProvider {
+	names := lo.Keys(clients)
+	slices.Sort(names)
+	return lo.FilterMap(names, func(name ClientName, _ int) (*ClientProvider, bool) {
+		return clients[name], clients[name] != nil
+	})
+}
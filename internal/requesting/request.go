@@ -35,6 +35,10 @@ func WithYoutubeApiClient(key string) option.ClientOption {
 
 var thumbnailRequestSem = semaphore.NewWeighted(6)
 
+// thumbnails may be intercepted by us as well, so they are dialed to the origin rather than the hosts file overrides,
+// the proxy from environment is still used
+var directThumbnailClient = createDirectClient()
+
 func RequestThumbnail(url string) (*http.Response, error) {
 	err := thumbnailRequestSem.Acquire(context.Background(), 1)
 	if err != nil {
@@ -48,7 +52,7 @@ func RequestThumbnail(url string) (*http.Response, error) {
 	if utils.CheckYoutubeThumbnailURL(url) {
 		return clients[YouTubeImage].Get(url)
 	}
-	return http.Get(url)
+	return directThumbnailClient.Get(url)
 }
 
 func SetupHeader(req *http.Request, referer string) {
@@ -0,0 +1,254 @@
+package requesting
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// When intercepted domains are pointed to this machine by the hosts file or DNS overrides, the system resolver
+// returns ourselves for them. Resolver asks a DNS server directly, so that the real origins can still be reached.
+
+const resolverTimeout = 5 * time.Second
+
+const minResolvedTTL = 30 * time.Second
+const maxResolvedTTL = time.Hour
+
+var ErrNoAddress = errors.New("no address found")
+
+type resolved struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+type Resolver struct {
+	server string
+
+	cache      map[string]resolved
+	cacheMutex sync.Mutex
+}
+
+// NewResolver creates a resolver asking server, which is an IP with an optional port, 53 by default
+func NewResolver(server string) (*Resolver, error) {
+	server = strings.TrimSpace(server)
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(strings.Trim(server, "[]"), "53")
+	}
+	host, _, _ := net.SplitHostPort(server)
+	if net.ParseIP(host) == nil {
+		return nil, fmt.Errorf("DNS server should be an IP address: %s", host)
+	}
+	return &Resolver{
+		server: server,
+		cache:  make(map[string]resolved),
+	}, nil
+}
+
+func (r *Resolver) Server() string {
+	return r.server
+}
+
+func newQuery(host string, qtype dnsmessage.Type) (uint16, []byte, error) {
+	name, err := dnsmessage.NewName(strings.TrimSuffix(host, ".") + ".")
+	if err != nil {
+		return 0, nil, err
+	}
+	id := uint16(rand.UintN(1 << 16))
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{
+			{Name: name, Type: qtype, Class: dnsmessage.ClassINET},
+		},
+	}
+	packed, err := msg.Pack()
+	return id, packed, err
+}
+
+// exchange sends the query over UDP, and over TCP again if the answer is truncated
+func (r *Resolver) exchange(ctx context.Context, id uint16, query []byte) (*dnsmessage.Message, error) {
+	var d net.Dialer
+	ctx, cancel := context.WithTimeout(ctx, resolverTimeout)
+	defer cancel()
+
+	conn, err := d.DialContext(ctx, "udp", r.server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 1500)
+	var msg dnsmessage.Message
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// ignore stray responses
+		if err := msg.Unpack(buf[:n]); err == nil && msg.ID == id {
+			break
+		}
+	}
+	if !msg.Truncated {
+		return &msg, nil
+	}
+
+	tcpConn, err := d.DialContext(ctx, "tcp", r.server)
+	if err != nil {
+		return nil, err
+	}
+	defer tcpConn.Close()
+	tcpConn.SetDeadline(deadline)
+
+	framed := binary.BigEndian.AppendUint16(nil, uint16(len(query)))
+	if _, err := tcpConn.Write(append(framed, query...)); err != nil {
+		return nil, err
+	}
+	var length uint16
+	if err := binary.Read(tcpConn, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	answer := make([]byte, length)
+	if _, err := io.ReadFull(tcpConn, answer); err != nil {
+		return nil, err
+	}
+	if err := msg.Unpack(answer); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func (r *Resolver) lookup(ctx context.Context, host string, qtype dnsmessage.Type) ([]net.IP, time.Duration, error) {
+	id, query, err := newQuery(host, qtype)
+	if err != nil {
+		return nil, 0, err
+	}
+	msg, err := r.exchange(ctx, id, query)
+	if err != nil {
+		return nil, 0, err
+	}
+	if msg.RCode != dnsmessage.RCodeSuccess {
+		return nil, 0, fmt.Errorf("DNS server responded %s for %s", msg.RCode, host)
+	}
+
+	var ips []net.IP
+	ttl := maxResolvedTTL
+	for _, answer := range msg.Answers {
+		switch body := answer.Body.(type) {
+		case *dnsmessage.AResource:
+			ips = append(ips, net.IP(body.A[:]))
+		case *dnsmessage.AAAAResource:
+			ips = append(ips, net.IP(body.AAAA[:]))
+		default:
+			// CNAME records are followed by the server
+			continue
+		}
+		ttl = min(ttl, time.Duration(answer.Header.TTL)*time.Second)
+	}
+	return ips, max(ttl, minResolvedTTL), nil
+}
+
+// LookupIP resolves host without the hosts file, IPv4 addresses are preferred
+func (r *Resolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	r.cacheMutex.Lock()
+	if entry, ok := r.cache[host]; ok && time.Now().Before(entry.expires) {
+		r.cacheMutex.Unlock()
+		return entry.ips, nil
+	}
+	r.cacheMutex.Unlock()
+
+	ips, ttl, err := r.lookup(ctx, host, dnsmessage.TypeA)
+	if err == nil && len(ips) == 0 {
+		ips, ttl, err = r.lookup(ctx, host, dnsmessage.TypeAAAA)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("%w for %s", ErrNoAddress, host)
+	}
+
+	r.cacheMutex.Lock()
+	r.cache[host] = resolved{ips: ips, expires: time.Now().Add(ttl)}
+	r.cacheMutex.Unlock()
+
+	return ips, nil
+}
+
+// DialContext dials addr with the addresses resolved by r, every address is tried in order
+func (r *Resolver) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := r.LookupIP(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var d net.Dialer
+	var lastErr error
+	for _, ip := range ips {
+		conn, err := d.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+var bypassResolver atomic.Pointer[Resolver]
+
+// SetBypassResolver makes every client resolve domains with server instead of the system, empty to disable it
+func SetBypassResolver(server string) error {
+	if server == "" {
+		bypassResolver.Store(nil)
+		return nil
+	}
+	r, err := NewResolver(server)
+	if err != nil {
+		return err
+	}
+	bypassResolver.Store(r)
+	return nil
+}
+
+func GetBypassResolver() *Resolver {
+	return bypassResolver.Load()
+}
+
+// LookupOrigin resolves host like DialOrigin does
+func LookupOrigin(ctx context.Context, host string) ([]net.IP, error) {
+	if r := bypassResolver.Load(); r != nil {
+		return r.LookupIP(ctx, host)
+	}
+	return net.DefaultResolver.LookupIP(ctx, "ip", host)
+}
+
+// DialOrigin dials the real address of addr, the hosts file is bypassed if a bypass resolver is set
+func DialOrigin(ctx context.Context, network, addr string) (net.Conn, error) {
+	if r := bypassResolver.Load(); r != nil {
+		return r.DialContext(ctx, network, addr)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, network, addr)
+}
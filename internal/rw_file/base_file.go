@@ -24,8 +24,11 @@ func (f *BaseFile) ModTime() time.Time {
 func (f *BaseFile) UpdateRemoteInfo(contentLength int64, lastModified time.Time) {
 	f.File.Init(contentLength, lastModified)
 }
+func (f *BaseFile) SetSource(url, validator string) {
+	f.File.SetSource(url, validator)
+}
 func (f *BaseFile) IsComplete() bool {
-	return f.File.Completed
+	return f.File.IsCompleted()
 }
 
 func ConstructBaseFile(baseName string) BaseFile {
@@ -34,3 +37,11 @@ func ConstructBaseFile(baseName string) BaseFile {
 		File:     trunk.NewTrunkFile(baseName),
 	}
 }
+
+// ConstructReadOnlyBaseFile opens an existing file for reading, File is nil if it can't be opened
+func ConstructReadOnlyBaseFile(baseName string) BaseFile {
+	return BaseFile{
+		baseName: baseName,
+		File:     trunk.OpenReadOnly(baseName),
+	}
+}
@@ -5,11 +5,11 @@ func (f *File) Append(bytes []byte) (int, error) {
 	if err != nil {
 		return 0, err
 	}
-	f.em.NotifySubscribers(f.fragment.Length)
-
+	// mark it completed first, so that subscribers woken afterward see it
 	if f.File.IsSuffix(f.fragment) {
 		f.File.MarkCompleted()
 	}
+	f.em.NotifySubscribers(f.fragment.Length)
 
 	return len(bytes), nil
 }
@@ -18,6 +18,9 @@ type File struct {
 }
 
 func (f *File) Clear() error {
+	if f.File.ReadOnly() {
+		return rw_file.ErrReadOnly
+	}
 	f.fragment.Length = 0
 	f.File.ClearTrunks()
 	return nil
@@ -27,8 +30,8 @@ func (f *File) IsRequestFulfilled() bool {
 	return f.IsComplete()
 }
 
-func (f *File) NotifyRequestStart(start int64) {
-	// Do nothing
+func (f *File) NotifyRequestRanges(ranges []rw_file.Range) {
+	// Do nothing, it's always downloaded from the start
 }
 func (f *File) MarkDownloading() {
 	// Do nothing
@@ -62,3 +65,20 @@ func NewFile(baseName string) *File {
 
 	return f
 }
+
+// NewReadOnlyFile opens an existing file for reading, e.g. the file shared by an alias
+func NewReadOnlyFile(baseName string) *File {
+	f := &File{
+		BaseFile: rw_file.ConstructReadOnlyBaseFile(baseName),
+
+		em: utils.NewEventManager[int64](),
+	}
+
+	if f.File == nil {
+		return nil
+	}
+
+	f.fragment = f.File.ToFragments()[0]
+
+	return f
+}
@@ -13,10 +13,15 @@ func (f *File) RequestRange(offset, length int64, ctx context.Context) error {
 	ch := f.em.SubscribeEvent()
 	defer ch.Close()
 
+	// the download may complete before subscribing, and the last event is dropped if the channel is full
+	if f.IsComplete() {
+		return nil
+	}
+
 	for {
 		select {
 		case l := <-ch.Channel:
-			if l >= offset+length {
+			if l >= offset+length || f.IsComplete() {
 				return nil
 			}
 		case <-ctx.Done():
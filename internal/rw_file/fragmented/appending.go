@@ -48,7 +48,7 @@ func (f *File) checkAppend(bytes []byte) (int, error) {
 }
 
 func (f *File) Append(bytes []byte) (int, error) {
-	if f.downloadingFragment != f.activeFragment {
+	if !f.isDownloadingActive() {
 		// force re-downloading active fragment
 		return 0, ErrEndOfFragment
 	}
@@ -57,11 +57,15 @@ func (f *File) Append(bytes []byte) (int, error) {
 	if err != nil {
 		if errors.Is(err, ErrEndOfFragment) {
 			f.mergeInLoop(f.downloadingFragment)
+			f.switchIfFulfilled()
 		}
 		return 0, err
 	}
 
+	// the fragment grows, and readers walk through the fragments
+	f.fragmentsMutex.Lock()
 	err = f.File.AppendTo(f.downloadingFragment, bytes[:n])
+	f.fragmentsMutex.Unlock()
 	if err != nil {
 		return 0, err
 	}
@@ -70,17 +74,26 @@ func (f *File) Append(bytes []byte) (int, error) {
 		// This means current fragment is finished if this n bytes are written
 		// We can also merge current fragment
 		f.mergeInLoop(f.downloadingFragment)
+		f.switchIfFulfilled()
 		// and force the downloader to restart with new offset
 		return n, ErrEndOfFragment
 	}
-	if f.downloadingFragment.End() == f.File.FullSize {
-		// We have reached the end, back to the first fragment if there's no other request
-		f.backToFirst()
+	if f.isSuffix(f.downloadingFragment) {
+		// We have reached the end, go to the next requested range or back to the first fragment
+		f.em.NotifySubscribers(f.downloadingFragment)
+		if !f.nextAfterEnd() {
+			f.backToFirst()
+		}
 		return n, ErrEndOfFragment
 	}
 
 	f.em.NotifySubscribers(f.downloadingFragment)
 
+	if f.switchIfFulfilled() {
+		// the requested range is ready, other requested ranges go next
+		return n, ErrEndOfFragment
+	}
+
 	//f.fragmentsMutex.RLock()
 	//f.printFragments()
 	//f.fragmentsMutex.RUnlock()
@@ -30,6 +30,11 @@ type File struct {
 	activeFragMutex sync.RWMutex
 
 	lastestRequestStart int64
+
+	// the range that the active fragment is downloaded for, and other requested ranges waiting for it
+	activeRange   *rw_file.Range
+	pendingRanges []rw_file.Range
+	pendingMutex  sync.Mutex
 }
 
 func (f *File) Clear() error {
@@ -38,6 +43,11 @@ func (f *File) Clear() error {
 	f.File.ClearTrunks()
 	f.fragments = f.File.ToFragments()
 	f.activeFragment = f.fragments[0]
+
+	f.pendingMutex.Lock()
+	f.activeRange = nil
+	f.pendingRanges = nil
+	f.pendingMutex.Unlock()
 	return nil
 }
 
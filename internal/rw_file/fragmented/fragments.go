@@ -12,9 +12,17 @@ func (f *File) LoadFragments() {
 	f.backToFirst()
 }
 
+// isSuffix checks if the fragment reaches the end, the fragment may be growing in another goroutine
+func (f *File) isSuffix(frag *trunk.Fragment) bool {
+	f.fragmentsMutex.RLock()
+	defer f.fragmentsMutex.RUnlock()
+
+	return f.File.IsSuffix(frag)
+}
+
 func (f *File) setActive(frag *trunk.Fragment) {
 	f.mergeInLoop(frag)
-	if f.File.IsSuffix(frag) {
+	if f.isSuffix(frag) {
 		frag = f.fragments[0]
 	}
 
@@ -92,7 +100,7 @@ func (f *File) mergeForward(currentFrag *trunk.Fragment) {
 
 func (f *File) mergeInLoop(frag *trunk.Fragment) {
 	f.mergeForward(frag)
-	if f.File.IsSuffix(frag) {
+	if f.isSuffix(frag) {
 		return
 	}
 	// suffix downloaded, start from prefix
@@ -101,9 +109,13 @@ func (f *File) mergeInLoop(frag *trunk.Fragment) {
 }
 
 func (f *File) checkComplete() {
-	if f.File.Completed {
+	if f.File.IsCompleted() {
 		return
 	}
+
+	f.fragmentsMutex.RLock()
+	defer f.fragmentsMutex.RUnlock()
+
 	if len(f.fragments) == 1 && f.File.IsSuffix(f.fragments[0]) {
 		f.File.MarkCompleted()
 	}
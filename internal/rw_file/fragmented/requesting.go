@@ -1,6 +1,11 @@
 package fragmented
 
-import "github.com/wzhqwq/VRCDancePreloader/internal/rw_file/trunk"
+import (
+	"slices"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/rw_file"
+	"github.com/wzhqwq/VRCDancePreloader/internal/rw_file/trunk"
+)
 
 func (f *File) findAvailableFragment(start int64) *trunk.Fragment {
 	f.fragmentsMutex.RLock()
@@ -38,27 +43,104 @@ func (f *File) activeOrCreateFragment(start int64) {
 	f.setActive(active)
 }
 
-func (f *File) NotifyRequestStart(start int64) {
-	if f.File.Completed {
-		return
-	}
+func (f *File) requestStart(start int64) {
 	f.activeOrCreateFragment(start)
 	f.lastestRequestStart = start
 }
 
+// NotifyRequestRanges downloads the most important range at once, the others are queued.
+// The range that was being downloaded is continued after them
+func (f *File) NotifyRequestRanges(ranges []rw_file.Range) {
+	if f.File.IsCompleted() || len(ranges) == 0 {
+		return
+	}
+	ranges = rw_file.PrioritizeRanges(ranges, f.File.FullSize)
+
+	f.pendingMutex.Lock()
+	defer f.pendingMutex.Unlock()
+
+	pending := slices.Clone(ranges[1:])
+	if f.activeRange != nil && !f.checkRange(f.activeRange.Start, f.activeRange.Length) {
+		pending = append(pending, *f.activeRange)
+	}
+	f.pendingRanges = append(pending, f.pendingRanges...)
+
+	f.activeRange = &ranges[0]
+	f.requestStart(ranges[0].Start)
+}
+
+// activateNextPending moves on to the next queued range that isn't downloaded yet (please wrap with pendingMutex!!)
+func (f *File) activateNextPending() bool {
+	for len(f.pendingRanges) > 0 {
+		r := f.pendingRanges[0]
+		f.pendingRanges = f.pendingRanges[1:]
+		if f.checkRange(r.Start, r.Length) {
+			continue
+		}
+		f.activeRange = &r
+		f.requestStart(r.Start)
+		return true
+	}
+	f.activeRange = nil
+	return false
+}
+
+func (f *File) isDownloadingActive() bool {
+	f.activeFragMutex.RLock()
+	defer f.activeFragMutex.RUnlock()
+
+	return f.activeFragment == f.downloadingFragment
+}
+
+// switchIfFulfilled activates the next queued range once the active range is downloaded,
+// true is returned if the downloader should restart from another offset
+func (f *File) switchIfFulfilled() bool {
+	f.pendingMutex.Lock()
+	defer f.pendingMutex.Unlock()
+
+	if f.activeRange == nil || len(f.pendingRanges) == 0 {
+		return false
+	}
+	// a new request is not processed by the downloader yet
+	if !f.isDownloadingActive() {
+		return false
+	}
+	if !f.checkRange(f.activeRange.Start, f.activeRange.Length) {
+		return false
+	}
+	return f.activateNextPending()
+}
+
+// nextAfterEnd activates the next queued range when the downloading fragment reaches the end of the file
+func (f *File) nextAfterEnd() bool {
+	f.pendingMutex.Lock()
+	defer f.pendingMutex.Unlock()
+
+	if !f.isDownloadingActive() {
+		return false
+	}
+	return f.activateNextPending()
+}
+
 func (f *File) IsRequestFulfilled() bool {
 	requestedFrag := f.findAvailableFragment(f.lastestRequestStart)
 	if requestedFrag == nil {
 		return false
 	}
-	return f.File.IsSuffix(requestedFrag)
+	return f.isSuffix(requestedFrag)
 }
 
 func (f *File) GetDownloadOffset() int64 {
+	f.fragmentsMutex.RLock()
+	defer f.fragmentsMutex.RUnlock()
+
 	return f.downloadingFragment.End()
 }
 
 func (f *File) MarkDownloading() {
+	f.activeFragMutex.RLock()
+	defer f.activeFragMutex.RUnlock()
+
 	// It never races with Append, so we don't need to protect downloadingFragment
 	f.downloadingFragment = f.activeFragment
 }
@@ -1,11 +1,17 @@
 package legacy_file
 
+import "github.com/wzhqwq/VRCDancePreloader/internal/rw_file"
+
 func (f *File) Append(bytes []byte) (int, error) {
+	if f.readOnly {
+		return 0, rw_file.ErrReadOnly
+	}
+
 	f.fileMutex.RLock()
 	defer func() {
 		f.fileMutex.RUnlock()
 
-		if f.downloaded == f.totalLen {
+		if f.downloaded.Load() == f.totalLen {
 			go func() {
 				err := f.Save()
 				if err != nil {
@@ -15,12 +21,11 @@ func (f *File) Append(bytes []byte) (int, error) {
 		}
 	}()
 
-	n, err := f.file.WriteAt(bytes, f.downloaded)
+	n, err := f.file.WriteAt(bytes, f.downloaded.Load())
 	if err != nil {
 		return 0, err
 	}
-	f.downloaded += int64(n)
-	f.em.NotifySubscribers(f.downloaded)
+	f.em.NotifySubscribers(f.downloaded.Add(int64(n)))
 
 	return n, nil
 }
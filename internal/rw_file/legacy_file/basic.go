@@ -5,6 +5,7 @@ import (
 	"io"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/wzhqwq/VRCDancePreloader/internal/rw_file"
@@ -17,15 +18,21 @@ type File struct {
 	baseName string
 	totalLen int64
 
-	downloaded int64
+	// read by the readers while it's appended
+	downloaded atomic.Int64
 
 	em *utils.EventManager[int64]
 
 	fileMutex sync.RWMutex
 	file      *os.File
+	readOnly  bool
 }
 
 func (f *File) Clear() error {
+	if f.readOnly {
+		return rw_file.ErrReadOnly
+	}
+
 	f.fileMutex.RLock()
 	defer f.fileMutex.RUnlock()
 
@@ -34,7 +41,7 @@ func (f *File) Clear() error {
 		return err
 	}
 
-	f.downloaded = 0
+	f.downloaded.Store(0)
 
 	return nil
 }
@@ -43,8 +50,8 @@ func (f *File) IsRequestFulfilled() bool {
 	return f.IsComplete()
 }
 
-func (f *File) NotifyRequestStart(start int64) {
-	// Do nothing
+func (f *File) NotifyRequestRanges(ranges []rw_file.Range) {
+	// Do nothing, it's always downloaded from the start
 }
 func (f *File) MarkDownloading() {
 	// Do nothing
@@ -99,15 +106,15 @@ func (f *File) ModTime() time.Time {
 }
 
 func (f *File) GetDownloadOffset() int64 {
-	return f.downloaded
+	return f.downloaded.Load()
 }
 
 func (f *File) GetDownloadedBytes() int64 {
-	return f.downloaded
+	return f.downloaded.Load()
 }
 
 func (f *File) IsComplete() bool {
-	return f.totalLen > 0 && f.downloaded >= f.totalLen
+	return f.totalLen > 0 && f.downloaded.Load() >= f.totalLen
 }
 
 func (f *File) TotalLen() int64 {
@@ -137,15 +144,37 @@ func NewFile(baseName string) *File {
 		}
 	}
 
-	return &File{
-		baseName:   baseName,
-		totalLen:   totalLen,
-		downloaded: downloaded,
+	f := &File{
+		baseName: baseName,
+		totalLen: totalLen,
 
 		em: utils.NewEventManager[int64](),
 
 		file: file,
 	}
+	f.downloaded.Store(downloaded)
+	return f
+}
+
+// NewReadOnlyFile opens a complete file for reading, e.g. the file shared by an alias
+func NewReadOnlyFile(baseName string) *File {
+	file, err := os.Open(baseName)
+	if err != nil {
+		return nil
+	}
+	totalLen := getFileSize(baseName)
+
+	f := &File{
+		baseName: baseName,
+		totalLen: totalLen,
+
+		em: utils.NewEventManager[int64](),
+
+		file:     file,
+		readOnly: true,
+	}
+	f.downloaded.Store(totalLen)
+	return f
 }
 
 func openFile(path string) (*os.File, error) {
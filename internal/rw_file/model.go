@@ -4,8 +4,13 @@ import (
 	"context"
 	"io"
 	"time"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/rw_file/trunk"
 )
 
+// ErrReadOnly is returned when a file opened for reading only is modified
+var ErrReadOnly = trunk.ErrReadOnly
+
 type DeferredReadableFile interface {
 	UpdateRemoteInfo(contentLength int64, lastModified time.Time)
 	TotalLen() int64
@@ -18,7 +23,8 @@ type DeferredReadableFile interface {
 
 	RequestRs(ctx context.Context) io.ReadSeeker
 
-	NotifyRequestStart(start int64)
+	// NotifyRequestRanges hints the ranges requested by a player, so that they can be downloaded first
+	NotifyRequestRanges(ranges []Range)
 	MarkDownloading()
 
 	GetDownloadOffset() int64
@@ -28,6 +34,11 @@ type DeferredReadableFile interface {
 	IsRequestFulfilled() bool
 }
 
+// SourceRecorder is implemented by files that can remember where they are downloaded from
+type SourceRecorder interface {
+	SetSource(url, validator string)
+}
+
 type DeferredReader interface {
 	RequestRange(offset, length int64, ctx context.Context) error
 	ReadAt(p []byte, off int64) (int, error)
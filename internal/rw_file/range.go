@@ -0,0 +1,127 @@
+package rw_file
+
+import (
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Range is a byte range requested by a player
+type Range struct {
+	Start  int64
+	Length int64
+	// requested as the last bytes of the file, like bytes=-N
+	Suffix bool
+}
+
+// a range reaching the end is taken as a suffix if it starts in the last tenth of the file
+const suffixTailDivisor = 10
+
+func (r Range) End() int64 {
+	return r.Start + r.Length
+}
+
+// IsSuffix reports whether the range is the tail of a file in this size, e.g. players read the moov box there.
+// Open-ended ranges from the front like bytes=0- are not suffixes
+func (r Range) IsSuffix(size int64) bool {
+	if r.Suffix {
+		return true
+	}
+	return r.End() >= size && r.Start >= size-size/suffixTailDivisor
+}
+
+// ParseRangeHeader parses the Range header like net/http/fs.go, ranges beyond the size are dropped
+// and the ranges are clipped into the file. Nil is returned if the header is empty or malformed
+func ParseRangeHeader(s string, size int64) []Range {
+	if s == "" || size <= 0 {
+		return nil
+	}
+	if !strings.HasPrefix(s, "bytes=") {
+		return nil
+	}
+	var ranges []Range
+	for _, ra := range strings.Split(strings.TrimPrefix(s, "bytes="), ",") {
+		ra = textproto.TrimString(ra)
+		if ra == "" {
+			continue
+		}
+		start, end, ok := strings.Cut(ra, "-")
+		if !ok {
+			return nil
+		}
+		start, end = textproto.TrimString(start), textproto.TrimString(end)
+		var r Range
+		if start == "" {
+			// If no start is specified, end specifies the
+			// range start relative to the end of the file,
+			// and we are dealing with <suffix-length>
+			// which has to be a non-negative integer as per
+			// RFC 7233 Section 2.1 "Byte-Ranges".
+			if end == "" || end[0] == '-' {
+				return nil
+			}
+			i, err := strconv.ParseInt(end, 10, 64)
+			if i < 0 || err != nil {
+				return nil
+			}
+			if i == 0 {
+				continue
+			}
+			if i > size {
+				i = size
+			}
+			r.Start = size - i
+			r.Length = i
+			r.Suffix = true
+		} else {
+			i, err := strconv.ParseInt(start, 10, 64)
+			if err != nil || i < 0 {
+				return nil
+			}
+			if i >= size {
+				// out of bounds, the range can't be satisfied
+				continue
+			}
+			r.Start = i
+			if end == "" {
+				// If no end is specified, range extends to end of the file.
+				r.Length = size - r.Start
+			} else {
+				i, err := strconv.ParseInt(end, 10, 64)
+				if err != nil || r.Start > i {
+					return nil
+				}
+				if i >= size {
+					i = size - 1
+				}
+				r.Length = i - r.Start + 1
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges
+}
+
+// PrioritizeRanges sorts ranges in the order of fetching, suffix ranges go first because players can't start
+// without the moov box at the end, the others are fetched from the front
+func PrioritizeRanges(ranges []Range, size int64) []Range {
+	sorted := slices.Clone(ranges)
+	slices.SortStableFunc(sorted, func(a, b Range) int {
+		aSuffix, bSuffix := a.IsSuffix(size), b.IsSuffix(size)
+		if aSuffix != bSuffix {
+			if aSuffix {
+				return -1
+			}
+			return 1
+		}
+		if a.Start < b.Start {
+			return -1
+		}
+		if a.Start > b.Start {
+			return 1
+		}
+		return 0
+	})
+	return sorted
+}
@@ -5,51 +5,123 @@ import (
 	"time"
 )
 
-// structure:
+// v1 structure:
 // | magic | full_size(int64) | last_modified(int64) | states(byte) |
 // |                          trunks (16KB)                         |
 // |                       body (256MB maximum)                     |
 
+// v2 structure:
+// | magic | version(uint16) | trunk_size(uint32) | full_size(int64) | last_modified(int64) | states(byte) |
+// | validator_len(uint16) | validator (256B) | url_len(uint16) | url (2KB) | bitmap_len(uint32) | body_offset(int64) |
+// |                           bitmap (1 bit per trunk, bitmap_len bytes)                             |
+// |                                   body (aligned to 4KB)                                          |
+
+const (
+	Version1 = 1
+	Version2 = 2
+)
+
 // magic 11 bytes
-const magic = "VRCDP_CACHE"
-const magicLen = len(magic)
+const magicV1 = "VRCDP_CACHE"
+const magicV2 = "VRCDP_TRUNK"
+const magicLen = len(magicV1)
 const magicOffset = int64(0)
 
-// full size int64
-const fullSizeLen = 8
-const fullSizeOffset = magicOffset + int64(magicLen)
+// v1 offsets
+
+const v1FullSizeOffset = magicOffset + int64(magicLen)
+const v1LastModifiedOffset = v1FullSizeOffset + 8
+const v1StatesOffset = v1LastModifiedOffset + 8
+
+// all trunks in the header takes 16KB, it's alright
+const v1NumTrunks = v1Capacity / v1TrunkSize
+const v1TrunksOffset = v1StatesOffset + statesLen
+const v1BodyOffset = v1TrunksOffset + v1NumTrunks
+
+// v2 offsets
 
-// last modified time int64
-const lastModifiedLen = 8
-const lastModifiedOffset = fullSizeOffset + fullSizeLen
+const versionOffset = magicOffset + int64(magicLen)
+const trunkSizeOffset = versionOffset + 2
+const fullSizeOffset = trunkSizeOffset + 4
+const lastModifiedOffset = fullSizeOffset + 8
+const statesOffset = lastModifiedOffset + 8
+
+// ETag or other validators of the remote file
+const maxValidatorLen = 256
+const validatorLenOffset = statesOffset + statesLen
+const validatorOffset = validatorLenOffset + 2
+
+// the url that the body is downloaded from
+const maxSourceUrlLen = 2048
+const sourceUrlLenOffset = validatorOffset + maxValidatorLen
+const sourceUrlOffset = sourceUrlLenOffset + 2
+
+const bitmapLenOffset = sourceUrlOffset + maxSourceUrlLen
+const bodyOffsetOffset = bitmapLenOffset + 4
+const bitmapOffset = bodyOffsetOffset + 8
+
+const bodyAlignment = 4096
 
 // state byte
 const stateCompletedFlag = 0x01
 const statesLen = 1
-const statesOffset = lastModifiedOffset + lastModifiedLen
 
-// trunks []byte
-// all trunks in the header takes 16KB, it's alright
-const numTrunks = capacity / bytesPerTrunk
-const trunksOffset = statesOffset + statesLen
+func bitmapLenFor(fullSize, trunkSize int64) int64 {
+	numTrunks := (fullSize + trunkSize - 1) / trunkSize
+	return (numTrunks + 7) / 8
+}
+
+func bodyOffsetFor(bitmapLen int64) int64 {
+	return (bitmapOffset + bitmapLen + bodyAlignment - 1) / bodyAlignment * bodyAlignment
+}
+
+// layout arranges a v2 header for the given full size, the bitmap will be cleared
+func (f *File) layout(fullSize int64) {
+	f.version = Version2
+	f.bitmapLen = bitmapLenFor(fullSize, f.trunkSize)
+	f.bodyOffset = bodyOffsetFor(f.bitmapLen)
+	f.trunks = make([]byte, f.bitmapLen*8)
+}
 
-// body
-const bodyOffset = trunksOffset + numTrunks
+// growBitmap extends the bitmap of a v2 file into the padding before the body, so the body stays where it is
+func (f *File) growBitmap(fullSize int64) bool {
+	if f.version == Version1 {
+		return false
+	}
+	bitmapLen := bitmapLenFor(fullSize, f.trunkSize)
+	if bitmapOffset+bitmapLen > f.bodyOffset {
+		return false
+	}
+	f.bitmapLen = bitmapLen
+	f.trunks = append(f.trunks, make([]byte, bitmapLen*8-int64(len(f.trunks)))...)
+	return f.writeLayout() && f.writeTrunks()
+}
 
+// tryCreate arranges a new v2 file in the trunk size of this file
 func (f *File) tryCreate() bool {
-	err := f.file.Truncate(bodyOffset)
+	f.layout(f.FullSize)
+
+	err := f.file.Truncate(0)
+	if err == nil {
+		err = f.file.Truncate(f.bodyOffset + f.FullSize)
+	}
 	if err != nil {
 		logger.ErrorLn("Failed to truncate cache file:", err)
 		return false
 	}
 
 	// write magic
-	_, err = f.file.WriteAt([]byte(magic), magicOffset)
+	_, err = f.file.WriteAt([]byte(magicV2), magicOffset)
 	if err != nil {
 		logger.ErrorLn("Failed to write magic:", err)
 		return false
 	}
 
+	// write version and layout
+	if !f.writeLayout() {
+		return false
+	}
+
 	// write full size
 	if !f.writeFullSize() {
 		return false
@@ -65,6 +137,14 @@ func (f *File) tryCreate() bool {
 		return false
 	}
 
+	// write source
+	if !f.writeString(f.Validator, validatorLenOffset, maxValidatorLen) {
+		return false
+	}
+	if !f.writeString(f.SourceUrl, sourceUrlLenOffset, maxSourceUrlLen) {
+		return false
+	}
+
 	// write trunks
 	if !f.writeTrunks() {
 		return false
@@ -73,10 +153,46 @@ func (f *File) tryCreate() bool {
 	return true
 }
 
+func (f *File) writeUint(value uint64, size int, offset int64) error {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, value)
+	_, err := f.file.WriteAt(buf[:size], offset)
+	return err
+}
+
+func (f *File) readUint(size int, offset int64) (uint64, error) {
+	buf := make([]byte, 8)
+	_, err := f.file.ReadAt(buf[:size], offset)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(buf), nil
+}
+
+func (f *File) writeLayout() bool {
+	err := f.writeUint(uint64(f.version), 2, versionOffset)
+	if err == nil {
+		err = f.writeUint(uint64(f.trunkSize), 4, trunkSizeOffset)
+	}
+	if err == nil {
+		err = f.writeUint(uint64(f.bitmapLen), 4, bitmapLenOffset)
+	}
+	if err == nil {
+		err = f.writeUint(uint64(f.bodyOffset), 8, bodyOffsetOffset)
+	}
+	if err != nil {
+		logger.ErrorLn("Failed to write layout:", err)
+		return false
+	}
+	return true
+}
+
 func (f *File) writeFullSize() bool {
-	int64Buf := make([]byte, 8)
-	binary.LittleEndian.PutUint64(int64Buf, uint64(f.FullSize))
-	_, err := f.file.WriteAt(int64Buf, fullSizeOffset)
+	offset := fullSizeOffset
+	if f.version == Version1 {
+		offset = v1FullSizeOffset
+	}
+	err := f.writeUint(uint64(f.FullSize), 8, offset)
 	if err != nil {
 		logger.ErrorLn("Failed to write full size:", err)
 		return false
@@ -85,10 +201,11 @@ func (f *File) writeFullSize() bool {
 }
 
 func (f *File) writeLastModifiedTime() bool {
-	int64Buf := make([]byte, 8)
-	unix := f.LastModified.Unix()
-	binary.LittleEndian.PutUint64(int64Buf, uint64(unix))
-	_, err := f.file.WriteAt(int64Buf, lastModifiedOffset)
+	offset := lastModifiedOffset
+	if f.version == Version1 {
+		offset = v1LastModifiedOffset
+	}
+	err := f.writeUint(uint64(f.LastModified.Unix()), 8, offset)
 	if err != nil {
 		logger.ErrorLn("Failed to write last modified time:", err)
 		return false
@@ -98,11 +215,15 @@ func (f *File) writeLastModifiedTime() bool {
 
 func (f *File) writeStates() bool {
 	stateByte := byte(0)
-	if f.Completed {
+	if f.IsCompleted() {
 		stateByte |= stateCompletedFlag
 	}
 
-	_, err := f.file.WriteAt([]byte{stateByte}, statesOffset)
+	offset := statesOffset
+	if f.version == Version1 {
+		offset = v1StatesOffset
+	}
+	_, err := f.file.WriteAt([]byte{stateByte}, offset)
 	if err != nil {
 		logger.ErrorLn("Failed to write states:", err)
 		return false
@@ -110,8 +231,52 @@ func (f *File) writeStates() bool {
 	return true
 }
 
+// writeString writes a length-prefixed string into a reserved slot, the string is dropped if it's too long
+func (f *File) writeString(s string, offset int64, maxLen int) bool {
+	if len(s) > maxLen {
+		s = ""
+	}
+	buf := make([]byte, 2+maxLen)
+	binary.LittleEndian.PutUint16(buf, uint16(len(s)))
+	copy(buf[2:], s)
+
+	_, err := f.file.WriteAt(buf, offset)
+	if err != nil {
+		logger.ErrorLn("Failed to write header string:", err)
+		return false
+	}
+	return true
+}
+
+func (f *File) readString(offset int64, maxLen int) (string, error) {
+	length, err := f.readUint(2, offset)
+	if err != nil {
+		return "", err
+	}
+	if length > uint64(maxLen) {
+		return "", ErrCorrupted
+	}
+	buf := make([]byte, length)
+	_, err = f.file.ReadAt(buf, offset+2)
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
 func (f *File) writeTrunks() bool {
-	_, err := f.file.WriteAt(f.trunks, trunksOffset)
+	var err error
+	if f.version == Version1 {
+		_, err = f.file.WriteAt(f.trunks, v1TrunksOffset)
+	} else {
+		bitmap := make([]byte, f.bitmapLen)
+		for i, b := range f.trunks {
+			if b != 0 {
+				bitmap[i/8] |= 1 << (i % 8)
+			}
+		}
+		_, err = f.file.WriteAt(bitmap, bitmapOffset)
+	}
 	if err != nil {
 		logger.ErrorLn("Failed to write trunks:", err)
 		return false
@@ -127,7 +292,7 @@ func (f *File) tryRead() bool {
 	}
 
 	size := stat.Size()
-	if size <= bodyOffset {
+	if size <= bitmapOffset {
 		if size > 0 {
 			logger.ErrorLnf("Corrupted file: %s, re-initialize it", f.file.Name())
 		}
@@ -136,48 +301,128 @@ func (f *File) tryRead() bool {
 
 	// magic check
 	magicTest := make([]byte, magicLen)
-	_, err = f.file.ReadAt(magicTest, 0)
+	_, err = f.file.ReadAt(magicTest, magicOffset)
 	if err != nil {
 		logger.ErrorLn("Failed to read magic:", err)
 		return false
 	}
-	if string(magicTest) != magic {
-		logger.ErrorLnf("Corrupted file: %s, re-initialize it", f.file.Name())
+
+	switch string(magicTest) {
+	case magicV1:
+		if size <= v1BodyOffset {
+			logger.ErrorLnf("Corrupted file: %s, re-initialize it", f.file.Name())
+			return false
+		}
+		err = f.readV1()
+	case magicV2:
+		err = f.readV2(size)
+	default:
+		err = ErrCorrupted
+	}
+	if err != nil {
+		logger.ErrorLnf("Corrupted file: %s (%v), re-initialize it", f.file.Name(), err)
 		return false
 	}
 
-	int64Buf := make([]byte, lastModifiedLen)
+	return true
+}
 
-	// read full size
-	_, err = f.file.ReadAt(int64Buf, fullSizeOffset)
+func (f *File) readCommon(fullSizeOffset, lastModifiedOffset, statesOffset int64) error {
+	fullSize, err := f.readUint(8, fullSizeOffset)
 	if err != nil {
-		logger.ErrorLn("Failed to read full size:", err)
-		return false
+		return err
 	}
-	f.FullSize = int64(binary.LittleEndian.Uint64(int64Buf))
+	f.FullSize = int64(fullSize)
 
-	// read last modified time
-	_, err = f.file.ReadAt(int64Buf, lastModifiedOffset)
+	lastModified, err := f.readUint(8, lastModifiedOffset)
 	if err != nil {
-		logger.ErrorLn("Failed to read last modified time:", err)
-		return false
+		return err
 	}
-	f.LastModified = time.Unix(int64(binary.LittleEndian.Uint64(int64Buf)), 0)
+	f.LastModified = time.Unix(int64(lastModified), 0)
 
-	// read states
-	stateBuf := make([]byte, statesLen)
-	_, err = f.file.ReadAt(stateBuf, statesOffset)
+	states, err := f.readUint(statesLen, statesOffset)
 	if err != nil {
-		logger.ErrorLn("Failed to read states:", err)
+		return err
 	}
-	f.Completed = stateBuf[0]&stateCompletedFlag == stateCompletedFlag
+	f.completed.Store(states&stateCompletedFlag == stateCompletedFlag)
+
+	return nil
+}
+
+func (f *File) readV1() error {
+	f.version = Version1
+	f.trunkSize = v1TrunkSize
+	f.bodyOffset = v1BodyOffset
 
-	// read trunks
-	_, err = f.file.ReadAt(f.trunks, trunksOffset)
+	err := f.readCommon(v1FullSizeOffset, v1LastModifiedOffset, v1StatesOffset)
 	if err != nil {
-		logger.ErrorLn("Failed to read trunks:", err)
-		return false
+		return err
 	}
 
-	return true
+	f.trunks = make([]byte, v1NumTrunks)
+	_, err = f.file.ReadAt(f.trunks, v1TrunksOffset)
+	return err
+}
+
+func (f *File) readV2(size int64) error {
+	version, err := f.readUint(2, versionOffset)
+	if err != nil {
+		return err
+	}
+	if version != Version2 {
+		return ErrUnknownVersion
+	}
+	f.version = Version2
+
+	trunkSize, err := f.readUint(4, trunkSizeOffset)
+	if err != nil {
+		return err
+	}
+	if trunkSize == 0 {
+		return ErrCorrupted
+	}
+	f.trunkSize = int64(trunkSize)
+
+	err = f.readCommon(fullSizeOffset, lastModifiedOffset, statesOffset)
+	if err != nil {
+		return err
+	}
+
+	f.Validator, err = f.readString(validatorLenOffset, maxValidatorLen)
+	if err != nil {
+		return err
+	}
+	f.SourceUrl, err = f.readString(sourceUrlLenOffset, maxSourceUrlLen)
+	if err != nil {
+		return err
+	}
+
+	bitmapLen, err := f.readUint(4, bitmapLenOffset)
+	if err != nil {
+		return err
+	}
+	f.bitmapLen = int64(bitmapLen)
+
+	bodyOffset, err := f.readUint(8, bodyOffsetOffset)
+	if err != nil {
+		return err
+	}
+	f.bodyOffset = int64(bodyOffset)
+	if f.bodyOffset < bitmapOffset+f.bitmapLen || f.bodyOffset > size {
+		return ErrCorrupted
+	}
+
+	bitmap := make([]byte, f.bitmapLen)
+	_, err = f.file.ReadAt(bitmap, bitmapOffset)
+	if err != nil {
+		return err
+	}
+	f.trunks = make([]byte, f.bitmapLen*8)
+	for i := range f.trunks {
+		if bitmap[i/8]&(1<<(i%8)) != 0 {
+			f.trunks[i] = 1
+		}
+	}
+
+	return nil
 }
@@ -1,30 +1,60 @@
 package trunk
 
 import (
+	"errors"
+	"io"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/wzhqwq/VRCDancePreloader/internal/utils"
 )
 
-// 16KB per trunk
-const bytesPerTrunk = 1024 * 16
+// v1 files have 16KB per trunk and 256MB capacity
+const v1TrunkSize = 1024 * 16
+const v1Capacity = 1024 * 1024 * 256
 
-// 256MB capacity
-// It's enough for a dance video. If a file exceed this size, we will fall back to legacy cache
-const capacity = 1024 * 1024 * 256
+// trunk size of new files, the size of v2 files is not limited
+var defaultTrunkSize atomic.Int64
+
+func init() {
+	defaultTrunkSize.Store(1024 * 16)
+}
 
 var logger = utils.NewLogger("Cache File")
 
+var ErrUnknownVersion = errors.New("unknown trunk file version")
+var ErrReadOnly = errors.New("trunk file is opened read-only")
+var ErrBeyondCapacity = errors.New("writing beyond the trunks of the file")
+
+// SetTrunkSize changes the trunk size of files created later
+func SetTrunkSize(size int64) {
+	if size < 1024 {
+		size = 1024
+	}
+	defaultTrunkSize.Store(size)
+}
+
 type File struct {
 	file         *os.File
 	trunks       []byte
 	LastModified time.Time
 	FullSize     int64
 
+	// only stored by v2 files
+	Validator string
+	SourceUrl string
+
+	// layout
+	version    int
+	trunkSize  int64
+	bitmapLen  int64
+	bodyOffset int64
+
 	// states
-	Completed bool
+	completed atomic.Bool
+	readOnly  bool
 
 	readerWg sync.WaitGroup
 	a        sync.Once
@@ -39,10 +69,11 @@ func NewTrunkFile(baseName string) *File {
 
 	f := &File{
 		file:         dlf,
-		trunks:       make([]byte, numTrunks),
 		LastModified: time.Time{},
 	}
 	if !f.tryRead() {
+		// the trunk size is chosen once, it's kept in the file since then
+		f.trunkSize = defaultTrunkSize.Load()
 		if !f.tryCreate() {
 			return nil
 		}
@@ -50,8 +81,37 @@ func NewTrunkFile(baseName string) *File {
 	return f
 }
 
+// OpenReadOnly opens an existing trunk file for reading, any modification is refused
+func OpenReadOnly(baseName string) *File {
+	file, err := os.Open(baseName + ".vrcdp")
+	if err != nil {
+		logger.ErrorLn("Failed to open cache file:", err)
+		return nil
+	}
+
+	f := &File{
+		file:     file,
+		readOnly: true,
+	}
+	if !f.tryRead() {
+		file.Close()
+		return nil
+	}
+	return f
+}
+
+func (f *File) ReadOnly() bool {
+	return f.readOnly
+}
+
 func (f *File) AppendTo(frag *Fragment, data []byte) error {
-	offset := bodyOffset + frag.End()
+	if f.readOnly {
+		return ErrReadOnly
+	}
+	if frag.End()+int64(len(data)) > f.capacity() {
+		return ErrBeyondCapacity
+	}
+	offset := f.bodyOffset + frag.End()
 
 	n, err := f.file.WriteAt(data, offset)
 	if err != nil {
@@ -69,7 +129,7 @@ func (f *File) ReadAt(p []byte, off int64) (n int, err error) {
 	f.readerWg.Add(1)
 	defer f.readerWg.Done()
 
-	offset := bodyOffset + off
+	offset := f.bodyOffset + off
 	return f.file.ReadAt(p, offset)
 }
 
@@ -83,21 +143,38 @@ func (f *File) Close() error {
 }
 
 func (f *File) ClearTrunks() {
+	if f.readOnly {
+		return
+	}
 	// remove complete flag
-	f.Completed = false
+	f.completed.Store(false)
 	f.writeStates()
 	// fill zeros
-	for i := 0; i < numTrunks; i++ {
+	for i := range f.trunks {
 		f.trunks[i] = 0
 	}
 	f.writeTrunks()
 }
 
 func (f *File) Init(contentLength int64, lastModified time.Time) {
+	if f.readOnly {
+		return
+	}
 	f.FullSize = contentLength
 	f.LastModified = lastModified
 
-	err := f.file.Truncate(bodyOffset + contentLength)
+	if f.needsLayout(contentLength) {
+		if !f.IsCompleted() && f.DownloadedBytes() == 0 {
+			// nothing to keep, so the file is arranged again, upgrading v1 files as well
+			if f.tryCreate() {
+				return
+			}
+		} else if !f.growBitmap(contentLength) {
+			logger.WarnLnf("Trunks of %s cannot cover %d bytes, the rest won't be written", f.file.Name(), contentLength)
+		}
+	}
+
+	err := f.file.Truncate(f.bodyOffset + contentLength)
 	if err != nil {
 		logger.ErrorLn("Failed to truncate trunk file:", err)
 	}
@@ -106,10 +183,141 @@ func (f *File) Init(contentLength int64, lastModified time.Time) {
 	f.writeLastModifiedTime()
 }
 
+// capacity is the body size covered by the trunks
+func (f *File) capacity() int64 {
+	return int64(len(f.trunks)) * f.trunkSize
+}
+
+// needsLayout reports whether the bitmap cannot cover the whole body
+func (f *File) needsLayout(contentLength int64) bool {
+	if f.version == Version1 {
+		return true
+	}
+	return bitmapLenFor(contentLength, f.trunkSize) > f.bitmapLen
+}
+
+// SetSource records the validator and the url of the remote file, it's ignored by v1 files
+func (f *File) SetSource(url, validator string) {
+	if f.readOnly || f.version == Version1 || (f.SourceUrl == url && f.Validator == validator) {
+		return
+	}
+	f.SourceUrl = url
+	f.Validator = validator
+	f.writeString(validator, validatorLenOffset, maxValidatorLen)
+	f.writeString(url, sourceUrlLenOffset, maxSourceUrlLen)
+}
+
+func (f *File) Version() int {
+	return f.version
+}
+
+func (f *File) TrunkSize() int64 {
+	return f.trunkSize
+}
+
 func (f *File) MarkCompleted() {
-	f.Completed = true
+	if f.readOnly {
+		return
+	}
+	f.completed.Store(true)
 	f.writeStates()
 }
+func (f *File) IsCompleted() bool {
+	return f.completed.Load()
+}
 func (f *File) IsSuffix(frag *Fragment) bool {
 	return f.FullSize > 0 && frag.End() >= f.FullSize
 }
+
+// Info is the header information of a trunk file
+type Info struct {
+	Version         int
+	TrunkSize       int64
+	FullSize        int64
+	LastModified    time.Time
+	Completed       bool
+	DownloadedBytes int64
+	Validator       string
+	SourceUrl       string
+}
+
+var ErrCorrupted = errors.New("corrupted trunk file")
+
+// Stat reads the header of a trunk file without creating or modifying it
+func Stat(path string) (*Info, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	f := &File{
+		file: file,
+	}
+	if !f.tryRead() {
+		return nil, ErrCorrupted
+	}
+
+	return &Info{
+		Version:         f.version,
+		TrunkSize:       f.trunkSize,
+		FullSize:        f.FullSize,
+		LastModified:    f.LastModified,
+		Completed:       f.IsCompleted(),
+		DownloadedBytes: f.DownloadedBytes(),
+		Validator:       f.Validator,
+		SourceUrl:       f.SourceUrl,
+	}, nil
+}
+
+// DownloadedBytes estimates the downloaded bytes by filled trunks
+func (f *File) DownloadedBytes() int64 {
+	if f.IsCompleted() {
+		return f.FullSize
+	}
+
+	filled := int64(0)
+	for _, b := range f.trunks {
+		if b != 0 {
+			filled++
+		}
+	}
+	return min(filled*f.trunkSize, f.FullSize)
+}
+
+var ErrIncomplete = errors.New("trunk file is not completed")
+
+// BodyReader reads the body of a completed trunk file
+type BodyReader struct {
+	*io.SectionReader
+	file *os.File
+}
+
+func (r *BodyReader) Close() error {
+	return r.file.Close()
+}
+
+// OpenBody opens the body of a completed trunk file for reading
+func OpenBody(path string) (*BodyReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &File{
+		file: file,
+	}
+	if !f.tryRead() {
+		file.Close()
+		return nil, ErrCorrupted
+	}
+	if !f.IsCompleted() {
+		file.Close()
+		return nil, ErrIncomplete
+	}
+
+	return &BodyReader{
+		SectionReader: io.NewSectionReader(file, f.bodyOffset, f.FullSize),
+		file:          file,
+	}, nil
+}
@@ -1,9 +1,9 @@
 package trunk
 
-func trunkRangeToFragment(start, length int) *Fragment {
+func (f *File) trunkRangeToFragment(start, length int) *Fragment {
 	return &Fragment{
-		Start:  int64(start) * bytesPerTrunk,
-		Length: int64(length) * bytesPerTrunk,
+		Start:  int64(start) * f.trunkSize,
+		Length: int64(length) * f.trunkSize,
 	}
 }
 
@@ -13,7 +13,7 @@ func (f *File) ToFragments() []*Fragment {
 	for i, b := range f.trunks {
 		if b == 0 {
 			if startIndex != -1 {
-				fragments = append(fragments, trunkRangeToFragment(startIndex, i-startIndex))
+				fragments = append(fragments, f.trunkRangeToFragment(startIndex, i-startIndex))
 				startIndex = -1
 			}
 			continue
@@ -24,7 +24,7 @@ func (f *File) ToFragments() []*Fragment {
 		}
 	}
 	if startIndex != -1 {
-		fragments = append(fragments, trunkRangeToFragment(startIndex, len(f.trunks)-startIndex))
+		fragments = append(fragments, f.trunkRangeToFragment(startIndex, len(f.trunks)-startIndex))
 	}
 	if len(fragments) == 0 {
 		return []*Fragment{
@@ -35,8 +35,9 @@ func (f *File) ToFragments() []*Fragment {
 }
 
 func (f *File) FillTrunks(frag *Fragment) {
-	fillStart := (frag.Start + bytesPerTrunk - 1) / bytesPerTrunk
-	fillEnd := frag.End() / bytesPerTrunk
+	fillStart := (frag.Start + f.trunkSize - 1) / f.trunkSize
+	// writing beyond the trunks is refused, so the end is always covered
+	fillEnd := min(frag.End()/f.trunkSize, int64(len(f.trunks)))
 	trunksChanged := false
 
 	for i := fillStart; i < fillEnd; i++ {
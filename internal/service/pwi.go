@@ -5,6 +5,7 @@ import (
 	"errors"
 
 	"github.com/google/uuid"
+	"github.com/wzhqwq/VRCDancePreloader/internal/exposure"
 	"github.com/wzhqwq/VRCDancePreloader/internal/persistence"
 	"github.com/wzhqwq/VRCDancePreloader/internal/utils"
 
@@ -16,6 +17,19 @@ import (
 var currentPWIServer *PWIServer
 var currentWorldID = ""
 
+const pwiPort = 22500
+
+// worlds can't send credentials, so only the binding and the allow list apply to PWI
+var pwiGuard = exposure.NewGuard(exposure.Settings{Bind: exposure.BindLoopback})
+
+// SetPWIExposure decides who can reach the PWI server, it's applied when the server starts
+func SetPWIExposure(settings exposure.Settings) {
+	pwiGuard = exposure.NewGuard(exposure.Settings{
+		Bind:      settings.Bind,
+		AllowList: settings.AllowList,
+	})
+}
+
 var pwiLogger = utils.NewLogger("PWI")
 
 type PWIConnection struct {
@@ -56,8 +70,8 @@ func NewPWIServer() *PWIServer {
 
 	s := &PWIServer{
 		Server: http.Server{
-			Addr:    ":22500",
-			Handler: mux,
+			Addr:    pwiGuard.Addr(pwiPort),
+			Handler: pwiGuard.Wrap(mux),
 		},
 		connections: make(map[string]*PWIConnection),
 		store:       persistence.GetLocalWorlds(),
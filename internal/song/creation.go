@@ -98,7 +98,7 @@ func CreateUnknownSong() *PreloadedSong {
 
 	ret.InfoNa = true
 	ret.Unknown = true
-	ret.sm.DownloadStatus = NotAvailable
+	ret.sm.setDownloadStatus(NotAvailable)
 
 	return ret
 }
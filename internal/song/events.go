@@ -44,3 +44,8 @@ func (ps *PreloadedSong) notifyTimeChange(routine bool) {
 		ps.notifyLazySubscribers(TimeChange)
 	}
 }
+
+// NotifyOfflineChange refreshes the status when the song starts or stops being served offline
+func (ps *PreloadedSong) NotifyOfflineChange() {
+	ps.notifyStatusChange()
+}
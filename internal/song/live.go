@@ -38,7 +38,7 @@ func (ps *PreloadedSong) LiveFullInfo() LiveFullInfo {
 		Group:  basic.Group,
 
 		PlayStatus:     string(ps.sm.PlayStatus),
-		DownloadStatus: string(ps.sm.DownloadStatus),
+		DownloadStatus: string(ps.sm.GetDownloadStatus()),
 
 		Duration:   int(ps.Duration.Milliseconds()),
 		TimePassed: max(0, int(ps.TimePassed.Milliseconds())),
@@ -65,7 +65,7 @@ func (ps *PreloadedSong) LiveStatusChange() LiveStatusChange {
 	return LiveStatusChange{
 		ID: ps.ID,
 
-		DownloadStatus: string(ps.sm.DownloadStatus),
+		DownloadStatus: string(ps.sm.GetDownloadStatus()),
 
 		Error: err,
 	}
@@ -77,7 +77,7 @@ func (ps *PreloadedSong) GetSongId() string {
 	return "unknown"
 }
 func (ps *PreloadedSong) GetPreloadStatus() DownloadStatus {
-	return ps.sm.DownloadStatus
+	return ps.sm.GetDownloadStatus()
 }
 func (ps *PreloadedSong) DownloadInstantly(complete bool, ctx context.Context) (cache.Entry, error) {
 	err := ps.sm.DownloadInstantly(complete)
@@ -91,7 +91,7 @@ func (ps *PreloadedSong) DownloadInstantly(complete bool, ctx context.Context) (
 	}
 
 	// reference the cache entry until request closed
-	entry, err := cache.OpenCacheEntry(ps.GetSongId(), logger)
+	entry, err := cache.OpenCacheEntry(ctx, ps.GetSongId(), logger)
 	if err != nil {
 		return nil, err
 	}
@@ -181,7 +181,7 @@ func (ps *PreloadedSong) RemoveFromList() {
 	ps.sm.RemoveFromList()
 }
 func (ps *PreloadedSong) UpdateStartPlayingEta(eta time.Duration) {
-	if ps.sm.DownloadStatus == Downloading {
+	if ps.sm.GetDownloadStatus() == Downloading {
 		download.UpdateRequestEta(ps.GetSongId(), time.Now().Add(eta), ps.Duration)
 	}
 }
@@ -6,6 +6,7 @@ import (
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/theme"
 	"github.com/eduardolat/goeasyi18n"
+	"github.com/wzhqwq/VRCDancePreloader/internal/cache"
 	"github.com/wzhqwq/VRCDancePreloader/internal/i18n"
 	"github.com/wzhqwq/VRCDancePreloader/internal/utils"
 )
@@ -99,7 +100,7 @@ func (ps *PreloadedSong) GetProgressInfo() PreloadedSongProgressInfo {
 		Total:      ps.TotalSize,
 		Downloaded: ps.DownloadedSize,
 
-		IsDownloading: ps.sm.DownloadStatus == Downloading,
+		IsDownloading: ps.sm.GetDownloadStatus() == Downloading,
 	}
 }
 
@@ -155,8 +156,9 @@ type PreloadedSongStatusInfo struct {
 }
 
 func (ps *PreloadedSong) GetStatusInfo() PreloadedSongStatusInfo {
+	downloadStatus := ps.sm.GetDownloadStatus()
 	var color fyne.ThemeColorName
-	switch ps.sm.DownloadStatus {
+	switch downloadStatus {
 	case Initial, Removed, NotAvailable, Disabled:
 		color = theme.ColorNamePlaceHolder
 	case Pending, CoolingDown:
@@ -168,8 +170,14 @@ func (ps *PreloadedSong) GetStatusInfo() PreloadedSongStatusInfo {
 	case Failed:
 		color = theme.ColorNameError
 	}
+	status := i18n.T(fmt.Sprintf("status_%s", downloadStatus))
+	if downloadStatus == Downloaded && cache.IsServedOffline(ps.GetSongId()) {
+		// the remote server is unavailable, so the cached copy is not confirmed up to date
+		status = i18n.T("status_served_offline")
+		color = theme.ColorNameWarning
+	}
 	return PreloadedSongStatusInfo{
-		Status: i18n.T(fmt.Sprintf("status_%s", ps.sm.DownloadStatus)),
+		Status: status,
 		Color:  color,
 
 		PreloadError: ps.PreloadError,
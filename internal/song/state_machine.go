@@ -1,6 +1,7 @@
 package song
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"sync"
@@ -13,7 +14,7 @@ import (
 
 // StateMachine is the state machine for a song
 type StateMachine struct {
-	DownloadStatus DownloadStatus
+	downloadStatus DownloadStatus
 	PlayStatus     PlayStatus
 
 	ps *PreloadedSong
@@ -28,11 +29,13 @@ type StateMachine struct {
 	// locks
 	timeMutex          sync.Mutex
 	startDownloadMutex sync.Mutex
+	// the download loop, the hijack requests and the playlist all change the download status
+	statusMutex sync.RWMutex
 }
 
 func NewSongStateMachine() *StateMachine {
 	sm := &StateMachine{
-		DownloadStatus: Initial,
+		downloadStatus: Initial,
 		PlayStatus:     Queued,
 		syncTimeCh:     make(chan time.Duration, 1),
 	}
@@ -47,7 +50,7 @@ func (sm *StateMachine) DownloadInstantly(waitComplete bool) error {
 		sm.completeSongWg.Wait()
 	}
 
-	switch sm.DownloadStatus {
+	switch sm.GetDownloadStatus() {
 	case Removed:
 		return fmt.Errorf("download removed")
 	case Failed:
@@ -64,12 +67,12 @@ func (sm *StateMachine) StartDownload() {
 		return
 	}
 
-	if sm.DownloadStatus == Initial {
+	if sm.GetDownloadStatus() == Initial {
 		// Call OpenCacheEntry to increase the reference count
 		// We will release it in RemoveFromList
-		entry, err := cache.OpenCacheEntry(sm.ps.GetSongId(), activeSongLogger)
+		entry, err := cache.OpenCacheEntry(context.Background(), sm.ps.GetSongId(), activeSongLogger)
 		if err != nil {
-			sm.DownloadStatus = NotAvailable
+			sm.setDownloadStatus(NotAvailable)
 			sm.ps.notifyStatusChange()
 			return
 		}
@@ -77,15 +80,17 @@ func (sm *StateMachine) StartDownload() {
 	}
 
 	if !sm.IsDownloadLoopStarted() {
-		sm.DownloadStatus = Pending
+		sm.setDownloadStatus(Pending)
 		sm.ps.notifyStatusChange()
 
 		task := download.Download(sm.ps.GetSongId())
 		if task == nil {
-			sm.DownloadStatus = NotAvailable
+			sm.setDownloadStatus(NotAvailable)
 			sm.ps.notifyStatusChange()
 			return
 		}
+		// added here so that DownloadInstantly never waits before the loop starts
+		sm.completeSongWg.Add(1)
 		go sm.StartDownloadLoop(task)
 	}
 }
@@ -95,16 +100,29 @@ func (sm *StateMachine) Prioritize() {
 	}
 }
 
+func (sm *StateMachine) GetDownloadStatus() DownloadStatus {
+	sm.statusMutex.RLock()
+	defer sm.statusMutex.RUnlock()
+	return sm.downloadStatus
+}
+func (sm *StateMachine) setDownloadStatus(s DownloadStatus) {
+	sm.statusMutex.Lock()
+	defer sm.statusMutex.Unlock()
+	sm.downloadStatus = s
+}
 func (sm *StateMachine) SwitchDownloadStatus(s DownloadStatus) {
-	if sm.DownloadStatus == s {
+	sm.statusMutex.Lock()
+	if sm.downloadStatus == s {
+		sm.statusMutex.Unlock()
 		return
 	}
-	sm.DownloadStatus = s
+	sm.downloadStatus = s
+	sm.statusMutex.Unlock()
 	sm.ps.notifyStatusChange()
 }
 
+// StartDownloadLoop follows the task until it is done, the caller adds it to completeSongWg
 func (sm *StateMachine) StartDownloadLoop(task *download.Task) {
-	sm.completeSongWg.Add(1)
 	defer sm.completeSongWg.Done()
 
 	sm.ps.PreloadError = nil
@@ -119,47 +137,48 @@ func (sm *StateMachine) StartDownloadLoop(task *download.Task) {
 	for {
 		select {
 		case change := <-ch.Channel:
+			state := task.State()
 			switch change {
 			case download.State:
-				if task.Done {
-					sm.DownloadStatus = Downloaded
-					sm.ps.TotalSize = task.TotalSize
-					sm.ps.DownloadedSize = task.DownloadedSize
+				if state.Done {
+					sm.setDownloadStatus(Downloaded)
+					sm.ps.TotalSize = state.TotalSize
+					sm.ps.DownloadedSize = state.DownloadedSize
 					sm.ps.notifySubscribers(ProgressChange)
 					sm.ps.notifyStatusChange()
 					return
 				}
-				if task.Error != nil {
-					if errors.Is(task.Error, cache.ErrNotSupported) {
+				if state.Error != nil {
+					if errors.Is(state.Error, cache.ErrNotSupported) {
 						sm.SwitchDownloadStatus(NotAvailable)
 						download.CancelDownload(sm.ps.GetSongId())
 						return
 					}
-					if errors.Is(task.Error, download.ErrCanceled) {
+					if errors.Is(state.Error, download.ErrCanceled) {
 						return
 					}
 
-					sm.DownloadStatus = Failed
-					sm.ps.PreloadError = task.Error
+					sm.setDownloadStatus(Failed)
+					sm.ps.PreloadError = state.Error
 					sm.ps.notifyStatusChange()
 					download.Retry(task)
 				} else {
 					sm.ps.PreloadError = nil
 
-					if task.Pending {
+					if state.Pending {
 						sm.SwitchDownloadStatus(Pending)
-					} else if task.Cooling {
+					} else if state.Cooling {
 						sm.SwitchDownloadStatus(CoolingDown)
-					} else if task.Requesting {
+					} else if state.Requesting {
 						sm.SwitchDownloadStatus(Requesting)
 					} else {
 						// Otherwise, it's downloading
-						sm.ps.TotalSize = task.TotalSize
+						sm.ps.TotalSize = state.TotalSize
 						sm.SwitchDownloadStatus(Downloading)
 					}
 				}
 			case download.Progress:
-				sm.ps.DownloadedSize = task.DownloadedSize
+				sm.ps.DownloadedSize = state.DownloadedSize
 				sm.ps.notifySubscribers(ProgressChange)
 				lazy.Change()
 			}
@@ -196,7 +215,7 @@ func (sm *StateMachine) PlaySong() {
 }
 
 func (sm *StateMachine) CancelPlayingLoop() {
-	if sm.DownloadStatus == Removed {
+	if sm.GetDownloadStatus() == Removed {
 		return
 	}
 	if sm.PlayStatus != Queued {
@@ -237,7 +256,7 @@ func (sm *StateMachine) StartPlayingLoop() {
 }
 
 func (sm *StateMachine) RemoveFromList() {
-	sm.DownloadStatus = Removed
+	sm.setDownloadStatus(Removed)
 	if sm.IsPlaying() {
 		sm.PlayStatus = Ended
 		if sm.ps.TimePassed > 20*time.Second {
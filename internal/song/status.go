@@ -40,13 +40,16 @@ const (
 )
 
 func (sm *StateMachine) IsDownloadLoopStarted() bool {
-	return sm.DownloadStatus == Pending || sm.DownloadStatus == CoolingDown || sm.DownloadStatus == Requesting || sm.DownloadStatus == Downloading
+	s := sm.GetDownloadStatus()
+	return s == Pending || s == CoolingDown || s == Requesting || s == Downloading
 }
 func (sm *StateMachine) IsDownloadNeeded() bool {
-	return sm.DownloadStatus != Downloaded && sm.DownloadStatus != Removed && sm.DownloadStatus != NotAvailable
+	s := sm.GetDownloadStatus()
+	return s != Downloaded && s != Removed && s != NotAvailable
 }
 func (sm *StateMachine) CanPreload() bool {
-	return sm.DownloadStatus != NotAvailable && (sm.DownloadStatus == Initial || sm.DownloadStatus == Failed)
+	s := sm.GetDownloadStatus()
+	return s != NotAvailable && (s == Initial || s == Failed)
 }
 func (sm *StateMachine) IsPlaying() bool {
 	return sm.PlayStatus == Playing || sm.PlayStatus == SyncPlaying
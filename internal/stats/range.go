@@ -0,0 +1,68 @@
+package stats
+
+import (
+	"time"
+)
+
+// A dance day starts at 6:00, so a night dancing past midnight counts as one day
+const dayStartOffset = 6 * time.Hour
+
+var endOfTime = time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Range is [From, To) of order times
+type Range struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// dayOf gives the start of the dance day t belongs to
+func dayOf(t time.Time) time.Time {
+	t = t.Add(-dayStartOffset)
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location()).Add(dayStartOffset)
+}
+
+// weekOf gives the start of the week (from Monday) t belongs to
+func weekOf(t time.Time) time.Time {
+	day := dayOf(t)
+	offset := (int(day.Weekday()) + 6) % 7
+	return day.AddDate(0, 0, -offset)
+}
+
+func monthOf(t time.Time) time.Time {
+	day := dayOf(t)
+	return time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, day.Location()).Add(dayStartOffset)
+}
+
+// LastDays covers today and the n-1 dance days before
+func LastDays(n int) Range {
+	now := time.Now()
+	return Range{From: dayOf(now).AddDate(0, 0, 1-n), To: endOfTime}
+}
+
+// Year covers the dance days of year in local time
+func Year(year int) Range {
+	return Range{
+		From: time.Date(year, 1, 1, 0, 0, 0, 0, time.Local).Add(dayStartOffset),
+		To:   time.Date(year+1, 1, 1, 0, 0, 0, 0, time.Local).Add(dayStartOffset),
+	}
+}
+
+func AllTime() Range {
+	return Range{From: time.Unix(0, 0), To: endOfTime}
+}
+
+// RangeByName resolves the ranges offered in the GUI and the live server: 7d, 30d, year and all
+func RangeByName(name string) (Range, bool) {
+	switch name {
+	case "7d":
+		return LastDays(7), true
+	case "30d":
+		return LastDays(30), true
+	case "year":
+		return Year(time.Now().Add(-dayStartOffset).Year()), true
+	case "all":
+		return AllTime(), true
+	}
+	return Range{}, false
+}
@@ -0,0 +1,31 @@
+package stats
+
+import (
+	"time"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/song/raw_song"
+	"github.com/wzhqwq/VRCDancePreloader/internal/utils"
+)
+
+// Songs without a known length are assumed to be this long, unless the next order comes earlier
+const defaultSongDuration = 4 * time.Minute
+
+// songInfoOf gives the group and the length of a song from the song lists, empty if it's not in any of them
+func songInfoOf(id string) (group string, duration time.Duration) {
+	if pypyId, isPypy := utils.CheckIdIsPyPy(id); isPypy {
+		if song, ok := raw_song.FindPyPySong(pypyId); ok {
+			return song.GetGroupName(), time.Duration(song.End) * time.Second
+		}
+	}
+	if wannaId, isWanna := utils.CheckIdIsWanna(id); isWanna {
+		if song, ok := raw_song.FindWannaSong(wannaId); ok {
+			return song.Group, time.Duration(song.End) * time.Second
+		}
+	}
+	if duduId, isDuDu := utils.CheckIdIsDuDu(id); isDuDu {
+		if song, ok := raw_song.FindDuDuSong(duduId); ok {
+			return song.Group, time.Duration(song.End) * time.Second
+		}
+	}
+	return "", 0
+}
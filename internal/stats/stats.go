@@ -0,0 +1,254 @@
+package stats
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/persistence"
+)
+
+// Stats are computed from the orders of dance records. How long a song is danced isn't recorded, so it's estimated
+// by the length of the song, cut short if the next order of the same record comes earlier.
+
+type Count struct {
+	Name    string  `json:"name"`
+	Plays   int     `json:"plays"`
+	Minutes float64 `json:"minutes"`
+}
+
+type SongCount struct {
+	ID      string  `json:"id"`
+	Title   string  `json:"title"`
+	Group   string  `json:"group"`
+	Plays   int     `json:"plays"`
+	Minutes float64 `json:"minutes"`
+}
+
+// Period is a day, a week or a month starting at Start
+type Period struct {
+	Start   time.Time `json:"start"`
+	Plays   int       `json:"plays"`
+	Minutes float64   `json:"minutes"`
+}
+
+// Streak is a run of consecutive dance days
+type Streak struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Days  int       `json:"days"`
+}
+
+type Summary struct {
+	Range
+
+	Plays     int     `json:"plays"`
+	Songs     int     `json:"songs"`
+	DanceDays int     `json:"dance_days"`
+	Minutes   float64 `json:"minutes"`
+
+	TopSongs  []SongCount `json:"top_songs"`
+	TopAdders []Count     `json:"top_adders"`
+	TopGroups []Count     `json:"top_groups"`
+	// songs danced for the first time in the range
+	NewSongs []SongCount `json:"new_songs"`
+	Rooms    []Count     `json:"rooms"`
+
+	Daily  []Period `json:"daily"`
+	Weekly []Period `json:"weekly"`
+
+	LongestStreak Streak `json:"longest_streak"`
+	// the streak reaching today or yesterday
+	CurrentStreak Streak `json:"current_streak"`
+}
+
+type play struct {
+	persistence.RecordedOrder
+	group    string
+	duration time.Duration
+}
+
+// toPlays estimates how long each order is danced, orders are ordered by time
+func toPlays(orders []persistence.RecordedOrder) []play {
+	plays := make([]play, len(orders))
+	lastOfRecord := make(map[int]int)
+	for i, order := range orders {
+		group, duration := songInfoOf(order.ID)
+		if duration <= 0 {
+			duration = defaultSongDuration
+		}
+		plays[i] = play{RecordedOrder: order, group: group, duration: duration}
+
+		if last, ok := lastOfRecord[order.RecordID]; ok {
+			gap := order.Time.Sub(plays[last].Time)
+			plays[last].duration = max(0, min(plays[last].duration, gap))
+		}
+		lastOfRecord[order.RecordID] = i
+	}
+	return plays
+}
+
+func toMinutes(d time.Duration) float64 {
+	return math.Round(d.Minutes()*10) / 10
+}
+
+type counter struct {
+	plays    int
+	duration time.Duration
+}
+
+func (c *counter) add(p play) {
+	c.plays++
+	c.duration += p.duration
+}
+
+func countBy(plays []play, key func(p play) string) map[string]*counter {
+	counters := make(map[string]*counter)
+	for _, p := range plays {
+		k := key(p)
+		if k == "" {
+			continue
+		}
+		if _, ok := counters[k]; !ok {
+			counters[k] = &counter{}
+		}
+		counters[k].add(p)
+	}
+	return counters
+}
+
+// rank sorts counters by plays and then minutes, keeping the first top ones (all if top <= 0)
+func rank(counters map[string]*counter, top int) []Count {
+	counts := make([]Count, 0, len(counters))
+	for name, c := range counters {
+		counts = append(counts, Count{Name: name, Plays: c.plays, Minutes: toMinutes(c.duration)})
+	}
+	slices.SortFunc(counts, func(a, b Count) int {
+		return cmp.Or(cmp.Compare(b.Plays, a.Plays), cmp.Compare(b.Minutes, a.Minutes), cmp.Compare(a.Name, b.Name))
+	})
+	if top > 0 && len(counts) > top {
+		counts = counts[:top]
+	}
+	return counts
+}
+
+// periodsBy groups plays by the period they start in, in time order
+func periodsBy(plays []play, periodOf func(t time.Time) time.Time) []Period {
+	counters := make(map[int64]*counter)
+	starts := make(map[int64]time.Time)
+	for _, p := range plays {
+		start := periodOf(p.Time)
+		k := start.Unix()
+		if _, ok := counters[k]; !ok {
+			counters[k] = &counter{}
+			starts[k] = start
+		}
+		counters[k].add(p)
+	}
+
+	periods := make([]Period, 0, len(counters))
+	for k, c := range counters {
+		periods = append(periods, Period{Start: starts[k], Plays: c.plays, Minutes: toMinutes(c.duration)})
+	}
+	slices.SortFunc(periods, func(a, b Period) int {
+		return a.Start.Compare(b.Start)
+	})
+	return periods
+}
+
+// streaksOf finds the longest streak and the one reaching today, days are in time order
+func streaksOf(days []Period, today time.Time) (longest, current Streak) {
+	var streak Streak
+	for _, day := range days {
+		if streak.Days > 0 && day.Start.Equal(streak.End.AddDate(0, 0, 1)) {
+			streak.End = day.Start
+			streak.Days++
+		} else {
+			streak = Streak{Start: day.Start, End: day.Start, Days: 1}
+		}
+		if streak.Days > longest.Days {
+			longest = streak
+		}
+	}
+	if streak.Days > 0 && !streak.End.Before(today.AddDate(0, 0, -1)) {
+		current = streak
+	}
+	return
+}
+
+func songCounts(plays []play) map[string]*SongCount {
+	songs := make(map[string]*SongCount)
+	for _, p := range plays {
+		s, ok := songs[p.ID]
+		if !ok {
+			s = &SongCount{ID: p.ID, Group: p.group}
+			songs[p.ID] = s
+		}
+		// the latest title wins, earlier ones may be incomplete
+		if p.Title != "" {
+			s.Title = p.Title
+		}
+		s.Plays++
+		s.Minutes += p.duration.Minutes()
+	}
+	for _, s := range songs {
+		s.Minutes = math.Round(s.Minutes*10) / 10
+	}
+	return songs
+}
+
+func summarize(r Range, plays []play, newSongIDs []string, top int, now time.Time) *Summary {
+	s := &Summary{Range: r, Plays: len(plays)}
+
+	var total time.Duration
+	for _, p := range plays {
+		total += p.duration
+	}
+	s.Minutes = toMinutes(total)
+
+	songs := songCounts(plays)
+	s.Songs = len(songs)
+	s.TopSongs = make([]SongCount, 0, len(songs))
+	for _, song := range songs {
+		s.TopSongs = append(s.TopSongs, *song)
+	}
+	slices.SortFunc(s.TopSongs, func(a, b SongCount) int {
+		return cmp.Or(cmp.Compare(b.Plays, a.Plays), cmp.Compare(b.Minutes, a.Minutes), cmp.Compare(a.ID, b.ID))
+	})
+	if top > 0 && len(s.TopSongs) > top {
+		s.TopSongs = s.TopSongs[:top]
+	}
+
+	s.NewSongs = make([]SongCount, 0, len(newSongIDs))
+	for _, id := range newSongIDs {
+		if song, ok := songs[id]; ok {
+			s.NewSongs = append(s.NewSongs, *song)
+		}
+	}
+
+	s.TopAdders = rank(countBy(plays, func(p play) string { return p.Username }), top)
+	s.TopGroups = rank(countBy(plays, func(p play) string { return p.group }), top)
+	s.Rooms = rank(countBy(plays, func(p play) string { return p.DanceRoom }), 0)
+
+	s.Daily = periodsBy(plays, dayOf)
+	s.Weekly = periodsBy(plays, weekOf)
+	s.DanceDays = len(s.Daily)
+	s.LongestStreak, s.CurrentStreak = streaksOf(s.Daily, dayOf(now))
+
+	return s
+}
+
+// Compute summarizes the orders in r, the rankings are cut to top entries (all if top <= 0)
+func Compute(r Range, top int) (*Summary, error) {
+	records := persistence.GetLocalRecords()
+	orders, err := records.GetOrdersBetween(r.From, r.To)
+	if err != nil {
+		return nil, err
+	}
+	newSongIDs, err := records.GetSongsFirstPlayedBetween(r.From, r.To)
+	if err != nil {
+		return nil, err
+	}
+	return summarize(r, toPlays(orders), newSongIDs, top, time.Now()), nil
+}
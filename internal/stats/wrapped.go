@@ -0,0 +1,59 @@
+package stats
+
+import (
+	"time"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/persistence"
+)
+
+const wrappedTop = 5
+
+// Wrapped is the summary of a year, with the highlights
+type Wrapped struct {
+	Year int `json:"year"`
+	Summary
+
+	FirstSong    *persistence.Order `json:"first_song"`
+	BusiestDay   *Period            `json:"busiest_day"`
+	BusiestMonth *Period            `json:"busiest_month"`
+	Monthly      []Period           `json:"monthly"`
+}
+
+func busiestOf(periods []Period) *Period {
+	var busiest *Period
+	for i, p := range periods {
+		if busiest == nil || p.Minutes > busiest.Minutes {
+			busiest = &periods[i]
+		}
+	}
+	return busiest
+}
+
+func wrap(year int, plays []play, newSongIDs []string, now time.Time) *Wrapped {
+	w := &Wrapped{
+		Year:    year,
+		Summary: *summarize(Year(year), plays, newSongIDs, wrappedTop, now),
+		Monthly: periodsBy(plays, monthOf),
+	}
+	if len(plays) > 0 {
+		w.FirstSong = &plays[0].Order
+	}
+	w.BusiestDay = busiestOf(w.Daily)
+	w.BusiestMonth = busiestOf(w.Monthly)
+	return w
+}
+
+// ComputeWrapped summarizes the dance days of year
+func ComputeWrapped(year int) (*Wrapped, error) {
+	r := Year(year)
+	records := persistence.GetLocalRecords()
+	orders, err := records.GetOrdersBetween(r.From, r.To)
+	if err != nil {
+		return nil, err
+	}
+	newSongIDs, err := records.GetSongsFirstPlayedBetween(r.From, r.To)
+	if err != nil {
+		return nil, err
+	}
+	return wrap(year, toPlays(orders), newSongIDs, time.Now()), nil
+}
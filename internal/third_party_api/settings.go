@@ -3,3 +3,8 @@ package third_party_api
 var YoutubeApiKey string
 var EnableYoutubeApi bool
 var EnableYoutubeThumbnail bool
+
+var EnableYoutubeVideo bool
+var YtDlpPath = "yt-dlp"
+var YtDlpFormat = "(mp4/best)[height<=?1080][height>=?64][width>=?64]"
+var YtDlpProxy string
@@ -0,0 +1,118 @@
+package third_party_api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/utils"
+)
+
+// VRChat resolves YouTube videos with yt-dlp and plays the googlevideo.com URL it prints. We run yt-dlp with the same
+// format, so the URL we download from and the URL VRChat requests share the same stream key.
+
+// resolved URLs are given up a bit earlier than they expire, for the download may take a while
+const streamExpirySafety = 5 * time.Minute
+
+type YoutubeStream struct {
+	Url      string
+	FormatID string
+	Expires  time.Time
+}
+
+func (s *YoutubeStream) Valid() bool {
+	return time.Now().Add(streamExpirySafety).Before(s.Expires)
+}
+
+type ytDlpOutput struct {
+	ID       string `json:"id"`
+	Url      string `json:"url"`
+	FormatID string `json:"format_id"`
+}
+
+var youtubeStreamCache = utils.NewWeakCache[*YoutubeStream](10)
+
+// stream key -> YouTube video id, a key stays the same after the URL expires, so the latest videos are remembered
+// even if their streams are evicted from youtubeStreamCache
+var youtubeStreamKeys = utils.NewWeakCache[string](100)
+
+func ytDlpArgs(videoID string) []string {
+	args := []string{"--no-warnings", "--no-playlist", "-f", YtDlpFormat, "-j"}
+	if YtDlpProxy != "" {
+		args = append(args, "--proxy", YtDlpProxy)
+	}
+	return append(args, "--", utils.GetStandardYoutubeURL(videoID))
+}
+
+func runYtDlp(videoID string, ctx context.Context) (*ytDlpOutput, error) {
+	cmd := exec.CommandContext(ctx, YtDlpPath, ytDlpArgs(videoID)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	stdout, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("yt-dlp failed: %w, %s", err, msg)
+		}
+		return nil, fmt.Errorf("yt-dlp failed: %w", err)
+	}
+
+	var output ytDlpOutput
+	if err := json.Unmarshal(stdout, &output); err != nil {
+		return nil, fmt.Errorf("failed to parse the output of yt-dlp: %w", err)
+	}
+	if output.Url == "" {
+		return nil, errors.New("yt-dlp selected a format without a direct url, check yt-dlp-format")
+	}
+	return &output, nil
+}
+
+// streamExpires reads the expire parameter of googlevideo.com URLs, an hour from now if it's not there
+func streamExpires(u *url.URL) time.Time {
+	if expire, err := strconv.ParseInt(u.Query().Get("expire"), 10, 64); err == nil {
+		return time.Unix(expire, 0)
+	}
+	return time.Now().Add(time.Hour)
+}
+
+// ResolveYoutubeVideo asks yt-dlp for the direct URL of the video in the format VRChat plays
+func ResolveYoutubeVideo(videoID string, ctx context.Context) (*YoutubeStream, error) {
+	if stream, ok := youtubeStreamCache.Get(videoID); ok && stream.Valid() {
+		return stream, nil
+	}
+
+	output, err := runYtDlp(videoID, ctx)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(output.Url)
+	if err != nil {
+		return nil, err
+	}
+
+	stream := &YoutubeStream{
+		Url:      output.Url,
+		FormatID: output.FormatID,
+		Expires:  streamExpires(u),
+	}
+	if key, ok := utils.GetGoogleVideoStreamKey(u); ok {
+		youtubeStreamKeys.Set(key, videoID)
+	} else {
+		logger.WarnLn("The URL resolved by yt-dlp is not a googlevideo.com stream, requests from VRChat won't be recognized:", output.Url)
+	}
+
+	youtubeStreamCache.Set(videoID, stream)
+	return stream, nil
+}
+
+// FindYoutubeVideoByStreamKey tells which video a googlevideo.com request is for, if we have resolved it
+func FindYoutubeVideoByStreamKey(key string) (string, bool) {
+	return youtubeStreamKeys.Get(key)
+}
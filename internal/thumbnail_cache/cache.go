@@ -0,0 +1,301 @@
+package thumbnail_cache
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nfnt/resize"
+	"github.com/wzhqwq/VRCDancePreloader/internal/requesting"
+	"github.com/wzhqwq/VRCDancePreloader/internal/utils"
+	"golang.org/x/sync/singleflight"
+)
+
+// Thumbnails are saved as they are downloaded (.orig) along with the resized variants (_<width>.jpg), all the files
+// of a thumbnail share the hash of its URL. A file is fresh within the TTL since it's written, and the oldest files
+// are removed when the total size exceeds the limit. Without a path, nothing is saved and thumbnails are always fetched.
+
+const maxThumbnailSize = 10 * 1024 * 1024
+
+// Widths of the resized variants, a requested width is rounded up to one of them
+var Widths = []uint{160, 320, 640}
+
+var ErrBadThumbnail = errors.New("bad thumbnail response")
+
+var cachePath string
+var maxSize int64 = 50 * 1024 * 1024
+var ttl = 7 * 24 * time.Hour
+
+var totalSize int64
+var fileMutex sync.Mutex
+
+var fetchGroup singleflight.Group
+
+var logger = utils.NewLogger("Thumbnail Cache")
+
+func Setup(path string) {
+	if err := os.MkdirAll(path, 0777); err != nil {
+		logger.ErrorLn("Failed to create thumbnail cache directory:", err)
+		return
+	}
+
+	fileMutex.Lock()
+	cachePath = path
+	totalSize = 0
+	for _, f := range listFiles() {
+		totalSize += f.size
+	}
+	fileMutex.Unlock()
+
+	CleanUp()
+}
+
+func SetMaxSize(size int64) {
+	maxSize = size
+	CleanUp()
+}
+
+func SetTTL(d time.Duration) {
+	ttl = d
+}
+
+func GetTotalSize() int64 {
+	fileMutex.Lock()
+	defer fileMutex.Unlock()
+	return totalSize
+}
+
+func keyOf(url string) string {
+	sum := sha1.Sum([]byte(url))
+	return hex.EncodeToString(sum[:])
+}
+
+func originalName(url string) string {
+	return keyOf(url) + ".orig"
+}
+
+func variantName(url string, width uint) string {
+	return fmt.Sprintf("%s_%d.jpg", keyOf(url), width)
+}
+
+// VariantWidth rounds width up to a width of the variants, the largest one if it's too large
+func VariantWidth(width uint) uint {
+	for _, w := range Widths {
+		if width <= w {
+			return w
+		}
+	}
+	return Widths[len(Widths)-1]
+}
+
+// readFile returns the content of a saved file, and whether it's still fresh
+func readFile(name string) ([]byte, time.Time, bool, error) {
+	fileMutex.Lock()
+	defer fileMutex.Unlock()
+
+	if cachePath == "" {
+		return nil, time.Time{}, false, os.ErrNotExist
+	}
+	path := filepath.Join(cachePath, name)
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, time.Time{}, false, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, false, err
+	}
+	return data, stat.ModTime(), time.Since(stat.ModTime()) < ttl, nil
+}
+
+func writeFile(name string, data []byte) {
+	fileMutex.Lock()
+	if cachePath == "" {
+		fileMutex.Unlock()
+		return
+	}
+	path := filepath.Join(cachePath, name)
+	if stat, err := os.Stat(path); err == nil {
+		totalSize -= stat.Size()
+	}
+	if err := os.WriteFile(path, data, 0666); err != nil {
+		fileMutex.Unlock()
+		logger.WarnLn("Failed to save thumbnail:", err)
+		return
+	}
+	totalSize += int64(len(data))
+	exceeded := totalSize > maxSize
+	fileMutex.Unlock()
+
+	if exceeded {
+		CleanUp()
+	}
+}
+
+func fetch(url string) ([]byte, error) {
+	logger.InfoLn("Downloading thumbnail from", url)
+	resp, err := requesting.RequestThumbnail(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", ErrBadThumbnail, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxThumbnailSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxThumbnailSize {
+		return nil, fmt.Errorf("%w: too large", ErrBadThumbnail)
+	}
+	if !strings.HasPrefix(http.DetectContentType(data), "image/") {
+		return nil, fmt.Errorf("%w: not an image", ErrBadThumbnail)
+	}
+	return data, nil
+}
+
+// GetOriginal returns the thumbnail as it's served by url, a stale copy is used if it can't be fetched again
+func GetOriginal(url string) ([]byte, time.Time, error) {
+	name := originalName(url)
+	data, modTime, fresh, readErr := readFile(name)
+	if readErr == nil && fresh {
+		return data, modTime, nil
+	}
+
+	result, err, _ := fetchGroup.Do(name, func() (any, error) {
+		fetched, err := fetch(url)
+		if err != nil {
+			return nil, err
+		}
+		writeFile(name, fetched)
+		return fetched, nil
+	})
+	if err != nil {
+		if readErr == nil {
+			logger.WarnLn("Failed to refresh thumbnail, using the stale one:", err)
+			return data, modTime, nil
+		}
+		return nil, time.Time{}, err
+	}
+	return result.([]byte), time.Now(), nil
+}
+
+// GetResized returns the thumbnail resized to the variant width of width, encoded in JPEG
+func GetResized(url string, width uint) ([]byte, time.Time, error) {
+	width = VariantWidth(width)
+	name := variantName(url, width)
+	if data, modTime, fresh, err := readFile(name); err == nil && fresh {
+		return data, modTime, nil
+	}
+
+	result, err, _ := fetchGroup.Do(name, func() (any, error) {
+		original, _, err := GetOriginal(url)
+		if err != nil {
+			return nil, err
+		}
+		img, _, err := image.Decode(bytes.NewReader(original))
+		if err != nil {
+			return nil, err
+		}
+		if uint(img.Bounds().Dx()) > width {
+			img = resize.Resize(width, 0, img, resize.Bilinear)
+		}
+
+		buf := new(bytes.Buffer)
+		if err := jpeg.Encode(buf, img, nil); err != nil {
+			return nil, err
+		}
+		writeFile(name, buf.Bytes())
+		return buf.Bytes(), nil
+	})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return result.([]byte), time.Now(), nil
+}
+
+type cachedFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func listFiles() []cachedFile {
+	entries, err := os.ReadDir(cachePath)
+	if err != nil {
+		logger.WarnLn("Failed to read thumbnail cache directory:", err)
+		return nil
+	}
+	var files []cachedFile
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, cachedFile{
+			path:    filepath.Join(cachePath, entry.Name()),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+	}
+	return files
+}
+
+// CleanUp removes the oldest files until the total size is within the limit
+func CleanUp() {
+	fileMutex.Lock()
+	defer fileMutex.Unlock()
+
+	if cachePath == "" || totalSize <= maxSize {
+		return
+	}
+
+	files := listFiles()
+	slices.SortFunc(files, func(a, b cachedFile) int {
+		return a.modTime.Compare(b.modTime)
+	})
+	for _, f := range files {
+		if totalSize <= maxSize {
+			break
+		}
+		if err := os.Remove(f.path); err != nil {
+			logger.WarnLn("Failed to remove thumbnail:", err)
+			continue
+		}
+		totalSize -= f.size
+	}
+}
+
+func Clear() {
+	fileMutex.Lock()
+	defer fileMutex.Unlock()
+
+	if cachePath == "" {
+		return
+	}
+	for _, f := range listFiles() {
+		if err := os.Remove(f.path); err != nil {
+			logger.WarnLn("Failed to remove thumbnail:", err)
+			continue
+		}
+		totalSize -= f.size
+	}
+}
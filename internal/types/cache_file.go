@@ -1,9 +1,28 @@
 package types
 
+import "time"
+
+// on-disk formats of cache files
+const (
+	// <id>.mp4 and <id>.mp4.dl
+	CacheFormatLegacy = "legacy"
+	// <id>.mp4.vrcdp, shared by continuous and fragmented files
+	CacheFormatTrunk = "trunk"
+)
+
 type CacheFileInfo struct {
 	ID   string
 	Size int64
 
+	Format     string
+	FullSize   int64
+	Downloaded int64
+	IsComplete bool
+	ModTime    time.Time
+	LastServed time.Time
+	ServeCount int
+	Origin     string
+
 	// not in database
 	IsActive  bool
 	IsPartial bool
@@ -0,0 +1,39 @@
+package types
+
+import "time"
+
+// cache states of the requested video when a request is intercepted
+const (
+	CacheStateComplete = "complete"
+	CacheStatePartial  = "partial"
+	CacheStateMissing  = "missing"
+)
+
+// InterceptedRequest records how an intercepted video request is served
+type InterceptedRequest struct {
+	ID        int       `json:"id"`
+	Time      time.Time `json:"time"`
+	Platform  string    `json:"platform"`
+	VideoID   string    `json:"videoId"`
+	Range     string    `json:"range"`
+	Client    string    `json:"client"`
+	UserAgent string    `json:"userAgent"`
+
+	CacheState string `json:"cacheState"`
+	// bytes cached when the request comes
+	CachedSize int64 `json:"cachedSize"`
+
+	Status      int   `json:"status"`
+	BytesServed int64 `json:"bytesServed"`
+	// durations in milliseconds
+	TTFB     int64 `json:"ttfb"`
+	Duration int64 `json:"duration"`
+
+	// why the request falls back to the remote server, empty if it's served by us
+	Fallback string `json:"fallback"`
+	Finished bool   `json:"finished"`
+}
+
+func (r *InterceptedRequest) IsServed() bool {
+	return r.Fallback == ""
+}
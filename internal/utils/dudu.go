@@ -10,6 +10,7 @@ import (
 
 var duDuVideoURLRegex = regexp.MustCompile(`videos/(\d+)`)
 var duDuVideoPathRegex = regexp.MustCompile(`videos/(\d+)`)
+var duDuThumbnailPathRegex = regexp.MustCompile(`^/thumbnails/(\d+)\.jpg$`)
 
 func GetDuDuVideoUrl(id int) string {
 	return fmt.Sprintf("https://api.dudufit.dance/api/v1/videos/%d", id)
@@ -44,6 +45,15 @@ func CheckDuDuRequest(req *http.Request) (string, bool) {
 	return "", false
 }
 
+func CheckDuDuThumbnailRequest(req *http.Request) (int, bool) {
+	// api.dudufit.dance/thumbnails/VIDEO_ID.jpg
+	if matches := duDuThumbnailPathRegex.FindStringSubmatch(req.URL.Path); len(matches) > 1 {
+		num, err := strconv.Atoi(matches[1])
+		return num, err == nil
+	}
+	return 0, false
+}
+
 func CheckIdIsDuDu(id string) (int, bool) {
 	if !strings.Contains(id, "dudu_") {
 		return 0, false
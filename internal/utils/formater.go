@@ -29,3 +29,14 @@ func FirstLine(s string) string {
 	lines := strings.Split(s, "\n")
 	return lines[0]
 }
+
+// SplitList splits a comma separated list typed by the user, blank items are dropped
+func SplitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -11,6 +11,7 @@ import (
 var pypyVideoURLRegex = regexp.MustCompile(`videos/(\d+)\.mp4|video\?id=(\d+)`)
 var pypyVideoLegacyPathRegex = regexp.MustCompile(`videos/(\d+)\.mp4`)
 var pypyVideoNewPath = "/video"
+var pypyThumbnailPath = "/thumb"
 
 func GetPyPyVideoUrl(id int) string {
 	return fmt.Sprintf("http://api.pypy.dance/video?id=%d", id)
@@ -57,6 +58,18 @@ func CheckPyPyRequest(req *http.Request) (string, bool) {
 	return "", false
 }
 
+func CheckPyPyThumbnailRequest(req *http.Request) (int, bool) {
+	// api.pypy.dance/thumb?id=VIDEO_ID
+	if req.URL.Path != pypyThumbnailPath {
+		return 0, false
+	}
+	num, err := strconv.Atoi(req.URL.Query().Get("id"))
+	if err != nil {
+		return 0, false
+	}
+	return num, true
+}
+
 func CheckPyPyResource(url string) bool {
 	return strings.Contains(url, "api.pypy.dance") || strings.Contains(url, "jd.pypy.moe")
 }
@@ -0,0 +1,60 @@
+package utils
+
+import "sync"
+
+// RingBuffer keeps the latest items up to its capacity, the oldest item is overwritten when it's full
+type RingBuffer[T any] struct {
+	sync.Mutex
+
+	items []T
+	// index of the oldest item
+	head  int
+	count int
+}
+
+func NewRingBuffer[T any](capacity int) *RingBuffer[T] {
+	return &RingBuffer[T]{
+		items: make([]T, max(capacity, 1)),
+	}
+}
+
+func (r *RingBuffer[T]) Push(item T) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.count < len(r.items) {
+		r.items[(r.head+r.count)%len(r.items)] = item
+		r.count++
+		return
+	}
+	r.items[r.head] = item
+	r.head = (r.head + 1) % len(r.items)
+}
+
+// Items returns the items from the oldest to the latest
+func (r *RingBuffer[T]) Items() []T {
+	r.Lock()
+	defer r.Unlock()
+
+	result := make([]T, r.count)
+	for i := range result {
+		result[i] = r.items[(r.head+i)%len(r.items)]
+	}
+	return result
+}
+
+func (r *RingBuffer[T]) Len() int {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.count
+}
+
+func (r *RingBuffer[T]) Clear() {
+	r.Lock()
+	defer r.Unlock()
+
+	clear(r.items)
+	r.head = 0
+	r.count = 0
+}
@@ -1,6 +1,20 @@
 package utils
 
-import "fmt"
+import "strconv"
+
+// platformPrefixes are the prefixes of the ids of songs and cache files
+var platformPrefixes = map[string]string{
+	"PyPyDance":    "pypy_",
+	"WannaDance":   "wanna_",
+	"DuDuFitDance": "dudu_",
+	"BiliBili":     "bili_",
+	"YouTube":      "yt_",
+}
+
+// GetPlatformId prefixes the id of a video by its platform, the id is unchanged if the platform is unknown
+func GetPlatformId(platform, id string) string {
+	return platformPrefixes[platform] + id
+}
 
 func GetIdFromCustomUrl(url string) (string, bool) {
 	if id, isYoutube := CheckYoutubeURL(url); isYoutube {
@@ -14,16 +28,16 @@ func GetIdFromCustomUrl(url string) (string, bool) {
 
 func GetIdFromUrl(url string) (string, bool) {
 	if id, isPyPy := CheckIdIsPyPy(url); isPyPy {
-		return fmt.Sprintf("pypy_%d", id), true
+		return GetPlatformId("PyPyDance", strconv.Itoa(id)), true
 	}
 	if id, isWanna := CheckIdIsWanna(url); isWanna {
-		return fmt.Sprintf("wanna_%d", id), true
+		return GetPlatformId("WannaDance", strconv.Itoa(id)), true
 	}
 	if id, isYoutube := CheckYoutubeURL(url); isYoutube {
-		return "yt_" + id, true
+		return GetPlatformId("YouTube", id), true
 	}
 	if id, isBiliBili := CheckBiliURL(url); isBiliBili {
-		return "bili_" + id, true
+		return GetPlatformId("BiliBili", id), true
 	}
 	return "", false
 }
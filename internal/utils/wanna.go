@@ -9,6 +9,7 @@ import (
 )
 
 var wannaVideoURLRegex = regexp.MustCompile(`play\?id=(\d+)`)
+var wannaThumbnailPathRegex = regexp.MustCompile(`^/images/(\d+)\.jpg$`)
 var wannaVideoPath = "/Api/Songs/play"
 
 func GetWannaVideoUrl(id int) string {
@@ -48,6 +49,15 @@ func CheckWannaRequest(req *http.Request) (string, bool) {
 	return "", false
 }
 
+func CheckWannaThumbnailRequest(req *http.Request) (int, bool) {
+	// aya.kiva.moe/images/VIDEO_ID.jpg
+	if matches := wannaThumbnailPathRegex.FindStringSubmatch(req.URL.Path); len(matches) > 1 {
+		num, err := strconv.Atoi(matches[1])
+		return num, err == nil
+	}
+	return 0, false
+}
+
 func CheckIdIsWanna(id string) (int, bool) {
 	if !strings.Contains(id, "wanna_") {
 		return 0, false
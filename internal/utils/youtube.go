@@ -2,10 +2,14 @@ package utils
 
 import (
 	"fmt"
+	"net/http"
+	"net/url"
 	"regexp"
 	"strings"
 )
 
+var youtubeUrlRegex = regexp.MustCompile(`(?:youtube\.com/watch\?(?:[^#]*&)?v=|youtube\.com/(?:v|shorts|embed|live)/|youtu\.be/)([a-zA-Z0-9_-]{11})`)
+
 func GetStandardYoutubeURL(videoID string) string {
 	return fmt.Sprintf("https://www.youtube.com/watch?v=%s", videoID)
 }
@@ -18,14 +22,18 @@ func GetYoutubeHQThumbnailURL(videoID string) string {
 
 func CheckYoutubeURL(url string) (string, bool) {
 	// youtube.com/watch?v=VIDEO_ID
+	// youtube.com/watch?feature=share&v=VIDEO_ID
 	// youtube.com/v/VIDEO_ID
+	// youtube.com/shorts/VIDEO_ID
+	// youtube.com/embed/VIDEO_ID
+	// youtube.com/live/VIDEO_ID
 	// youtu.be/VIDEO_ID
 
 	if len(url) < 11 {
 		return "", false
 	}
 
-	matched := regexp.MustCompile(`(?:youtube\.com/watch\?v=|youtube\.com/v/|youtu\.be/)([a-zA-Z0-9_-]{11})`).FindStringSubmatch(url)
+	matched := youtubeUrlRegex.FindStringSubmatch(url)
 	if len(matched) > 1 {
 		return matched[1], true
 	}
@@ -33,6 +41,26 @@ func CheckYoutubeURL(url string) (string, bool) {
 	return "", false
 }
 
+// GetGoogleVideoStreamKey identifies a stream on googlevideo.com by the id and itag (format) of it.
+// Every resolution of the same video and format gives a different URL, but the key stays the same.
+func GetGoogleVideoStreamKey(u *url.URL) (string, bool) {
+	query := u.Query()
+	id := query.Get("id")
+	itag := query.Get("itag")
+	if id == "" || itag == "" {
+		return "", false
+	}
+	return id + "/" + itag, true
+}
+
+func CheckGoogleVideoRequest(req *http.Request) (string, bool) {
+	// rr1---sn-xxx.googlevideo.com/videoplayback?id=STREAM_ID&itag=FORMAT&...
+	if req.URL.Path != "/videoplayback" {
+		return "", false
+	}
+	return GetGoogleVideoStreamKey(req.URL)
+}
+
 func CheckYoutubeThumbnailURL(url string) bool {
 	return strings.Contains(url, "i.ytimg.com")
 }
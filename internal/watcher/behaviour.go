@@ -11,10 +11,11 @@ import (
 )
 
 var enterRoomRegex = regexp.MustCompile(`^Entering Room: (.*)`)
-var joinWorldRegex = regexp.MustCompile(`^Joining (wrld_.*):`)
+var joinWorldRegex = regexp.MustCompile(`^Joining (wrld_[^:]+):(.*)`)
 
 var lastEnteredRoom = NewLastValue("")
 var lastWorldId = NewLastValue("")
+var lastInstanceId = NewLastValue("")
 
 func checkBehaviourLine(version int32, content []byte, backtrace bool) bool {
 	if bytes.HasPrefix(content, []byte("[Behaviour]")) {
@@ -44,6 +45,7 @@ func checkBehaviourLine(version int32, content []byte, backtrace bool) bool {
 		matches = joinWorldRegex.FindSubmatch(content[12:])
 		if len(matches) > 1 {
 			lastWorldId.Set(version, string(matches[1]))
+			lastInstanceId.Set(version, string(matches[2]))
 			return true
 		}
 		return true
@@ -67,8 +69,11 @@ func behaviourPostProcess() {
 
 	worldId := lastWorldId.Get()
 	lastWorldId.Reset("")
+	instanceId := lastInstanceId.Get()
+	lastInstanceId.Reset("")
 
 	if worldId != "" {
 		service.SetCurrentWorldID(worldId)
+		persistence.SetCurrentInstance(worldId, instanceId)
 	}
 }
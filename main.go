@@ -7,6 +7,7 @@ import (
 	"github.com/wzhqwq/VRCDancePreloader/internal/download"
 	"github.com/wzhqwq/VRCDancePreloader/internal/global_state"
 	"github.com/wzhqwq/VRCDancePreloader/internal/gui/main_window"
+	"github.com/wzhqwq/VRCDancePreloader/internal/hijack"
 	"github.com/wzhqwq/VRCDancePreloader/internal/persistence"
 	"github.com/wzhqwq/VRCDancePreloader/internal/tui"
 	"github.com/wzhqwq/VRCDancePreloader/internal/utils"
@@ -36,6 +37,19 @@ var args struct {
 
 	SkipClientTest bool `arg:"--skip-client-test" default:"false" help:"skip client connectivity test"`
 
+	// cache transfer, the program exits after finishing them
+
+	ImportDir      string   `arg:"--import" default:"" help:"import mp4 files in this directory into cache"`
+	ImportPlatform string   `arg:"--import-platform" default:"" help:"platform of imported files only named by number (pypy, wanna or dudu), guessed if empty"`
+	ExportDir      string   `arg:"--export" default:"" help:"export cached songs into this directory as mp4 files"`
+	ExportIds      []string `arg:"--export-ids" help:"ids of songs to export, all complete files if empty"`
+
+	// print the proxy rules, export the CA or diagnose the proxy, the program exits after that
+
+	PrintClashRules bool   `arg:"--print-clash-rules" default:"false" help:"print Clash and Proxifier rules for the current config"`
+	ExportCA        string `arg:"--export-ca" default:"" help:"export the CA certificate into this file, DER for .der/.cer and PEM otherwise"`
+	Diagnose        bool   `arg:"--diagnose" default:"false" help:"check the proxy, intercepted sites and HTTPS interception, then print the results"`
+
 	// switches
 
 	DisableAsyncDownload bool `arg:"--disable-async-download" default:"false" help:"disable async download"`
@@ -66,8 +80,26 @@ func main() {
 	config.LoadConfig()
 	config.GetYoutubeConfig().Init()
 	config.GetKeyConfig().Init()
+	// before testing clients, the intercepted sites may be overridden to us
+	config.GetHijackConfig().InitResolver()
 	config.GetProxyConfig().Init()
 
+	if args.PrintClashRules {
+		config.GetHijackConfig().PrintRules()
+		return
+	}
+	if args.ExportCA != "" {
+		config.GetHijackConfig().InitCA()
+		if err := hijack.ExportCAFile(args.ExportCA); err != nil {
+			logger.ErrorLn("Failed to export CA:", err)
+		}
+		return
+	}
+	if args.Diagnose {
+		config.GetHijackConfig().Diagnose()
+		return
+	}
+
 	// Listen for interrupt
 	osSignalCh := make(chan os.Signal, 1)
 	signal.Notify(osSignalCh, syscall.SIGINT, syscall.SIGTERM)
@@ -94,6 +126,23 @@ func main() {
 		logger.InfoLn("Stopping cache")
 		cache.StopCache()
 	}()
+	config.GetThumbnailConfig().Init()
+
+	if args.ImportDir != "" || args.ExportDir != "" {
+		if args.ImportDir != "" {
+			cache.ImportVideoFiles(args.ImportDir, args.ImportPlatform)
+		}
+		if args.ExportDir != "" {
+			cache.ExportCachedSongs(args.ExportIds, args.ExportDir)
+		}
+		return
+	}
+
+	config.GetPeerConfig().Init()
+	defer func() {
+		logger.InfoLn("Stopping peer sharing")
+		config.GetPeerConfig().Stop()
+	}()
 
 	config.GetDownloadConfig().Init()
 	defer func() {
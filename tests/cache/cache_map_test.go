@@ -0,0 +1,70 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/cache"
+	"github.com/wzhqwq/VRCDancePreloader/internal/persistence"
+)
+
+func initDB(t *testing.T) {
+	if err := persistence.InitDB(filepath.Join(t.TempDir(), "data.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(persistence.CloseDB)
+}
+
+func TestOpenInterruptsLockedFile(t *testing.T) {
+	initDB(t)
+	cm := cache.NewCacheMap()
+
+	lockCtx, ok := cm.TryLockFileInterruptible(context.Background(), "unknown_1")
+	if !ok {
+		t.Fatal("failed to lock the file")
+	}
+
+	opened := make(chan error, 1)
+	go func() {
+		_, err := cm.Open(context.Background(), "unknown_1")
+		opened <- err
+	}()
+
+	select {
+	case <-lockCtx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("the work holding the lock is not interrupted")
+	}
+	if cause := context.Cause(lockCtx); !errors.Is(cause, cache.ErrFileRequested) {
+		t.Errorf("unexpected cause %v", cause)
+	}
+
+	select {
+	case <-opened:
+		t.Fatal("entry is opened before the file is unlocked")
+	case <-time.After(50 * time.Millisecond):
+	}
+	cm.UnlockFile("unknown_1")
+	if err := <-opened; !errors.Is(err, cache.ErrNotSupported) {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestOpenHonoursContext(t *testing.T) {
+	initDB(t)
+	cm := cache.NewCacheMap()
+
+	if !cm.TryLockFile("unknown_2") {
+		t.Fatal("failed to lock the file")
+	}
+	defer cm.UnlockFile("unknown_2")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := cm.Open(ctx, "unknown_2"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline to be exceeded, got %v", err)
+	}
+}
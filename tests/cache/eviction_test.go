@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/wzhqwq/VRCDancePreloader/internal/cache"
+	"github.com/wzhqwq/VRCDancePreloader/internal/persistence"
+	"github.com/wzhqwq/VRCDancePreloader/internal/utils"
+)
+
+const mb = 1024 * 1024
+
+// writeCompleteFiles writes 1MB complete files in the legacy format, the first one is modified the earliest
+func writeCompleteFiles(t *testing.T, dir string, ids ...string) {
+	base := time.Now().Add(-time.Hour)
+	for i, id := range ids {
+		path := filepath.Join(dir, id+".mp4")
+		// different contents, so that they are not taken as duplicates
+		if err := os.WriteFile(path, bytes.Repeat([]byte(id+" "), mb/(len(id)+1)+1)[:mb], 0666); err != nil {
+			t.Fatal(err)
+		}
+		modTime := base.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cache.RebuildCacheIndex()
+}
+
+func setLastServed(t *testing.T, id string, at time.Time) {
+	if _, err := persistence.DB.Exec("UPDATE cache_index SET last_served = ? WHERE id = ?", at.Unix(), id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitForMessage waits until the local file event carries message
+func waitForMessage(t *testing.T, ch *utils.EventSubscriber[string], message string) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case m := <-ch.Channel:
+			if m == message {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("%s is not notified", message)
+		}
+	}
+}
+
+// cleanUpWithMaxSize triggers a cleanup by setting the max size, and returns its report
+func cleanUpWithMaxSize(t *testing.T, size int64) *cache.CleanupReport {
+	ch := cache.SubscribeLocalFileEvent()
+	defer ch.Close()
+
+	cache.SetMaxSize(size)
+	waitForMessage(t, ch, "cleanup")
+
+	report := cache.GetLastCleanupReport()
+	if report == nil {
+		t.Fatal("no cleanup report")
+	}
+	return report
+}
+
+func removedIds(report *cache.CleanupReport) []string {
+	return lo.Map(report.Removed, func(f cache.EvictedFile, _ int) string {
+		return f.ID
+	})
+}
+
+func TestEvictLeastRecentlyServed(t *testing.T) {
+	dir := setupCacheDir(t)
+	cache.SetEvictionPolicy(cache.EvictByLastServed)
+	defer cache.SetEvictionPolicy(cache.EvictByModTime)
+
+	ids := []string{"pypy_101", "pypy_102", "pypy_103", "pypy_104"}
+	writeCompleteFiles(t, dir, ids...)
+	// served in the reverse order of modification, so the oldest file is the most recently served one
+	now := time.Now()
+	for i, id := range ids {
+		setLastServed(t, id, now.Add(-time.Duration(i)*time.Hour))
+	}
+
+	report := cleanUpWithMaxSize(t, 2*mb+mb/2)
+
+	removed := removedIds(report)
+	if len(removed) != 2 || removed[0] != "pypy_104" || removed[1] != "pypy_103" {
+		t.Fatalf("expected the least recently served files to be removed in order, got %v", removed)
+	}
+	if report.Policy != cache.EvictByLastServed {
+		t.Errorf("unexpected policy %s", report.Policy)
+	}
+	if report.SizeBefore != 4*mb || report.SizeAfter != 2*mb || report.FreedSize() != 2*mb {
+		t.Errorf("unexpected sizes %d -> %d", report.SizeBefore, report.SizeAfter)
+	}
+	for _, id := range removed {
+		if _, err := os.Stat(filepath.Join(dir, id+".mp4")); !os.IsNotExist(err) {
+			t.Errorf("%s should be removed from disk", id)
+		}
+	}
+}
+
+func TestEvictionSkipsPinnedAndInUse(t *testing.T) {
+	dir := setupCacheDir(t)
+	cache.SetKeepFavorites(true)
+	defer cache.SetKeepFavorites(false)
+
+	// the oldest three are pinned or in use
+	ids := []string{"pypy_201", "pypy_202", "pypy_203", "pypy_204", "pypy_205", "pypy_206"}
+	writeCompleteFiles(t, dir, ids...)
+
+	persistence.GetLocalSongs().SetFavorite("pypy_201", "favorite")
+	persistence.AddToAllowList("pypy_202", mb)
+
+	entry, err := cache.OpenCacheEntry(t.Context(), "pypy_203", utils.NewLogger("Test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !entry.IsComplete() {
+		t.Fatal("the opened entry should be complete")
+	}
+
+	report := cleanUpWithMaxSize(t, 3*mb+mb/2)
+
+	removed := removedIds(report)
+	if len(removed) != 3 || removed[0] != "pypy_204" || removed[1] != "pypy_205" || removed[2] != "pypy_206" {
+		t.Fatalf("expected only the files that aren't pinned or in use to be removed, got %v", removed)
+	}
+	if report.SizeAfter >= 3*mb+mb/2 {
+		t.Errorf("the size target is not reached, %d left", report.SizeAfter)
+	}
+
+	// closing the entry triggers another cleanup, it must be done before the database is closed
+	ch := cache.SubscribeLocalFileEvent()
+	defer ch.Close()
+	cache.ReleaseCacheEntry("pypy_203", utils.NewLogger("Test"))
+	waitForMessage(t, ch, "*pypy_203")
+}
@@ -0,0 +1,203 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/samber/lo"
+	"github.com/wzhqwq/VRCDancePreloader/internal/cache"
+	"github.com/wzhqwq/VRCDancePreloader/internal/persistence"
+	"github.com/wzhqwq/VRCDancePreloader/internal/requesting"
+)
+
+func readCompleteCache(t *testing.T, id string) []byte {
+	f, err := cache.OpenCompleteCache(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	dir := setupCacheDir(t)
+	cache.SetMaxSize(100 * mb)
+	ids := []string{"pypy_401", "wanna_402", "bili_BV1ab2cd3ef4"}
+	writeCompleteFiles(t, dir, ids...)
+	contents := lo.Associate(ids, func(id string) (string, []byte) {
+		return id, readCompleteCache(t, id)
+	})
+
+	exportDir := filepath.Join(t.TempDir(), "export")
+	exported := cache.ExportCachedSongs(nil, exportDir)
+	if len(exported) != len(ids) {
+		t.Fatalf("expected all complete files to be exported, got %d", len(exported))
+	}
+	for _, result := range exported {
+		if result.Err != nil {
+			t.Fatalf("failed to export %s: %v", result.ID, result.Err)
+		}
+		// without titles, the files are named by ids
+		if filepath.Base(result.Path) != result.ID+".mp4" {
+			t.Errorf("unexpected name %s of %s", result.Path, result.ID)
+		}
+		if err := cache.RemoveLocalCacheById(result.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	imported := cache.ImportVideoFiles(exportDir, "")
+	if len(imported) != len(ids) {
+		t.Fatalf("expected all exported files to be imported, got %d", len(imported))
+	}
+	for _, result := range imported {
+		if result.Err != nil {
+			t.Fatalf("failed to import %s: %v", result.Path, result.Err)
+		}
+		if result.MatchedBy != "file name" {
+			t.Errorf("%s is matched by %s", result.ID, result.MatchedBy)
+		}
+		if !bytes.Equal(readCompleteCache(t, result.ID), contents[result.ID]) {
+			t.Errorf("content of %s differs after the round trip", result.ID)
+		}
+	}
+
+	// complete files are not overwritten
+	for _, result := range cache.ImportVideoFiles(exportDir, "") {
+		if !errors.Is(result.Err, cache.ErrAlreadyCached) {
+			t.Errorf("expected %s to be rejected as already cached, got %v", result.ID, result.Err)
+		}
+	}
+}
+
+func TestExportSelectedSongs(t *testing.T) {
+	dir := setupCacheDir(t)
+	cache.SetMaxSize(100 * mb)
+	writeCompleteFiles(t, dir, "pypy_411", "pypy_412", "pypy_413")
+	persistence.GetLocalSongs().SetFavorite("pypy_412", `Title: "Remix"`)
+
+	exportDir := filepath.Join(t.TempDir(), "export")
+	results := cache.ExportCachedSongs([]string{"pypy_412", "pypy_414"}, exportDir)
+	if len(results) != 2 {
+		t.Fatalf("expected only the selected ids to be exported, got %d", len(results))
+	}
+	if results[0].Err != nil || filepath.Base(results[0].Path) != "Title_ _Remix_.mp4" {
+		t.Errorf("expected pypy_412 to be named by its title, got %q %v", results[0].Path, results[0].Err)
+	}
+	if results[1].Err == nil {
+		t.Error("pypy_414 is not cached, it should fail")
+	}
+
+	entries, err := os.ReadDir(exportDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected one exported file, got %d", len(entries))
+	}
+}
+
+// fakePlatforms serves the video sizes of numbered platforms as a proxy, the hosts asked are recorded
+func fakePlatforms(t *testing.T, sizes map[string]int64) *[]string {
+	var mutex sync.Mutex
+	var asked []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		asked = append(asked, r.Host)
+		mutex.Unlock()
+
+		size, ok := sizes[r.Host]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	requesting.InitClient(requesting.PyPyDance, server.URL)
+	requesting.InitClient(requesting.WannaDance, server.URL)
+	return &asked
+}
+
+func TestImportByPlatform(t *testing.T) {
+	setupCacheDir(t)
+	cache.SetMaxSize(100 * mb)
+
+	importDir := t.TempDir()
+	body := bytes.Repeat([]byte("numbered "), 1000)
+	if err := os.WriteFile(filepath.Join(importDir, "123 - some song.mp4"), body, 0666); err != nil {
+		t.Fatal(err)
+	}
+	// only the video of WannaDance is in the same size
+	asked := fakePlatforms(t, map[string]int64{
+		"api.pypy.dance": int64(len(body)) + 1,
+		"api.udon.dance": int64(len(body)),
+	})
+
+	results := cache.ImportVideoFiles(importDir, "pypy")
+	if len(results) != 1 || !errors.Is(results[0].Err, cache.ErrUnknownVideo) {
+		t.Fatalf("the file should not be taken as a video of PyPyDance, got %+v", results)
+	}
+	if len(*asked) != 1 || (*asked)[0] != "api.pypy.dance" {
+		t.Fatalf("only PyPyDance should be asked, got %v", *asked)
+	}
+
+	results = cache.ImportVideoFiles(importDir, "")
+	if len(results) != 1 || results[0].Err != nil {
+		t.Fatalf("the file should be imported, got %+v", results)
+	}
+	if results[0].ID != "wanna_123" || results[0].MatchedBy != "remote file" {
+		t.Fatalf("unexpected match %s by %s", results[0].ID, results[0].MatchedBy)
+	}
+	if !bytes.Equal(readCompleteCache(t, "wanna_123"), body) {
+		t.Fatal("imported content differs")
+	}
+}
+
+func TestImportRejectsUnknownFiles(t *testing.T) {
+	setupCacheDir(t)
+
+	importDir := t.TempDir()
+	files := map[string]string{
+		"notes.txt":         "not a video",
+		"holiday clip.mp4":  "no id in the name",
+		"pypy_abc def.mp4x": "not an mp4",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(importDir, name), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(importDir, "pypy_1.mp4"), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	results := cache.ImportVideoFiles(importDir, "")
+	if len(results) != 1 {
+		t.Fatalf("expected only the mp4 file to be tried, got %+v", results)
+	}
+	if !errors.Is(results[0].Err, cache.ErrUnknownVideo) {
+		t.Fatalf("expected the file to be rejected as unknown, got %v", results[0].Err)
+	}
+	if len(persistence.ListCacheFiles()) != 0 {
+		t.Fatal("nothing should be imported")
+	}
+
+	if results := cache.ImportVideoFiles(filepath.Join(importDir, "missing"), ""); results != nil {
+		t.Fatalf("a missing directory should import nothing, got %+v", results)
+	}
+}
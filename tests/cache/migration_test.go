@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/cache"
+	"github.com/wzhqwq/VRCDancePreloader/internal/persistence"
+	"github.com/wzhqwq/VRCDancePreloader/internal/rw_file/trunk"
+	"github.com/wzhqwq/VRCDancePreloader/internal/types"
+)
+
+// waitForMigration waits until a migration of total files is finished, the progress is polled since events may be
+// dropped while copying
+func waitForMigration(t *testing.T, total int) cache.MigrationProgress {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		p := cache.GetMigrationProgress()
+		if !p.Running && p.Total == total && p.Done == total {
+			return p
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("migration of %d files is not finished, progress %+v", total, cache.GetMigrationProgress())
+	return cache.MigrationProgress{}
+}
+
+func assertFormat(t *testing.T, id string, format string) {
+	f, err := persistence.GetCacheFile(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Format != format {
+		t.Errorf("expected %s to be in %s format, got %s", id, format, f.Format)
+	}
+}
+
+func TestMigrateLegacyFiles(t *testing.T) {
+	dir := setupCacheDir(t)
+	cache.SetMaxSize(100 * mb)
+	// the cache is in continuous format, so legacy files are converted
+	ids := []string{"pypy_501", "pypy_502"}
+	writeCompleteFiles(t, dir, ids...)
+	contents := make(map[string][]byte)
+	modTimes := make(map[string]time.Time)
+	for _, id := range ids {
+		contents[id] = readCompleteCache(t, id)
+		stat, err := os.Stat(filepath.Join(dir, id+".mp4"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		modTimes[id] = stat.ModTime()
+	}
+	// the full size of a partial legacy file is unknown, so its progress can't be kept
+	if err := os.WriteFile(filepath.Join(dir, "pypy_503.mp4.dl"), []byte("partial"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	cache.RebuildCacheIndex()
+
+	cache.SetMigrateFormat(true)
+	t.Cleanup(func() {
+		cache.SetMigrateFormat(false)
+	})
+
+	p := waitForMigration(t, 3)
+	if p.Failed != 1 || p.Target != types.CacheFormatTrunk {
+		t.Fatalf("expected one file to fail, progress %+v", p)
+	}
+
+	for _, id := range ids {
+		if _, err := os.Stat(filepath.Join(dir, id+".mp4")); !os.IsNotExist(err) {
+			t.Errorf("legacy file of %s should be removed", id)
+		}
+		assertFormat(t, id, types.CacheFormatTrunk)
+		if !bytes.Equal(readCompleteCache(t, id), contents[id]) {
+			t.Errorf("content of %s differs after the migration", id)
+		}
+		// the modified time is compared with the remote one, it's kept in the header
+		info, err := trunk.Stat(filepath.Join(dir, id+".mp4.vrcdp"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.Completed || info.LastModified.Unix() != modTimes[id].Unix() {
+			t.Errorf("unexpected header of %s %+v", id, info)
+		}
+	}
+	// the file that failed is left as it is
+	if data, err := os.ReadFile(filepath.Join(dir, "pypy_503.mp4.dl")); err != nil || string(data) != "partial" {
+		t.Errorf("the partial file should be kept, got %q %v", data, err)
+	}
+	assertFormat(t, "pypy_503", types.CacheFormatLegacy)
+
+	// running again only converts what's left in the old layout
+	writeCompleteFiles(t, dir, "pypy_504")
+	if err := os.Remove(filepath.Join(dir, "pypy_503.mp4.dl")); err != nil {
+		t.Fatal(err)
+	}
+	cache.RebuildCacheIndex()
+	cache.StartMigration()
+
+	p = waitForMigration(t, 1)
+	if p.Failed != 0 {
+		t.Fatalf("expected no failure, progress %+v", p)
+	}
+	for _, id := range append(ids, "pypy_504") {
+		assertFormat(t, id, types.CacheFormatTrunk)
+	}
+	for _, id := range ids {
+		if !bytes.Equal(readCompleteCache(t, id), contents[id]) {
+			t.Errorf("content of %s differs after migrating again", id)
+		}
+	}
+}
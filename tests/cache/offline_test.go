@@ -0,0 +1,168 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/cache"
+)
+
+func (v *remoteVideo) setDown(down bool) {
+	v.Lock()
+	defer v.Unlock()
+	v.down = down
+}
+
+func (v *remoteVideo) requestCount() int {
+	v.Lock()
+	defer v.Unlock()
+	return v.requests
+}
+
+type revalidator interface {
+	Revalidate(ctx context.Context) error
+}
+
+func TestServeLocalCopyOffline(t *testing.T) {
+	body := videoBody("offline ")
+	video := &remoteVideo{}
+	video.set(`"v1"`, body)
+	id := setupRemoteVideo(t, "bbbbbbbbbb1", video)
+	setupCacheDir(t)
+
+	entry := openEntry(t, id)
+	if err := download(t, entry, 1024*1024); err != nil {
+		t.Fatal(err)
+	}
+	entry.Close()
+
+	video.setDown(true)
+	ch := cache.SubscribeOfflineEvent()
+	defer ch.Close()
+
+	entry = openEntry(t, id)
+	defer entry.Close()
+	// the partial file is checked with the remote server, which fails
+	size, err := entry.TotalLen()
+	if err != nil {
+		t.Fatalf("the local copy should be served, got %v", err)
+	}
+	if size != int64(len(body)) {
+		t.Fatalf("expected the recorded size %d, got %d", len(body), size)
+	}
+	if !cache.IsServedOffline(id) {
+		t.Fatal("the entry should be served offline")
+	}
+	select {
+	case changed := <-ch.Channel:
+		if changed != id {
+			t.Fatalf("unexpected offline event of %s", changed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the offline state is not notified")
+	}
+
+	// nothing is downloaded until it's revalidated, and the remote server is not asked again
+	requests := video.requestCount()
+	if _, err := entry.GetDownloadStream(context.Background()); !errors.Is(err, cache.ErrOffline) {
+		t.Fatalf("expected ErrOffline, got %v", err)
+	}
+	if _, err := entry.TotalLen(); err != nil {
+		t.Fatal(err)
+	}
+	if n := video.requestCount(); n != requests {
+		t.Fatalf("the remote server should not be asked while offline, %d more requests", n-requests)
+	}
+	if entry.DownloadedSize() != 1024*1024 {
+		t.Fatalf("the downloaded part should be kept, got %d bytes", entry.DownloadedSize())
+	}
+
+	r, ok := entry.(revalidator)
+	if !ok {
+		t.Fatal("the entry can't be revalidated")
+	}
+	if err := r.Revalidate(context.Background()); err == nil {
+		t.Fatal("revalidation should fail while the server is down")
+	}
+	if !cache.IsServedOffline(id) {
+		t.Fatal("the entry should stay offline")
+	}
+
+	video.setDown(false)
+	if err := r.Revalidate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if cache.IsServedOffline(id) {
+		t.Fatal("the entry should be online after it's revalidated")
+	}
+	if err := download(t, entry, -1); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readAll(t, entry), body) {
+		t.Fatal("cached content differs")
+	}
+}
+
+func TestOfflineWithoutLocalCopy(t *testing.T) {
+	video := &remoteVideo{down: true}
+	video.set(`"v1"`, videoBody("never downloaded "))
+	id := setupRemoteVideo(t, "bbbbbbbbbb2", video)
+	setupCacheDir(t)
+
+	entry := openEntry(t, id)
+	defer entry.Close()
+	err := download(t, entry, -1)
+	if err == nil || errors.Is(err, cache.ErrOffline) {
+		t.Fatalf("expected the failure of the remote server, got %v", err)
+	}
+	if cache.IsServedOffline(id) {
+		t.Fatal("there is nothing to serve offline")
+	}
+}
+
+func TestCompleteFileNotResolved(t *testing.T) {
+	body := videoBody("complete ")
+	video := &remoteVideo{}
+	video.set(`"v1"`, body)
+	id := setupRemoteVideo(t, "bbbbbbbbbb3", video)
+	setupCacheDir(t)
+
+	entry := openEntry(t, id)
+	if err := download(t, entry, -1); err != nil {
+		t.Fatal(err)
+	}
+	entry.Close()
+
+	// a complete file is served without asking the remote server
+	video.setDown(true)
+	requests := video.requestCount()
+	entry = openEntry(t, id)
+	if !bytes.Equal(readAll(t, entry), body) {
+		t.Fatal("cached content differs")
+	}
+	entry.Close()
+	if n := video.requestCount(); n != requests {
+		t.Fatalf("the complete file should not be resolved, %d more requests", n-requests)
+	}
+	if cache.IsServedOffline(id) {
+		t.Fatal("the complete file is not checked, so it's not served offline")
+	}
+
+	// unless the expiration is checked, then it's served offline
+	cache.SetForceExpirationCheck(true)
+	defer cache.SetForceExpirationCheck(false)
+	entry = openEntry(t, id)
+	defer entry.Close()
+	if !bytes.Equal(readAll(t, entry), body) {
+		t.Fatal("cached content differs")
+	}
+	if video.requestCount() == requests {
+		t.Fatal("the expiration should be checked")
+	}
+	if !cache.IsServedOffline(id) {
+		t.Fatal("the complete file should be served offline")
+	}
+}
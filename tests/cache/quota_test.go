@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/samber/lo"
+	"github.com/wzhqwq/VRCDancePreloader/internal/cache"
+	"github.com/wzhqwq/VRCDancePreloader/internal/persistence"
+)
+
+// cleanUpWithQuota triggers a cleanup by setting the quota of platform, and returns its report
+func cleanUpWithQuota(t *testing.T, platform string, size int64) *cache.CleanupReport {
+	// registered after the cache is set up, so the quota is removed before the cache is stopped
+	t.Cleanup(func() {
+		cache.SetPlatformQuota(platform, 0)
+	})
+
+	ch := cache.SubscribeLocalFileEvent()
+	defer ch.Close()
+
+	cache.SetPlatformQuota(platform, size)
+	waitForMessage(t, ch, "cleanup")
+
+	report := cache.GetLastCleanupReport()
+	if report == nil {
+		t.Fatal("no cleanup report")
+	}
+	return report
+}
+
+func findUsage(t *testing.T, platform string) cache.PlatformUsage {
+	usage, ok := lo.Find(cache.GetPlatformUsages(), func(u cache.PlatformUsage) bool {
+		return u.Platform == platform
+	})
+	if !ok {
+		t.Fatalf("no usage of %s", platform)
+	}
+	return usage
+}
+
+func TestPlatformQuota(t *testing.T) {
+	dir := setupCacheDir(t)
+	// far from full, only the quota matters
+	cache.SetMaxSize(100 * mb)
+	writeCompleteFiles(t, dir, "bili_BV1aa", "pypy_301", "bili_BV1bb", "bili_BV1cc")
+
+	if usage := findUsage(t, "bili"); usage.Size != 3*mb || usage.Quota != 0 || usage.Exceeded() {
+		t.Fatalf("unexpected usage before the quota is set %+v", usage)
+	}
+
+	report := cleanUpWithQuota(t, "bili", mb+mb/2)
+
+	removed := removedIds(report)
+	if len(removed) != 2 || removed[0] != "bili_BV1aa" || removed[1] != "bili_BV1bb" {
+		t.Fatalf("expected the oldest files of bili to be removed, got %v", removed)
+	}
+	for _, f := range report.Removed {
+		if !strings.Contains(f.Reason, "quota of bili exceeded") {
+			t.Errorf("the quota is not mentioned in the reason %q", f.Reason)
+		}
+	}
+
+	bili := findUsage(t, "bili")
+	if bili.Size != mb || bili.Quota != mb+mb/2 || bili.Exceeded() {
+		t.Errorf("unexpected usage of bili %+v", bili)
+	}
+	// other platforms are left alone
+	pypy := findUsage(t, "pypy")
+	if pypy.Size != mb || pypy.Quota != 0 || pypy.Exceeded() {
+		t.Errorf("unexpected usage of pypy %+v", pypy)
+	}
+}
+
+func TestQuotaExceededByPinnedFiles(t *testing.T) {
+	dir := setupCacheDir(t)
+	cache.SetMaxSize(100 * mb)
+	writeCompleteFiles(t, dir, "bili_BV1dd", "bili_BV1ee")
+	persistence.AddToAllowList("bili_BV1dd", mb)
+
+	report := cleanUpWithQuota(t, "bili", mb/2)
+
+	if removed := removedIds(report); len(removed) != 1 || removed[0] != "bili_BV1ee" {
+		t.Fatalf("expected only the file that isn't pinned to be removed, got %v", removed)
+	}
+	// nothing else can be removed, so the quota stays exceeded
+	if usage := findUsage(t, "bili"); usage.Size != mb || !usage.Exceeded() {
+		t.Errorf("expected the quota to be exceeded %+v", usage)
+	}
+}
@@ -0,0 +1,252 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/cache"
+	"github.com/wzhqwq/VRCDancePreloader/internal/persistence"
+	"github.com/wzhqwq/VRCDancePreloader/internal/requesting"
+	"github.com/wzhqwq/VRCDancePreloader/internal/third_party_api"
+)
+
+// remoteVideo serves the current version of a video with its ETag, and remembers the validators it received
+type remoteVideo struct {
+	sync.Mutex
+	etag string
+	body []byte
+	// the server answers 503 while it's down
+	down bool
+
+	requests    int
+	ifNoneMatch []string
+	ifRange     []string
+}
+
+func (v *remoteVideo) set(etag string, body []byte) {
+	v.Lock()
+	defer v.Unlock()
+	v.etag = etag
+	v.body = body
+}
+
+func (v *remoteVideo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v.Lock()
+	etag, body, down := v.etag, v.body, v.down
+	v.requests++
+	if h := r.Header.Get("If-None-Match"); h != "" {
+		v.ifNoneMatch = append(v.ifNoneMatch, h)
+	}
+	if h := r.Header.Get("If-Range"); h != "" {
+		v.ifRange = append(v.ifRange, h)
+	}
+	v.Unlock()
+
+	if down {
+		http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	http.ServeContent(w, r, "video.mp4", time.Unix(1700000000, 0), bytes.NewReader(body))
+}
+
+// videoBody makes a body large enough to be taken as a video
+func videoBody(seed string) []byte {
+	return bytes.Repeat([]byte(seed), 2*1024*1024/len(seed))
+}
+
+// setupRemoteVideo serves a YouTube video through a fake yt-dlp, the id of its cache entry is returned
+func setupRemoteVideo(t *testing.T, videoId string, video *remoteVideo) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake yt-dlp is a shell script")
+	}
+	server := httptest.NewServer(video)
+	t.Cleanup(server.Close)
+
+	script := filepath.Join(t.TempDir(), "yt-dlp")
+	content := fmt.Sprintf(`#!/bin/sh
+cat <<'JSON'
+{"id": "%s", "format_id": "18", "url": "%s/videoplayback?expire=%d&itag=18", "http_headers": {}}
+JSON
+`, videoId, server.URL, time.Now().Add(6*time.Hour).Unix())
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	third_party_api.YtDlpPath = script
+	third_party_api.YtDlpProxy = ""
+	third_party_api.EnableYoutubeVideo = true
+	requesting.InitClient(requesting.YouTubeVideo, "")
+
+	return "yt_" + videoId
+}
+
+// setupCacheDir starts the cache in a temporary directory and returns the directory
+func setupCacheDir(t *testing.T) string {
+	initDB(t)
+	dir := filepath.Join(t.TempDir(), "cache")
+	if err := os.Mkdir(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	cache.SetFileFormat(1)
+	cache.SetupCache(dir)
+	// registered after initDB, so the cache is stopped before the database is closed
+	t.Cleanup(cache.StopCache)
+	return dir
+}
+
+func openEntry(t *testing.T, id string) cache.Entry {
+	entry := cache.NewEntry(id)
+	if entry == nil {
+		t.Fatalf("entry %s is not created", id)
+	}
+	entry.Open()
+	return entry
+}
+
+// download writes at most limit bytes of the stream to the entry like the downloader does, a negative limit means all
+func download(t *testing.T, entry cache.Entry, limit int64) error {
+	stream, err := entry.GetDownloadStream(context.Background())
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	if limit < 0 {
+		_, err = io.Copy(entry, stream)
+	} else {
+		_, err = io.CopyN(entry, stream, limit)
+	}
+	return err
+}
+
+func readAll(t *testing.T, entry cache.Entry) []byte {
+	rs, err := entry.GetReadSeeker(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, err := entry.TotalLen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, size)
+	if _, err := io.ReadFull(rs, got); err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestRevalidateNotModified(t *testing.T) {
+	body := videoBody("not modified ")
+	video := &remoteVideo{}
+	video.set(`"v1"`, body)
+	id := setupRemoteVideo(t, "aaaaaaaaaa1", video)
+	setupCacheDir(t)
+
+	entry := openEntry(t, id)
+	if err := download(t, entry, -1); err != nil {
+		t.Fatal(err)
+	}
+	entry.Close()
+
+	cache.SetForceExpirationCheck(true)
+	defer cache.SetForceExpirationCheck(false)
+
+	entry = openEntry(t, id)
+	defer entry.Close()
+	if !entry.IsComplete() {
+		t.Fatal("the confirmed file should stay complete")
+	}
+	if !bytes.Equal(readAll(t, entry), body) {
+		t.Fatal("cached content differs")
+	}
+
+	video.Lock()
+	ifNoneMatch := video.ifNoneMatch
+	video.Unlock()
+	if len(ifNoneMatch) != 1 || ifNoneMatch[0] != `"v1"` {
+		t.Fatalf("expected one conditional request with the recorded ETag, got %v", ifNoneMatch)
+	}
+	v := persistence.GetCacheValidation(id)
+	if v == nil || v.Result != persistence.RevalidationNotModified {
+		t.Fatalf("expected the check to be recorded as not modified, got %+v", v)
+	}
+}
+
+func TestResumeWhenValidatorMatches(t *testing.T) {
+	body := videoBody("resumed ")
+	video := &remoteVideo{}
+	video.set(`"v1"`, body)
+	id := setupRemoteVideo(t, "aaaaaaaaaa2", video)
+	setupCacheDir(t)
+
+	entry := openEntry(t, id)
+	defer entry.Close()
+	if err := download(t, entry, 1024*1024); err != nil {
+		t.Fatal(err)
+	}
+	if entry.IsComplete() {
+		t.Fatal("the entry should be partial")
+	}
+
+	if err := download(t, entry, -1); err != nil {
+		t.Fatal(err)
+	}
+	if !entry.IsComplete() {
+		t.Fatal("the entry should be complete after resuming")
+	}
+	if !bytes.Equal(readAll(t, entry), body) {
+		t.Fatal("cached content differs")
+	}
+
+	video.Lock()
+	ifRange := video.ifRange
+	video.Unlock()
+	if len(ifRange) != 1 || ifRange[0] != `"v1"` {
+		t.Fatalf("expected the resumed request to carry If-Range, got %v", ifRange)
+	}
+}
+
+func TestResumeWhenValidatorChanged(t *testing.T) {
+	video := &remoteVideo{}
+	video.set(`"v1"`, videoBody("old version "))
+	id := setupRemoteVideo(t, "aaaaaaaaaa3", video)
+	setupCacheDir(t)
+
+	entry := openEntry(t, id)
+	defer entry.Close()
+	if err := download(t, entry, 1024*1024); err != nil {
+		t.Fatal(err)
+	}
+
+	newBody := videoBody("new version, a bit longer ")
+	video.set(`"v2"`, newBody)
+
+	if err := download(t, entry, -1); !errors.Is(err, cache.ErrRemoteChanged) {
+		t.Fatalf("expected the remote change to be detected, got %v", err)
+	}
+	if entry.DownloadedSize() != 0 {
+		t.Fatalf("the outdated part should be cleared, %d bytes left", entry.DownloadedSize())
+	}
+
+	// resolved again, the new version is downloaded from the start
+	if err := download(t, entry, -1); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readAll(t, entry), newBody) {
+		t.Fatal("cached content is not the new version")
+	}
+	if v := persistence.GetCacheValidation(id); v == nil || v.ETag != `"v2"` {
+		t.Fatalf("expected the new validator to be recorded, got %+v", v)
+	}
+}
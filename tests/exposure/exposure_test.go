@@ -0,0 +1,168 @@
+package exposure
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/exposure"
+)
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+func serve(handler http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	return rec
+}
+
+func TestAddr(t *testing.T) {
+	if addr := exposure.NewGuard(exposure.Settings{Bind: exposure.BindLoopback}).Addr(7652); addr != "127.0.0.1:7652" {
+		t.Errorf("unexpected loopback address %s", addr)
+	}
+	if addr := exposure.NewGuard(exposure.Settings{Bind: exposure.BindLAN}).Addr(7652); addr != ":7652" {
+		t.Errorf("unexpected LAN address %s", addr)
+	}
+}
+
+func TestAllowList(t *testing.T) {
+	g := exposure.NewGuard(exposure.Settings{
+		Bind:      exposure.BindLAN,
+		AllowList: []string{"192.168.1.0/24", "10.0.0.5", "not an ip"},
+	})
+
+	cases := map[string]bool{
+		"192.168.1.20:5000": true,
+		"192.168.2.20:5000": false,
+		"10.0.0.5:5000":     true,
+		"10.0.0.6:5000":     false,
+		"[::1]:5000":        false,
+	}
+	for addr, allowed := range cases {
+		if g.AllowAddr(addr) != allowed {
+			t.Errorf("AllowAddr(%s) should be %v", addr, allowed)
+		}
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = addr
+		code := serve(g.Wrap(okHandler), r).Code
+		if allowed && code != http.StatusOK || !allowed && code != http.StatusForbidden {
+			t.Errorf("unexpected status %d for %s", code, addr)
+		}
+	}
+
+	if !exposure.NewGuard(exposure.Settings{}).AllowAddr("8.8.8.8:80") {
+		t.Error("empty allow list should allow everyone")
+	}
+}
+
+func TestAuth(t *testing.T) {
+	g := exposure.NewGuard(exposure.Settings{
+		Username: "user",
+		Password: "pass",
+		Token:    "secret",
+	})
+	handler := g.Wrap(okHandler)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := serve(handler, r)
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("expected 401 with WWW-Authenticate, got %d", rec.Code)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.SetBasicAuth("user", "pass")
+	if code := serve(handler, r).Code; code != http.StatusOK {
+		t.Errorf("basic auth failed: %d", code)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.SetBasicAuth("user", "wrong")
+	if code := serve(handler, r).Code; code != http.StatusUnauthorized {
+		t.Errorf("wrong password accepted: %d", code)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.SetBasicAuth("anyone", "secret")
+	if code := serve(handler, r).Code; code != http.StatusOK {
+		t.Errorf("token as basic password failed: %d", code)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	if code := serve(handler, r).Code; code != http.StatusOK {
+		t.Errorf("bearer token failed: %d", code)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/?token=secret", nil)
+	rec = serve(handler, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("query token failed: %d", rec.Code)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != exposure.TokenCookie {
+		t.Fatalf("expected the token cookie, got %v", cookies)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/ws", nil)
+	r.AddCookie(cookies[0])
+	if code := serve(handler, r).Code; code != http.StatusOK {
+		t.Errorf("token cookie failed: %d", code)
+	}
+}
+
+func TestProxyAuth(t *testing.T) {
+	g := exposure.NewGuard(exposure.Settings{Bind: exposure.BindLAN, Token: "secret"})
+	var forwarded *http.Request
+	handler := g.WrapProxy(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "http://jd.pypy.moe/api/v2/videos/1.mp4", nil)
+	rec := serve(handler, r)
+	if rec.Code != http.StatusProxyAuthRequired || rec.Header().Get("Proxy-Authenticate") == "" {
+		t.Fatalf("expected 407 with Proxy-Authenticate, got %d", rec.Code)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "http://jd.pypy.moe/api/v2/videos/1.mp4", nil)
+	probe := httptest.NewRequest(http.MethodGet, "/", nil)
+	probe.SetBasicAuth("vrchat", "secret")
+	r.Header.Set("Proxy-Authorization", probe.Header.Get("Authorization"))
+	serve(handler, r)
+	if forwarded == nil {
+		t.Fatal("authorized request is not forwarded")
+	}
+	if forwarded.Header.Get("Proxy-Authorization") != "" {
+		t.Error("Proxy-Authorization should be removed before forwarding")
+	}
+
+	// the PAC file is fetched by the system without credentials
+	forwarded = nil
+	r = httptest.NewRequest(http.MethodGet, "/proxy.pac", nil)
+	serve(handler, r)
+	if forwarded == nil {
+		t.Error("requests to the proxy itself should not require credentials")
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	g := exposure.NewGuard(exposure.Settings{AllowedOrigins: []string{"https://obs.example.com/"}})
+
+	cases := map[string]bool{
+		"":                           true,
+		"http://127.0.0.1:7652":      true,
+		"https://obs.example.com":    true,
+		"https://evil.example.com":   false,
+		"http://127.0.0.1:7652.evil": false,
+	}
+	for origin, allowed := range cases {
+		r := httptest.NewRequest(http.MethodGet, "http://127.0.0.1:7652/ws", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if g.CheckOrigin(r) != allowed {
+			t.Errorf("CheckOrigin(%q) should be %v", origin, allowed)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package hijack
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/hijack"
+)
+
+func TestCALifecycle(t *testing.T) {
+	dir := t.TempDir()
+	if err := hijack.InitCA(dir); err != nil {
+		t.Fatal(err)
+	}
+	first := hijack.GetCAInfo()
+	if first == nil {
+		t.Fatal("CA is not generated")
+	}
+
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(filepath.Join(dir, "ca.key"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Fatalf("CA key is accessible by others: %v", info.Mode().Perm())
+		}
+	}
+
+	// the same CA is loaded again
+	if err := hijack.InitCA(dir); err != nil {
+		t.Fatal(err)
+	}
+	if hijack.GetCAInfo().Fingerprint != first.Fingerprint {
+		t.Fatal("CA is regenerated on load")
+	}
+
+	var pemBuf, derBuf bytes.Buffer
+	if err := hijack.ExportCA(&pemBuf, hijack.CAFormatPEM); err != nil {
+		t.Fatal(err)
+	}
+	if err := hijack.ExportCA(&derBuf, hijack.CAFormatDER); err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(pemBuf.Bytes())
+	if block == nil || !bytes.Equal(block.Bytes, derBuf.Bytes()) {
+		t.Fatal("PEM and DER exports differ")
+	}
+	cert, err := x509.ParseCertificate(derBuf.Bytes())
+	if err != nil || !cert.IsCA {
+		t.Fatal("exported certificate is not a CA", err)
+	}
+
+	if err := hijack.SelfTestCA(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := hijack.RotateCA(); err != nil {
+		t.Fatal(err)
+	}
+	if hijack.GetCAInfo().Fingerprint == first.Fingerprint {
+		t.Fatal("CA is not rotated")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ca.key.bak")); err != nil {
+		t.Fatal("old CA is not backed up", err)
+	}
+	if err := hijack.SelfTestCA(); err != nil {
+		t.Fatal(err)
+	}
+}
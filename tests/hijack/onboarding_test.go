@@ -0,0 +1,68 @@
+package hijack
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/hijack"
+	"github.com/wzhqwq/VRCDancePreloader/internal/i18n"
+)
+
+func getOnboardingPage(t *testing.T, client *http.Client, pageUrl string) string {
+	var res *http.Response
+	var err error
+	for i := 0; i < 50; i++ {
+		res, err = client.Get(pageUrl)
+		if err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", res.StatusCode)
+	}
+	if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Fatalf("unexpected content type: %s", ct)
+	}
+	body, _ := io.ReadAll(res.Body)
+	return string(body)
+}
+
+func TestOnboardingPage(t *testing.T) {
+	i18n.Init()
+
+	port := getFreePort(t)
+	sites := []string{"api.pypy.dance", "api.udon.dance"}
+
+	go hijack.Start(sites, false, port)
+	defer hijack.Stop()
+
+	proxyAddr := fmt.Sprintf("127.0.0.1:%d", port)
+	pageUrl := fmt.Sprintf("http://%s/", proxyAddr)
+
+	page := getOnboardingPage(t, http.DefaultClient, pageUrl)
+	if !strings.Contains(page, proxyAddr) || !strings.Contains(page, "api.udon.dance") {
+		t.Fatalf("proxy address or sites are missing:\n%s", page)
+	}
+	if strings.Contains(page, "/ca.crt") {
+		t.Fatal("CA download is shown without HTTPS interception")
+	}
+
+	// a device whose Wi-Fi proxy is already set to us opens the page through the proxy
+	proxyUrl, _ := url.Parse(pageUrl)
+	proxied := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyUrl)}}
+	page = getOnboardingPage(t, proxied, pageUrl)
+	if !strings.Contains(page, proxyAddr) {
+		t.Fatalf("proxy address is missing in the proxied page:\n%s", page)
+	}
+}
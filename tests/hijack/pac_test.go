@@ -0,0 +1,85 @@
+package hijack
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/hijack"
+)
+
+func getFreePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestServePAC(t *testing.T) {
+	port := getFreePort(t)
+	sites := []string{"api.pypy.dance", "api.udon.dance"}
+
+	go hijack.Start(sites, false, port)
+	defer hijack.Stop()
+
+	url := fmt.Sprintf("http://127.0.0.1:%d/proxy.pac", port)
+	var res *http.Response
+	var err error
+	for i := 0; i < 50; i++ {
+		res, err = http.Get(url)
+		if err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", res.StatusCode)
+	}
+	if ct := res.Header.Get("Content-Type"); ct != "application/x-ns-proxy-autoconfig" {
+		t.Fatalf("unexpected content type: %s", ct)
+	}
+	body, _ := io.ReadAll(res.Body)
+	pac := string(body)
+
+	if !strings.Contains(pac, `["api.pypy.dance","api.udon.dance"]`) {
+		t.Fatalf("intercepted sites are missing:\n%s", pac)
+	}
+	if !strings.Contains(pac, fmt.Sprintf(`"PROXY 127.0.0.1:%d"`, port)) || !strings.Contains(pac, `"DIRECT"`) {
+		t.Fatalf("unexpected routes:\n%s", pac)
+	}
+}
+
+func TestClashRules(t *testing.T) {
+	rules := hijack.GenerateClashRules([]string{"api.pypy.dance", "api.pypy.dance"}, 1234)
+
+	if strings.Count(rules, "(DOMAIN,api.pypy.dance)") != 2 {
+		t.Fatalf("expected a rule for each process:\n%s", rules)
+	}
+	if !strings.Contains(rules, "port: 1234") {
+		t.Fatalf("port is missing:\n%s", rules)
+	}
+}
+
+func TestWildcardSiteRules(t *testing.T) {
+	sites := []string{"api.pypy.dance", "*.googlevideo.com"}
+
+	rules := hijack.GenerateClashRules(sites, 1234)
+	if !strings.Contains(rules, "(DOMAIN-SUFFIX,googlevideo.com)") {
+		t.Fatalf("wildcard site should be a suffix rule:\n%s", rules)
+	}
+	pac := hijack.GeneratePAC(sites, 1234)
+	if !strings.Contains(pac, "dnsDomainIs") {
+		t.Fatalf("wildcard site isn't matched by suffix:\n%s", pac)
+	}
+}
@@ -0,0 +1,221 @@
+package hijack
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/elazarl/goproxy"
+	"github.com/wzhqwq/VRCDancePreloader/internal/hijack"
+)
+
+// the intercepted site, CONNECT to it on port 80 is relayed by us
+const relaySite = "relay.test"
+
+// serveRawTarget answers requests with raw responses, so that every way of ending a body is under control.
+// The number of accepted connections is counted.
+func serveRawTarget(t *testing.T) (string, *atomic.Int32) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	var accepted atomic.Int32
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			go serveRawConn(conn)
+		}
+	}()
+	return l.Addr().String(), &accepted
+}
+
+func serveRawConn(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		req, err := http.ReadRequest(r)
+		if err != nil {
+			return
+		}
+		switch req.URL.Path {
+		case "/fixed":
+			fmt.Fprint(conn, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nfixed")
+		case "/chunked":
+			fmt.Fprint(conn, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n6\r\nchunk \r\n8\r\nby chunk\r\n0\r\n\r\n")
+		case "/close":
+			// neither length nor chunked, the body ends when the connection closes
+			fmt.Fprint(conn, "HTTP/1.1 200 OK\r\n\r\nuntil close")
+			return
+		default:
+			fmt.Fprint(conn, "HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n")
+		}
+	}
+}
+
+// startRelay starts the proxy intercepting relaySite, requests that no handler takes go through upstreamUrl
+func startRelay(t *testing.T, upstreamUrl string) string {
+	if err := hijack.SetUpstreamProxy(upstreamUrl); err != nil {
+		t.Fatal(err)
+	}
+	hijack.SetUpstreamForMisses(true)
+	t.Cleanup(func() {
+		hijack.SetUpstreamProxy("")
+		hijack.SetUpstreamForMisses(false)
+	})
+
+	port := getFreePort(t)
+	go hijack.Start([]string{relaySite}, false, port)
+	t.Cleanup(hijack.Stop)
+
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+	for i := 0; i < 50; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			return addr
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("proxy is not started")
+	return ""
+}
+
+// startUpstreamTo starts an upstream proxy that connects every CONNECT to target
+func startUpstreamTo(t *testing.T, target string) string {
+	upstream := goproxy.NewProxyHttpServer()
+	upstream.ConnectDial = func(network, _ string) (net.Conn, error) {
+		return net.Dial(network, target)
+	}
+	server := &http.Server{Handler: upstream}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(l)
+	t.Cleanup(func() { server.Close() })
+	return "http://" + l.Addr().String()
+}
+
+type tunnel struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func openTunnel(t *testing.T, proxyAddr string) *tunnel {
+	conn, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	target := relaySite + ":80"
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", target, target)
+	tun := &tunnel{Conn: conn, reader: bufio.NewReader(conn)}
+	res, err := http.ReadResponse(tun.reader, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("CONNECT is not established: %s", res.Status)
+	}
+	return tun
+}
+
+// get sends a request through the tunnel and reads the whole response
+func (tun *tunnel) get(t *testing.T, path string) (*http.Response, string) {
+	req, _ := http.NewRequest(http.MethodGet, "http://"+relaySite+path, nil)
+	if err := req.Write(tun); err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.ReadResponse(tun.reader, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, string(body)
+}
+
+func TestRelayRequestsOnOneConnect(t *testing.T) {
+	target, accepted := serveRawTarget(t)
+	proxyAddr := startRelay(t, startUpstreamTo(t, target))
+
+	tun := openTunnel(t, proxyAddr)
+	for i, path := range []string{"/fixed", "/chunked", "/fixed", "/missing"} {
+		res, body := tun.get(t, path)
+		switch path {
+		case "/fixed":
+			if res.ContentLength != 5 || body != "fixed" {
+				t.Fatalf("request %d: unexpected body %q in length %d", i, body, res.ContentLength)
+			}
+		case "/chunked":
+			if len(res.TransferEncoding) == 0 || res.TransferEncoding[0] != "chunked" {
+				t.Fatalf("request %d: the body should stay chunked, got %v", i, res.TransferEncoding)
+			}
+			if body != "chunk by chunk" {
+				t.Fatalf("request %d: unexpected body %q", i, body)
+			}
+		case "/missing":
+			if res.StatusCode != http.StatusNotFound {
+				t.Fatalf("request %d: unexpected status %s", i, res.Status)
+			}
+		}
+		if res.Close {
+			t.Fatalf("request %d: the tunnel should be kept alive", i)
+		}
+	}
+
+	if n := accepted.Load(); n != 1 {
+		t.Fatalf("expected the connection to the target to be reused, %d connections are made", n)
+	}
+}
+
+func TestRelayBodyUntilClose(t *testing.T) {
+	target, _ := serveRawTarget(t)
+	proxyAddr := startRelay(t, startUpstreamTo(t, target))
+
+	tun := openTunnel(t, proxyAddr)
+	res, body := tun.get(t, "/close")
+	if body != "until close" {
+		t.Fatalf("unexpected body %q", body)
+	}
+	if !res.Close {
+		t.Fatal("the client should be told that the connection closes")
+	}
+	if _, err := tun.reader.ReadByte(); err != io.EOF {
+		t.Fatalf("the tunnel should be closed, got %v", err)
+	}
+}
+
+func TestRelayUnreachableUpstream(t *testing.T) {
+	// nothing listens on this port
+	proxyAddr := startRelay(t, fmt.Sprintf("http://127.0.0.1:%d", getFreePort(t)))
+
+	tun := openTunnel(t, proxyAddr)
+	res, body := tun.get(t, "/fixed")
+	if res.StatusCode != http.StatusBadGateway {
+		t.Fatalf("expected 502, got %s", res.Status)
+	}
+	if !strings.Contains(body, relaySite) {
+		t.Fatalf("the target should be mentioned, got %q", body)
+	}
+	if _, err := tun.reader.ReadByte(); err != io.EOF {
+		t.Fatalf("the tunnel should be closed, got %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package hijack
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/hijack"
+)
+
+func findResult(t *testing.T, results []hijack.CheckResult, prefix string) hijack.CheckResult {
+	for _, r := range results {
+		if strings.HasPrefix(r.Name, prefix) {
+			return r
+		}
+	}
+	t.Fatalf("no result of %s in %v", prefix, results)
+	return hijack.CheckResult{}
+}
+
+func waitForPort(t *testing.T, port int) {
+	for i := 0; i < 50; i++ {
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		if err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("proxy is not started")
+}
+
+func TestSelfCheck(t *testing.T) {
+	if err := hijack.InitCA(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	port := getFreePort(t)
+	// api.pypy.dance is served over http and aya.kiva.moe over https
+	sites := []string{"api.pypy.dance", "aya.kiva.moe"}
+
+	// nothing listens on the port yet
+	results := hijack.SelfCheck(sites, true, port)
+	if r := findResult(t, results, "Intercept http://api.pypy.dance"); r.Status != hijack.CheckFailed {
+		t.Fatalf("interception passed without the proxy: %v", r)
+	}
+
+	go hijack.Start(sites, true, port)
+	defer hijack.Stop()
+	waitForPort(t, port)
+
+	results = hijack.SelfCheck(sites, true, port)
+	if r := findResult(t, results, "Upstream proxy loop"); r.Status != hijack.CheckPassed {
+		t.Errorf("unexpected loop: %v", r)
+	}
+	if r := findResult(t, results, "HTTPS interception"); r.Status != hijack.CheckPassed {
+		t.Errorf("MITM self test failed: %v", r)
+	}
+	if r := findResult(t, results, "Intercept http://api.pypy.dance"); r.Status != hijack.CheckPassed {
+		t.Errorf("http request is not intercepted: %v", r)
+	}
+	if r := findResult(t, results, "Intercept https://aya.kiva.moe"); r.Status != hijack.CheckPassed {
+		t.Errorf("https request is not intercepted: %v", r)
+	}
+}
+
+func TestSelfCheckProxyLoop(t *testing.T) {
+	port := getFreePort(t)
+	if err := hijack.SetUpstreamProxy(fmt.Sprintf("http://127.0.0.1:%d", port)); err != nil {
+		t.Fatal(err)
+	}
+	defer hijack.SetUpstreamProxy("")
+
+	results := hijack.SelfCheck(nil, false, port)
+	if r := findResult(t, results, "Upstream proxy loop"); r.Status != hijack.CheckFailed {
+		t.Fatalf("loop is not detected: %v", r)
+	}
+}
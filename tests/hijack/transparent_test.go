@@ -0,0 +1,131 @@
+package hijack
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/hijack"
+	"github.com/wzhqwq/VRCDancePreloader/internal/requesting"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// serveFakeDNS answers every A query with ip
+func serveFakeDNS(t *testing.T, ip [4]byte) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var query dnsmessage.Message
+			if err := query.Unpack(buf[:n]); err != nil || len(query.Questions) == 0 {
+				continue
+			}
+			q := query.Questions[0]
+			answer := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: query.ID, Response: true, RecursionAvailable: true},
+				Questions: query.Questions,
+			}
+			if q.Type == dnsmessage.TypeA {
+				answer.Answers = append(answer.Answers, dnsmessage.Resource{
+					Header: dnsmessage.ResourceHeader{Name: q.Name, Type: q.Type, Class: q.Class, TTL: 60},
+					Body:   &dnsmessage.AResource{A: ip},
+				})
+			}
+			packed, err := answer.Pack()
+			if err != nil {
+				continue
+			}
+			conn.WriteTo(packed, addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func TestBypassResolver(t *testing.T) {
+	server := serveFakeDNS(t, [4]byte{10, 1, 2, 3})
+	r, err := requesting.NewResolver(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ips, err := r.LookupIP(context.Background(), "jd.pypy.moe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 1 || !ips[0].Equal(net.IPv4(10, 1, 2, 3)) {
+		t.Fatalf("unexpected addresses %v", ips)
+	}
+
+	if _, err := requesting.NewResolver("dns.example.com"); err == nil {
+		t.Fatal("resolver should be an IP address")
+	}
+}
+
+func TestTransparentSNI(t *testing.T) {
+	dir := t.TempDir()
+	if err := hijack.InitCA(dir); err != nil {
+		t.Fatal(err)
+	}
+	caPEM, err := os.ReadFile(filepath.Join(dir, "ca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caPEM)
+
+	port := getFreePort(t)
+	if err := hijack.StartTransparent([]string{"www.bilibili.com"}, true, 0, port); err != nil {
+		t.Fatal(err)
+	}
+	defer hijack.StopTransparent()
+	addr := "127.0.0.1:" + strconv.Itoa(port)
+
+	// the certificate is signed for the server name
+	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: "www.bilibili.com", RootCAs: roots})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.ConnectionState().PeerCertificates[0].VerifyHostname("www.bilibili.com"); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	// sites that aren't intercepted are rejected
+	if conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: "example.com", RootCAs: roots}); err == nil {
+		conn.Close()
+		t.Fatal("handshake for a site that isn't intercepted should fail")
+	}
+
+	// requests for other hosts through the intercepted connection are rejected too
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{ServerName: "www.bilibili.com", RootCAs: roots},
+		},
+	}
+	defer client.CloseIdleConnections()
+	req, _ := http.NewRequest(http.MethodGet, "https://"+addr+"/", nil)
+	req.Host = "example.com"
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusMisdirectedRequest {
+		t.Fatalf("unexpected status %d", res.StatusCode)
+	}
+}
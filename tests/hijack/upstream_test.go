@@ -0,0 +1,68 @@
+package hijack
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/elazarl/goproxy"
+	"github.com/wzhqwq/VRCDancePreloader/internal/hijack"
+)
+
+func TestUpstreamProxy(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer target.Close()
+
+	var forwarded atomic.Int32
+	upstream := goproxy.NewProxyHttpServer()
+	upstream.OnRequest().DoFunc(func(req *http.Request, ctx *goproxy.ProxyCtx) (*http.Request, *http.Response) {
+		forwarded.Add(1)
+		return req, nil
+	})
+	upstreamServer := httptest.NewServer(upstream)
+	defer upstreamServer.Close()
+
+	if err := hijack.SetUpstreamProxy("ftp://127.0.0.1:21"); err == nil {
+		t.Fatal("unsupported upstream proxy is accepted")
+	}
+	if err := hijack.SetUpstreamProxy(upstreamServer.URL); err != nil {
+		t.Fatal(err)
+	}
+	defer hijack.SetUpstreamProxy("")
+
+	port := getFreePort(t)
+	go hijack.Start([]string{"api.pypy.dance"}, false, port)
+	defer hijack.Stop()
+
+	proxyUrl, _ := url.Parse(fmt.Sprintf("http://127.0.0.1:%d", port))
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyUrl)}}
+
+	var res *http.Response
+	var err error
+	for i := 0; i < 50; i++ {
+		res, err = client.Get(target.URL)
+		if err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+
+	if string(body) != "ok" {
+		t.Fatalf("unexpected body: %s", body)
+	}
+	if forwarded.Load() != 1 {
+		t.Fatalf("expected the request to go through the upstream proxy, got %d", forwarded.Load())
+	}
+}
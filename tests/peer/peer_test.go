@@ -0,0 +1,190 @@
+package peer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/cache"
+	"github.com/wzhqwq/VRCDancePreloader/internal/peer"
+	"github.com/wzhqwq/VRCDancePreloader/internal/persistence"
+)
+
+func getFreePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+const testETag = `"pypy-1-etag"`
+
+// startInstance starts our own peer server, so that downloads ask the peers first
+func startInstance(t *testing.T) {
+	port := getFreePort(t)
+	go peer.Start(port, false)
+	t.Cleanup(peer.Stop)
+
+	for i := 0; i < 50; i++ {
+		res, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/peer/info", port))
+		if err == nil {
+			res.Body.Close()
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("peer server is not started")
+}
+
+// startPeer serves the files of another instance and makes it the only peer, its address is returned
+func startPeer(t *testing.T, open peer.Opener) string {
+	server := httptest.NewServer(peer.NewServer(0, open).Handler)
+	t.Cleanup(server.Close)
+
+	addr := strings.TrimPrefix(server.URL, "http://")
+	peer.SetStaticPeers([]string{addr})
+	return addr
+}
+
+// memoryOpener opens the files kept in memory, all of them are downloaded from the remote files with etag
+func memoryOpener(files map[string][]byte, etag string) peer.Opener {
+	return func(id string) (*cache.CompleteFile, error) {
+		body, ok := files[id]
+		if !ok {
+			return nil, errors.New("not found")
+		}
+		return &cache.CompleteFile{
+			ReadSeeker: bytes.NewReader(body),
+			Closer:     io.NopCloser(nil),
+			Size:       int64(len(body)),
+			ModTime:    time.Unix(1700000000, 0),
+			ETag:       etag,
+		}, nil
+	}
+}
+
+func TestFetchFromPeer(t *testing.T) {
+	body := bytes.Repeat([]byte("0123456789"), 100000)
+	peer.SetAllowList([]string{"127.0.0.1", "::1"})
+	startInstance(t)
+	startPeer(t, memoryOpener(map[string][]byte{"pypy_1": body}, testETag))
+
+	ctx := context.Background()
+
+	r := peer.Fetch(ctx, "pypy_1", int64(len(body)), testETag, 0)
+	if r == nil {
+		t.Fatal("pypy_1 should be fetched")
+	}
+	all, _ := io.ReadAll(r)
+	r.Close()
+	if !bytes.Equal(all, body) {
+		t.Fatal("body mismatch")
+	}
+
+	// resume from the middle
+	r = peer.Fetch(ctx, "pypy_1", int64(len(body)), testETag, 12345)
+	if r == nil {
+		t.Fatal("pypy_1 should be fetched from offset")
+	}
+	rest, _ := io.ReadAll(r)
+	r.Close()
+	if !bytes.Equal(rest, body[12345:]) {
+		t.Fatal("body from offset mismatch")
+	}
+
+	if r := peer.Fetch(ctx, "pypy_1", int64(len(body))+1, testETag, 0); r != nil {
+		r.Close()
+		t.Fatal("a copy in a different size should not be fetched")
+	}
+	for _, etag := range []string{"", `W/"pypy-1-etag"`, `"another-etag"`} {
+		if r := peer.Fetch(ctx, "pypy_1", int64(len(body)), etag, 0); r != nil {
+			r.Close()
+			t.Fatalf("a copy should not be fetched with etag %q", etag)
+		}
+	}
+	if r := peer.Fetch(ctx, "pypy_2", int64(len(body)), testETag, 0); r != nil {
+		r.Close()
+		t.Fatal("pypy_2 is not cached by the peer")
+	}
+}
+
+func TestFetchWithoutETagFromPeer(t *testing.T) {
+	body := bytes.Repeat([]byte("9876543210"), 100000)
+	peer.SetAllowList([]string{"127.0.0.1", "::1"})
+	startInstance(t)
+	// the peer does not know which remote file its copy is downloaded from
+	startPeer(t, memoryOpener(map[string][]byte{"pypy_1": body}, ""))
+
+	if r := peer.Fetch(context.Background(), "pypy_1", int64(len(body)), testETag, 0); r != nil {
+		r.Close()
+		t.Fatal("a copy without ETag should not be fetched")
+	}
+}
+
+func TestAllowList(t *testing.T) {
+	body := bytes.Repeat([]byte("abcdefghij"), 10000)
+	peer.SetAllowList([]string{"192.168.0.0/16"})
+	startInstance(t)
+	addr := startPeer(t, memoryOpener(map[string][]byte{"pypy_1": body}, testETag))
+
+	res, err := http.Get(fmt.Sprintf("http://%s/peer/cache/pypy_1", addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", res.StatusCode)
+	}
+
+	if r := peer.Fetch(context.Background(), "pypy_1", int64(len(body)), testETag, 0); r != nil {
+		r.Close()
+		t.Fatal("fetching from a peer out of the allow list should fail")
+	}
+}
+
+// the only test that sets up the cache, the globals of the cache can't be set up twice in a process
+func TestServeCompleteCache(t *testing.T) {
+	body := bytes.Repeat([]byte("0123456789"), 100000)
+
+	dir := t.TempDir()
+	if err := persistence.InitDB(filepath.Join(dir, "data.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(persistence.CloseDB)
+	cacheDir := filepath.Join(dir, "cache")
+	if err := os.Mkdir(cacheDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(cacheDir, "pypy_1.mp4"), body, 0666); err != nil {
+		t.Fatal(err)
+	}
+	cache.SetupCache(cacheDir)
+	t.Cleanup(cache.StopCache)
+	persistence.SaveCacheValidators("pypy_1", testETag, "", int64(len(body)))
+
+	peer.SetAllowList([]string{"127.0.0.1", "::1"})
+	startInstance(t)
+	startPeer(t, cache.OpenCompleteCache)
+
+	r := peer.Fetch(context.Background(), "pypy_1", int64(len(body)), testETag, 100)
+	if r == nil {
+		t.Fatal("pypy_1 should be fetched")
+	}
+	rest, _ := io.ReadAll(r)
+	r.Close()
+	if !bytes.Equal(rest, body[100:]) {
+		t.Fatal("body mismatch")
+	}
+}
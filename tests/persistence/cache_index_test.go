@@ -0,0 +1,40 @@
+package persistence
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/persistence"
+	"github.com/wzhqwq/VRCDancePreloader/internal/types"
+)
+
+func TestServedBeforeIndexed(t *testing.T) {
+	if err := persistence.InitDB(filepath.Join(t.TempDir(), "data.db")); err != nil {
+		t.Fatal(err)
+	}
+	defer persistence.CloseDB()
+
+	persistence.MarkCacheServed("pypy_1")
+	persistence.MarkCacheServed("pypy_1")
+	persistence.SaveCacheFile(&persistence.CacheFile{
+		ID:         "pypy_1",
+		Format:     types.CacheFormatTrunk,
+		Size:       100,
+		FullSize:   100,
+		Downloaded: 100,
+		IsComplete: true,
+		ModTime:    time.Now(),
+	})
+
+	f, err := persistence.GetCacheFile("pypy_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.ServeCount != 2 || f.LastServed.IsZero() {
+		t.Errorf("serves before indexing are lost: count %d, last served %v", f.ServeCount, f.LastServed)
+	}
+	if f.Size != 100 || !f.IsComplete {
+		t.Errorf("file info is not saved: %+v", f)
+	}
+}
@@ -0,0 +1,157 @@
+package persistence
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/wzhqwq/VRCDancePreloader/internal/persistence"
+)
+
+// createFixtureDB creates a database of the schema before migrations with some rows
+func createFixtureDB(t *testing.T) string {
+	fixture, err := os.ReadFile(filepath.Join("testdata", "schema_v0.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "data.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(string(fixture)); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func countRows(t *testing.T, db *sql.DB, table string) int {
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestUpgradeFixture(t *testing.T) {
+	path := createFixtureDB(t)
+
+	if err := persistence.InitDB(path); err != nil {
+		t.Fatal(err)
+	}
+	defer persistence.CloseDB()
+
+	version, err := persistence.GetSchemaVersion(persistence.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != persistence.LatestSchemaVersion() {
+		t.Fatalf("database is at version %d instead of %d", version, persistence.LatestSchemaVersion())
+	}
+
+	// the existing rows are kept, and the tables added later are created
+	if n := countRows(t, persistence.DB, "local_song"); n != 2 {
+		t.Errorf("expected 2 local songs, got %d", n)
+	}
+	if n := countRows(t, persistence.DB, "allow_list"); n != 1 {
+		t.Errorf("expected 1 allow list entry, got %d", n)
+	}
+	countRows(t, persistence.DB, "cache_index")
+	countRows(t, persistence.DB, "request_log")
+
+	// the database before migration is backed up
+	backup, err := sql.Open("sqlite3", path+".v0.bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backup.Close()
+	if n := countRows(t, backup, "local_song"); n != 2 {
+		t.Errorf("expected 2 local songs in the backup, got %d", n)
+	}
+	if _, err := backup.Exec("SELECT * FROM schema_version"); err == nil {
+		t.Error("backup should be taken before migrating")
+	}
+}
+
+func TestNoBackupWithoutPendingMigrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.db")
+	if err := persistence.InitDB(path); err != nil {
+		t.Fatal(err)
+	}
+	persistence.CloseDB()
+	if _, err := os.Stat(path + ".v0.bak"); err == nil {
+		t.Error("a new database should not be backed up")
+	}
+
+	if err := persistence.InitDB(path); err != nil {
+		t.Fatal(err)
+	}
+	persistence.CloseDB()
+	matches, _ := filepath.Glob(path + ".*.bak")
+	if len(matches) > 0 {
+		t.Errorf("up-to-date database should not be backed up: %v", matches)
+	}
+}
+
+func TestFailedMigrationRollsBack(t *testing.T) {
+	path := createFixtureDB(t)
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ms := []persistence.Migration{
+		{
+			Version: 1,
+			Name:    "add column",
+			Up: func(tx *sql.Tx) error {
+				_, err := tx.Exec("ALTER TABLE local_song ADD COLUMN note TEXT DEFAULT ''")
+				return err
+			},
+		},
+		{
+			Version: 2,
+			Name:    "broken",
+			SQL: []string{
+				"CREATE TABLE half_done (id INTEGER)",
+				"INSERT INTO no_such_table VALUES (1)",
+			},
+		},
+	}
+	if err := persistence.Migrate(db, path, ms); err == nil {
+		t.Fatal("broken migration should fail")
+	}
+
+	version, err := persistence.GetSchemaVersion(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 {
+		t.Fatalf("database should stay at version 1, got %d", version)
+	}
+	if _, err := db.Exec("SELECT note FROM local_song"); err != nil {
+		t.Errorf("migration 1 is not applied: %v", err)
+	}
+	if _, err := db.Exec("SELECT * FROM half_done"); err == nil {
+		t.Error("changes of the failed migration should be rolled back")
+	}
+}
+
+func TestMigrationOrder(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ms := []persistence.Migration{{Version: 2}, {Version: 1}}
+	if err := persistence.Migrate(db, "", ms); !errors.Is(err, persistence.ErrMigrationOrder) {
+		t.Fatalf("expected ErrMigrationOrder, got %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package persistence
+
+import (
+	"testing"
+	"time"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/persistence"
+)
+
+func TestOrdersMigrated(t *testing.T) {
+	path := createFixtureDB(t)
+	if err := persistence.InitDB(path); err != nil {
+		t.Fatal(err)
+	}
+	defer persistence.CloseDB()
+
+	if n := countRows(t, persistence.DB, "dance_order"); n != 2 {
+		t.Fatalf("expected 2 orders, got %d", n)
+	}
+	if _, err := persistence.DB.Exec("SELECT orders FROM dance_record"); err == nil {
+		t.Error("orders column should be dropped")
+	}
+
+	record, err := persistence.GetLocalRecords().GetRecord(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Comment != "first night" {
+		t.Errorf("unexpected comment %q", record.Comment)
+	}
+	orders := record.GetOrdersSnapshot()
+	if len(orders) != 2 {
+		t.Fatalf("expected 2 orders, got %d", len(orders))
+	}
+	if orders[0].ID != "pypy_1" || orders[0].Username != "alice" || orders[0].DanceRoom != "PyPyDance" {
+		t.Errorf("unexpected first order %+v", orders[0])
+	}
+	if orders[1].ID != "wanna_2" || !orders[1].Time.After(orders[0].Time) {
+		t.Errorf("unexpected second order %+v", orders[1])
+	}
+}
+
+func TestOrdersPersisted(t *testing.T) {
+	path := createFixtureDB(t)
+	if err := persistence.InitDB(path); err != nil {
+		t.Fatal(err)
+	}
+	defer persistence.CloseDB()
+
+	records := persistence.GetLocalRecords()
+	record, err := records.GetRecord(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orderTime := time.Unix(1767226800, 0)
+	record.AddOrder(persistence.Order{
+		ID:       "pypy_1",
+		Title:    "Song 1",
+		Username: "carol",
+		Time:     orderTime,
+		World:    "wrld_test",
+		Instance: "1~region(jp)",
+	})
+
+	counts, err := records.CountPlays()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts["pypy_1"] != 2 || counts["wanna_2"] != 1 {
+		t.Errorf("unexpected play counts %v", counts)
+	}
+	orders, err := records.GetOrdersOf("pypy_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 2 || orders[0].Username != "carol" || orders[0].Instance != "1~region(jp)" {
+		t.Errorf("unexpected orders of pypy_1 %+v", orders)
+	}
+
+	record.RemoveOrder(orderTime)
+	if n, err := records.CountPlaysOf("pypy_1"); err != nil || n != 1 {
+		t.Errorf("order is not removed, %d plays (%v)", n, err)
+	}
+
+	if err := records.DeleteRecord(1); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, persistence.DB, "dance_order"); n != 0 {
+		t.Errorf("orders of the deleted record are kept: %d", n)
+	}
+}
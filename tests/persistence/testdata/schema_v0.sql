@@ -0,0 +1,38 @@
+-- a database created before schema migrations are introduced, tables are created by CREATE TABLE IF NOT EXISTS
+CREATE TABLE allow_list (
+		id TEXT PRIMARY KEY,
+		size INTEGER
+);
+CREATE TABLE dance_record (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		start_time INTEGER,
+		comment TEXT,
+		orders TEXT
+);
+CREATE TABLE local_song (
+		id TEXT PRIMARY KEY,
+		title TEXT,
+		like INTEGER,
+		skill INTEGER,
+		is_favorite BOOLEAN,
+		sync_in_game BOOLEAN
+);
+CREATE INDEX idx_local_song_is_favorite ON local_song (is_favorite);
+CREATE INDEX idx_local_song_like ON local_song (like);
+CREATE INDEX idx_local_song_skill ON local_song (skill);
+CREATE INDEX idx_local_song_sync_in_game ON local_song (sync_in_game);
+CREATE TABLE world_data (
+		world TEXT PRIMARY KEY,
+		data TEXT,
+		settings TEXT
+);
+
+INSERT INTO allow_list (id, size) VALUES ('pypy_1', 1024);
+INSERT INTO local_song (id, title, like, skill, is_favorite, sync_in_game) VALUES ('pypy_1', 'Song 1', 5, 3, 1, 0);
+INSERT INTO local_song (id, title, like, skill, is_favorite, sync_in_game) VALUES ('wanna_2', 'Song 2', 0, 0, 0, 0);
+INSERT INTO world_data (world, data, settings) VALUES ('wrld_1', '{"a":"1"}', '{}');
+INSERT INTO dance_record (start_time, comment, orders) VALUES (
+	1767225600,
+	'first night',
+	'[{"id":"pypy_1","title":"Song 1","username":"alice","time":"2026-01-01T00:05:00Z","dance_room":"PyPyDance"},{"id":"wanna_2","title":"Song 2","username":"bob","time":"2026-01-01T00:10:00Z","dance_room":"PyPyDance"}]'
+);
@@ -0,0 +1,248 @@
+package rw_file
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/rw_file"
+	"github.com/wzhqwq/VRCDancePreloader/internal/rw_file/continuous"
+	"github.com/wzhqwq/VRCDancePreloader/internal/rw_file/fragmented"
+	"github.com/wzhqwq/VRCDancePreloader/internal/rw_file/legacy_file"
+)
+
+const bodySize = 1024 * 300
+const chunkSize = 1024 * 4
+
+func makeBody(size int) []byte {
+	body := make([]byte, size)
+	for i := range body {
+		body[i] = byte(i % 251)
+	}
+	return body
+}
+
+func TestParseRangeHeader(t *testing.T) {
+	cases := []struct {
+		header string
+		want   []rw_file.Range
+	}{
+		{"", nil},
+		{"bytes=-500", []rw_file.Range{{Start: 500, Length: 500, Suffix: true}}},
+		{"bytes=-2000", []rw_file.Range{{Start: 0, Length: 1000, Suffix: true}}},
+		{"bytes=0-99, 900-", []rw_file.Range{{Start: 0, Length: 100}, {Start: 900, Length: 100}}},
+		{"bytes=900-5000", []rw_file.Range{{Start: 900, Length: 100}}},
+		// out of bounds
+		{"bytes=2000-", nil},
+		{"bytes=0-9,2000-3000", []rw_file.Range{{Start: 0, Length: 10}}},
+		// malformed
+		{"bytes=a-b", nil},
+		{"bytes=10-5", nil},
+		{"items=0-1", nil},
+	}
+	for _, c := range cases {
+		got := rw_file.ParseRangeHeader(c.header, 1000)
+		if len(got) == 0 && len(c.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%q: got %v, want %v", c.header, got, c.want)
+		}
+	}
+}
+
+func TestPrioritizeRanges(t *testing.T) {
+	ranges := []rw_file.Range{{Start: 500, Length: 10}, {Start: 0, Length: 10}, {Start: 900, Length: 100}}
+	got := rw_file.PrioritizeRanges(ranges, 1000)
+	want := []rw_file.Range{{Start: 900, Length: 100}, {Start: 0, Length: 10}, {Start: 500, Length: 10}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestIsSuffix(t *testing.T) {
+	cases := []struct {
+		header string
+		want   bool
+	}{
+		{"bytes=-100", true},
+		{"bytes=950-", true},
+		{"bytes=900-999", true},
+		// open-ended ranges from the front
+		{"bytes=0-", false},
+		{"bytes=500-", false},
+		{"bytes=950-980", false},
+	}
+	for _, c := range cases {
+		r := rw_file.ParseRangeHeader(c.header, 1000)[0]
+		if got := r.IsSuffix(1000); got != c.want {
+			t.Errorf("%q: got %v, want %v", c.header, got, c.want)
+		}
+	}
+}
+
+// download acts like the downloader, it restarts from the new offset whenever the file asks
+func download(ctx context.Context, f rw_file.DeferredReadableFile, body []byte, offsets chan<- int64) {
+	for !f.IsComplete() {
+		f.MarkDownloading()
+		offset := f.GetDownloadOffset()
+		if offsets != nil {
+			offsets <- offset
+		}
+		for offset < int64(len(body)) {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Millisecond):
+			}
+			n, err := f.Append(body[offset:min(offset+chunkSize, int64(len(body)))])
+			offset += int64(n)
+			if err != nil {
+				break
+			}
+		}
+	}
+}
+
+type fileFormat struct {
+	name string
+	open func(baseName string) rw_file.DeferredReadableFile
+}
+
+var formats = []fileFormat{
+	{"legacy", func(baseName string) rw_file.DeferredReadableFile { return legacy_file.NewFile(baseName) }},
+	{"continuous", func(baseName string) rw_file.DeferredReadableFile { return continuous.NewFile(baseName) }},
+	{"fragmented", func(baseName string) rw_file.DeferredReadableFile { return fragmented.NewFile(baseName) }},
+}
+
+func serve(t *testing.T, f rw_file.DeferredReadableFile, rangeHeader string) *http.Response {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req := httptest.NewRequest(http.MethodGet, "/video.mp4", nil)
+	req.Header.Set("Range", rangeHeader)
+	if ranges := rw_file.ParseRangeHeader(rangeHeader, f.TotalLen()); len(ranges) > 0 {
+		f.NotifyRequestRanges(ranges)
+	}
+
+	w := httptest.NewRecorder()
+	http.ServeContent(w, req, "video.mp4", time.Unix(1700000000, 0), f.RequestRs(ctx))
+	return w.Result()
+}
+
+func readParts(t *testing.T, res *http.Response) map[string][]byte {
+	_, params, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := make(map[string][]byte)
+	r := multipart.NewReader(res.Body, params["boundary"])
+	for {
+		p, err := r.NextPart()
+		if errors.Is(err, io.EOF) {
+			return parts
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(p)
+		parts[p.Header.Get("Content-Range")] = data
+	}
+}
+
+func TestServeRanges(t *testing.T) {
+	body := makeBody(bodySize)
+
+	for _, format := range formats {
+		t.Run(format.name, func(t *testing.T) {
+			f := format.open(filepath.Join(t.TempDir(), "pypy_1.mp4"))
+			if f == nil {
+				t.Fatal("failed to create file")
+			}
+			defer f.Close()
+			f.UpdateRemoteInfo(bodySize, time.Unix(1700000000, 0))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// suffix range
+			suffixRes := make(chan *http.Response, 1)
+			go func() {
+				suffixRes <- serve(t, f, "bytes=-1000")
+			}()
+			go download(ctx, f, body, nil)
+
+			res := <-suffixRes
+			if res.StatusCode != http.StatusPartialContent {
+				t.Fatalf("suffix: unexpected status %d", res.StatusCode)
+			}
+			data, _ := io.ReadAll(res.Body)
+			if !bytes.Equal(data, body[bodySize-1000:]) {
+				t.Fatal("suffix: content mismatch")
+			}
+
+			// multiple ranges
+			res = serve(t, f, "bytes=0-99,200000-200099")
+			if res.StatusCode != http.StatusPartialContent {
+				t.Fatalf("multi: unexpected status %d", res.StatusCode)
+			}
+			parts := readParts(t, res)
+			if !bytes.Equal(parts["bytes 0-99/307200"], body[:100]) ||
+				!bytes.Equal(parts["bytes 200000-200099/307200"], body[200000:200100]) {
+				t.Fatalf("multi: content mismatch, got parts %v", len(parts))
+			}
+
+			// out of bounds
+			res = serve(t, f, "bytes=400000-")
+			if res.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+				t.Fatalf("out of bounds: unexpected status %d", res.StatusCode)
+			}
+			if !strings.HasPrefix(res.Header.Get("Content-Range"), "bytes */") {
+				t.Fatalf("out of bounds: unexpected Content-Range %s", res.Header.Get("Content-Range"))
+			}
+		})
+	}
+}
+
+func TestFragmentedPrioritizesSuffix(t *testing.T) {
+	body := makeBody(bodySize)
+	f := fragmented.NewFile(filepath.Join(t.TempDir(), "pypy_1.mp4"))
+	if f == nil {
+		t.Fatal("failed to create file")
+	}
+	defer f.Close()
+	f.UpdateRemoteInfo(bodySize, time.Unix(1700000000, 0))
+
+	// a player reads the head and the moov box at the end with one request
+	f.NotifyRequestRanges([]rw_file.Range{{Start: 1000, Length: 100}, {Start: bodySize - 5000, Length: 5000}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	offsets := make(chan int64, 16)
+	go download(ctx, f, body, offsets)
+
+	if offset := <-offsets; offset != bodySize-5000 {
+		t.Fatalf("expected to download the suffix first, got offset %d", offset)
+	}
+	if offset := <-offsets; offset != 1000 {
+		t.Fatalf("expected to download the other range next, got offset %d", offset)
+	}
+
+	// all requested ranges are readable
+	rs := f.RequestRs(ctx)
+	buf := make([]byte, 100)
+	rs.Seek(1000, io.SeekStart)
+	if _, err := io.ReadFull(rs, buf); err != nil || !bytes.Equal(buf, body[1000:1100]) {
+		t.Fatal("head range mismatch", err)
+	}
+}
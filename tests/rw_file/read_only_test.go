@@ -0,0 +1,55 @@
+package rw_file
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/rw_file"
+	"github.com/wzhqwq/VRCDancePreloader/internal/rw_file/continuous"
+)
+
+func TestReadOnlyFile(t *testing.T) {
+	baseName := filepath.Join(t.TempDir(), "video.mp4")
+	body := makeBody(bodySize)
+
+	f := continuous.NewFile(baseName)
+	f.UpdateRemoteInfo(int64(len(body)), time.Unix(1700000000, 0))
+	if _, err := f.Append(body); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ro := continuous.NewReadOnlyFile(baseName)
+	if ro == nil || !ro.IsComplete() {
+		t.Fatal("complete file is not opened")
+	}
+	if _, err := ro.Append(body[:chunkSize]); !errors.Is(err, rw_file.ErrReadOnly) {
+		t.Errorf("append should be refused, got %v", err)
+	}
+	if err := ro.Clear(); !errors.Is(err, rw_file.ErrReadOnly) {
+		t.Errorf("clear should be refused, got %v", err)
+	}
+	ro.UpdateRemoteInfo(1, time.Now())
+
+	got, err := io.ReadAll(io.NewSectionReader(ro, 0, ro.TotalLen()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, body) {
+		t.Error("content differs")
+	}
+	ro.Close()
+
+	// nothing is changed on disk
+	f = continuous.NewFile(baseName)
+	defer f.Close()
+	if !f.IsComplete() || f.TotalLen() != int64(len(body)) {
+		t.Errorf("file is modified through the read-only handle: complete %v, size %d", f.IsComplete(), f.TotalLen())
+	}
+}
@@ -0,0 +1,152 @@
+package stats
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/persistence"
+	"github.com/wzhqwq/VRCDancePreloader/internal/stats"
+)
+
+func at(month time.Month, day, hour, minute int) time.Time {
+	return time.Date(2026, month, day, hour, minute, 0, 0, time.Local)
+}
+
+// addRecord saves a new dance record with orders, the song lists aren't loaded so every song lasts 4 minutes at most
+func addRecord(orders ...persistence.Order) {
+	persistence.PrepareHistory(false)
+	record := persistence.GetCurrentRecord()
+	for _, order := range orders {
+		record.AddOrder(order)
+	}
+}
+
+func setUpHistory(t *testing.T) {
+	if err := persistence.InitDB(filepath.Join(t.TempDir(), "data.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(persistence.CloseDB)
+
+	addRecord(
+		persistence.Order{ID: "wanna_2", Title: "Song 2", Username: "bob", Time: time.Date(2025, 12, 31, 21, 0, 0, 0, time.Local), DanceRoom: "WannaDance"},
+	)
+	addRecord(
+		persistence.Order{ID: "pypy_1", Title: "Song 1", Username: "alice", Time: at(3, 1, 20, 0), DanceRoom: "PyPyDance"},
+		persistence.Order{ID: "wanna_2", Title: "Song 2", Username: "bob", Time: at(3, 1, 20, 3), DanceRoom: "WannaDance"},
+		persistence.Order{ID: "yt_abc", Title: "Custom", Username: "alice", Time: at(3, 1, 20, 10), DanceRoom: "PyPyDance"},
+	)
+	// the order after midnight counts to the night before
+	addRecord(
+		persistence.Order{ID: "pypy_1", Title: "Song 1", Username: "carol", Time: at(3, 2, 21, 0), DanceRoom: "PyPyDance"},
+		persistence.Order{ID: "pypy_3", Title: "Song 3", Time: at(3, 3, 1, 0), DanceRoom: "PyPyDance"},
+	)
+	addRecord(
+		persistence.Order{ID: "pypy_1", Title: "Song 1", Username: "alice", Time: at(3, 4, 21, 0), DanceRoom: "PyPyDance"},
+	)
+}
+
+func TestCompute(t *testing.T) {
+	setUpHistory(t)
+
+	summary, err := stats.Compute(stats.Year(2026), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Plays != 6 || summary.Songs != 4 || summary.DanceDays != 3 {
+		t.Errorf("unexpected counts: %d plays, %d songs, %d days", summary.Plays, summary.Songs, summary.DanceDays)
+	}
+	// 3 + 4 + 4 minutes in the first record, cut by the next orders, and 4 minutes for the others
+	if summary.Minutes != 23 {
+		t.Errorf("expected 23 minutes, got %v", summary.Minutes)
+	}
+	if len(summary.Daily) != 3 || summary.Daily[0].Minutes != 11 || summary.Daily[1].Plays != 2 {
+		t.Errorf("unexpected daily stats %+v", summary.Daily)
+	}
+	if len(summary.Weekly) != 2 || summary.Weekly[0].Plays != 3 {
+		t.Errorf("unexpected weekly stats %+v", summary.Weekly)
+	}
+
+	if s := summary.TopSongs[0]; s.ID != "pypy_1" || s.Plays != 3 || s.Title != "Song 1" {
+		t.Errorf("unexpected top song %+v", s)
+	}
+	if a := summary.TopAdders[0]; a.Name != "alice" || a.Plays != 3 {
+		t.Errorf("unexpected top adder %+v", a)
+	}
+	if len(summary.TopAdders) != 3 {
+		t.Errorf("orders without adders should be skipped: %+v", summary.TopAdders)
+	}
+	if len(summary.Rooms) != 2 || summary.Rooms[0].Name != "PyPyDance" || summary.Rooms[0].Plays != 5 {
+		t.Errorf("unexpected rooms %+v", summary.Rooms)
+	}
+
+	// wanna_2 was danced last year
+	if len(summary.NewSongs) != 3 {
+		t.Errorf("unexpected new songs %+v", summary.NewSongs)
+	}
+	for _, s := range summary.NewSongs {
+		if s.ID == "wanna_2" {
+			t.Errorf("wanna_2 is not new")
+		}
+	}
+
+	if summary.LongestStreak.Days != 2 || !summary.LongestStreak.End.Equal(at(3, 2, 6, 0)) {
+		t.Errorf("unexpected longest streak %+v", summary.LongestStreak)
+	}
+	if summary.CurrentStreak.Days != 0 {
+		t.Errorf("unexpected current streak %+v", summary.CurrentStreak)
+	}
+}
+
+func TestComputeTop(t *testing.T) {
+	setUpHistory(t)
+
+	summary, err := stats.Compute(stats.AllTime(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.TopSongs) != 1 || len(summary.TopAdders) != 1 {
+		t.Errorf("rankings are not limited: %+v %+v", summary.TopSongs, summary.TopAdders)
+	}
+	if summary.Plays != 7 || len(summary.NewSongs) != 4 {
+		t.Errorf("unexpected all time stats: %d plays, %d new songs", summary.Plays, len(summary.NewSongs))
+	}
+}
+
+func TestWrapped(t *testing.T) {
+	setUpHistory(t)
+
+	wrapped, err := stats.ComputeWrapped(2026)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wrapped.FirstSong == nil || wrapped.FirstSong.ID != "pypy_1" || !wrapped.FirstSong.Time.Equal(at(3, 1, 20, 0)) {
+		t.Errorf("unexpected first song %+v", wrapped.FirstSong)
+	}
+	if wrapped.BusiestDay == nil || wrapped.BusiestDay.Minutes != 11 {
+		t.Errorf("unexpected busiest day %+v", wrapped.BusiestDay)
+	}
+	if len(wrapped.Monthly) != 1 || wrapped.BusiestMonth.Plays != 6 {
+		t.Errorf("unexpected months %+v", wrapped.Monthly)
+	}
+
+	empty, err := stats.ComputeWrapped(2020)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if empty.Plays != 0 || empty.FirstSong != nil || empty.BusiestDay != nil {
+		t.Errorf("unexpected wrapped of an empty year %+v", empty)
+	}
+}
+
+func TestRangeByName(t *testing.T) {
+	for _, name := range []string{"7d", "30d", "year", "all"} {
+		r, ok := stats.RangeByName(name)
+		if !ok || !r.From.Before(time.Now()) || !r.To.After(time.Now()) {
+			t.Errorf("range %s does not cover now: %+v", name, r)
+		}
+	}
+	if _, ok := stats.RangeByName("bogus"); ok {
+		t.Error("unknown range should be rejected")
+	}
+}
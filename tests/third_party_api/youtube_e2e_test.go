@@ -0,0 +1,184 @@
+package third_party_api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/cache"
+	"github.com/wzhqwq/VRCDancePreloader/internal/download"
+	"github.com/wzhqwq/VRCDancePreloader/internal/hijack"
+	"github.com/wzhqwq/VRCDancePreloader/internal/persistence"
+	"github.com/wzhqwq/VRCDancePreloader/internal/requesting"
+	"github.com/wzhqwq/VRCDancePreloader/internal/third_party_api"
+)
+
+func getFreePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// fakeGoogleVideo serves body as the stream of itag 18, the number of requests is counted
+func fakeGoogleVideo(t *testing.T, body []byte) (*httptest.Server, *atomic.Int32) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/videoplayback" || r.URL.Query().Get("itag") != "18" {
+			http.NotFound(w, r)
+			return
+		}
+		requests.Add(1)
+		http.ServeContent(w, r, "video.mp4", time.Unix(1700000000, 0), bytes.NewReader(body))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func setupCache(t *testing.T) {
+	dir := t.TempDir()
+	if err := persistence.InitDB(filepath.Join(dir, "data.db")); err != nil {
+		t.Fatal(err)
+	}
+	cacheDir := filepath.Join(dir, "cache")
+	if err := os.Mkdir(cacheDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	// the default format of the config
+	cache.SetFileFormat(1)
+	cache.SetupCache(cacheDir)
+	t.Cleanup(func() {
+		cache.StopCache()
+		persistence.CloseDB()
+	})
+
+	for _, name := range []requesting.ClientName{requesting.YouTubeVideo, requesting.YouTubeApi, requesting.YouTubeImage} {
+		requesting.InitClient(name, "")
+	}
+	download.InitDownloadManager(2)
+	// cleanups run last in first out, the downloads are stopped before the cache and the database
+	t.Cleanup(download.StopAllAndWait)
+}
+
+func TestDownloadYoutubeEntry(t *testing.T) {
+	// videos smaller than 1MB are not taken as videos
+	body := bytes.Repeat([]byte("0123456789abcdef"), 1024*128)
+	googleVideo, requests := fakeGoogleVideo(t, body)
+
+	streamUrl := fmt.Sprintf("%s/videoplayback?expire=%d&id=o-entry&itag=18&sig=ours", googleVideo.URL, time.Now().Add(6*time.Hour).Unix())
+	script, _ := fakeYtDlp(t, "jNQXAC9IVRw", streamUrl)
+	third_party_api.YtDlpPath = script
+	third_party_api.YtDlpProxy = ""
+	third_party_api.EnableYoutubeVideo = true
+	setupCache(t)
+
+	entry := cache.NewEntry("yt_jNQXAC9IVRw")
+	if entry == nil {
+		t.Fatal("YouTube entry is not created")
+	}
+	entry.Open()
+	defer entry.Close()
+
+	ctx := context.Background()
+	stream, err := entry.GetDownloadStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// written like the downloader does
+	_, err = io.Copy(entry, stream)
+	stream.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !entry.IsComplete() {
+		t.Fatal("entry should be complete")
+	}
+	rs, err := entry.GetReadSeeker(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, len(body))
+	if _, err := io.ReadFull(rs, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, body) {
+		t.Fatal("cached content differs")
+	}
+	if requests.Load() == 0 {
+		t.Fatal("the stream is not downloaded from googlevideo")
+	}
+}
+
+func TestServeYoutubeThroughHijack(t *testing.T) {
+	body := bytes.Repeat([]byte("fedcba9876543210"), 1024*128)
+	googleVideo, requests := fakeGoogleVideo(t, body)
+
+	streamUrl := fmt.Sprintf("%s/videoplayback?expire=%d&id=o-hijack&itag=18&sig=ours", googleVideo.URL, time.Now().Add(6*time.Hour).Unix())
+	script, _ := fakeYtDlp(t, "9bZkp7q19f0", streamUrl)
+	third_party_api.YtDlpPath = script
+	third_party_api.YtDlpProxy = ""
+	third_party_api.EnableYoutubeVideo = true
+	setupCache(t)
+
+	// our yt-dlp resolves the stream first, e.g. when the song is queued
+	if _, err := third_party_api.ResolveYoutubeVideo("9bZkp7q19f0", context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	port := getFreePort(t)
+	go hijack.Start([]string{"*.googlevideo.com"}, false, port)
+	defer hijack.Stop()
+
+	proxyUrl, _ := url.Parse(fmt.Sprintf("http://127.0.0.1:%d", port))
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyUrl)}}
+	defer client.CloseIdleConnections()
+
+	// VRChat requests another URL of the same stream, which is served from the cache
+	vrcUrl := "http://rr3---sn-test.googlevideo.com/videoplayback?expire=1&id=o-hijack&itag=18&sig=theirs"
+	var res *http.Response
+	var err error
+	for i := 0; i < 50; i++ {
+		req, _ := http.NewRequest(http.MethodGet, vrcUrl, nil)
+		req.Header.Set("Range", "bytes=-1024")
+		res, err = client.Do(req)
+		if err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusPartialContent {
+		t.Fatalf("unexpected status %d", res.StatusCode)
+	}
+	if got := mustReadAll(t, res.Body); !bytes.Equal(got, body[len(body)-1024:]) {
+		t.Fatal("served content differs")
+	}
+	if requests.Load() == 0 {
+		t.Fatal("the request is not served through our download")
+	}
+}
+
+func mustReadAll(t *testing.T, r io.Reader) []byte {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
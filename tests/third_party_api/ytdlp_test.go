@@ -0,0 +1,126 @@
+package third_party_api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/third_party_api"
+	"github.com/wzhqwq/VRCDancePreloader/internal/utils"
+)
+
+const fakeVideo = "fake video content"
+
+// fakeYtDlp writes a script printing what yt-dlp -j prints, the arguments are appended to the returned log file
+func fakeYtDlp(t *testing.T, videoId, streamUrl string) (string, string) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake yt-dlp is a shell script")
+	}
+	dir := t.TempDir()
+	argsLog := filepath.Join(dir, "args.log")
+	script := filepath.Join(dir, "yt-dlp")
+	content := fmt.Sprintf(`#!/bin/sh
+echo "$@" >> '%s'
+cat <<'JSON'
+{"id": "%s", "format_id": "18", "url": "%s", "http_headers": {"User-Agent": "fake"}}
+JSON
+`, argsLog, videoId, streamUrl)
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return script, argsLog
+}
+
+func TestResolveYoutubeVideo(t *testing.T) {
+	googleVideo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/videoplayback" || r.URL.Query().Get("itag") != "18" {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, "video.mp4", time.Now(), strings.NewReader(fakeVideo))
+	}))
+	defer googleVideo.Close()
+
+	expire := time.Now().Add(6 * time.Hour).Unix()
+	streamUrl := fmt.Sprintf("%s/videoplayback?expire=%d&id=o-stream&itag=18&sig=ours", googleVideo.URL, expire)
+	script, argsLog := fakeYtDlp(t, "dQw4w9WgXcQ", streamUrl)
+
+	third_party_api.YtDlpPath = script
+	third_party_api.YtDlpProxy = "socks5://127.0.0.1:1080"
+
+	stream, err := third_party_api.ResolveYoutubeVideo("dQw4w9WgXcQ", context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stream.Url != streamUrl || stream.FormatID != "18" {
+		t.Fatalf("unexpected stream: %+v", stream)
+	}
+	if stream.Expires.Unix() != expire {
+		t.Fatalf("expected to expire at %d, got %d", expire, stream.Expires.Unix())
+	}
+
+	// resolved again from the cache
+	if _, err := third_party_api.ResolveYoutubeVideo("dQw4w9WgXcQ", context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	args, _ := os.ReadFile(argsLog)
+	lines := strings.Split(strings.TrimSpace(string(args)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected yt-dlp to run once, got %d times", len(lines))
+	}
+	for _, expected := range []string{"-f " + third_party_api.YtDlpFormat, "--proxy socks5://127.0.0.1:1080", "https://www.youtube.com/watch?v=dQw4w9WgXcQ"} {
+		if !strings.Contains(lines[0], expected) {
+			t.Fatalf("%q is missing in the arguments: %s", expected, lines[0])
+		}
+	}
+
+	// VRChat gets another URL of the same stream from its own yt-dlp
+	vrcReq := httptest.NewRequest(http.MethodGet, "https://rr3---sn-test.googlevideo.com/videoplayback?expire=1&id=o-stream&itag=18&sig=theirs", nil)
+	key, ok := utils.CheckGoogleVideoRequest(vrcReq)
+	if !ok {
+		t.Fatal("the request from VRChat is not recognized")
+	}
+	if id, ok := third_party_api.FindYoutubeVideoByStreamKey(key); !ok || id != "dQw4w9WgXcQ" {
+		t.Fatalf("expected the stream to be dQw4w9WgXcQ, got %q", id)
+	}
+	otherFormat := httptest.NewRequest(http.MethodGet, "https://rr3---sn-test.googlevideo.com/videoplayback?id=o-stream&itag=22", nil)
+	key, _ = utils.CheckGoogleVideoRequest(otherFormat)
+	if _, ok := third_party_api.FindYoutubeVideoByStreamKey(key); ok {
+		t.Fatal("another format of the stream shouldn't be recognized")
+	}
+
+	res, err := http.Get(stream.Url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	if string(body) != fakeVideo {
+		t.Fatalf("unexpected video content: %q", body)
+	}
+}
+
+func TestResolveYoutubeVideoFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake yt-dlp is a shell script")
+	}
+	script := filepath.Join(t.TempDir(), "yt-dlp")
+	content := "#!/bin/sh\necho 'ERROR: Video unavailable' >&2\nexit 1\n"
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	third_party_api.YtDlpPath = script
+
+	_, err := third_party_api.ResolveYoutubeVideo("aaaaaaaaaaa", context.Background())
+	if err == nil || !strings.Contains(err.Error(), "Video unavailable") {
+		t.Fatalf("expected the error of yt-dlp, got %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+package thumbnail_cache
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/thumbnail_cache"
+)
+
+func encodePNG(t *testing.T, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		img.Set(x, x%height, color.RGBA{R: 255, A: 255})
+	}
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+type fakeServer struct {
+	*httptest.Server
+	requests atomic.Int32
+	failing  atomic.Bool
+}
+
+func newFakeServer(t *testing.T, data []byte) *fakeServer {
+	s := &fakeServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests.Add(1)
+		if s.failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestThumbnailCache(t *testing.T) {
+	dir := t.TempDir()
+	thumbnail_cache.SetTTL(time.Hour)
+	thumbnail_cache.SetMaxSize(10 * 1024 * 1024)
+	thumbnail_cache.Setup(dir)
+
+	original := encodePNG(t, 800, 400)
+	server := newFakeServer(t, original)
+	url := server.URL + "/images/1.jpg"
+
+	data, _, err := thumbnail_cache.GetOriginal(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, original) {
+		t.Fatal("original thumbnail is changed")
+	}
+
+	resized, _, err := thumbnail_cache.GetResized(url, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(resized))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 320 || img.Bounds().Dy() != 160 {
+		t.Fatalf("expected a 320x160 variant, got %v", img.Bounds())
+	}
+	if _, _, err := thumbnail_cache.GetResized(url, 320); err != nil {
+		t.Fatal(err)
+	}
+	if n := server.requests.Load(); n != 1 {
+		t.Fatalf("expected the thumbnail to be fetched once, got %d", n)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 2 {
+		t.Fatalf("expected the original and a variant on disk, got %v", files)
+	}
+
+	// expired, but the server is down
+	for _, f := range files {
+		old := time.Now().Add(-2 * time.Hour)
+		os.Chtimes(f, old, old)
+	}
+	server.failing.Store(true)
+	data, _, err = thumbnail_cache.GetOriginal(url)
+	if err != nil || !bytes.Equal(data, original) {
+		t.Fatalf("expected the stale thumbnail, got error %v", err)
+	}
+	if n := server.requests.Load(); n != 2 {
+		t.Fatalf("expected the expired thumbnail to be fetched again, got %d requests", n)
+	}
+
+	thumbnail_cache.Clear()
+	if size := thumbnail_cache.GetTotalSize(); size != 0 {
+		t.Fatalf("expected nothing left, got %d bytes", size)
+	}
+	if _, _, err := thumbnail_cache.GetOriginal(url); err == nil {
+		t.Fatal("expected an error without any copy")
+	}
+}
+
+func TestThumbnailCacheSizeLimit(t *testing.T) {
+	dir := t.TempDir()
+	thumbnail_cache.SetTTL(time.Hour)
+	thumbnail_cache.Setup(dir)
+
+	original := encodePNG(t, 200, 100)
+	server := newFakeServer(t, original)
+	thumbnail_cache.SetMaxSize(int64(len(original)) * 2)
+
+	for i, name := range []string{"/1.png", "/2.png", "/3.png"} {
+		if _, _, err := thumbnail_cache.GetOriginal(server.URL + name); err != nil {
+			t.Fatal(err)
+		}
+		// make the order of modification time certain
+		past := time.Now().Add(time.Duration(i-10) * time.Minute)
+		files, _ := filepath.Glob(filepath.Join(dir, "*"))
+		for _, f := range files {
+			if stat, _ := os.Stat(f); time.Since(stat.ModTime()) < time.Minute {
+				os.Chtimes(f, past, past)
+			}
+		}
+	}
+
+	if size := thumbnail_cache.GetTotalSize(); size > int64(len(original))*2 {
+		t.Fatalf("size limit exceeded: %d", size)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 2 {
+		t.Fatalf("expected the oldest thumbnail to be removed, got %v", files)
+	}
+	server.requests.Store(0)
+	if _, _, err := thumbnail_cache.GetOriginal(server.URL + "/3.png"); err != nil {
+		t.Fatal(err)
+	}
+	if n := server.requests.Load(); n != 0 {
+		t.Fatal("the latest thumbnail should be kept")
+	}
+}
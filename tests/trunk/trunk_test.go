@@ -0,0 +1,308 @@
+package trunk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/rw_file/trunk"
+)
+
+func makeBody(size int) []byte {
+	body := make([]byte, size)
+	for i := range body {
+		body[i] = byte(i % 251)
+	}
+	return body
+}
+
+// writeV1File writes a file in the layout used before versioning, the first filled bytes are marked in trunks
+func writeV1File(t *testing.T, baseName string, body []byte, filled int, completed bool, lastModified time.Time) {
+	const v1TrunkSize = 1024 * 16
+	const v1NumTrunks = 1024 * 1024 * 256 / v1TrunkSize
+
+	header := bytes.NewBufferString("VRCDP_CACHE")
+	_ = binary.Write(header, binary.LittleEndian, int64(len(body)))
+	_ = binary.Write(header, binary.LittleEndian, lastModified.Unix())
+	if completed {
+		header.WriteByte(1)
+	} else {
+		header.WriteByte(0)
+	}
+	trunks := make([]byte, v1NumTrunks)
+	for i := 0; i < filled/v1TrunkSize; i++ {
+		trunks[i] = 1
+	}
+	header.Write(trunks)
+
+	data := make([]byte, len(body))
+	copy(data, body[:filled])
+	err := os.WriteFile(baseName+".vrcdp", append(header.Bytes(), data...), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readAll(t *testing.T, f *trunk.File, size int64) []byte {
+	buf := make([]byte, size)
+	_, err := f.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func TestV2CreateAndReopen(t *testing.T) {
+	baseName := filepath.Join(t.TempDir(), "pypy_1.mp4")
+	body := makeBody(1024 * 100)
+	lastModified := time.Unix(1700000000, 0)
+
+	f := trunk.NewTrunkFile(baseName)
+	if f == nil {
+		t.Fatal("failed to create file")
+	}
+	if f.Version() != trunk.Version2 {
+		t.Fatalf("new file should be v2, got v%d", f.Version())
+	}
+	f.Init(int64(len(body)), lastModified)
+	f.SetSource("https://example.com/video.mp4", `"etag-1"`)
+
+	frag := trunk.NewFragment(0, 0)
+	if err := f.AppendTo(frag, body[:1024*40]); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f = trunk.NewTrunkFile(baseName)
+	defer f.Close()
+
+	if f.Version() != trunk.Version2 || f.FullSize != int64(len(body)) || !f.LastModified.Equal(lastModified) {
+		t.Fatalf("header mismatch: v%d %d %v", f.Version(), f.FullSize, f.LastModified)
+	}
+	if f.SourceUrl != "https://example.com/video.mp4" || f.Validator != `"etag-1"` {
+		t.Fatalf("source mismatch: %q %q", f.SourceUrl, f.Validator)
+	}
+	frags := f.ToFragments()
+	if len(frags) != 1 || frags[0].Start != 0 || frags[0].Length != 1024*32 {
+		t.Fatalf("unexpected fragments: %+v", frags[0])
+	}
+	if f.IsCompleted() {
+		t.Fatal("file should not be completed")
+	}
+	if !bytes.Equal(readAll(t, f, 1024*40), body[:1024*40]) {
+		t.Fatal("body mismatch")
+	}
+}
+
+func TestV2LargeBody(t *testing.T) {
+	baseName := filepath.Join(t.TempDir(), "bili_BV1xx411c7mD.mp4")
+	// larger than v1 capacity, the file is sparse so it won't take the disk
+	fullSize := int64(1024 * 1024 * 300)
+	tail := makeBody(1024 * 64)
+
+	f := trunk.NewTrunkFile(baseName)
+	f.Init(fullSize, time.Unix(1700000000, 0))
+
+	frag := trunk.NewFragment(fullSize-int64(len(tail)), 0)
+	if err := f.AppendTo(frag, tail); err != nil {
+		t.Fatal(err)
+	}
+	if !f.IsSuffix(frag) {
+		t.Fatal("tail fragment should be suffix")
+	}
+	f.MarkCompleted()
+	f.Close()
+
+	info, err := trunk.Stat(baseName + ".vrcdp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.FullSize != fullSize || !info.Completed {
+		t.Fatalf("unexpected info: %+v", info)
+	}
+
+	r, err := trunk.OpenBody(baseName + ".vrcdp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if r.Size() != fullSize {
+		t.Fatalf("unexpected body size %d", r.Size())
+	}
+	buf := make([]byte, len(tail))
+	if _, err := r.ReadAt(buf, fullSize-int64(len(tail))); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, tail) {
+		t.Fatal("tail mismatch")
+	}
+}
+
+func TestV2TrunkSize(t *testing.T) {
+	trunk.SetTrunkSize(1024 * 64)
+	defer trunk.SetTrunkSize(1024 * 16)
+
+	baseName := filepath.Join(t.TempDir(), "pypy_2.mp4")
+	body := makeBody(1024 * 200)
+
+	f := trunk.NewTrunkFile(baseName)
+	// the trunk size is chosen when the file is created, not taken from the current setting
+	trunk.SetTrunkSize(1024 * 16)
+	f.Init(int64(len(body)), time.Unix(1700000000, 0))
+	frag := trunk.NewFragment(0, 0)
+	_ = f.AppendTo(frag, body[:1024*100])
+	f.Close()
+
+	f = trunk.NewTrunkFile(baseName)
+	defer f.Close()
+
+	if f.TrunkSize() != 1024*64 {
+		t.Fatalf("unexpected trunk size %d", f.TrunkSize())
+	}
+	if frags := f.ToFragments(); frags[0].Length != 1024*64 {
+		t.Fatalf("unexpected fragment length %d", frags[0].Length)
+	}
+}
+
+func TestV2BitmapGrows(t *testing.T) {
+	baseName := filepath.Join(t.TempDir(), "pypy_4.mp4")
+	body := makeBody(1024 * 1024)
+
+	f := trunk.NewTrunkFile(baseName)
+	f.Init(1024*100, time.Unix(1700000000, 0))
+	frag := trunk.NewFragment(0, 0)
+	if err := f.AppendTo(frag, body[:1024*64]); err != nil {
+		t.Fatal(err)
+	}
+
+	// the remote size is known to be larger after something is downloaded
+	f.Init(int64(len(body)), time.Unix(1700000000, 0))
+	if err := f.AppendTo(frag, body[1024*64:1024*512]); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	f = trunk.NewTrunkFile(baseName)
+	defer f.Close()
+	if frags := f.ToFragments(); frags[0].Length != 1024*512 {
+		t.Fatalf("progress beyond the old bitmap is lost: %+v", frags[0])
+	}
+	if !bytes.Equal(readAll(t, f, 1024*512), body[:1024*512]) {
+		t.Fatal("body mismatch")
+	}
+}
+
+func TestV2BeyondCapacity(t *testing.T) {
+	baseName := filepath.Join(t.TempDir(), "pypy_5.mp4")
+	body := makeBody(1024 * 64)
+
+	f := trunk.NewTrunkFile(baseName)
+	defer f.Close()
+	f.Init(1024*100, time.Unix(1700000000, 0))
+	frag := trunk.NewFragment(0, 0)
+	if err := f.AppendTo(frag, body); err != nil {
+		t.Fatal(err)
+	}
+
+	// too large for the bitmap to grow in place
+	f.Init(1024*1024*300, time.Unix(1700000000, 0))
+	tail := trunk.NewFragment(1024*1024*200, 0)
+	if err := f.AppendTo(tail, body); !errors.Is(err, trunk.ErrBeyondCapacity) {
+		t.Fatalf("writing beyond the trunks should be refused, got %v", err)
+	}
+	if err := f.AppendTo(frag, body); err != nil {
+		t.Fatalf("writing within the trunks should work, got %v", err)
+	}
+}
+
+func TestV1Read(t *testing.T) {
+	baseName := filepath.Join(t.TempDir(), "pypy_3.mp4")
+	body := makeBody(1024 * 100)
+	lastModified := time.Unix(1600000000, 0)
+	writeV1File(t, baseName, body, 1024*48, false, lastModified)
+
+	info, err := trunk.Stat(baseName + ".vrcdp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != trunk.Version1 || info.DownloadedBytes != 1024*48 {
+		t.Fatalf("unexpected info: %+v", info)
+	}
+
+	f := trunk.NewTrunkFile(baseName)
+	if f.Version() != trunk.Version1 || f.FullSize != int64(len(body)) || !f.LastModified.Equal(lastModified) {
+		t.Fatalf("header mismatch: v%d %d %v", f.Version(), f.FullSize, f.LastModified)
+	}
+	frags := f.ToFragments()
+	if len(frags) != 1 || frags[0].Length != 1024*48 {
+		t.Fatalf("unexpected fragments: %+v", frags[0])
+	}
+	if !bytes.Equal(readAll(t, f, 1024*48), body[:1024*48]) {
+		t.Fatal("body mismatch")
+	}
+
+	// continue downloading in v1 layout
+	frag := frags[0]
+	if err := f.AppendTo(frag, body[frag.End():]); err != nil {
+		t.Fatal(err)
+	}
+	f.MarkCompleted()
+	f.Close()
+
+	r, err := trunk.OpenBody(baseName + ".vrcdp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	all, _ := io.ReadAll(r)
+	if !bytes.Equal(all, body) {
+		t.Fatal("completed body mismatch")
+	}
+}
+
+func TestV1CompletedRead(t *testing.T) {
+	baseName := filepath.Join(t.TempDir(), "wanna_1.mp4")
+	body := makeBody(1024 * 70)
+	writeV1File(t, baseName, body, len(body), true, time.Unix(1600000000, 0))
+
+	r, err := trunk.OpenBody(baseName + ".vrcdp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	all, _ := io.ReadAll(r)
+	if !bytes.Equal(all, body) {
+		t.Fatal("body mismatch")
+	}
+}
+
+func TestV1EmptyUpgraded(t *testing.T) {
+	baseName := filepath.Join(t.TempDir(), "dudu_1.mp4")
+	// nothing downloaded yet
+	writeV1File(t, baseName, makeBody(1024*100), 0, false, time.Unix(0, 0))
+
+	f := trunk.NewTrunkFile(baseName)
+	if f.Version() != trunk.Version1 {
+		t.Fatalf("expected v1, got v%d", f.Version())
+	}
+	f.Init(1024*1024*300, time.Unix(1700000000, 0))
+	if f.Version() != trunk.Version2 {
+		t.Fatalf("empty v1 file should be upgraded, got v%d", f.Version())
+	}
+	f.Close()
+
+	info, err := trunk.Stat(baseName + ".vrcdp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != trunk.Version2 || info.FullSize != 1024*1024*300 {
+		t.Fatalf("unexpected info: %+v", info)
+	}
+}
@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/wzhqwq/VRCDancePreloader/internal/utils"
+)
+
+func TestRingBuffer(t *testing.T) {
+	ring := utils.NewRingBuffer[int](3)
+	if len(ring.Items()) != 0 {
+		t.Fatal("new ring buffer should be empty")
+	}
+
+	ring.Push(1)
+	ring.Push(2)
+	if items := ring.Items(); !slices.Equal(items, []int{1, 2}) {
+		t.Fatalf("unexpected items %v", items)
+	}
+
+	ring.Push(3)
+	ring.Push(4)
+	ring.Push(5)
+	if items := ring.Items(); !slices.Equal(items, []int{3, 4, 5}) {
+		t.Fatalf("oldest items should be overwritten, got %v", items)
+	}
+	if ring.Len() != 3 {
+		t.Fatalf("unexpected length %d", ring.Len())
+	}
+
+	ring.Clear()
+	ring.Push(6)
+	if items := ring.Items(); !slices.Equal(items, []int{6}) {
+		t.Fatalf("unexpected items after clear %v", items)
+	}
+}